@@ -23,13 +23,17 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"time"
 
 	proto "github.com/container-storage-interface/spec/lib/go/csi"
 	"google.golang.org/grpc"
 
 	"github.com/sergelogvinov/proxmox-csi-plugin/pkg/csi"
+	"github.com/sergelogvinov/proxmox-csi-plugin/pkg/gc"
+	"github.com/sergelogvinov/proxmox-csi-plugin/pkg/migration"
 	tools "github.com/sergelogvinov/proxmox-csi-plugin/pkg/tools/kubernetes"
 
+	"k8s.io/client-go/dynamic"
 	clientkubernetes "k8s.io/client-go/kubernetes"
 	"k8s.io/component-base/metrics/legacyregistry"
 	"k8s.io/klog/v2"
@@ -47,6 +51,12 @@ var (
 
 	cloudconfig = flag.String("cloud-config", "", "The path to the CSI driver cloud config.")
 	kubeconfig  = flag.String("kubeconfig", "", "Absolute path to the kubeconfig file. Either this or master needs to be set if the provisioner is being run out of cluster.")
+
+	migrationController = flag.Bool("migration-controller", false, "Migrate local volumes to the Proxmox node set in the PersistentVolumeClaim annotation.")
+
+	orphanGCInterval    = flag.Duration("orphan-gc-interval", 0, "Interval of the search for the orphaned disks on Proxmox storages. By default the search is disabled.")
+	orphanGCGracePeriod = flag.Duration("orphan-gc-grace-period", 24*time.Hour, "Minimum age of the orphaned disk before it is deleted.")
+	orphanGCDelete      = flag.Bool("orphan-gc-delete", false, "Delete the orphaned disks after the grace period. By default the orphaned disks are only reported.")
 )
 
 func main() {
@@ -71,7 +81,7 @@ func main() {
 		klog.FlushAndExit(klog.ExitFlushTimeout, 1)
 	}
 
-	kconfig, _, err := tools.BuildConfig(*kubeconfig, "")
+	kconfig, namespace, err := tools.BuildConfig(*kubeconfig, "")
 	if err != nil {
 		klog.Error(err, "Failed to build a Kubernetes config")
 		klog.FlushAndExit(klog.ExitFlushTimeout, 1)
@@ -133,7 +143,34 @@ func main() {
 		klog.FlushAndExit(klog.ExitFlushTimeout, 1)
 	}
 
+	if *migrationController {
+		migrationController, err := migration.NewController(clientset, *cloudconfig)
+		if err != nil {
+			klog.ErrorS(err, "Failed to create migration controller")
+			klog.FlushAndExit(klog.ExitFlushTimeout, 1)
+		}
+
+		go runWithLease(clientset, namespace, migration.ControllerName, migrationController.Run)
+	}
+
+	if *orphanGCInterval > 0 {
+		dclient, err := dynamic.NewForConfig(kconfig)
+		if err != nil {
+			klog.ErrorS(err, "Failed to create a dynamic client")
+			klog.FlushAndExit(klog.ExitFlushTimeout, 1)
+		}
+
+		orphanController, err := gc.NewController(clientset, dclient, *cloudconfig, namespace, *orphanGCInterval, *orphanGCGracePeriod, *orphanGCDelete)
+		if err != nil {
+			klog.ErrorS(err, "Failed to create orphaned disk collector")
+			klog.FlushAndExit(klog.ExitFlushTimeout, 1)
+		}
+
+		go runWithLease(clientset, namespace, gc.ControllerName, orphanController.Run)
+	}
+
 	proto.RegisterControllerServer(srv, controllerService)
+	proto.RegisterGroupControllerServer(srv, csi.NewGroupControllerService(controllerService))
 	proto.RegisterIdentityServer(srv, identityService)
 
 	klog.InfoS("Listening for connection on address", "address", listener.Addr())
@@ -143,3 +180,15 @@ func main() {
 		klog.FlushAndExit(klog.ExitFlushTimeout, 1)
 	}
 }
+
+// runWithLease runs the controller loop only in the replica which holds the lease.
+// The process exits when the lease is lost, so the loop never runs in two replicas at once.
+func runWithLease(kclient clientkubernetes.Interface, namespace, name string, run func(ctx context.Context)) {
+	if err := tools.RunWithLease(context.Background(), kclient, tools.LeaseConfig{Namespace: namespace, Name: name}, run); err != nil {
+		klog.ErrorS(err, "Failed to run with lease", "lease", name)
+	} else {
+		klog.ErrorS(nil, "Lost lease", "lease", name)
+	}
+
+	klog.FlushAndExit(klog.ExitFlushTimeout, 1)
+}
@@ -0,0 +1,514 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/pmezard/go-difflib/difflib"
+
+	pxpool "github.com/sergelogvinov/proxmox-csi-plugin/pkg/proxmoxpool"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	clientkubernetes "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	planOutputText = "text"
+	planOutputJSON = "json"
+
+	planChangeKubernetes = "kubernetes"
+	planChangeProxmox    = "proxmox"
+	planChangeDevice     = "device"
+
+	// dryRunTaskType is the type of the Proxmox tasks which are returned instead of the real ones.
+	dryRunTaskType = "dryrun"
+
+	// redactedValue replaces the secrets in the parameters of the recorded Proxmox API calls.
+	redactedValue = "***"
+)
+
+// plan records the changes in dry-run mode, it is nil otherwise.
+var plan *dryRunPlan
+
+// dryRunPlan is the list of the changes which the command would make.
+type dryRunPlan struct {
+	mu      sync.Mutex
+	Changes []planChange `json:"changes"`
+}
+
+// planChange is a Kubernetes object change, a Proxmox API call or a change of the local device.
+type planChange struct {
+	Type      string          `json:"type"`
+	Action    string          `json:"action"`
+	Resource  string          `json:"resource,omitempty"`
+	Namespace string          `json:"namespace,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Diff      string          `json:"diff,omitempty"`
+	Host      string          `json:"host,omitempty"`
+	Path      string          `json:"path,omitempty"`
+	Params    json.RawMessage `json:"params,omitempty"`
+}
+
+func (p *dryRunPlan) add(change planChange) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.Changes = append(p.Changes, change)
+}
+
+func (p *dryRunPlan) print(w io.Writer, output string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if output == planOutputJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+
+		return enc.Encode(p)
+	}
+
+	if len(p.Changes) == 0 {
+		_, err := fmt.Fprintln(w, "dry-run: no changes")
+
+		return err
+	}
+
+	for _, c := range p.Changes {
+		var err error
+
+		switch c.Type {
+		case planChangeKubernetes:
+			name := c.Name
+			if c.Namespace != "" {
+				name = c.Namespace + "/" + c.Name
+			}
+
+			_, err = fmt.Fprintf(w, "# %s: %s %s %s\n%s\n", c.Type, c.Action, c.Resource, name, c.Diff)
+		case planChangeProxmox:
+			_, err = fmt.Fprintf(w, "# %s: %s %s (%s)\n", c.Type, c.Action, c.Path, c.Host)
+			if err == nil && len(c.Params) > 0 {
+				_, err = fmt.Fprintf(w, "%s\n", c.Params)
+			}
+
+			if err == nil {
+				_, err = fmt.Fprintln(w)
+			}
+		default:
+			_, err = fmt.Fprintf(w, "# %s: %s %s\n\n", c.Type, c.Action, c.Path)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// newProxmoxPool creates the Proxmox client pool, in dry-run mode the API calls which change the cluster are recorded only.
+func newProxmoxPool(clusters []*pxpool.ProxmoxCluster) (*pxpool.ProxmoxPool, error) {
+	if plan != nil {
+		for _, c := range clusters {
+			c.WrapTransport = func(rt http.RoundTripper) http.RoundTripper {
+				return &dryRunTransport{plan: plan, next: rt}
+			}
+		}
+	}
+
+	return pxpool.NewProxmoxPool(clusters)
+}
+
+// dryRunTransport passes the read requests to the Proxmox API and records the others.
+// The recorded requests return the fake task, which is always completed successfully.
+type dryRunTransport struct {
+	plan  *dryRunPlan
+	next  http.RoundTripper
+	mu    sync.Mutex
+	tasks int
+}
+
+func (t *dryRunTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	_, path, ok := strings.Cut(req.URL.Path, "/api2/json")
+	if !ok {
+		path = req.URL.Path
+	}
+
+	if req.Method == http.MethodGet {
+		// GET /nodes/{node}/tasks/{upid}/status
+		if parts := strings.Split(path, "/"); len(parts) == 6 && parts[3] == "tasks" && strings.Contains(parts[4], ":"+dryRunTaskType+":") {
+			return dryRunResponse(req, fmt.Sprintf(`{"data":{"upid":%q,"node":%q,"status":"stopped","exitstatus":"OK"}}`, parts[4], parts[2])), nil
+		}
+
+		return t.next.RoundTrip(req)
+	}
+
+	if path == "/access/ticket" {
+		return t.next.RoundTrip(req)
+	}
+
+	var params json.RawMessage
+
+	if req.Body != nil {
+		data, err := io.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+
+		if params, err = redactParams(data); err != nil {
+			return nil, err
+		}
+	}
+
+	t.plan.add(planChange{
+		Type:   planChangeProxmox,
+		Action: req.Method,
+		Host:   req.URL.Host,
+		Path:   path,
+		Params: params,
+	})
+
+	node := ""
+	if parts := strings.Split(path, "/"); len(parts) > 2 && parts[1] == "nodes" {
+		node = parts[2]
+	}
+
+	t.mu.Lock()
+	t.tasks++
+	upid := fmt.Sprintf("UPID:%s:00000000:00000000:00000000:%s:%d:root@pam:", node, dryRunTaskType, t.tasks)
+	t.mu.Unlock()
+
+	return dryRunResponse(req, `{"data":"`+upid+`"}`), nil
+}
+
+// redactParams returns the JSON parameters of the Proxmox API call with the secrets replaced, nil if data is not JSON.
+// The secrets inside the property strings, like the apitoken of the remote endpoint, are replaced too.
+func redactParams(data []byte) (json.RawMessage, error) {
+	params := map[string]any{}
+	if err := json.Unmarshal(data, &params); err != nil {
+		return nil, nil //nolint: nilerr
+	}
+
+	for k, v := range params {
+		if sensitiveParam(k) {
+			params[k] = redactedValue
+
+			continue
+		}
+
+		if value, ok := v.(string); ok {
+			props := strings.Split(value, ",")
+			for i, prop := range props {
+				if name, _, ok := strings.Cut(prop, "="); ok && sensitiveParam(name) {
+					props[i] = name + "=" + redactedValue
+				}
+			}
+
+			params[k] = strings.Join(props, ",")
+		}
+	}
+
+	return json.Marshal(params)
+}
+
+// sensitiveParam reports whether the parameter holds a secret, the password, the passphrase or the API token.
+func sensitiveParam(name string) bool {
+	name = strings.ToLower(strings.TrimSpace(name))
+
+	return name == "password" || name == "passphrase" || strings.Contains(name, "token")
+}
+
+func dryRunResponse(req *http.Request, body string) *http.Response {
+	return &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}
+}
+
+// dryRunKubeClient returns the Kubernetes client, in dry-run mode the client reads the objects from the cluster
+// and changes only the in-memory copies of them, the changes are recorded to the plan.
+func dryRunKubeClient(kclient clientkubernetes.Interface) clientkubernetes.Interface {
+	if plan == nil {
+		return kclient
+	}
+
+	cs := fake.NewClientset()
+	d := &dryRunClient{
+		ctx:     context.Background(),
+		kclient: kclient,
+		tracker: cs.Tracker(),
+		seen:    map[string]bool{},
+		listed:  map[string]bool{},
+	}
+
+	cs.PrependReactor("*", "*", d.react)
+
+	return cs
+}
+
+type dryRunClient struct {
+	ctx     context.Context
+	kclient clientkubernetes.Interface
+	tracker k8stesting.ObjectTracker
+
+	// seen is the objects which were loaded from the cluster, resource/namespace/name
+	seen map[string]bool
+	// listed is the resources which were listed in the cluster, resource/namespace
+	listed map[string]bool
+}
+
+// react loads the objects from the cluster on the first access, and records the changes of them.
+// The reactors are called under the clientset lock, so the calls are serialized.
+func (d *dryRunClient) react(action k8stesting.Action) (bool, runtime.Object, error) {
+	gvr := action.GetResource()
+	ns := action.GetNamespace()
+
+	var name string
+
+	switch action.GetVerb() {
+	case "list":
+		if err := d.loadList(gvr, ns); err != nil {
+			return true, nil, err
+		}
+
+		return false, nil, nil
+	case "get":
+		if err := d.load(gvr, ns, action.(k8stesting.GetAction).GetName()); err != nil { //nolint: forcetypeassert
+			return true, nil, err
+		}
+
+		return false, nil, nil
+	case "create", "update":
+		obj, err := meta.Accessor(action.(interface{ GetObject() runtime.Object }).GetObject()) //nolint: forcetypeassert
+		if err != nil {
+			return true, nil, err
+		}
+
+		name = obj.GetName()
+	case "patch":
+		name = action.(k8stesting.PatchAction).GetName() //nolint: forcetypeassert
+	case "delete":
+		name = action.(k8stesting.DeleteAction).GetName() //nolint: forcetypeassert
+	default:
+		return false, nil, nil
+	}
+
+	if err := d.load(gvr, ns, name); err != nil {
+		return true, nil, err
+	}
+
+	before, _ := d.tracker.Get(gvr, ns, name) //nolint: errcheck
+
+	handled, ret, err := k8stesting.ObjectReaction(d.tracker)(action)
+	if err != nil {
+		return handled, ret, err
+	}
+
+	after, _ := d.tracker.Get(gvr, ns, name) //nolint: errcheck
+
+	diff, err := objectDiff(before, after)
+	if err != nil {
+		return true, nil, err
+	}
+
+	plan.add(planChange{
+		Type:      planChangeKubernetes,
+		Action:    action.GetVerb(),
+		Resource:  gvr.Resource,
+		Namespace: ns,
+		Name:      name,
+		Diff:      diff,
+	})
+
+	return handled, ret, err
+}
+
+func (d *dryRunClient) restClient(gvr schema.GroupVersionResource) rest.Interface {
+	switch gvr.GroupVersion() {
+	case corev1.SchemeGroupVersion:
+		return d.kclient.CoreV1().RESTClient()
+	case storagev1.SchemeGroupVersion:
+		return d.kclient.StorageV1().RESTClient()
+	}
+
+	return nil
+}
+
+func (d *dryRunClient) isListed(gvr schema.GroupVersionResource, ns string) bool {
+	return d.listed[gvr.Resource+"/"] || d.listed[gvr.Resource+"/"+ns]
+}
+
+func (d *dryRunClient) load(gvr schema.GroupVersionResource, ns, name string) error {
+	key := gvr.Resource + "/" + ns + "/" + name
+	if name == "" || d.seen[key] || d.isListed(gvr, ns) {
+		return nil
+	}
+
+	rc := d.restClient(gvr)
+	if rc == nil {
+		return nil
+	}
+
+	obj, err := rc.Get().NamespaceIfScoped(ns, ns != "").Resource(gvr.Resource).Name(name).Do(d.ctx).Get()
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+
+	d.seen[key] = true
+
+	if err != nil {
+		return nil
+	}
+
+	return d.tracker.Add(obj)
+}
+
+func (d *dryRunClient) loadList(gvr schema.GroupVersionResource, ns string) error {
+	if d.isListed(gvr, ns) {
+		return nil
+	}
+
+	rc := d.restClient(gvr)
+	if rc == nil {
+		return nil
+	}
+
+	list, err := rc.Get().NamespaceIfScoped(ns, ns != "").Resource(gvr.Resource).Do(d.ctx).Get()
+	if err != nil {
+		return err
+	}
+
+	items, err := meta.ExtractList(list)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		obj, err := meta.Accessor(item)
+		if err != nil {
+			return err
+		}
+
+		// The object could be changed or deleted already
+		key := gvr.Resource + "/" + obj.GetNamespace() + "/" + obj.GetName()
+		if d.seen[key] {
+			continue
+		}
+
+		d.seen[key] = true
+
+		if err = d.tracker.Add(item); err != nil {
+			return err
+		}
+	}
+
+	d.listed[gvr.Resource+"/"+ns] = true
+
+	return nil
+}
+
+// objectDiff returns the unified diff of the objects in YAML, the secret values are replaced by their hashes.
+func objectDiff(before, after runtime.Object) (string, error) {
+	a, err := objectYAML(before)
+	if err != nil {
+		return "", err
+	}
+
+	b, err := objectYAML(after)
+	if err != nil {
+		return "", err
+	}
+
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        splitLines(a),
+		B:        splitLines(b),
+		FromFile: "before",
+		ToFile:   "after",
+		Context:  3,
+	})
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	return lines
+}
+
+func objectYAML(obj runtime.Object) (string, error) {
+	if obj == nil {
+		return "", nil
+	}
+
+	obj = obj.DeepCopyObject()
+	obj.GetObjectKind().SetGroupVersionKind(schema.GroupVersionKind{})
+
+	if m, err := meta.Accessor(obj); err == nil {
+		m.SetManagedFields(nil)
+		m.SetResourceVersion("")
+	}
+
+	if secret, ok := obj.(*corev1.Secret); ok {
+		data := map[string]string{}
+
+		for k, v := range secret.StringData {
+			data[k] = fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(v)))
+		}
+
+		for k, v := range secret.Data {
+			data[k] = fmt.Sprintf("sha256:%x", sha256.Sum256(v))
+		}
+
+		secret.Data = nil
+		secret.StringData = data
+	}
+
+	data, err := yaml.Marshal(obj)
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDryRunTransportRedactsSecrets(t *testing.T) {
+	pool := newTestProxmoxPool(t)
+
+	endpoint, err := pool.GetRemoteEndpoint("cluster-1")
+	require.NoError(t, err)
+
+	tests := []struct {
+		msg            string
+		params         map[string]any
+		expectedParams string
+	}{
+		{
+			msg:            "RemoteEndpoint",
+			params:         map[string]any{"target-endpoint": endpoint, "target-vmid": 100},
+			expectedParams: `{"target-endpoint":"apitoken=***,host=127.0.0.1,port=8006","target-vmid":100}`,
+		},
+		{
+			msg:            "Password",
+			params:         map[string]any{"username": "root@pam", "password": "secret"},
+			expectedParams: `{"password":"***","username":"root@pam"}`,
+		},
+		{
+			msg:            "Passphrase",
+			params:         map[string]any{"Passphrase": "secret"},
+			expectedParams: `{"Passphrase":"***"}`,
+		},
+		{
+			msg:            "Token",
+			params:         map[string]any{"apitoken": "s3cr3t", "options": "a=1,token=s3cr3t"},
+			expectedParams: `{"apitoken":"***","options":"a=1,token=***"}`,
+		},
+		{
+			msg:            "NoSecrets",
+			params:         map[string]any{"target": "local-lvm:vm-9999-pvc-1", "size": "10G"},
+			expectedParams: `{"size":"10G","target":"local-lvm:vm-9999-pvc-1"}`,
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.msg, func(t *testing.T) {
+			p := setTestPlan(t)
+
+			body, err := json.Marshal(testCase.params)
+			require.NoError(t, err)
+
+			req, err := http.NewRequest(http.MethodPost, "https://127.0.0.1:8006/api2/json/nodes/pve-1/qemu/100/remote_migrate", bytes.NewReader(body))
+			require.NoError(t, err)
+
+			tr := &dryRunTransport{plan: p, next: http.DefaultTransport}
+
+			resp, err := tr.RoundTrip(req)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+
+			require.Len(t, p.Changes, 1)
+			assert.JSONEq(t, testCase.expectedParams, string(p.Changes[0].Params))
+
+			out := &bytes.Buffer{}
+			require.NoError(t, p.print(out, planOutputText))
+			assert.NotRegexp(t, "secret|s3cr3t", out.String())
+
+			out.Reset()
+			require.NoError(t, p.print(out, planOutputJSON))
+			assert.NotRegexp(t, "secret|s3cr3t", out.String())
+		})
+	}
+}
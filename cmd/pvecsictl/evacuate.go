@@ -0,0 +1,315 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	cobra "github.com/spf13/cobra"
+
+	"github.com/sergelogvinov/proxmox-csi-plugin/pkg/csi"
+	tools "github.com/sergelogvinov/proxmox-csi-plugin/pkg/tools/kubernetes"
+	volume "github.com/sergelogvinov/proxmox-csi-plugin/pkg/utils/volume"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type evacuateCmd struct {
+	migrateCmd
+}
+
+// evacuateItem is the migration of one persistentvolumeclaims from the evacuated node.
+type evacuateItem struct {
+	namespace string
+	pvc       string
+	pv        string
+	size      int64
+	cluster   string
+	storage   string
+	from      string
+	to        string
+	status    string
+	err       error
+}
+
+func buildEvacuateCmd() *cobra.Command {
+	c := &evacuateCmd{}
+
+	cmd := cobra.Command{
+		Use:           "evacuate proxmox-node",
+		Aliases:       []string{"ev"},
+		Short:         "Migrate all persistentvolumeclaims from the Proxmox node to other nodes",
+		Args:          cobra.ExactArgs(1),
+		PreRunE:       c.migrationValidate,
+		RunE:          c.runEvacuate,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+
+	setEvacuateCmdFlags(&cmd)
+
+	return &cmd
+}
+
+func setEvacuateCmdFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+
+	flags.StringP("namespace", "n", "", "namespace of the persistentvolumeclaims, default is all namespaces")
+
+	flags.BoolP("force", "f", false, "force migration even if the persistentvolumeclaims is in use")
+	flags.StringSlice("nodes", []string{}, "destination proxmox nodes, default is all nodes with the storage")
+	flags.Int("parallel", 2, "number of parallel migrations")
+	flags.Int("timeout", 10800, "task timeout in seconds")
+}
+
+// nolint: cyclop, gocyclo
+func (c *evacuateCmd) runEvacuate(cmd *cobra.Command, args []string) error {
+	flags := cmd.Flags()
+	force, _ := flags.GetBool("force")        //nolint: errcheck
+	nodes, _ := flags.GetStringSlice("nodes") //nolint: errcheck
+	parallel, _ := flags.GetInt("parallel")   //nolint: errcheck
+	taskTimeout, _ := flags.GetInt("timeout") //nolint: errcheck
+
+	ctx := context.Background()
+	node := args[0]
+
+	if parallel < 1 {
+		return fmt.Errorf("parallel must be greater than 0")
+	}
+
+	namespace := ""
+	if flags.Changed("namespace") {
+		namespace = c.namespace
+	}
+
+	items, err := c.evacuatePlan(ctx, namespace, node, nodes)
+	if err != nil {
+		return err
+	}
+
+	if len(items) == 0 {
+		logger.Infof("no persistentvolumeclaims found on proxmox node %s", node)
+
+		return nil
+	}
+
+	if force {
+		csiNodes, err := tools.CSINodes(ctx, c.kclient, csi.DriverName)
+		if err != nil {
+			return err
+		}
+
+		logger.Infof("cordoning nodes: %s", strings.Join(csiNodes, ","))
+
+		cordonedNodes, err := tools.CondonNodes(ctx, c.kclient, csiNodes)
+		if err != nil {
+			return fmt.Errorf("failed to cordon nodes: %v", err)
+		}
+
+		defer func() {
+			logger.Infof("uncordoning nodes: %s", strings.Join(cordonedNodes, ","))
+
+			if err := tools.UncondonNodes(ctx, c.kclient, cordonedNodes); err != nil {
+				logger.Errorf("failed to uncordon nodes: %v", err)
+			}
+		}()
+	}
+
+	var wg sync.WaitGroup
+
+	sem := make(chan struct{}, parallel)
+
+	for i := range items {
+		if items[i].err != nil {
+			continue
+		}
+
+		wg.Add(1)
+
+		sem <- struct{}{}
+
+		go func(item *evacuateItem) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			start := time.Now()
+
+			logger.Infof("migrating persistentvolumeclaims %s/%s to proxmox node %s", item.namespace, item.pvc, item.to)
+
+			item.err = c.migratePVC(ctx, item.namespace, item.pvc, item.to, migrateOptions{
+				storage: item.storage,
+				force:   force,
+				timeout: taskTimeout,
+			})
+			if item.err == nil && !dryRun {
+				item.status = fmt.Sprintf("migrated in %s", time.Since(start).Round(time.Second))
+			}
+		}(&items[i])
+	}
+
+	wg.Wait()
+
+	printEvacuateReport(items)
+
+	failed := 0
+
+	for _, item := range items {
+		if item.err != nil {
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d persistentvolumeclaims were not migrated from proxmox node %s", failed, len(items), node)
+	}
+
+	logger.Infof("proxmox node %s has been evacuated", node)
+
+	return nil
+}
+
+// evacuatePlan finds the persistentvolumeclaims on the node and picks the destination node with the most free space on the same storage.
+func (c *evacuateCmd) evacuatePlan(ctx context.Context, namespace, node string, nodes []string) ([]evacuateItem, error) {
+	pvs, err := c.kclient.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list persistentvolumes: %v", err)
+	}
+
+	items := []evacuateItem{}
+
+	for _, pv := range pvs.Items {
+		if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != csi.DriverName || pv.Spec.ClaimRef == nil || pv.Status.Phase != corev1.VolumeBound {
+			continue
+		}
+
+		if namespace != "" && pv.Spec.ClaimRef.Namespace != namespace {
+			continue
+		}
+
+		vol, err := volume.NewVolumeFromVolumeID(pv.Spec.CSI.VolumeHandle)
+		if err != nil || vol.Node() != node {
+			continue
+		}
+
+		capacity := pv.Spec.Capacity[corev1.ResourceStorage]
+
+		items = append(items, evacuateItem{
+			namespace: pv.Spec.ClaimRef.Namespace,
+			pvc:       pv.Spec.ClaimRef.Name,
+			pv:        pv.Name,
+			size:      capacity.Value(),
+			cluster:   vol.Cluster(),
+			storage:   vol.Storage(),
+			from:      node,
+			status:    "planned",
+		})
+	}
+
+	// Place the largest volumes first
+	sort.SliceStable(items, func(i, j int) bool {
+		if items[i].size != items[j].size {
+			return items[i].size > items[j].size
+		}
+
+		return items[i].namespace+"/"+items[i].pvc < items[j].namespace+"/"+items[j].pvc
+	})
+
+	// free space of the storage on the node, cluster/node/storage -> bytes
+	free := map[string]int64{}
+
+	for i := range items {
+		item := &items[i]
+
+		cluster, err := c.pclient.GetProxmoxCluster(item.cluster)
+		if err != nil {
+			item.err = fmt.Errorf("failed to get Proxmox cluster: %v", err)
+
+			continue
+		}
+
+		candidates, err := cluster.GetNodesForStorage(ctx, item.storage)
+		if err != nil {
+			item.err = fmt.Errorf("failed to get nodes for storage %s: %v", item.storage, err)
+
+			continue
+		}
+
+		sort.Strings(candidates)
+
+		for _, n := range candidates {
+			if n == node || (len(nodes) > 0 && !slices.Contains(nodes, n)) {
+				continue
+			}
+
+			key := item.cluster + "/" + n + "/" + item.storage
+			if _, ok := free[key]; !ok {
+				st, err := cluster.GetStorageStatus(ctx, n, item.storage)
+				if err != nil {
+					logger.Warnf("failed to get storage %s status on proxmox node %s: %v", item.storage, n, err)
+
+					free[key] = 0
+
+					continue
+				}
+
+				free[key] = int64(st.Avail)
+			}
+
+			if free[key] >= item.size && (item.to == "" || free[key] > free[item.cluster+"/"+item.to+"/"+item.storage]) {
+				item.to = n
+			}
+		}
+
+		if item.to == "" {
+			item.err = fmt.Errorf("no proxmox node with enough free space on storage %s", item.storage)
+
+			continue
+		}
+
+		free[item.cluster+"/"+item.to+"/"+item.storage] -= item.size
+	}
+
+	return items, nil
+}
+
+func printEvacuateReport(items []evacuateItem) {
+	w := tabwriter.NewWriter(logger.Logger.Out, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(w, "NAMESPACE\tPVC\tPV\tSIZE\tSTORAGE\tFROM\tTO\tSTATUS")
+
+	for _, item := range items {
+		status := item.status
+		if item.err != nil {
+			status = "error: " + item.err.Error()
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			item.namespace, item.pvc, item.pv, resource.NewQuantity(item.size, resource.BinarySI).String(),
+			item.storage, item.from, item.to, status)
+	}
+
+	w.Flush() //nolint: errcheck
+}
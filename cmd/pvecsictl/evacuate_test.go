@@ -0,0 +1,143 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/jarcoal/httpmock"
+	cobra "github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testBoundPV(name, pvc, handle string) *corev1.PersistentVolume {
+	pv := testPV(name, handle)
+	pv.Spec.Capacity = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("1Gi")}
+	pv.Spec.ClaimRef = &corev1.ObjectReference{Namespace: "default", Name: pvc}
+	pv.Status.Phase = corev1.VolumeBound
+
+	return pv
+}
+
+func TestRunEvacuate(t *testing.T) {
+	objects := []runtime.Object{
+		testPVC("storage-test-0", "pvc-1"),
+		testBoundPV("pvc-1", "storage-test-0", "cluster-1/pve-1/local-lvm/vm-9999-pvc-1"),
+		testPVC("storage-test-1", "pvc-2"),
+		testBoundPV("pvc-2", "storage-test-1", "cluster-1/pve-1/local-lvm/vm-9999-pvc-2"),
+		testPVC("storage-test-2", "pvc-3"),
+		testBoundPV("pvc-3", "storage-test-2", "cluster-1/pve-2/local-lvm/vm-9999-pvc-3"),
+		testPVC("storage-test-3", "pvc-4"),
+		testBoundPV("pvc-4", "storage-test-3", "cluster-1//ceph/vm-9999-pvc-4"),
+	}
+
+	tests := []struct {
+		msg             string
+		avail           int64
+		copyTask        string
+		expectedError   string
+		expectedHandles map[string]string
+		expectedCopies  int
+		expectedDeletes int
+	}{
+		{
+			msg:      "Evacuated",
+			avail:    10 * 1024 * 1024 * 1024,
+			copyTask: testTaskOK,
+			expectedHandles: map[string]string{
+				"pvc-1": "cluster-1/pve-2/local-lvm/vm-9999-pvc-1",
+				"pvc-2": "cluster-1/pve-2/local-lvm/vm-9999-pvc-2",
+				"pvc-3": "cluster-1/pve-2/local-lvm/vm-9999-pvc-3",
+				"pvc-4": "cluster-1//ceph/vm-9999-pvc-4",
+			},
+			expectedCopies:  2,
+			expectedDeletes: 2,
+		},
+		{
+			msg:           "NoFreeSpace",
+			avail:         1024 * 1024 * 1024,
+			copyTask:      testTaskOK,
+			expectedError: "1 of 2 persistentvolumeclaims were not migrated from proxmox node pve-1",
+			expectedHandles: map[string]string{
+				"pvc-1": "cluster-1/pve-2/local-lvm/vm-9999-pvc-1",
+				"pvc-2": "cluster-1/pve-1/local-lvm/vm-9999-pvc-2",
+			},
+			expectedCopies:  1,
+			expectedDeletes: 1,
+		},
+		{
+			msg:           "CopyFailed",
+			avail:         10 * 1024 * 1024 * 1024,
+			copyTask:      testTaskError,
+			expectedError: "2 of 2 persistentvolumeclaims were not migrated from proxmox node pve-1",
+			expectedHandles: map[string]string{
+				"pvc-1": "cluster-1/pve-1/local-lvm/vm-9999-pvc-1",
+				"pvc-2": "cluster-1/pve-1/local-lvm/vm-9999-pvc-2",
+			},
+			expectedCopies: 2,
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.msg, func(t *testing.T) {
+			pool := newTestProxmoxPool(t)
+			registerTestStorages()
+
+			diskURL := `=~/nodes/pve-1/storage/local-lvm/content/vm-9999-pvc-\d+$`
+
+			httpmock.RegisterResponder(http.MethodGet, `=~/nodes/pve-2/storage/local-lvm/status$`,
+				httpmock.NewJsonResponderOrPanic(200, map[string]any{"data": map[string]any{"avail": testCase.avail}}))
+			httpmock.RegisterResponder(http.MethodPost, diskURL,
+				httpmock.NewJsonResponderOrPanic(200, map[string]any{"data": testCase.copyTask}))
+			httpmock.RegisterResponder(http.MethodDelete, diskURL,
+				httpmock.NewJsonResponderOrPanic(200, map[string]any{"data": testTaskOK}))
+
+			kclient := fake.NewClientset(objects...)
+			c := &evacuateCmd{migrateCmd{pclient: pool, kclient: kclient, namespace: "default"}}
+
+			cmd := &cobra.Command{}
+			setEvacuateCmdFlags(cmd)
+			require.NoError(t, cmd.Flags().Set("timeout", "60"))
+
+			err := c.runEvacuate(cmd, []string{"pve-1"})
+			if testCase.expectedError == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, testCase.expectedError)
+			}
+
+			for name, handle := range testCase.expectedHandles {
+				pv, err := kclient.CoreV1().PersistentVolumes().Get(context.Background(), name, metav1.GetOptions{})
+				require.NoError(t, err)
+				assert.Equal(t, handle, pv.Spec.CSI.VolumeHandle, name)
+			}
+
+			calls := httpmock.GetCallCountInfo()
+			assert.Equal(t, testCase.expectedCopies, calls["POST "+diskURL])
+			assert.Equal(t, testCase.expectedDeletes, calls["DELETE "+diskURL])
+		})
+	}
+}
@@ -0,0 +1,212 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	cobra "github.com/spf13/cobra"
+
+	csiconfig "github.com/sergelogvinov/proxmox-csi-plugin/pkg/config"
+	"github.com/sergelogvinov/proxmox-csi-plugin/pkg/gc"
+	pxpool "github.com/sergelogvinov/proxmox-csi-plugin/pkg/proxmoxpool"
+	tools "github.com/sergelogvinov/proxmox-csi-plugin/pkg/tools/kubernetes"
+
+	rbacv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/client-go/dynamic"
+	clientkubernetes "k8s.io/client-go/kubernetes"
+)
+
+type gcCmd struct {
+	pclient  *pxpool.ProxmoxPool
+	kclient  clientkubernetes.Interface
+	dclient  dynamic.Interface
+	features csiconfig.ClustersConfig
+}
+
+// gcItem is the orphaned disk in the report of the gc command.
+type gcItem struct {
+	orphan gc.Orphan
+	age    string
+	status string
+	err    error
+}
+
+func buildGCCmd() *cobra.Command {
+	c := &gcCmd{}
+
+	cmd := cobra.Command{
+		Use:           "gc",
+		Short:         "Find the orphaned disks on Proxmox storages and delete them",
+		Args:          cobra.NoArgs,
+		PreRunE:       c.gcValidate,
+		RunE:          c.runGC,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+
+	setGCCmdFlags(&cmd)
+
+	return &cmd
+}
+
+func setGCCmdFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+
+	flags.Bool("delete", false, "delete the orphaned disks older than the grace period, by default the disks are only reported")
+	flags.Duration("grace-period", 24*time.Hour, "minimum age of the orphaned disk before it is deleted, at least the copy task timeout, the disks of unknown age are not deleted")
+}
+
+func (c *gcCmd) runGC(cmd *cobra.Command, _ []string) error {
+	flags := cmd.Flags()
+	deleteOrphans, _ := flags.GetBool("delete")         //nolint: errcheck
+	gracePeriod, _ := flags.GetDuration("grace-period") //nolint: errcheck
+
+	if minGracePeriod := gc.MinGracePeriod(c.features); deleteOrphans && gracePeriod < minGracePeriod {
+		return fmt.Errorf("grace period %s is shorter than the copy task timeout %s", gracePeriod, minGracePeriod)
+	}
+
+	ctx := context.Background()
+
+	collector := gc.NewCollector(c.pclient, c.kclient, c.dclient, c.features)
+
+	orphans, err := collector.Orphans(ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(orphans) == 0 {
+		logger.Infof("no orphaned disks found")
+
+		return nil
+	}
+
+	now := time.Now()
+	items := make([]gcItem, 0, len(orphans))
+
+	for _, orphan := range orphans {
+		item := gcItem{orphan: orphan, age: "unknown", status: "orphaned"}
+
+		// The disk has been found right now, the age is known only from the creation time
+		age := orphan.Age(now)
+		if !orphan.Created.IsZero() {
+			item.age = age.Round(time.Minute).String()
+		}
+
+		if deleteOrphans {
+			if age < gracePeriod {
+				item.status = "kept, grace period"
+			} else if item.err = collector.Delete(ctx, orphan); item.err == nil {
+				item.status = "deleted"
+			}
+		}
+
+		items = append(items, item)
+	}
+
+	printGCReport(items)
+
+	failed := 0
+
+	for _, item := range items {
+		if item.err != nil {
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d orphaned disks were not deleted", failed, len(items))
+	}
+
+	return nil
+}
+
+func printGCReport(items []gcItem) {
+	w := tabwriter.NewWriter(logger.Logger.Out, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(w, "REGION\tNODE\tSTORAGE\tDISK\tSIZE\tAGE\tSTATUS")
+
+	for _, item := range items {
+		vol := item.orphan.Volume
+
+		status := item.status
+		if item.err != nil {
+			status = "error: " + item.err.Error()
+		}
+
+		node := vol.Node()
+		if item.orphan.Shared {
+			node += " (shared)"
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			vol.Region(), node, vol.Storage(), vol.Disk(), resource.NewQuantity(item.orphan.Size, resource.BinarySI).String(),
+			item.age, status)
+	}
+
+	w.Flush() //nolint: errcheck
+}
+
+func (c *gcCmd) gcValidate(_ *cobra.Command, _ []string) error {
+	cfg, err := csiconfig.ReadCloudConfigFromFile(cloudconfig)
+	if err != nil {
+		return fmt.Errorf("failed to read config: %v", err)
+	}
+
+	c.features = cfg
+
+	c.pclient, err = newProxmoxPool(cfg.Clusters)
+	if err != nil {
+		return fmt.Errorf("failed to create Proxmox cluster client: %v", err)
+	}
+
+	if err = c.pclient.CheckClusters(context.TODO()); err != nil {
+		return fmt.Errorf("failed to initialize Proxmox clusters: %v", err)
+	}
+
+	kclientConfig, _, err := tools.BuildConfig(kubeconfig, "")
+	if err != nil {
+		return fmt.Errorf("failed to create kubernetes config: %v", err)
+	}
+
+	c.kclient, err = clientkubernetes.NewForConfig(kclientConfig)
+	if err != nil {
+		return fmt.Errorf("failed to create kubernetes client: %v", err)
+	}
+
+	c.dclient, err = dynamic.NewForConfig(kclientConfig)
+	if err != nil {
+		return fmt.Errorf("failed to create kubernetes dynamic client: %v", err)
+	}
+
+	accessCheck := []rbacv1.ResourceAttributes{
+		{Group: "", Namespace: "", Resource: "persistentvolumes", Verb: "list"},
+		{Group: "snapshot.storage.k8s.io", Namespace: "", Resource: "volumesnapshotcontents", Verb: "list"},
+	}
+
+	if err = checkPermissions(context.TODO(), c.kclient, accessCheck); err != nil {
+		return err
+	}
+
+	c.kclient = dryRunKubeClient(c.kclient)
+
+	return nil
+}
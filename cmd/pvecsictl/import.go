@@ -0,0 +1,355 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	cobra "github.com/spf13/cobra"
+
+	goproxmox "github.com/sergelogvinov/go-proxmox"
+	csiconfig "github.com/sergelogvinov/proxmox-csi-plugin/pkg/config"
+	"github.com/sergelogvinov/proxmox-csi-plugin/pkg/csi"
+	pxpool "github.com/sergelogvinov/proxmox-csi-plugin/pkg/proxmoxpool"
+	tools "github.com/sergelogvinov/proxmox-csi-plugin/pkg/tools/kubernetes"
+	toolsproxmox "github.com/sergelogvinov/proxmox-csi-plugin/pkg/tools/proxmox"
+	volume "github.com/sergelogvinov/proxmox-csi-plugin/pkg/utils/volume"
+
+	rbacv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	clientkubernetes "k8s.io/client-go/kubernetes"
+)
+
+type importCmd struct {
+	pclient   *pxpool.ProxmoxPool
+	kclient   clientkubernetes.Interface
+	namespace string
+	features  csiconfig.ClustersConfig
+	storages  csiconfig.StorageAliases
+}
+
+func buildImportCmd() *cobra.Command {
+	c := &importCmd{}
+
+	cmd := cobra.Command{
+		Use:           "import pvc region/node/storage/disk",
+		Aliases:       []string{"im"},
+		Short:         "Import existing Proxmox disk as PersistentVolumeClaim",
+		Args:          cobra.ExactArgs(2),
+		PreRunE:       c.importValidate,
+		RunE:          c.runImport,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+
+	setImportCmdFlags(&cmd)
+
+	return &cmd
+}
+
+func setImportCmdFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+
+	flags.StringP("namespace", "n", "", "namespace of the persistentvolumeclaims")
+
+	flags.String("storage-class", "", "storage class of the persistentvolumeclaims")
+	flags.String("pv-name", "", "name of the persistentvolume, default is generated")
+	flags.Bool("rename", false, "rename the disk to the controller VM ID, so it is not deleted with the virtual machine")
+	flags.String("reclaim-policy", string(corev1.PersistentVolumeReclaimRetain),
+		fmt.Sprintf("reclaim policy of the persistentvolume, must be one of: %s, %s", corev1.PersistentVolumeReclaimRetain, corev1.PersistentVolumeReclaimDelete))
+	flags.String("access-mode", string(corev1.ReadWriteOnce),
+		fmt.Sprintf("access mode of the persistentvolumeclaims, must be one of: %s, %s", corev1.ReadWriteOnce, corev1.ReadWriteOncePod))
+	flags.Int("timeout", 10800, "task timeout in seconds")
+
+	cmd.MarkFlagRequired("storage-class") //nolint: errcheck
+}
+
+// nolint: cyclop, gocyclo
+func (c *importCmd) runImport(cmd *cobra.Command, args []string) error {
+	flags := cmd.Flags()
+	storageClass, _ := flags.GetString("storage-class")   //nolint: errcheck
+	pvName, _ := flags.GetString("pv-name")               //nolint: errcheck
+	rename, _ := flags.GetBool("rename")                  //nolint: errcheck
+	reclaimPolicy, _ := flags.GetString("reclaim-policy") //nolint: errcheck
+	accessMode, _ := flags.GetString("access-mode")       //nolint: errcheck
+	taskTimeout, _ := flags.GetInt("timeout")             //nolint: errcheck
+
+	if policy := corev1.PersistentVolumeReclaimPolicy(reclaimPolicy); policy != corev1.PersistentVolumeReclaimRetain && policy != corev1.PersistentVolumeReclaimDelete {
+		return fmt.Errorf("invalid reclaim policy %s, must be one of: %s, %s", reclaimPolicy, corev1.PersistentVolumeReclaimRetain, corev1.PersistentVolumeReclaimDelete)
+	}
+
+	// The volumes are attached to one node only
+	if mode := corev1.PersistentVolumeAccessMode(accessMode); mode != corev1.ReadWriteOnce && mode != corev1.ReadWriteOncePod {
+		return fmt.Errorf("invalid access mode %s, must be one of: %s, %s", accessMode, corev1.ReadWriteOnce, corev1.ReadWriteOncePod)
+	}
+
+	ctx := context.Background()
+	pvc := args[0]
+
+	vol, err := volume.NewVolumeFromVolumeID(args[1])
+	if err != nil {
+		return fmt.Errorf("failed to parse volume ID: %v", err)
+	}
+
+	if vol.Node() == "" || vol.Snapshot() != "" {
+		return fmt.Errorf("volume %s must be in the format of region/node/storage/disk", args[1])
+	}
+
+	if pvName == "" {
+		pvName = "pvc-" + string(uuid.NewUUID())
+	}
+
+	sc, err := c.kclient.StorageV1().StorageClasses().Get(ctx, storageClass, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get storageclass %s: %v", storageClass, err)
+	}
+
+	if sc.Provisioner != csi.DriverName {
+		return fmt.Errorf("storageclass %s is not provisioned by Proxmox CSI driver", storageClass)
+	}
+
+	params, err := csi.ExtractParameters(sc.Parameters)
+	if err != nil {
+		return fmt.Errorf("failed to parse storageclass %s parameters: %v", storageClass, err)
+	}
+
+	storages := []string{}
+	for _, storage := range params.StorageIDs() {
+		storages = append(storages, c.storages.Resolve(vol.Region(), vol.Node(), storage))
+	}
+
+	if !slices.Contains(storages, vol.Storage()) {
+		return fmt.Errorf("storageclass %s uses storage %s, volume is on storage %s", storageClass, params.StorageID, vol.Storage())
+	}
+
+	if _, err = c.kclient.CoreV1().PersistentVolumeClaims(c.namespace).Get(ctx, pvc, metav1.GetOptions{}); err == nil {
+		return fmt.Errorf("persistentvolumeclaims %s already exists", pvc)
+	} else if !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to get persistentvolumeclaims %s: %v", pvc, err)
+	}
+
+	cluster, err := c.pclient.GetProxmoxCluster(vol.Cluster())
+	if err != nil {
+		return fmt.Errorf("failed to get Proxmox cluster: %v", err)
+	}
+
+	storage, err := cluster.GetClusterStorage(ctx, vol.Storage())
+	if err != nil {
+		return fmt.Errorf("failed to get storage %s: %v", vol.Storage(), err)
+	}
+
+	size, err := toolsproxmox.GetVolumeSize(ctx, cluster, vol)
+	if err != nil {
+		return err
+	}
+
+	vmID := c.features.RegionFeatures(vol.Region()).ControllerVMID
+
+	if id, err := strconv.Atoi(vol.VMID()); err == nil && id != vmID {
+		vm, err := cluster.GetVMConfig(ctx, id)
+		if err != nil && !errors.Is(err, goproxmox.ErrVirtualMachineNotFound) {
+			return fmt.Errorf("failed to get vm config: %v", err)
+		}
+
+		if vm != nil && vm.VirtualMachineConfig != nil {
+			for device, disk := range vm.VirtualMachineConfig.MergeDisks() {
+				if strings.Split(disk, ",")[0] == vol.VolID() {
+					return fmt.Errorf("disk %s is attached to vm %d as %s, detach it first", vol.VolID(), id, device)
+				}
+			}
+
+			if !rename {
+				logger.Warnf("disk %s belongs to vm %d and will be deleted with it, use --rename to keep it", vol.VolID(), id)
+			}
+		}
+	}
+
+	if rename {
+		format := strings.TrimPrefix(path.Ext(vol.Disk()), ".")
+		newVol := volume.NewVolume(vol.Region(), vol.Node(), vol.Storage(), fmt.Sprintf("vm-%d-%s", vmID, pvName), format)
+
+		logger.Infof("renaming disk %s to %s", vol.Disk(), newVol.Disk())
+
+		if err = toolsproxmox.RenameVolume(ctx, cluster, vol, newVol.Disk(), taskTimeout); err != nil {
+			return fmt.Errorf("failed to rename disk: %v", err)
+		}
+
+		vol = newVol
+	}
+
+	// The storage class can list several storages, the volume attributes keep the storage of the disk
+	params.StorageID = vol.Storage()
+
+	fsType := sc.Parameters["csi.storage.k8s.io/fstype"]
+	if fsType == "" {
+		fsType = "ext4"
+	}
+
+	volumeHandle := vol.VolumeID()
+	affinity := []corev1.NodeSelectorRequirement{
+		{
+			Key:      corev1.LabelTopologyRegion,
+			Operator: corev1.NodeSelectorOpIn,
+			Values:   []string{vol.Region()},
+		},
+	}
+
+	if storage.Shared == 1 {
+		volumeHandle = vol.VolumeSharedID()
+	} else {
+		affinity = append(affinity, corev1.NodeSelectorRequirement{
+			Key:      corev1.LabelTopologyZone,
+			Operator: corev1.NodeSelectorOpIn,
+			Values:   []string{vol.Node()},
+		})
+	}
+
+	capacity := *resource.NewQuantity(size, resource.BinarySI)
+
+	newPV := &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: pvName,
+			Annotations: map[string]string{
+				"pv.kubernetes.io/provisioned-by": csi.DriverName,
+			},
+		},
+		Spec: corev1.PersistentVolumeSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.PersistentVolumeAccessMode(accessMode)},
+			Capacity: corev1.ResourceList{
+				corev1.ResourceStorage: capacity,
+			},
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				CSI: &corev1.CSIPersistentVolumeSource{
+					Driver:           csi.DriverName,
+					FSType:           fsType,
+					VolumeHandle:     volumeHandle,
+					VolumeAttributes: params.ToMap(),
+				},
+			},
+			ClaimRef: &corev1.ObjectReference{
+				Kind:       "PersistentVolumeClaim",
+				APIVersion: "v1",
+				Namespace:  c.namespace,
+				Name:       pvc,
+			},
+			PersistentVolumeReclaimPolicy: corev1.PersistentVolumeReclaimPolicy(reclaimPolicy),
+			StorageClassName:              storageClass,
+			NodeAffinity: &corev1.VolumeNodeAffinity{
+				Required: &corev1.NodeSelector{
+					NodeSelectorTerms: []corev1.NodeSelectorTerm{{MatchExpressions: affinity}},
+				},
+			},
+		},
+	}
+
+	newPVC := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      pvc,
+			Namespace: c.namespace,
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes:      []corev1.PersistentVolumeAccessMode{corev1.PersistentVolumeAccessMode(accessMode)},
+			StorageClassName: &storageClass,
+			VolumeName:       pvName,
+			Resources: corev1.VolumeResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceStorage: capacity,
+				},
+			},
+		},
+	}
+
+	logger.Infof("creating persistentvolume %s with volume %s and size %s", pvName, volumeHandle, capacity.String())
+
+	if _, err = c.kclient.CoreV1().PersistentVolumes().Create(ctx, newPV, metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("failed to create PersistentVolume: %v", err)
+	}
+
+	if _, err = tools.PVCCreateOrUpdate(ctx, c.kclient, newPVC); err != nil {
+		return fmt.Errorf("failed to create/update PersistentVolumeClaim %s: %v", pvc, err)
+	}
+
+	logger.Infof("disk %s has been imported as persistentvolumeclaims %s", vol.VolID(), pvc)
+
+	return nil
+}
+
+// nolint: dupl
+func (c *importCmd) importValidate(cmd *cobra.Command, _ []string) error {
+	flags := cmd.Flags()
+
+	cfg, err := csiconfig.ReadCloudConfigFromFile(cloudconfig)
+	if err != nil {
+		return fmt.Errorf("failed to read config: %v", err)
+	}
+
+	for _, c := range cfg.Clusters {
+		if c.Username == "" || c.Password == "" {
+			return fmt.Errorf("this command requires Proxmox root account, please provide username and password in config file (cluster=%s)", c.Region)
+		}
+	}
+
+	c.features = cfg
+	c.storages = cfg.Storages
+
+	c.pclient, err = newProxmoxPool(cfg.Clusters)
+	if err != nil {
+		return fmt.Errorf("failed to create Proxmox cluster client: %v", err)
+	}
+
+	if err = c.pclient.CheckClusters(context.TODO()); err != nil {
+		return fmt.Errorf("failed to initialize Proxmox clusters: %v", err)
+	}
+
+	namespace, _ := flags.GetString("namespace") //nolint: errcheck
+
+	kclientConfig, namespace, err := tools.BuildConfig(kubeconfig, namespace)
+	if err != nil {
+		return fmt.Errorf("failed to create kubernetes config: %v", err)
+	}
+
+	c.kclient, err = clientkubernetes.NewForConfig(kclientConfig)
+	if err != nil {
+		return fmt.Errorf("failed to create kubernetes client: %v", err)
+	}
+
+	c.namespace = namespace
+
+	accessCheck := []rbacv1.ResourceAttributes{
+		{Group: "storage.k8s.io", Namespace: "", Resource: "storageclasses", Verb: "get"},
+		{Group: "", Namespace: "", Resource: "persistentvolumeclaims", Verb: "create"},
+		{Group: "", Namespace: "", Resource: "persistentvolumes", Verb: "create"},
+	}
+
+	if err = checkPermissions(context.TODO(), c.kclient, accessCheck); err != nil {
+		return err
+	}
+
+	c.kclient = dryRunKubeClient(c.kclient)
+
+	return nil
+}
@@ -0,0 +1,562 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	cobra "github.com/spf13/cobra"
+
+	"github.com/sergelogvinov/proxmox-csi-plugin/pkg/csi"
+	tools "github.com/sergelogvinov/proxmox-csi-plugin/pkg/tools/kubernetes"
+
+	rbacv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	clientkubernetes "k8s.io/client-go/kubernetes"
+)
+
+const (
+	journalDataKey = "journal.json"
+	journalLabel   = csi.DriverName + "/journal"
+
+	journalStarted    = "started"
+	journalCompleted  = "completed"
+	journalFailed     = "failed"
+	journalRolledBack = "rolledback"
+)
+
+// journal keeps the original PersistentVolumeClaims and PersistentVolumes and the changes of them,
+// it is saved before each change, so the previous state can be restored if the command fails halfway.
+type journal struct {
+	kclient clientkubernetes.Interface
+	saved   bool
+
+	Name      string                         `json:"name"`
+	Command   string                         `json:"command"`
+	Namespace string                         `json:"namespace"`
+	State     string                         `json:"state"`
+	Created   metav1.Time                    `json:"created"`
+	Claims    []corev1.PersistentVolumeClaim `json:"claims"`
+	Volumes   []corev1.PersistentVolume      `json:"volumes"`
+	Steps     []journalStep                  `json:"steps"`
+}
+
+// journalStep is a change of the Kubernetes object, it is recorded before the change is applied.
+type journalStep struct {
+	Action    string `json:"action"`
+	Resource  string `json:"resource"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+}
+
+// newJournal saves the original objects before the command changes them.
+func newJournal(
+	ctx context.Context,
+	kclient clientkubernetes.Interface,
+	command string,
+	namespace string,
+	pvcs []*corev1.PersistentVolumeClaim,
+	pvs []*corev1.PersistentVolume,
+) (*journal, error) {
+	j := &journal{
+		kclient:   kclient,
+		Name:      fmt.Sprintf("pvecsictl-%s-%s-%s", command, time.Now().UTC().Format("20060102150405"), utilrand.String(5)),
+		Command:   command,
+		Namespace: namespace,
+		State:     journalStarted,
+		Created:   metav1.Now(),
+	}
+
+	for _, pvc := range pvcs {
+		obj := pvc.DeepCopy()
+		obj.ManagedFields = nil
+
+		j.Claims = append(j.Claims, *obj)
+	}
+
+	for _, pv := range pvs {
+		obj := pv.DeepCopy()
+		obj.ManagedFields = nil
+
+		j.Volumes = append(j.Volumes, *obj)
+	}
+
+	if err := j.save(ctx); err != nil {
+		return nil, fmt.Errorf("failed to save journal: %v", err)
+	}
+
+	if plan == nil {
+		logger.Infof("journal %s has been saved", j.Name)
+	}
+
+	return j, nil
+}
+
+// journalAccessCheck returns the permissions to save the journal to the ConfigMap.
+func journalAccessCheck(namespace string) []rbacv1.ResourceAttributes {
+	if journalDir != "" {
+		return nil
+	}
+
+	return []rbacv1.ResourceAttributes{
+		{Group: "", Namespace: namespace, Resource: "configmaps", Verb: "create"},
+		{Group: "", Namespace: namespace, Resource: "configmaps", Verb: "update"},
+	}
+}
+
+// loadJournal reads the journal from the file or the ConfigMap in the namespace.
+func loadJournal(ctx context.Context, kclient clientkubernetes.Interface, namespace, name string) (*journal, error) {
+	var data []byte
+
+	if journalDir != "" {
+		var err error
+
+		if data, err = os.ReadFile(filepath.Join(journalDir, name+".json")); err != nil {
+			return nil, fmt.Errorf("failed to read journal: %v", err)
+		}
+	} else {
+		cm, err := kclient.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get journal: %v", err)
+		}
+
+		data = []byte(cm.Data[journalDataKey])
+	}
+
+	j := &journal{kclient: kclient, saved: true}
+	if err := json.Unmarshal(data, j); err != nil {
+		return nil, fmt.Errorf("failed to parse journal %s: %v", name, err)
+	}
+
+	return j, nil
+}
+
+// save writes the journal to the file or the ConfigMap, the journal is not saved in dry-run mode.
+func (j *journal) save(ctx context.Context) error {
+	if plan != nil {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if journalDir != "" {
+		if err = os.MkdirAll(journalDir, 0o700); err != nil {
+			return err
+		}
+
+		return os.WriteFile(filepath.Join(journalDir, j.Name+".json"), data, 0o600)
+	}
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      j.Name,
+			Namespace: j.Namespace,
+			Labels:    map[string]string{journalLabel: j.Command},
+		},
+		Data: map[string]string{journalDataKey: string(data)},
+	}
+
+	if j.saved {
+		_, err = j.kclient.CoreV1().ConfigMaps(j.Namespace).Update(ctx, cm, metav1.UpdateOptions{})
+	} else {
+		_, err = j.kclient.CoreV1().ConfigMaps(j.Namespace).Create(ctx, cm, metav1.CreateOptions{})
+	}
+
+	if err == nil {
+		j.saved = true
+	}
+
+	return err
+}
+
+// step records the change before it is applied.
+func (j *journal) step(ctx context.Context, action, resource, namespace, name string) error {
+	j.Steps = append(j.Steps, journalStep{Action: action, Resource: resource, Namespace: namespace, Name: name})
+
+	if err := j.save(ctx); err != nil {
+		return fmt.Errorf("failed to save journal: %v", err)
+	}
+
+	return nil
+}
+
+// finish marks the journal as completed or failed.
+func (j *journal) finish(ctx context.Context, err error) {
+	j.State = journalCompleted
+	if err != nil {
+		j.State = journalFailed
+	}
+
+	if serr := j.save(ctx); serr != nil {
+		logger.Errorf("failed to save journal %s: %v", j.Name, serr)
+	}
+
+	if err != nil && plan == nil {
+		logger.Warnf("to restore the previous state run: pvecsictl journal restore %s -n %s", j.Name, j.Namespace)
+	}
+}
+
+// restore returns the PersistentVolumeClaims and PersistentVolumes to the state saved in the journal.
+// The objects created by the command are deleted, the disks of them are kept.
+// nolint: cyclop, gocyclo
+func (j *journal) restore(ctx context.Context) error {
+	claims := map[string]*corev1.PersistentVolumeClaim{}
+	for i := range j.Claims {
+		claims[j.Claims[i].Namespace+"/"+j.Claims[i].Name] = &j.Claims[i]
+	}
+
+	volumes := map[string]*corev1.PersistentVolume{}
+	for i := range j.Volumes {
+		volumes[j.Volumes[i].Name] = &j.Volumes[i]
+	}
+
+	// The claims which were created by the command, and the original ones
+	claimKeys := []types.NamespacedName{}
+	volumeNames := []string{}
+
+	for _, s := range j.Steps {
+		if s.Action != "create" {
+			continue
+		}
+
+		switch s.Resource {
+		case "persistentvolumeclaims":
+			claimKeys = append(claimKeys, types.NamespacedName{Namespace: s.Namespace, Name: s.Name})
+		case "persistentvolumes":
+			volumeNames = append(volumeNames, s.Name)
+		}
+	}
+
+	for _, pvc := range j.Claims {
+		claimKeys = append(claimKeys, types.NamespacedName{Namespace: pvc.Namespace, Name: pvc.Name})
+	}
+
+	for _, key := range claimKeys {
+		pods, vmName, err := tools.PVCPodUsage(ctx, j.kclient, key.Namespace, key.Name)
+		if err != nil {
+			return fmt.Errorf("failed to find pods using pvc: %v", err)
+		}
+
+		if len(pods) > 0 {
+			return fmt.Errorf("persistentvolumeclaims %s is using by pods: %s on node %s, cannot rollback", key, pods, vmName)
+		}
+	}
+
+	retain := []byte(`{"spec":{"persistentVolumeReclaimPolicy":"` + corev1.PersistentVolumeReclaimRetain + `"}}`)
+	kept := map[string]bool{}
+
+	for _, key := range claimKeys {
+		pvc, err := j.kclient.CoreV1().PersistentVolumeClaims(key.Namespace).Get(ctx, key.Name, metav1.GetOptions{})
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+
+			return fmt.Errorf("failed to get PersistentVolumeClaim %s: %v", key, err)
+		}
+
+		if orig := claims[key.String()]; orig != nil && orig.UID == pvc.UID {
+			kept[key.String()] = true
+
+			continue
+		}
+
+		// The disk of the claim must not be deleted by the provisioner
+		if pvc.Spec.VolumeName != "" {
+			if _, err = j.kclient.CoreV1().PersistentVolumes().Patch(ctx, pvc.Spec.VolumeName, types.MergePatchType, retain, metav1.PatchOptions{}); err != nil && !apierrors.IsNotFound(err) {
+				return fmt.Errorf("failed to patch PersistentVolume %s: %v", pvc.Spec.VolumeName, err)
+			}
+		}
+
+		logger.Infof("deleting persistentvolumeclaims %s", key)
+
+		if err = j.kclient.CoreV1().PersistentVolumeClaims(key.Namespace).Delete(ctx, key.Name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete PersistentVolumeClaim %s: %v", key, err)
+		}
+
+		if err = j.waitPVCDelete(ctx, key); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range volumeNames {
+		if volumes[name] != nil {
+			continue
+		}
+
+		if err := j.deleteVolume(ctx, name); err != nil {
+			return err
+		}
+	}
+
+	for _, orig := range j.Volumes {
+		pv, err := j.kclient.CoreV1().PersistentVolumes().Get(ctx, orig.Name, metav1.GetOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to get PersistentVolume %s: %v", orig.Name, err)
+		}
+
+		claimKey := ""
+		if orig.Spec.ClaimRef != nil {
+			claimKey = orig.Spec.ClaimRef.Namespace + "/" + orig.Spec.ClaimRef.Name
+		}
+
+		if err == nil && pv.UID == orig.UID {
+			if kept[claimKey] {
+				if pv.Spec.PersistentVolumeReclaimPolicy != orig.Spec.PersistentVolumeReclaimPolicy {
+					patch := []byte(`{"spec":{"persistentVolumeReclaimPolicy":"` + orig.Spec.PersistentVolumeReclaimPolicy + `"}}`)
+					if _, err = j.kclient.CoreV1().PersistentVolumes().Patch(ctx, orig.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+						return fmt.Errorf("failed to patch PersistentVolume %s: %v", orig.Name, err)
+					}
+				}
+
+				continue
+			}
+
+			// Bind the volume to the restored claim
+			claimRef := "null"
+			if orig.Spec.ClaimRef != nil {
+				claimRef = fmt.Sprintf(`{"namespace":%q,"name":%q,"uid":null,"resourceVersion":null}`, orig.Spec.ClaimRef.Namespace, orig.Spec.ClaimRef.Name)
+			}
+
+			patch := []byte(`{"spec":{"claimRef":` + claimRef + `,"persistentVolumeReclaimPolicy":"` + string(orig.Spec.PersistentVolumeReclaimPolicy) + `"}}`)
+
+			logger.Infof("binding persistentvolume %s to persistentvolumeclaims %s", orig.Name, claimKey)
+
+			if _, err = j.kclient.CoreV1().PersistentVolumes().Patch(ctx, orig.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+				return fmt.Errorf("failed to patch PersistentVolume %s: %v", orig.Name, err)
+			}
+
+			continue
+		}
+
+		if err == nil {
+			if err = j.deleteVolume(ctx, orig.Name); err != nil {
+				return err
+			}
+		}
+
+		newPV := orig.DeepCopy()
+		newPV.ObjectMeta = metav1.ObjectMeta{
+			Name:        orig.Name,
+			Labels:      orig.Labels,
+			Annotations: orig.Annotations,
+			Finalizers:  orig.Finalizers,
+		}
+		newPV.Status = corev1.PersistentVolumeStatus{}
+
+		if orig.Spec.ClaimRef != nil {
+			newPV.Spec.ClaimRef = &corev1.ObjectReference{
+				Kind:       "PersistentVolumeClaim",
+				APIVersion: "v1",
+				Namespace:  orig.Spec.ClaimRef.Namespace,
+				Name:       orig.Spec.ClaimRef.Name,
+			}
+		}
+
+		logger.Infof("creating persistentvolume %s", orig.Name)
+
+		if _, err = j.kclient.CoreV1().PersistentVolumes().Create(ctx, newPV, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("failed to create PersistentVolume %s: %v", orig.Name, err)
+		}
+	}
+
+	for _, orig := range j.Claims {
+		if kept[orig.Namespace+"/"+orig.Name] {
+			continue
+		}
+
+		newPVC := orig.DeepCopy()
+		newPVC.ObjectMeta = metav1.ObjectMeta{
+			Name:        orig.Name,
+			Namespace:   orig.Namespace,
+			Labels:      orig.Labels,
+			Annotations: orig.Annotations,
+			Finalizers:  orig.Finalizers,
+		}
+		newPVC.Status = corev1.PersistentVolumeClaimStatus{}
+		newPVC.Spec.Resources.Requests = corev1.ResourceList{
+			corev1.ResourceStorage: orig.Status.Capacity[corev1.ResourceStorage],
+		}
+
+		logger.Infof("creating persistentvolumeclaims %s/%s", orig.Namespace, orig.Name)
+
+		if _, err := tools.PVCCreateOrUpdate(ctx, j.kclient, newPVC); err != nil {
+			return fmt.Errorf("failed to create PersistentVolumeClaim %s/%s: %v", orig.Namespace, orig.Name, err)
+		}
+	}
+
+	j.State = journalRolledBack
+
+	return j.save(ctx)
+}
+
+// deleteVolume deletes the PersistentVolume created by the command, the disk of it is kept.
+func (j *journal) deleteVolume(ctx context.Context, name string) error {
+	patch := []byte(`{"metadata":{"finalizers":null},"spec":{"persistentVolumeReclaimPolicy":"` + corev1.PersistentVolumeReclaimRetain + `"}}`)
+	if _, err := j.kclient.CoreV1().PersistentVolumes().Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+
+		return fmt.Errorf("failed to patch PersistentVolume %s: %v", name, err)
+	}
+
+	logger.Infof("deleting persistentvolume %s", name)
+
+	if err := j.kclient.CoreV1().PersistentVolumes().Delete(ctx, name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete PersistentVolume %s: %v", name, err)
+	}
+
+	if err := tools.PVWaitDelete(ctx, j.kclient, name); err != nil {
+		return fmt.Errorf("failed to wait for PersistentVolume %s deletion: %v", name, err)
+	}
+
+	return nil
+}
+
+func (j *journal) waitPVCDelete(ctx context.Context, key types.NamespacedName) error {
+	timeout := time.After(5 * time.Minute)
+
+	for {
+		if _, err := j.kclient.CoreV1().PersistentVolumeClaims(key.Namespace).Get(ctx, key.Name, metav1.GetOptions{}); err != nil {
+			if apierrors.IsNotFound(err) {
+				return nil
+			}
+
+			return fmt.Errorf("failed to get PersistentVolumeClaim %s: %v", key, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timeout:
+			return fmt.Errorf("timeout waiting for PersistentVolumeClaim %s deletion", key)
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+type journalCmd struct {
+	kclient   clientkubernetes.Interface
+	namespace string
+}
+
+func buildJournalCmd() *cobra.Command {
+	c := &journalCmd{}
+
+	cmd := cobra.Command{
+		Use:           "journal",
+		Short:         "Manage the journals of rename, swap and migrate commands",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+
+	restoreCmd := cobra.Command{
+		Use:           "restore name",
+		Short:         "Restore the previous state of PersistentVolumeClaims from the journal",
+		Args:          cobra.ExactArgs(1),
+		PreRunE:       c.journalValidate,
+		RunE:          c.runRestore,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+
+	restoreCmd.Flags().StringP("namespace", "n", "", "namespace of the journal")
+
+	cmd.AddCommand(&restoreCmd)
+
+	return &cmd
+}
+
+func (c *journalCmd) runRestore(_ *cobra.Command, args []string) error {
+	ctx := context.Background()
+	name := args[0]
+
+	j, err := loadJournal(ctx, c.kclient, c.namespace, name)
+	if err != nil {
+		return err
+	}
+
+	switch j.State {
+	case journalRolledBack:
+		return fmt.Errorf("journal %s has been rolled back already", name)
+	case journalCompleted:
+		// The source disk is deleted after the migration
+		if j.Command == "migrate" {
+			return fmt.Errorf("migration of journal %s is completed, the source disk does not exist anymore", name)
+		}
+	}
+
+	logger.Infof("restoring %s of persistentvolumeclaims from journal %s (%s)", j.Command, name, j.State)
+
+	if err = j.restore(ctx); err != nil {
+		return fmt.Errorf("failed to restore journal %s: %v", name, err)
+	}
+
+	logger.Infof("journal %s has been rolled back", name)
+
+	return nil
+}
+
+// journalValidate creates the kubernetes client only, the journal restores the Kubernetes objects.
+func (c *journalCmd) journalValidate(cmd *cobra.Command, _ []string) error {
+	flags := cmd.Flags()
+
+	namespace, _ := flags.GetString("namespace") //nolint: errcheck
+
+	kclientConfig, namespace, err := tools.BuildConfig(kubeconfig, namespace)
+	if err != nil {
+		return fmt.Errorf("failed to create kubernetes config: %v", err)
+	}
+
+	c.kclient, err = clientkubernetes.NewForConfig(kclientConfig)
+	if err != nil {
+		return fmt.Errorf("failed to create kubernetes client: %v", err)
+	}
+
+	c.namespace = namespace
+
+	accessCheck := []rbacv1.ResourceAttributes{
+		{Group: "", Namespace: "", Resource: "persistentvolumeclaims", Verb: "create"},
+		{Group: "", Namespace: "", Resource: "persistentvolumeclaims", Verb: "delete"},
+		{Group: "", Namespace: "", Resource: "persistentvolumes", Verb: "create"},
+		{Group: "", Namespace: "", Resource: "persistentvolumes", Verb: "delete"},
+		{Group: "", Namespace: "", Resource: "persistentvolumes", Verb: "patch"},
+		{Group: "", Namespace: "", Resource: "pods", Verb: "list"},
+	}
+	accessCheck = append(accessCheck, journalAccessCheck(namespace)...)
+
+	if err = checkPermissions(context.TODO(), c.kclient, accessCheck); err != nil {
+		return err
+	}
+
+	c.kclient = dryRunKubeClient(c.kclient)
+
+	return nil
+}
@@ -0,0 +1,306 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func journalPVC(name, pvName string, uid types.UID) *corev1.PersistentVolumeClaim {
+	pvc := testPVC(name, pvName)
+	pvc.UID = uid
+
+	return pvc
+}
+
+func journalPV(name, handle, pvc string, uid types.UID, policy corev1.PersistentVolumeReclaimPolicy) *corev1.PersistentVolume {
+	pv := testPV(name, handle)
+	pv.UID = uid
+	pv.Spec.ClaimRef = &corev1.ObjectReference{Namespace: "default", Name: pvc, UID: "uid-claim"}
+	pv.Spec.PersistentVolumeReclaimPolicy = policy
+
+	return pv
+}
+
+// journalChanges returns the changes of the persistentvolumeclaims and persistentvolumes in the order they were made.
+func journalChanges(kclient *fake.Clientset) []string {
+	changes := []string{}
+
+	for _, action := range kclient.Actions() {
+		resource := action.GetResource().Resource
+		if resource != "persistentvolumeclaims" && resource != "persistentvolumes" {
+			continue
+		}
+
+		switch a := action.(type) {
+		case k8stesting.CreateAction:
+			obj, err := meta.Accessor(a.GetObject())
+			if err != nil {
+				continue
+			}
+
+			changes = append(changes, "create "+resource+"/"+obj.GetName())
+		case k8stesting.PatchAction:
+			changes = append(changes, "patch "+resource+"/"+a.GetName()+" "+string(a.GetPatch()))
+		case k8stesting.DeleteAction:
+			changes = append(changes, "delete "+resource+"/"+a.GetName())
+		}
+	}
+
+	return changes
+}
+
+func TestJournalRestore(t *testing.T) {
+	origPVC := journalPVC("storage-test-0", "pvc-1", "uid-claim")
+	origPV := journalPV("pvc-1", "cluster-1/pve-1/local-lvm/vm-9999-pvc-1", "storage-test-0", "uid-volume", corev1.PersistentVolumeReclaimDelete)
+
+	tests := []struct {
+		msg             string
+		objects         []runtime.Object
+		steps           []journalStep
+		expectedError   string
+		expectedChanges []string
+		expectedClaims  []string
+		expectedHandle  string
+	}{
+		{
+			msg: "ReplacedVolume",
+			objects: []runtime.Object{
+				journalPVC("storage-test-0", "pvc-1", "uid-claim-2"),
+				journalPV("pvc-1", "cluster-1/pve-2/local-lvm/vm-9999-pvc-1", "storage-test-0", "uid-volume-2", corev1.PersistentVolumeReclaimDelete),
+			},
+			steps: []journalStep{
+				{Action: "patch", Resource: "persistentvolumes", Name: "pvc-1"},
+				{Action: "delete", Resource: "persistentvolumeclaims", Namespace: "default", Name: "storage-test-0"},
+				{Action: "delete", Resource: "persistentvolumes", Name: "pvc-1"},
+				{Action: "create", Resource: "persistentvolumes", Name: "pvc-1"},
+				{Action: "create", Resource: "persistentvolumeclaims", Namespace: "default", Name: "storage-test-0"},
+			},
+			// The disks of the new objects are kept, the volumes are retained before the deletion.
+			expectedChanges: []string{
+				`patch persistentvolumes/pvc-1 {"spec":{"persistentVolumeReclaimPolicy":"Retain"}}`,
+				"delete persistentvolumeclaims/storage-test-0",
+				`patch persistentvolumes/pvc-1 {"metadata":{"finalizers":null},"spec":{"persistentVolumeReclaimPolicy":"Retain"}}`,
+				"delete persistentvolumes/pvc-1",
+				"create persistentvolumes/pvc-1",
+				"create persistentvolumeclaims/storage-test-0",
+			},
+			expectedClaims: []string{"storage-test-0"},
+			expectedHandle: "cluster-1/pve-1/local-lvm/vm-9999-pvc-1",
+		},
+		{
+			msg: "RenamedClaim",
+			objects: []runtime.Object{
+				journalPVC("storage-test-1", "pvc-1", "uid-claim-2"),
+				journalPV("pvc-1", "cluster-1/pve-1/local-lvm/vm-9999-pvc-1", "storage-test-1", "uid-volume", corev1.PersistentVolumeReclaimRetain),
+			},
+			steps: []journalStep{
+				{Action: "patch", Resource: "persistentvolumes", Name: "pvc-1"},
+				{Action: "delete", Resource: "persistentvolumeclaims", Namespace: "default", Name: "storage-test-0"},
+				{Action: "create", Resource: "persistentvolumeclaims", Namespace: "default", Name: "storage-test-1"},
+			},
+			// The volume is bound to the restored claim only after the new claim is deleted.
+			expectedChanges: []string{
+				`patch persistentvolumes/pvc-1 {"spec":{"persistentVolumeReclaimPolicy":"Retain"}}`,
+				"delete persistentvolumeclaims/storage-test-1",
+				`patch persistentvolumes/pvc-1 {"spec":{"claimRef":{"namespace":"default","name":"storage-test-0","uid":null,"resourceVersion":null},"persistentVolumeReclaimPolicy":"Delete"}}`,
+				"create persistentvolumeclaims/storage-test-0",
+			},
+			expectedClaims: []string{"storage-test-0"},
+			expectedHandle: "cluster-1/pve-1/local-lvm/vm-9999-pvc-1",
+		},
+		{
+			msg: "RetainedOnly",
+			objects: []runtime.Object{
+				origPVC.DeepCopy(),
+				journalPV("pvc-1", "cluster-1/pve-1/local-lvm/vm-9999-pvc-1", "storage-test-0", "uid-volume", corev1.PersistentVolumeReclaimRetain),
+			},
+			steps: []journalStep{
+				{Action: "patch", Resource: "persistentvolumes", Name: "pvc-1"},
+			},
+			expectedChanges: []string{
+				`patch persistentvolumes/pvc-1 {"spec":{"persistentVolumeReclaimPolicy":"Delete"}}`,
+			},
+			expectedClaims: []string{"storage-test-0"},
+			expectedHandle: "cluster-1/pve-1/local-lvm/vm-9999-pvc-1",
+		},
+		{
+			msg: "UsedByPods",
+			objects: []runtime.Object{
+				journalPVC("storage-test-1", "pvc-1", "uid-claim-2"),
+				journalPV("pvc-1", "cluster-1/pve-1/local-lvm/vm-9999-pvc-1", "storage-test-1", "uid-volume", corev1.PersistentVolumeReclaimRetain),
+				&corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{Name: "test-0", Namespace: "default"},
+					Spec: corev1.PodSpec{
+						NodeName: "node-1",
+						Volumes: []corev1.Volume{{
+							Name: "data",
+							VolumeSource: corev1.VolumeSource{
+								PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "storage-test-1"},
+							},
+						}},
+					},
+					Status: corev1.PodStatus{Phase: corev1.PodRunning},
+				},
+			},
+			steps: []journalStep{
+				{Action: "patch", Resource: "persistentvolumes", Name: "pvc-1"},
+				{Action: "delete", Resource: "persistentvolumeclaims", Namespace: "default", Name: "storage-test-0"},
+				{Action: "create", Resource: "persistentvolumeclaims", Namespace: "default", Name: "storage-test-1"},
+			},
+			expectedError:   "persistentvolumeclaims default/storage-test-1 is using by pods: [test-0] on node node-1, cannot rollback",
+			expectedChanges: []string{},
+			expectedClaims:  []string{"storage-test-1"},
+			expectedHandle:  "cluster-1/pve-1/local-lvm/vm-9999-pvc-1",
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.msg, func(t *testing.T) {
+			ctx := context.Background()
+			kclient := fake.NewClientset(testCase.objects...)
+
+			j := &journal{
+				kclient:   kclient,
+				Name:      "pvecsictl-test",
+				Command:   "test",
+				Namespace: "default",
+				State:     journalFailed,
+				Claims:    []corev1.PersistentVolumeClaim{*origPVC.DeepCopy()},
+				Volumes:   []corev1.PersistentVolume{*origPV.DeepCopy()},
+				Steps:     testCase.steps,
+			}
+
+			err := j.restore(ctx)
+			if testCase.expectedError == "" {
+				require.NoError(t, err)
+				assert.Equal(t, journalRolledBack, j.State)
+			} else {
+				require.EqualError(t, err, testCase.expectedError)
+				assert.Equal(t, journalFailed, j.State)
+			}
+
+			assert.Equal(t, testCase.expectedChanges, journalChanges(kclient))
+
+			pvcs, err := kclient.CoreV1().PersistentVolumeClaims("default").List(ctx, metav1.ListOptions{})
+			require.NoError(t, err)
+
+			claims := []string{}
+			for _, pvc := range pvcs.Items {
+				claims = append(claims, pvc.Name)
+				assert.Equal(t, "pvc-1", pvc.Spec.VolumeName)
+			}
+
+			assert.Equal(t, testCase.expectedClaims, claims)
+
+			pv, err := kclient.CoreV1().PersistentVolumes().Get(ctx, "pvc-1", metav1.GetOptions{})
+			require.NoError(t, err)
+			assert.Equal(t, testCase.expectedHandle, pv.Spec.CSI.VolumeHandle)
+
+			if testCase.expectedError == "" {
+				assert.Equal(t, corev1.PersistentVolumeReclaimDelete, pv.Spec.PersistentVolumeReclaimPolicy)
+				assert.Equal(t, "storage-test-0", pv.Spec.ClaimRef.Name)
+			}
+		})
+	}
+}
+
+func TestRunRestore(t *testing.T) {
+	origPVC := journalPVC("storage-test-0", "pvc-1", "uid-claim")
+	origPV := journalPV("pvc-1", "cluster-1/pve-1/local-lvm/vm-9999-pvc-1", "storage-test-0", "uid-volume", corev1.PersistentVolumeReclaimDelete)
+
+	tests := []struct {
+		msg           string
+		command       string
+		state         string
+		expectedError string
+		expectedState string
+	}{
+		{
+			msg:           "Failed",
+			command:       "rename",
+			state:         journalFailed,
+			expectedState: journalRolledBack,
+		},
+		{
+			msg:           "RolledBack",
+			command:       "rename",
+			state:         journalRolledBack,
+			expectedError: "journal pvecsictl-test has been rolled back already",
+			expectedState: journalRolledBack,
+		},
+		{
+			msg:           "CompletedMigration",
+			command:       "migrate",
+			state:         journalCompleted,
+			expectedError: "migration of journal pvecsictl-test is completed, the source disk does not exist anymore",
+			expectedState: journalCompleted,
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.msg, func(t *testing.T) {
+			ctx := context.Background()
+
+			data, err := json.Marshal(&journal{
+				Name:      "pvecsictl-test",
+				Command:   testCase.command,
+				Namespace: "default",
+				State:     testCase.state,
+				Claims:    []corev1.PersistentVolumeClaim{*origPVC.DeepCopy()},
+				Volumes:   []corev1.PersistentVolume{*origPV.DeepCopy()},
+				Steps:     []journalStep{{Action: "patch", Resource: "persistentvolumes", Name: "pvc-1"}},
+			})
+			require.NoError(t, err)
+
+			kclient := fake.NewClientset(
+				origPVC.DeepCopy(),
+				journalPV("pvc-1", "cluster-1/pve-1/local-lvm/vm-9999-pvc-1", "storage-test-0", "uid-volume", corev1.PersistentVolumeReclaimRetain),
+				&corev1.ConfigMap{
+					ObjectMeta: metav1.ObjectMeta{Name: "pvecsictl-test", Namespace: "default"},
+					Data:       map[string]string{journalDataKey: string(data)},
+				},
+			)
+
+			c := &journalCmd{kclient: kclient, namespace: "default"}
+
+			err = c.runRestore(nil, []string{"pvecsictl-test"})
+			if testCase.expectedError == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, testCase.expectedError)
+			}
+
+			j, err := loadJournal(ctx, kclient, "default", "pvecsictl-test")
+			require.NoError(t, err)
+			assert.Equal(t, testCase.expectedState, j.State)
+		})
+	}
+}
@@ -36,11 +36,17 @@ var (
 
 	cloudconfig string
 	kubeconfig  string
+	dryRun      bool
+	planOutput  string
+	journalDir  string
 
 	flagLogLevel = "log-level"
 
 	flagProxmoxConfig = "config"
 	flagKubeConfig    = "kubeconfig"
+	flagDryRun        = "dry-run"
+	flagOutput        = "output"
+	flagJournalDir    = "journal-dir"
 
 	logger *log.Entry
 )
@@ -71,6 +77,19 @@ func run() int {
 
 			clilog.Configure(logger, loglvl)
 
+			if planOutput != planOutputText && planOutput != planOutputJSON {
+				return fmt.Errorf("invalid output format %s, must be one of: %s, %s", planOutput, planOutputText, planOutputJSON)
+			}
+
+			if dryRun {
+				plan = &dryRunPlan{}
+
+				// Keep the plan on stdout machine-readable
+				if planOutput == planOutputJSON {
+					logger.Logger.SetOutput(os.Stderr)
+				}
+			}
+
 			return nil
 		},
 		SilenceUsage:  true,
@@ -82,12 +101,30 @@ func run() int {
 
 	cmd.PersistentFlags().StringVar(&cloudconfig, flagProxmoxConfig, "", "proxmox cluster config file")
 	cmd.PersistentFlags().StringVar(&kubeconfig, flagKubeConfig, "", "kubernetes config file")
-
+	cmd.PersistentFlags().StringVar(&journalDir, flagJournalDir, "", "directory of the journal files, the journal is saved to the configmap in the namespace of the persistentvolumeclaims by default")
+	cmd.PersistentFlags().BoolVar(&dryRun, flagDryRun, false, "print the changes of the kubernetes objects and the proxmox api calls without executing them")
+	cmd.PersistentFlags().StringVarP(&planOutput, flagOutput, "o", planOutputText,
+		fmt.Sprintf("output format of the dry-run plan, must be one of: %s, %s", planOutputText, planOutputJSON))
+
+	cmd.AddCommand(buildEvacuateCmd())
+	cmd.AddCommand(buildGCCmd())
+	cmd.AddCommand(buildImportCmd())
+	cmd.AddCommand(buildJournalCmd())
 	cmd.AddCommand(buildMigrateCmd())
+	cmd.AddCommand(buildRekeyCmd())
 	cmd.AddCommand(buildRenameCmd())
+	cmd.AddCommand(buildResizeCmd())
+	cmd.AddCommand(buildRollbackCmd())
 	cmd.AddCommand(buildSwapCmd())
 
 	err := cmd.ExecuteContext(ctx)
+
+	if plan != nil {
+		if perr := plan.print(os.Stdout, planOutput); perr != nil {
+			logger.Errorf("failed to print the plan: %v", perr)
+		}
+	}
+
 	if err != nil {
 		errorString := err.Error()
 		if strings.Contains(errorString, "arg(s)") || strings.Contains(errorString, "flag") || strings.Contains(errorString, "command") {
@@ -18,7 +18,9 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"slices"
 	"strings"
 	"time"
 
@@ -32,13 +34,14 @@ import (
 	volume "github.com/sergelogvinov/proxmox-csi-plugin/pkg/utils/volume"
 
 	rbacv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	clientkubernetes "k8s.io/client-go/kubernetes"
 )
 
 type migrateCmd struct {
 	pclient   *pxpool.ProxmoxPool
-	kclient   *clientkubernetes.Clientset
+	kclient   clientkubernetes.Interface
 	namespace string
 }
 
@@ -48,7 +51,7 @@ func buildMigrateCmd() *cobra.Command {
 	cmd := cobra.Command{
 		Use:           "migrate pvc proxmox-node",
 		Aliases:       []string{"m"},
-		Short:         "Migrate data from one Proxmox node to another",
+		Short:         "Migrate data from one Proxmox node or storage to another",
 		Args:          cobra.ExactArgs(2),
 		PreRunE:       c.migrationValidate,
 		RunE:          c.runMigration,
@@ -67,21 +70,40 @@ func setMigrateCmdFlags(cmd *cobra.Command) {
 	flags.StringP("namespace", "n", "", "namespace of the persistentvolumeclaims")
 
 	flags.BoolP("force", "f", false, "force migration even if the persistentvolumeclaims is in use")
+	flags.String("storage", "", "target storage, default is the storage of the volume")
+	flags.Bool("live", false, "move the disk to another storage on the same proxmox node while the pods keep running")
 	flags.Int("timeout", 10800, "task timeout in seconds")
 }
 
-// nolint: cyclop, gocyclo
+// migrateOptions are the options of the persistentvolumeclaims migration.
+type migrateOptions struct {
+	storage string
+	force   bool
+	// cordon cordons the nodes with the CSI driver before the pods are terminated, and uncordons them after the migration.
+	cordon  bool
+	live    bool
+	timeout int
+}
+
 func (c *migrateCmd) runMigration(cmd *cobra.Command, args []string) error {
 	flags := cmd.Flags()
-	force, _ := flags.GetBool("force") //nolint: errcheck
+	opts := migrateOptions{}
+	opts.force, _ = flags.GetBool("force")       //nolint: errcheck
+	opts.storage, _ = flags.GetString("storage") //nolint: errcheck
+	opts.live, _ = flags.GetBool("live")         //nolint: errcheck
+	opts.timeout, _ = flags.GetInt("timeout")    //nolint: errcheck
+	opts.cordon = opts.force
+
+	return c.migratePVC(context.Background(), c.namespace, args[0], args[1], opts)
+}
 
+// nolint: cyclop, gocyclo
+func (c *migrateCmd) migratePVC(ctx context.Context, namespace, pvc, node string, opts migrateOptions) error {
 	var err error
 
-	ctx := context.Background()
-	pvc := args[0]
-	node := args[1]
+	storage := opts.storage
 
-	kubePVC, kubePV, err := tools.PVCResources(ctx, c.kclient, c.namespace, pvc)
+	kubePVC, kubePV, err := tools.PVCResources(ctx, c.kclient, namespace, pvc)
 	if err != nil {
 		return fmt.Errorf("failed to get resources: %v", err)
 	}
@@ -95,7 +117,11 @@ func (c *migrateCmd) runMigration(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to parse volume ID: %v", err)
 	}
 
-	if vol.Node() == node {
+	if storage == "" {
+		storage = vol.Storage()
+	}
+
+	if vol.Node() == node && vol.Storage() == storage {
 		return fmt.Errorf("persistentvolumeclaims %s is already on proxmox node %s", pvc, node)
 	}
 
@@ -104,7 +130,25 @@ func (c *migrateCmd) runMigration(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to get Proxmox cluster: %v", err)
 	}
 
-	pods, vmName, err := tools.PVCPodUsage(ctx, c.kclient, c.namespace, pvc)
+	nodes, err := cluster.GetNodesForStorage(ctx, storage)
+	if err != nil {
+		return fmt.Errorf("failed to get nodes for storage %s: %v", storage, err)
+	}
+
+	if !slices.Contains(nodes, node) {
+		return fmt.Errorf("storage %s is not available on proxmox node %s", storage, node)
+	}
+
+	dst, shared, err := toolsproxmox.DestinationVolume(ctx, cluster, vol, node, storage)
+	if err != nil {
+		return err
+	}
+
+	if sameDisk(vol, dst, shared) {
+		return fmt.Errorf("persistentvolumeclaims %s is already on storage %s", pvc, storage)
+	}
+
+	pods, vmName, err := tools.PVCPodUsage(ctx, c.kclient, namespace, pvc)
 	if err != nil {
 		return fmt.Errorf("failed to find pods using pvc: %v", err)
 	}
@@ -128,37 +172,76 @@ func (c *migrateCmd) runMigration(cmd *cobra.Command, args []string) error {
 		logger.Infof("resolved kubernetes node %s to Proxmox VMID %d", vmName, vmID)
 	}
 
+	if opts.live && vmID != 0 {
+		if storage == vol.Storage() {
+			return fmt.Errorf("live migration requires another storage, use --storage flag")
+		}
+
+		logger.Infof("persistentvolumeclaims is using by pods: %s on node %s, moving disk %s to storage %s online",
+			strings.Join(pods, ","), vmName, vol.Disk(), storage)
+
+		moved, err := toolsproxmox.MoveAttachedDisk(ctx, cluster, vmID, vol, dst, opts.timeout)
+		if err != nil {
+			// Proxmox names the moved disk, the plan keeps the destination volume name
+			if !dryRun || !errors.Is(err, toolsproxmox.ErrMovedDiskNotFound) {
+				return fmt.Errorf("failed to move disk: %v", err)
+			}
+		} else {
+			dst = moved
+		}
+
+		logger.Infof("replacing persistentvolume %s volume with %s", kubePV.Name, dst.VolID())
+
+		j, err := newJournal(ctx, c.kclient, "migrate", namespace, []*corev1.PersistentVolumeClaim{kubePVC}, []*corev1.PersistentVolume{kubePV})
+		if err != nil {
+			return err
+		}
+
+		err = replacePVVolume(ctx, c.kclient, j, kubePV, dst, shared)
+		j.finish(ctx, err)
+
+		if err != nil {
+			return fmt.Errorf("failed to replace PV volume: %v", err)
+		}
+
+		logger.Infof("persistentvolumeclaims %s has been moved to storage %s", pvc, storage)
+
+		return nil
+	}
+
 	cordonedNodes := []string{}
 
 	if len(pods) > 0 {
-		if force {
+		if opts.force {
 			logger.Infof("persistentvolumeclaims is using by pods: %s on node %s, trying to force migration\n", strings.Join(pods, ","), vmName)
 
-			var csiNodes []string
+			if opts.cordon {
+				var csiNodes []string
 
-			csiNodes, err = tools.CSINodes(ctx, c.kclient, csi.DriverName)
-			if err != nil {
-				return err
-			}
+				csiNodes, err = tools.CSINodes(ctx, c.kclient, csi.DriverName)
+				if err != nil {
+					return err
+				}
 
-			cordonedNodes = append(cordonedNodes, csiNodes...)
+				cordonedNodes = append(cordonedNodes, csiNodes...)
 
-			logger.Infof("cordoning nodes: %s", strings.Join(cordonedNodes, ","))
+				logger.Infof("cordoning nodes: %s", strings.Join(cordonedNodes, ","))
 
-			if cordonedNodes, err = tools.CondonNodes(ctx, c.kclient, cordonedNodes); err != nil {
-				return fmt.Errorf("failed to cordon nodes: %v", err)
+				if cordonedNodes, err = tools.CondonNodes(ctx, c.kclient, cordonedNodes); err != nil {
+					return fmt.Errorf("failed to cordon nodes: %v", err)
+				}
 			}
 
 			logger.Infof("terminated pods: %s", strings.Join(pods, ","))
 
 			for _, pod := range pods {
-				if err = c.kclient.CoreV1().Pods(c.namespace).Delete(ctx, pod, metav1.DeleteOptions{}); err != nil {
+				if err = c.kclient.CoreV1().Pods(namespace).Delete(ctx, pod, metav1.DeleteOptions{}); err != nil {
 					return fmt.Errorf("failed to delete pod: %v", err)
 				}
 			}
 
 			for {
-				p, _, e := tools.PVCPodUsage(ctx, c.kclient, c.namespace, pvc)
+				p, _, e := tools.PVCPodUsage(ctx, c.kclient, namespace, pvc)
 				if e != nil {
 					return fmt.Errorf("failed to find pods using pvc: %v", e)
 				}
@@ -178,24 +261,48 @@ func (c *migrateCmd) runMigration(cmd *cobra.Command, args []string) error {
 		}
 	}
 
-	if err = toolsproxmox.WaitForVolumeDetach(ctx, cluster, vmID, vol.Disk()); err != nil {
-		return fmt.Errorf("failed to wait for volume detach: %v", err)
+	// The pods are not terminated in dry-run mode, the volume is still attached
+	if !dryRun {
+		if err = toolsproxmox.WaitForVolumeDetach(ctx, cluster, vmID, vol.Disk()); err != nil {
+			return fmt.Errorf("failed to wait for volume detach: %v", err)
+		}
 	}
 
-	logger.Infof("moving disk %s to proxmox node %s", vol.Disk(), node)
+	logger.Infof("moving disk %s to proxmox node %s storage %s as %s", vol.Disk(), node, storage, dst.Disk())
 
-	taskTimeout, _ := flags.GetInt("timeout") //nolint: errcheck
-	if err = toolsproxmox.MoveQemuDisk(ctx, cluster, vol, node, taskTimeout); err != nil {
+	if err = toolsproxmox.MoveQemuDisk(ctx, cluster, vol, dst, opts.timeout); err != nil {
 		return fmt.Errorf("failed to move disk: %v", err)
 	}
 
 	logger.Infof("replacing persistentvolume topology")
 
-	if err = replacePVTopology(ctx, c.kclient, c.namespace, kubePVC, kubePV, vol, node); err != nil {
+	j, err := newJournal(ctx, c.kclient, "migrate", namespace, []*corev1.PersistentVolumeClaim{kubePVC}, []*corev1.PersistentVolume{kubePV})
+	if err != nil {
+		return err
+	}
+
+	err = replacePVTopology(ctx, c.kclient, j, namespace, kubePVC, kubePV, dst, shared)
+	j.finish(ctx, err)
+
+	if err != nil {
 		return fmt.Errorf("failed to replace PV topology: %v", err)
 	}
 
-	if force {
+	// The source disk was kept by the journal, the provisioner deletes the disks only of the deleted volumes
+	if kubePV.Spec.PersistentVolumeReclaimPolicy == corev1.PersistentVolumeReclaimDelete && !sameDisk(vol, dst, shared) {
+		srcNode := vol.Node()
+		if srcNode == "" {
+			srcNode = dst.Node()
+		}
+
+		logger.Infof("deleting source disk %s on proxmox node %s", vol.Disk(), srcNode)
+
+		if err = cluster.DeleteVMDisk(ctx, srcNode, vol.Storage(), vol.Disk()); err != nil {
+			logger.Errorf("failed to delete source disk %s: %v", vol.VolID(), err)
+		}
+	}
+
+	if opts.cordon {
 		logger.Infof("uncordoning nodes: %s", strings.Join(cordonedNodes, ","))
 
 		if err = tools.UncondonNodes(ctx, c.kclient, cordonedNodes); err != nil {
@@ -203,11 +310,21 @@ func (c *migrateCmd) runMigration(cmd *cobra.Command, args []string) error {
 		}
 	}
 
-	logger.Infof("persistentvolumeclaims %s has been migrated to proxmox node %s", pvc, node)
+	logger.Infof("persistentvolumeclaims %s has been migrated to proxmox node %s storage %s", pvc, node, storage)
 
 	return nil
 }
 
+// sameDisk reports whether the destination volume is the source disk itself.
+// The disks on shared storages are the same on all nodes, so the node is compared only for the local storages.
+func sameDisk(vol, dst *volume.Volume, shared bool) bool {
+	if vol.VolID() != dst.VolID() {
+		return false
+	}
+
+	return shared || vol.Node() == "" || vol.Node() == dst.Node()
+}
+
 // nolint: dupl
 func (c *migrateCmd) migrationValidate(cmd *cobra.Command, _ []string) error {
 	flags := cmd.Flags()
@@ -223,7 +340,7 @@ func (c *migrateCmd) migrationValidate(cmd *cobra.Command, _ []string) error {
 		}
 	}
 
-	c.pclient, err = pxpool.NewProxmoxPool(cfg.Clusters)
+	c.pclient, err = newProxmoxPool(cfg.Clusters)
 	if err != nil {
 		return fmt.Errorf("failed to create Proxmox cluster client: %v", err)
 	}
@@ -251,10 +368,18 @@ func (c *migrateCmd) migrationValidate(cmd *cobra.Command, _ []string) error {
 		{Group: "", Namespace: "", Resource: "persistentvolumeclaims", Verb: "delete"},
 		{Group: "", Namespace: "", Resource: "persistentvolumes", Verb: "create"},
 		{Group: "", Namespace: "", Resource: "persistentvolumes", Verb: "delete"},
+		{Group: "", Namespace: "", Resource: "persistentvolumes", Verb: "patch"},
 		{Group: "", Namespace: "", Resource: "pods", Verb: "delete"},
 		{Group: "", Namespace: "", Resource: "nodes", Verb: "get"},
 		{Group: "", Namespace: "", Resource: "nodes", Verb: "patch"},
 	}
+	accessCheck = append(accessCheck, journalAccessCheck(namespace)...)
 
-	return checkPermissions(context.TODO(), c.kclient, accessCheck)
+	if err = checkPermissions(context.TODO(), c.kclient, accessCheck); err != nil {
+		return err
+	}
+
+	c.kclient = dryRunKubeClient(c.kclient)
+
+	return nil
 }
@@ -0,0 +1,176 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	volume "github.com/sergelogvinov/proxmox-csi-plugin/pkg/utils/volume"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestMigratePVC(t *testing.T) {
+	tests := []struct {
+		msg             string
+		handle          string
+		node            string
+		storage         string
+		reclaimPolicy   corev1.PersistentVolumeReclaimPolicy
+		copyTask        string
+		expectedError   string
+		expectedHandle  string
+		expectedCopies  int
+		expectedDeletes int
+	}{
+		{
+			msg:             "Migrated",
+			handle:          "cluster-1/pve-1/local-lvm/vm-9999-pvc-1",
+			node:            "pve-2",
+			reclaimPolicy:   corev1.PersistentVolumeReclaimDelete,
+			copyTask:        testTaskOK,
+			expectedHandle:  "cluster-1/pve-2/local-lvm/vm-9999-pvc-1",
+			expectedCopies:  1,
+			expectedDeletes: 1,
+		},
+		{
+			msg:            "MigratedRetain",
+			handle:         "cluster-1/pve-1/local-lvm/vm-9999-pvc-1",
+			node:           "pve-2",
+			reclaimPolicy:  corev1.PersistentVolumeReclaimRetain,
+			copyTask:       testTaskOK,
+			expectedHandle: "cluster-1/pve-2/local-lvm/vm-9999-pvc-1",
+			expectedCopies: 1,
+		},
+		{
+			msg:            "CopyTaskFailed",
+			handle:         "cluster-1/pve-1/local-lvm/vm-9999-pvc-1",
+			node:           "pve-2",
+			reclaimPolicy:  corev1.PersistentVolumeReclaimDelete,
+			copyTask:       testTaskError,
+			expectedError:  "failed to move disk: failed to copy disk, exit status: ERROR",
+			expectedHandle: "cluster-1/pve-1/local-lvm/vm-9999-pvc-1",
+			expectedCopies: 1,
+		},
+		{
+			msg:            "SameNode",
+			handle:         "cluster-1/pve-1/local-lvm/vm-9999-pvc-1",
+			node:           "pve-1",
+			reclaimPolicy:  corev1.PersistentVolumeReclaimDelete,
+			expectedError:  "persistentvolumeclaims storage-test-0 is already on proxmox node pve-1",
+			expectedHandle: "cluster-1/pve-1/local-lvm/vm-9999-pvc-1",
+		},
+		{
+			msg:            "SharedStorage",
+			handle:         "cluster-1//ceph/vm-9999-pvc-1",
+			node:           "pve-2",
+			reclaimPolicy:  corev1.PersistentVolumeReclaimDelete,
+			expectedError:  "persistentvolumeclaims storage-test-0 is already on storage ceph",
+			expectedHandle: "cluster-1//ceph/vm-9999-pvc-1",
+		},
+		{
+			msg:            "SharedStorageWithNode",
+			handle:         "cluster-1/pve-1/ceph/vm-9999-pvc-1",
+			node:           "pve-2",
+			storage:        "ceph",
+			reclaimPolicy:  corev1.PersistentVolumeReclaimDelete,
+			expectedError:  "persistentvolumeclaims storage-test-0 is already on storage ceph",
+			expectedHandle: "cluster-1/pve-1/ceph/vm-9999-pvc-1",
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.msg, func(t *testing.T) {
+			pool := newTestProxmoxPool(t)
+			registerTestStorages()
+
+			vol, err := volume.NewVolumeFromVolumeID(testCase.handle)
+			require.NoError(t, err)
+
+			srcNode := vol.Node()
+			if srcNode == "" {
+				srcNode = testCase.node
+			}
+
+			diskURL := `=~/nodes/` + srcNode + `/storage/` + vol.Storage() + `/content/` + vol.Disk() + `$`
+
+			httpmock.RegisterResponder(http.MethodPost, diskURL,
+				httpmock.NewJsonResponderOrPanic(200, map[string]any{"data": testCase.copyTask}))
+			httpmock.RegisterResponder(http.MethodDelete, diskURL,
+				httpmock.NewJsonResponderOrPanic(200, map[string]any{"data": testTaskOK}))
+
+			pv := testPV("pvc-1", testCase.handle)
+			pv.Spec.PersistentVolumeReclaimPolicy = testCase.reclaimPolicy
+
+			kclient := fake.NewClientset(testPVC("storage-test-0", "pvc-1"), pv)
+			c := &migrateCmd{pclient: pool, kclient: kclient, namespace: "default"}
+
+			err = c.migratePVC(context.Background(), "default", "storage-test-0", testCase.node, migrateOptions{
+				storage: testCase.storage,
+				timeout: 60,
+			})
+			if testCase.expectedError == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, testCase.expectedError)
+			}
+
+			res, err := kclient.CoreV1().PersistentVolumes().Get(context.Background(), "pvc-1", metav1.GetOptions{})
+			require.NoError(t, err)
+			assert.Equal(t, testCase.expectedHandle, res.Spec.CSI.VolumeHandle)
+
+			calls := httpmock.GetCallCountInfo()
+			assert.Equal(t, testCase.expectedCopies, calls["POST "+diskURL])
+			assert.Equal(t, testCase.expectedDeletes, calls["DELETE "+diskURL])
+		})
+	}
+}
+
+func TestReplacePVVolume(t *testing.T) {
+	ctx := context.Background()
+
+	pv := journalPV("pvc-1", "cluster-1/pve-1/local-lvm/vm-9999-pvc-1", "storage-test-0", "uid-volume", corev1.PersistentVolumeReclaimDelete)
+	pv.Finalizers = []string{"kubernetes.io/pv-protection", attacherFinalizer}
+
+	kclient := fake.NewClientset(journalPVC("storage-test-0", "pvc-1", "uid-claim"), pv)
+
+	j := &journal{kclient: kclient, Name: "pvecsictl-test", Command: "migrate", Namespace: "default"}
+	dst := volume.NewVolume("cluster-1", "pve-1", "ceph", "vm-9999-pvc-1")
+
+	require.NoError(t, replacePVVolume(ctx, kclient, j, pv, dst, true))
+
+	assert.Equal(t, []journalStep{
+		{Action: "patch", Resource: "persistentvolumes", Name: "pvc-1"},
+		{Action: "delete", Resource: "persistentvolumes", Name: "pvc-1"},
+		{Action: "create", Resource: "persistentvolumes", Name: "pvc-1"},
+	}, j.Steps)
+
+	res, err := kclient.CoreV1().PersistentVolumes().Get(ctx, "pvc-1", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "cluster-1//ceph/vm-9999-pvc-1", res.Spec.CSI.VolumeHandle)
+	assert.Equal(t, corev1.PersistentVolumeReclaimDelete, res.Spec.PersistentVolumeReclaimPolicy)
+	assert.Equal(t, "storage-test-0", res.Spec.ClaimRef.Name)
+	assert.Contains(t, res.Finalizers, attacherFinalizer)
+}
@@ -0,0 +1,356 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/siderolabs/go-blockdevice/blockdevice/encryption"
+	luks "github.com/siderolabs/go-blockdevice/blockdevice/encryption/luks"
+	cobra "github.com/spf13/cobra"
+
+	csiconfig "github.com/sergelogvinov/proxmox-csi-plugin/pkg/config"
+	"github.com/sergelogvinov/proxmox-csi-plugin/pkg/csi"
+	pxpool "github.com/sergelogvinov/proxmox-csi-plugin/pkg/proxmoxpool"
+	tools "github.com/sergelogvinov/proxmox-csi-plugin/pkg/tools/kubernetes"
+	toolsproxmox "github.com/sergelogvinov/proxmox-csi-plugin/pkg/tools/proxmox"
+	volume "github.com/sergelogvinov/proxmox-csi-plugin/pkg/utils/volume"
+
+	rbacv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientkubernetes "k8s.io/client-go/kubernetes"
+)
+
+type rekeyCmd struct {
+	pclient   *pxpool.ProxmoxPool
+	kclient   clientkubernetes.Interface
+	namespace string
+}
+
+func buildRekeyCmd() *cobra.Command {
+	c := &rekeyCmd{}
+
+	cmd := cobra.Command{
+		Use:           "rekey pvc [namespace/]new-secret",
+		Aliases:       []string{"rk"},
+		Short:         "Rotate the passphrase of encrypted PersistentVolumeClaim",
+		Args:          cobra.ExactArgs(2),
+		PreRunE:       c.rekeyValidate,
+		RunE:          c.runRekey,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+
+	setRekeyCmdFlags(&cmd)
+
+	return &cmd
+}
+
+func setRekeyCmdFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+
+	flags.StringP("namespace", "n", "", "namespace of the persistentvolumeclaims")
+
+	flags.String("old-secret", "", "[namespace/]name of the secret with the current passphrase, default is the node stage secret of the persistentvolume")
+	flags.BoolP("force", "f", false, "rotate the passphrase even if the persistentvolumeclaims is in use")
+}
+
+// nolint: cyclop, gocyclo
+func (c *rekeyCmd) runRekey(cmd *cobra.Command, args []string) error {
+	flags := cmd.Flags()
+	force, _ := flags.GetBool("force")            //nolint: errcheck
+	oldSecret, _ := flags.GetString("old-secret") //nolint: errcheck
+
+	ctx := context.Background()
+	pvc := args[0]
+
+	_, kubePV, err := tools.PVCResources(ctx, c.kclient, c.namespace, pvc)
+	if err != nil {
+		return fmt.Errorf("failed to get resources: %v", err)
+	}
+
+	if kubePV.Spec.CSI == nil || kubePV.Spec.CSI.Driver != csi.DriverName {
+		return fmt.Errorf("persistentvolume %s is not provisioned by Proxmox CSI driver", kubePV.Name)
+	}
+
+	stageRef := kubePV.Spec.CSI.NodeStageSecretRef
+	if stageRef == nil {
+		return fmt.Errorf("persistentvolume %s is not encrypted", kubePV.Name)
+	}
+
+	vol, err := volume.NewVolumeFromVolumeID(kubePV.Spec.CSI.VolumeHandle)
+	if err != nil {
+		return fmt.Errorf("failed to parse volume ID: %v", err)
+	}
+
+	pods, vmName, err := tools.PVCPodUsage(ctx, c.kclient, c.namespace, pvc)
+	if err != nil {
+		return fmt.Errorf("failed to find pods using pvc: %v", err)
+	}
+
+	if len(pods) > 0 {
+		if !force {
+			return fmt.Errorf("persistentvolumeclaims is using by pods: %s on node %s, cannot rekey volume", strings.Join(pods, ","), vmName)
+		}
+
+		logger.Infof("persistentvolumeclaims is using by pods: %s on node %s, trying to force rekey", strings.Join(pods, ","), vmName)
+	}
+
+	// The persistentvolume secret references are immutable, the passphrase is replaced in the referenced secrets
+	refs := []*corev1.SecretReference{stageRef}
+	if ref := kubePV.Spec.CSI.NodeExpandSecretRef; ref != nil && (ref.Name != stageRef.Name || ref.Namespace != stageRef.Namespace) {
+		refs = append(refs, ref)
+	}
+
+	pvs, err := c.kclient.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list persistentvolumes: %v", err)
+	}
+
+	for _, pv := range pvs.Items {
+		if pv.Name == kubePV.Name || pv.Spec.CSI == nil {
+			continue
+		}
+
+		for _, ref := range refs {
+			for _, r := range []*corev1.SecretReference{pv.Spec.CSI.NodeStageSecretRef, pv.Spec.CSI.NodeExpandSecretRef} {
+				if r != nil && r.Name == ref.Name && r.Namespace == ref.Namespace {
+					return fmt.Errorf("secret %s/%s is used by persistentvolume %s too, use a secret per volume", ref.Namespace, ref.Name, pv.Name)
+				}
+			}
+		}
+	}
+
+	oldRef := stageRef
+	if oldSecret != "" {
+		oldRef = secretReference(oldSecret, c.namespace)
+	}
+
+	oldKey, err := c.secretPassphrase(ctx, oldRef)
+	if err != nil {
+		return err
+	}
+
+	newKey, err := c.secretPassphrase(ctx, secretReference(args[1], c.namespace))
+	if err != nil {
+		return err
+	}
+
+	if bytes.Equal(oldKey, newKey) {
+		return fmt.Errorf("new passphrase must differ from the current one")
+	}
+
+	cluster, err := c.pclient.GetProxmoxCluster(vol.Cluster())
+	if err != nil {
+		return fmt.Errorf("failed to get Proxmox cluster: %v", err)
+	}
+
+	// The disk is accessed on the Proxmox node, shared storage disks are accessed on the local node
+	node := vol.Node()
+	if node == "" {
+		if node, err = os.Hostname(); err != nil {
+			return fmt.Errorf("failed to get hostname: %v", err)
+		}
+
+		node, _, _ = strings.Cut(node, ".")
+	}
+
+	devicePath, err := toolsproxmox.GetVolumePath(ctx, cluster, vol, node)
+	if err != nil {
+		return err
+	}
+
+	if _, err = os.Stat(devicePath); err != nil {
+		return fmt.Errorf("device %s is not found, run the command on the Proxmox node %s: %v", devicePath, node, err)
+	}
+
+	logger.Infof("rotating passphrase of disk %s on device %s", vol.Disk(), devicePath)
+
+	if plan != nil {
+		plan.add(planChange{Type: planChangeDevice, Action: "rekey", Path: devicePath})
+	} else if err = rekeyDevice(devicePath, oldKey, newKey); err != nil {
+		return fmt.Errorf("failed to rotate passphrase: %v", err)
+	}
+
+	for _, ref := range refs {
+		secret, err := c.kclient.CoreV1().Secrets(ref.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to get secret %s/%s: %v", ref.Namespace, ref.Name, err)
+		}
+
+		if secret.Data == nil {
+			secret.Data = map[string][]byte{}
+		}
+
+		secret.Data[csi.EncryptionPassphraseKey] = newKey
+		delete(secret.StringData, csi.EncryptionPassphraseKey)
+
+		if _, err = c.kclient.CoreV1().Secrets(ref.Namespace).Update(ctx, secret, metav1.UpdateOptions{}); err != nil {
+			return fmt.Errorf("failed to update secret %s/%s, the disk uses the new passphrase already: %v", ref.Namespace, ref.Name, err)
+		}
+
+		logger.Infof("secret %s/%s has been updated", ref.Namespace, ref.Name)
+	}
+
+	logger.Infof("passphrase of persistentvolumeclaims %s has been rotated", pvc)
+
+	return nil
+}
+
+// rekeyDevice adds the new passphrase to a new keyslot and removes the keyslot of the old passphrase.
+func rekeyDevice(devicePath string, oldPassphrase, newPassphrase []byte) error {
+	l := luks.New(luks.AESXTSPlain64Cipher)
+
+	keyslots, err := l.ReadKeyslots(devicePath)
+	if err != nil {
+		return fmt.Errorf("failed to read keyslots: %v", err)
+	}
+
+	slots := []int{}
+
+	for k := range keyslots.Keyslots {
+		if slot, err := strconv.Atoi(k); err == nil {
+			slots = append(slots, slot)
+		}
+	}
+
+	slices.Sort(slots)
+
+	oldSlot := -1
+
+	for _, slot := range slots {
+		ok, err := l.CheckKey(devicePath, encryption.NewKey(slot, oldPassphrase))
+		if err != nil {
+			return fmt.Errorf("failed to check keyslot %d: %v", slot, err)
+		}
+
+		if ok {
+			oldSlot = slot
+
+			break
+		}
+	}
+
+	if oldSlot < 0 {
+		// The previous run could rotate the passphrase and fail to update the secrets
+		if ok, err := l.CheckKey(devicePath, encryption.NewKey(encryption.AnyKeyslot, newPassphrase)); err == nil && ok {
+			logger.Infof("device %s uses the new passphrase already", devicePath)
+
+			return nil
+		}
+
+		return fmt.Errorf("current passphrase is rejected")
+	}
+
+	oldKey := encryption.NewKey(oldSlot, oldPassphrase)
+	newKey := encryption.NewKey(encryption.AnyKeyslot, newPassphrase)
+
+	if err = l.AddKey(devicePath, oldKey, newKey); err != nil {
+		return fmt.Errorf("failed to add new passphrase: %v", err)
+	}
+
+	if ok, err := l.CheckKey(devicePath, newKey); err != nil || !ok {
+		return fmt.Errorf("new passphrase is rejected after adding, the old passphrase is kept: %v", err)
+	}
+
+	logger.Infof("new passphrase has been added, removing keyslot %d", oldSlot)
+
+	if err = l.RemoveKey(devicePath, oldSlot, newKey); err != nil {
+		return fmt.Errorf("failed to remove old keyslot %d: %v", oldSlot, err)
+	}
+
+	return nil
+}
+
+func (c *rekeyCmd) secretPassphrase(ctx context.Context, ref *corev1.SecretReference) ([]byte, error) {
+	secret, err := c.kclient.CoreV1().Secrets(ref.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get secret %s/%s: %v", ref.Namespace, ref.Name, err)
+	}
+
+	passphrase := secret.Data[csi.EncryptionPassphraseKey]
+	if len(passphrase) == 0 {
+		return nil, fmt.Errorf("secret %s/%s has no %s key", ref.Namespace, ref.Name, csi.EncryptionPassphraseKey)
+	}
+
+	return passphrase, nil
+}
+
+// secretReference parses [namespace/]name, the namespace defaults to the given one.
+func secretReference(ref string, namespace string) *corev1.SecretReference {
+	if ns, name, ok := strings.Cut(ref, "/"); ok {
+		return &corev1.SecretReference{Namespace: ns, Name: name}
+	}
+
+	return &corev1.SecretReference{Namespace: namespace, Name: ref}
+}
+
+// nolint: dupl
+func (c *rekeyCmd) rekeyValidate(cmd *cobra.Command, _ []string) error {
+	flags := cmd.Flags()
+
+	cfg, err := csiconfig.ReadCloudConfigFromFile(cloudconfig)
+	if err != nil {
+		return fmt.Errorf("failed to read config: %v", err)
+	}
+
+	c.pclient, err = newProxmoxPool(cfg.Clusters)
+	if err != nil {
+		return fmt.Errorf("failed to create Proxmox cluster client: %v", err)
+	}
+
+	if err = c.pclient.CheckClusters(context.TODO()); err != nil {
+		return fmt.Errorf("failed to initialize Proxmox clusters: %v", err)
+	}
+
+	namespace, _ := flags.GetString("namespace") //nolint: errcheck
+
+	kclientConfig, namespace, err := tools.BuildConfig(kubeconfig, namespace)
+	if err != nil {
+		return fmt.Errorf("failed to create kubernetes config: %v", err)
+	}
+
+	c.kclient, err = clientkubernetes.NewForConfig(kclientConfig)
+	if err != nil {
+		return fmt.Errorf("failed to create kubernetes client: %v", err)
+	}
+
+	c.namespace = namespace
+
+	accessCheck := []rbacv1.ResourceAttributes{
+		{Group: "", Namespace: "", Resource: "persistentvolumeclaims", Verb: "get"},
+		{Group: "", Namespace: "", Resource: "persistentvolumes", Verb: "list"},
+		{Group: "", Namespace: "", Resource: "pods", Verb: "list"},
+		{Group: "", Namespace: "", Resource: "secrets", Verb: "get"},
+		{Group: "", Namespace: "", Resource: "secrets", Verb: "update"},
+	}
+
+	if err = checkPermissions(context.TODO(), c.kclient, accessCheck); err != nil {
+		return err
+	}
+
+	c.kclient = dryRunKubeClient(c.kclient)
+
+	return nil
+}
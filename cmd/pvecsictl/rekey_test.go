@@ -0,0 +1,149 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jarcoal/httpmock"
+	cobra "github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sergelogvinov/proxmox-csi-plugin/pkg/csi"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testSecret(name, passphrase string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Data:       map[string][]byte{csi.EncryptionPassphraseKey: []byte(passphrase)},
+	}
+}
+
+func testEncryptedPV(name, handle, secret string) *corev1.PersistentVolume {
+	pv := testPV(name, handle)
+	pv.Spec.CSI.NodeStageSecretRef = &corev1.SecretReference{Namespace: "default", Name: secret}
+
+	return pv
+}
+
+func TestRunRekey(t *testing.T) {
+	devicePath := filepath.Join(t.TempDir(), "vm-9999-pvc-1")
+	require.NoError(t, os.WriteFile(devicePath, nil, 0o600))
+
+	tests := []struct {
+		msg                string
+		objects            []runtime.Object
+		args               []string
+		expectedError      string
+		expectedPassphrase string
+	}{
+		{
+			msg: "Rekeyed",
+			objects: []runtime.Object{
+				testSecret("secret-new", "new"),
+			},
+			args:               []string{"storage-test-0", "secret-new"},
+			expectedPassphrase: "new",
+		},
+		{
+			msg: "SamePassphrase",
+			objects: []runtime.Object{
+				testSecret("secret-new", "old"),
+			},
+			args:               []string{"storage-test-0", "secret-new"},
+			expectedError:      "new passphrase must differ from the current one",
+			expectedPassphrase: "old",
+		},
+		{
+			msg: "SharedSecret",
+			objects: []runtime.Object{
+				testSecret("secret-new", "new"),
+				testEncryptedPV("pvc-2", "cluster-1/pve-1/local-lvm/vm-9999-pvc-2", "secret-1"),
+			},
+			args:               []string{"storage-test-0", "secret-new"},
+			expectedError:      "secret default/secret-1 is used by persistentvolume pvc-2 too, use a secret per volume",
+			expectedPassphrase: "old",
+		},
+		{
+			msg: "UsedByPods",
+			objects: []runtime.Object{
+				testSecret("secret-new", "new"),
+				&corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{Name: "test-0", Namespace: "default"},
+					Spec: corev1.PodSpec{
+						NodeName: "node-1",
+						Volumes: []corev1.Volume{{
+							Name: "data",
+							VolumeSource: corev1.VolumeSource{
+								PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "storage-test-0"},
+							},
+						}},
+					},
+					Status: corev1.PodStatus{Phase: corev1.PodRunning},
+				},
+			},
+			args:               []string{"storage-test-0", "secret-new"},
+			expectedError:      "persistentvolumeclaims is using by pods: test-0 on node node-1, cannot rekey volume",
+			expectedPassphrase: "old",
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.msg, func(t *testing.T) {
+			pool := newTestProxmoxPool(t)
+			p := setTestPlan(t)
+
+			httpmock.RegisterResponder(http.MethodGet, `=~/nodes/pve-1/storage/local-lvm/content/vm-9999-pvc-1$`,
+				httpmock.NewJsonResponderOrPanic(200, map[string]any{"data": map[string]any{"path": devicePath}}))
+
+			objects := append([]runtime.Object{
+				testPVC("storage-test-0", "pvc-1"),
+				testEncryptedPV("pvc-1", "cluster-1/pve-1/local-lvm/vm-9999-pvc-1", "secret-1"),
+				testSecret("secret-1", "old"),
+			}, testCase.objects...)
+
+			kclient := fake.NewClientset(objects...)
+			c := &rekeyCmd{pclient: pool, kclient: kclient, namespace: "default"}
+
+			cmd := &cobra.Command{}
+			setRekeyCmdFlags(cmd)
+
+			err := c.runRekey(cmd, testCase.args)
+			if testCase.expectedError == "" {
+				require.NoError(t, err)
+				assert.Equal(t, []planChange{{Type: planChangeDevice, Action: "rekey", Path: devicePath}}, p.Changes)
+			} else {
+				require.EqualError(t, err, testCase.expectedError)
+				assert.Empty(t, p.Changes)
+			}
+
+			secret, err := kclient.CoreV1().Secrets("default").Get(context.Background(), "secret-1", metav1.GetOptions{})
+			require.NoError(t, err)
+			assert.Equal(t, testCase.expectedPassphrase, string(secret.Data[csi.EncryptionPassphraseKey]))
+		})
+	}
+}
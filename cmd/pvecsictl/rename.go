@@ -27,12 +27,13 @@ import (
 	tools "github.com/sergelogvinov/proxmox-csi-plugin/pkg/tools/kubernetes"
 
 	rbacv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	clientkubernetes "k8s.io/client-go/kubernetes"
 )
 
 type renameCmd struct {
-	kclient   *clientkubernetes.Clientset
+	kclient   clientkubernetes.Interface
 	namespace string
 }
 
@@ -137,7 +138,14 @@ func (c *renameCmd) runRename(cmd *cobra.Command, args []string) error {
 		}
 	}
 
-	err = renamePVC(ctx, c.kclient, c.namespace, srcPVC, srcPV, args[1])
+	j, err := newJournal(ctx, c.kclient, "rename", c.namespace, []*corev1.PersistentVolumeClaim{srcPVC}, []*corev1.PersistentVolume{srcPV})
+	if err != nil {
+		return err
+	}
+
+	err = renamePVC(ctx, c.kclient, j, c.namespace, srcPVC, srcPV, args[1])
+	j.finish(ctx, err)
+
 	if err != nil {
 		cordonedNodes = []string{}
 
@@ -175,6 +183,13 @@ func (c *renameCmd) renameValidate(cmd *cobra.Command, _ []string) error {
 		{Group: "", Namespace: "", Resource: "pods", Verb: "delete"},
 		{Group: "", Namespace: "", Resource: "nodes", Verb: "patch"},
 	}
+	accessCheck = append(accessCheck, journalAccessCheck(namespace)...)
 
-	return checkPermissions(context.TODO(), c.kclient, accessCheck)
+	if err = checkPermissions(context.TODO(), c.kclient, accessCheck); err != nil {
+		return err
+	}
+
+	c.kclient = dryRunKubeClient(c.kclient)
+
+	return nil
 }
@@ -0,0 +1,231 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	cobra "github.com/spf13/cobra"
+
+	csiconfig "github.com/sergelogvinov/proxmox-csi-plugin/pkg/config"
+	"github.com/sergelogvinov/proxmox-csi-plugin/pkg/csi"
+	pxpool "github.com/sergelogvinov/proxmox-csi-plugin/pkg/proxmoxpool"
+	tools "github.com/sergelogvinov/proxmox-csi-plugin/pkg/tools/kubernetes"
+	toolsproxmox "github.com/sergelogvinov/proxmox-csi-plugin/pkg/tools/proxmox"
+	volume "github.com/sergelogvinov/proxmox-csi-plugin/pkg/utils/volume"
+
+	rbacv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	clientkubernetes "k8s.io/client-go/kubernetes"
+)
+
+type resizeCmd struct {
+	pclient   *pxpool.ProxmoxPool
+	kclient   clientkubernetes.Interface
+	namespace string
+	features  csiconfig.ClustersConfig
+}
+
+func buildResizeCmd() *cobra.Command {
+	c := &resizeCmd{}
+
+	cmd := cobra.Command{
+		Use:           "resize pvc size",
+		Aliases:       []string{"rs"},
+		Short:         "Resize PersistentVolumeClaim, attached or detached",
+		Args:          cobra.ExactArgs(2),
+		PreRunE:       c.resizeValidate,
+		RunE:          c.runResize,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+
+	setResizeCmdFlags(&cmd)
+
+	return &cmd
+}
+
+func setResizeCmdFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+
+	flags.StringP("namespace", "n", "", "namespace of the persistentvolumeclaims")
+
+	flags.Int("timeout", 0, "task timeout in seconds, the vmTaskTimeout of the config by default")
+}
+
+// nolint: cyclop, gocyclo
+func (c *resizeCmd) runResize(cmd *cobra.Command, args []string) error {
+	flags := cmd.Flags()
+	taskTimeout, _ := flags.GetInt("timeout") //nolint: errcheck
+
+	ctx := context.Background()
+	pvc := args[0]
+
+	quantity, err := resource.ParseQuantity(args[1])
+	if err != nil {
+		return fmt.Errorf("failed to parse size %s: %v", args[1], err)
+	}
+
+	size := csi.RoundUpSizeBytes(quantity.Value(), csi.MinChunkSizeBytes)
+	quantity = *resource.NewQuantity(size, resource.BinarySI)
+
+	kubePVC, kubePV, err := tools.PVCResources(ctx, c.kclient, c.namespace, pvc)
+	if err != nil {
+		return fmt.Errorf("failed to get resources: %v", err)
+	}
+
+	if kubePV.Spec.CSI == nil || kubePV.Spec.CSI.Driver != csi.DriverName {
+		return fmt.Errorf("persistentvolume %s is not provisioned by Proxmox CSI driver", kubePV.Name)
+	}
+
+	capacity := kubePV.Spec.Capacity[corev1.ResourceStorage]
+	if capacity.Value() > size {
+		return fmt.Errorf("persistentvolume %s has size %s, shrinking to %s is not supported", kubePV.Name, capacity.String(), quantity.String())
+	}
+
+	vol, err := volume.NewVolumeFromVolumeID(kubePV.Spec.CSI.VolumeHandle)
+	if err != nil {
+		return fmt.Errorf("failed to parse volume ID: %v", err)
+	}
+
+	cluster, err := c.pclient.GetProxmoxCluster(vol.Cluster())
+	if err != nil {
+		return fmt.Errorf("failed to get Proxmox cluster: %v", err)
+	}
+
+	node := vol.Node()
+	if node == "" {
+		nodes, err := cluster.GetNodesForStorage(ctx, vol.Storage())
+		if err != nil || len(nodes) == 0 {
+			return fmt.Errorf("failed to find nodes for storage %s: %v", vol.Storage(), err)
+		}
+
+		node = nodes[0]
+	}
+
+	diskSize, err := toolsproxmox.GetVolumeSize(ctx, cluster, volume.NewVolume(vol.Region(), node, vol.Storage(), vol.Disk()))
+	if err != nil {
+		return fmt.Errorf("failed to get volume size: %v", err)
+	}
+
+	if diskSize < size {
+		pods, vmName, err := tools.PVCPodUsage(ctx, c.kclient, c.namespace, pvc)
+		if err != nil {
+			return fmt.Errorf("failed to find pods using pvc: %v", err)
+		}
+
+		if len(pods) > 0 {
+			logger.Infof("persistentvolumeclaims is using by pods: %s on node %s, resizing attached disk", strings.Join(pods, ","), vmName)
+		}
+
+		logger.Infof("resizing disk %s to %s", vol.Disk(), quantity.String())
+
+		features := c.features.RegionFeatures(vol.Region())
+		if taskTimeout > 0 {
+			features.VMTaskTimeout = time.Duration(taskTimeout) * time.Second
+		}
+
+		if err = csi.ResizeVolume(ctx, cluster, vol, size, features); err != nil {
+			return fmt.Errorf("failed to resize disk: %v", err)
+		}
+	} else {
+		logger.Infof("disk %s has size %s already, updating kubernetes objects only", vol.Disk(), resource.NewQuantity(diskSize, resource.BinarySI).String())
+	}
+
+	// The filesystem is expanded by kubelet, when the persistentvolume capacity is greater than the claim capacity
+	if capacity.Value() < size {
+		patch := []byte(`{"spec":{"capacity":{"storage":"` + quantity.String() + `"}}}`)
+		if _, err = c.kclient.CoreV1().PersistentVolumes().Patch(ctx, kubePV.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+			return fmt.Errorf("failed to patch persistentvolume %s: %v", kubePV.Name, err)
+		}
+	}
+
+	request := kubePVC.Spec.Resources.Requests[corev1.ResourceStorage]
+	if request.Value() < size {
+		patch := []byte(`{"spec":{"resources":{"requests":{"storage":"` + quantity.String() + `"}}}}`)
+		if _, err = c.kclient.CoreV1().PersistentVolumeClaims(c.namespace).Patch(ctx, pvc, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+			return fmt.Errorf("failed to patch persistentvolumeclaims %s: %v", pvc, err)
+		}
+	}
+
+	logger.Infof("persistentvolumeclaims %s has been resized to %s", pvc, quantity.String())
+
+	return nil
+}
+
+// nolint: dupl
+func (c *resizeCmd) resizeValidate(cmd *cobra.Command, _ []string) error {
+	flags := cmd.Flags()
+
+	cfg, err := csiconfig.ReadCloudConfigFromFile(cloudconfig)
+	if err != nil {
+		return fmt.Errorf("failed to read config: %v", err)
+	}
+
+	for _, c := range cfg.Clusters {
+		if c.Username == "" || c.Password == "" {
+			return fmt.Errorf("this command requires Proxmox root account, please provide username and password in config file (cluster=%s)", c.Region)
+		}
+	}
+
+	c.features = cfg
+
+	c.pclient, err = newProxmoxPool(cfg.Clusters)
+	if err != nil {
+		return fmt.Errorf("failed to create Proxmox cluster client: %v", err)
+	}
+
+	if err = c.pclient.CheckClusters(context.TODO()); err != nil {
+		return fmt.Errorf("failed to initialize Proxmox clusters: %v", err)
+	}
+
+	namespace, _ := flags.GetString("namespace") //nolint: errcheck
+
+	kclientConfig, namespace, err := tools.BuildConfig(kubeconfig, namespace)
+	if err != nil {
+		return fmt.Errorf("failed to create kubernetes config: %v", err)
+	}
+
+	c.kclient, err = clientkubernetes.NewForConfig(kclientConfig)
+	if err != nil {
+		return fmt.Errorf("failed to create kubernetes client: %v", err)
+	}
+
+	c.namespace = namespace
+
+	accessCheck := []rbacv1.ResourceAttributes{
+		{Group: "", Namespace: "", Resource: "persistentvolumeclaims", Verb: "get"},
+		{Group: "", Namespace: "", Resource: "persistentvolumeclaims", Verb: "patch"},
+		{Group: "", Namespace: "", Resource: "persistentvolumes", Verb: "get"},
+		{Group: "", Namespace: "", Resource: "persistentvolumes", Verb: "patch"},
+		{Group: "", Namespace: "", Resource: "pods", Verb: "list"},
+	}
+
+	if err = checkPermissions(context.TODO(), c.kclient, accessCheck); err != nil {
+		return err
+	}
+
+	c.kclient = dryRunKubeClient(c.kclient)
+
+	return nil
+}
@@ -0,0 +1,180 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	cobra "github.com/spf13/cobra"
+
+	csiconfig "github.com/sergelogvinov/proxmox-csi-plugin/pkg/config"
+	"github.com/sergelogvinov/proxmox-csi-plugin/pkg/csi"
+	pxpool "github.com/sergelogvinov/proxmox-csi-plugin/pkg/proxmoxpool"
+	tools "github.com/sergelogvinov/proxmox-csi-plugin/pkg/tools/kubernetes"
+	toolsproxmox "github.com/sergelogvinov/proxmox-csi-plugin/pkg/tools/proxmox"
+	volume "github.com/sergelogvinov/proxmox-csi-plugin/pkg/utils/volume"
+
+	rbacv1 "k8s.io/api/authorization/v1"
+	clientkubernetes "k8s.io/client-go/kubernetes"
+)
+
+type rollbackCmd struct {
+	pclient   *pxpool.ProxmoxPool
+	kclient   clientkubernetes.Interface
+	namespace string
+}
+
+func buildRollbackCmd() *cobra.Command {
+	c := &rollbackCmd{}
+
+	cmd := cobra.Command{
+		Use:           "rollback pvc snapshot-handle",
+		Aliases:       []string{"rb"},
+		Short:         "Rollback PersistentVolumeClaim to the native snapshot",
+		Args:          cobra.ExactArgs(2),
+		PreRunE:       c.rollbackValidate,
+		RunE:          c.runRollback,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+
+	setRollbackCmdFlags(&cmd)
+
+	return &cmd
+}
+
+func setRollbackCmdFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+
+	flags.StringP("namespace", "n", "", "namespace of the persistentvolumeclaims")
+
+	flags.Int("timeout", 600, "task timeout in seconds")
+}
+
+func (c *rollbackCmd) runRollback(cmd *cobra.Command, args []string) error {
+	flags := cmd.Flags()
+
+	ctx := context.Background()
+	pvc := args[0]
+
+	_, kubePV, err := tools.PVCResources(ctx, c.kclient, c.namespace, pvc)
+	if err != nil {
+		return fmt.Errorf("failed to get resources: %v", err)
+	}
+
+	if kubePV.Spec.CSI == nil || kubePV.Spec.CSI.Driver != csi.DriverName {
+		return fmt.Errorf("persistentvolume %s is not provisioned by Proxmox CSI driver", kubePV.Name)
+	}
+
+	vol, err := volume.NewVolumeFromVolumeID(kubePV.Spec.CSI.VolumeHandle)
+	if err != nil {
+		return fmt.Errorf("failed to parse volume ID: %v", err)
+	}
+
+	snap, err := volume.NewVolumeFromVolumeID(args[1])
+	if err != nil {
+		return fmt.Errorf("failed to parse snapshot handle: %v", err)
+	}
+
+	if snap.Snapshot() == "" {
+		return fmt.Errorf("snapshot %s is not a native snapshot", args[1])
+	}
+
+	if snap.Cluster() != vol.Cluster() || snap.Storage() != vol.Storage() || snap.Disk() != vol.Disk() {
+		return fmt.Errorf("snapshot %s does not belong to persistentvolume %s", args[1], kubePV.Name)
+	}
+
+	pods, vmName, err := tools.PVCPodUsage(ctx, c.kclient, c.namespace, pvc)
+	if err != nil {
+		return fmt.Errorf("failed to find pods using pvc: %v", err)
+	}
+
+	if len(pods) > 0 {
+		return fmt.Errorf("persistentvolumeclaims is using by pods: %s on node %s, cannot rollback volume", strings.Join(pods, ","), vmName)
+	}
+
+	cluster, err := c.pclient.GetProxmoxCluster(vol.Cluster())
+	if err != nil {
+		return fmt.Errorf("failed to get Proxmox cluster: %v", err)
+	}
+
+	logger.Infof("rolling back disk %s to snapshot %s", vol.Disk(), snap.Snapshot())
+
+	taskTimeout, _ := flags.GetInt("timeout") //nolint: errcheck
+	if err = toolsproxmox.RollbackVolumeSnapshot(ctx, cluster, snap, taskTimeout); err != nil {
+		return fmt.Errorf("failed to rollback volume: %v", err)
+	}
+
+	logger.Infof("persistentvolumeclaims %s has been rolled back to snapshot %s", pvc, snap.Snapshot())
+
+	return nil
+}
+
+// nolint: dupl
+func (c *rollbackCmd) rollbackValidate(cmd *cobra.Command, _ []string) error {
+	flags := cmd.Flags()
+
+	cfg, err := csiconfig.ReadCloudConfigFromFile(cloudconfig)
+	if err != nil {
+		return fmt.Errorf("failed to read config: %v", err)
+	}
+
+	for _, c := range cfg.Clusters {
+		if c.Username == "" || c.Password == "" {
+			return fmt.Errorf("this command requires Proxmox root account, please provide username and password in config file (cluster=%s)", c.Region)
+		}
+	}
+
+	c.pclient, err = newProxmoxPool(cfg.Clusters)
+	if err != nil {
+		return fmt.Errorf("failed to create Proxmox cluster client: %v", err)
+	}
+
+	if err = c.pclient.CheckClusters(context.TODO()); err != nil {
+		return fmt.Errorf("failed to initialize Proxmox clusters: %v", err)
+	}
+
+	namespace, _ := flags.GetString("namespace") //nolint: errcheck
+
+	kclientConfig, namespace, err := tools.BuildConfig(kubeconfig, namespace)
+	if err != nil {
+		return fmt.Errorf("failed to create kubernetes config: %v", err)
+	}
+
+	c.kclient, err = clientkubernetes.NewForConfig(kclientConfig)
+	if err != nil {
+		return fmt.Errorf("failed to create kubernetes client: %v", err)
+	}
+
+	c.namespace = namespace
+
+	accessCheck := []rbacv1.ResourceAttributes{
+		{Group: "", Namespace: "", Resource: "persistentvolumeclaims", Verb: "get"},
+		{Group: "", Namespace: "", Resource: "persistentvolumes", Verb: "get"},
+		{Group: "", Namespace: "", Resource: "pods", Verb: "list"},
+	}
+
+	if err = checkPermissions(context.TODO(), c.kclient, accessCheck); err != nil {
+		return err
+	}
+
+	c.kclient = dryRunKubeClient(c.kclient)
+
+	return nil
+}
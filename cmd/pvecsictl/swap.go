@@ -27,12 +27,13 @@ import (
 	tools "github.com/sergelogvinov/proxmox-csi-plugin/pkg/tools/kubernetes"
 
 	rbacv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	clientkubernetes "k8s.io/client-go/kubernetes"
 )
 
 type swapCmd struct {
-	kclient   *clientkubernetes.Clientset
+	kclient   clientkubernetes.Interface
 	namespace string
 }
 
@@ -186,7 +187,15 @@ func (c *swapCmd) runSwap(cmd *cobra.Command, args []string) error {
 		}
 	}
 
-	err = swapPVC(ctx, c.kclient, c.namespace, srcPVC, srcPV, dstPVC, dstPV)
+	j, err := newJournal(ctx, c.kclient, "swap", c.namespace,
+		[]*corev1.PersistentVolumeClaim{srcPVC, dstPVC}, []*corev1.PersistentVolume{srcPV, dstPV})
+	if err != nil {
+		return err
+	}
+
+	err = swapPVC(ctx, c.kclient, j, c.namespace, srcPVC, srcPV, dstPVC, dstPV)
+	j.finish(ctx, err)
+
 	if err != nil {
 		cordonedNodes = []string{}
 
@@ -224,6 +233,13 @@ func (c *swapCmd) swapValidate(cmd *cobra.Command, _ []string) error {
 		{Group: "", Namespace: "", Resource: "pods", Verb: "delete"},
 		{Group: "", Namespace: "", Resource: "nodes", Verb: "patch"},
 	}
+	accessCheck = append(accessCheck, journalAccessCheck(namespace)...)
 
-	return checkPermissions(context.TODO(), c.kclient, accessCheck)
+	if err = checkPermissions(context.TODO(), c.kclient, accessCheck); err != nil {
+		return err
+	}
+
+	c.kclient = dryRunKubeClient(c.kclient)
+
+	return nil
 }
@@ -19,6 +19,9 @@ package main
 import (
 	"context"
 	"fmt"
+	"path"
+	"slices"
+	"strings"
 
 	"github.com/sergelogvinov/proxmox-csi-plugin/pkg/csi"
 	tools "github.com/sergelogvinov/proxmox-csi-plugin/pkg/tools/kubernetes"
@@ -31,9 +34,12 @@ import (
 	clientkubernetes "k8s.io/client-go/kubernetes"
 )
 
+// attacherFinalizer is set by the external-attacher on the attached volumes.
+var attacherFinalizer = "external-attacher/" + strings.ReplaceAll(csi.DriverName, ".", "-")
+
 func cordoneNodeWithPVs(
 	ctx context.Context,
-	kclient *clientkubernetes.Clientset,
+	kclient clientkubernetes.Interface,
 	pv *corev1.PersistentVolume,
 ) ([]string, error) {
 	var (
@@ -55,12 +61,13 @@ func cordoneNodeWithPVs(
 
 func replacePVTopology(
 	ctx context.Context,
-	clientset *clientkubernetes.Clientset,
+	clientset clientkubernetes.Interface,
+	j *journal,
 	namespace string,
 	pvc *corev1.PersistentVolumeClaim,
 	pv *corev1.PersistentVolume,
-	vol *volume.Volume,
-	node string,
+	dst *volume.Volume,
+	shared bool,
 ) error {
 	newPVC := pvc.DeepCopy()
 	newPVC.ObjectMeta.UID = ""
@@ -83,24 +90,23 @@ func replacePVTopology(
 	newPV.ObjectMeta.DeletionGracePeriodSeconds = nil
 	newPV.Spec.ClaimRef = nil
 	newPV.Status = corev1.PersistentVolumeStatus{}
-	newPV.Spec.CSI.VolumeHandle = volume.NewVolume(vol.Region(), node, vol.Storage(), vol.Disk()).VolumeID()
-	newPV.Spec.NodeAffinity.Required = &corev1.NodeSelector{
-		NodeSelectorTerms: []corev1.NodeSelectorTerm{
-			{
-				MatchExpressions: []corev1.NodeSelectorRequirement{
-					{
-						Key:      corev1.LabelTopologyRegion,
-						Operator: "In",
-						Values:   []string{vol.Region()},
-					},
-					{
-						Key:      corev1.LabelTopologyZone,
-						Operator: "In",
-						Values:   []string{node},
-					},
-				},
-			},
-		},
+
+	setPVVolume(newPV, dst, shared)
+
+	// The source disk must be kept until the volume is replaced, the previous state can be restored from the journal.
+	if pv.Spec.PersistentVolumeReclaimPolicy == corev1.PersistentVolumeReclaimDelete {
+		if err := j.step(ctx, "patch", "persistentvolumes", "", pv.Name); err != nil {
+			return err
+		}
+
+		patch := []byte(`{"spec":{"persistentVolumeReclaimPolicy":"` + corev1.PersistentVolumeReclaimRetain + `"}}`)
+		if _, err := clientset.CoreV1().PersistentVolumes().Patch(ctx, pv.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+			return fmt.Errorf("failed to patch PV: %v", err)
+		}
+	}
+
+	if err := j.step(ctx, "delete", "persistentvolumeclaims", namespace, pvc.Name); err != nil {
+		return err
 	}
 
 	policy := metav1.DeletePropagationForeground
@@ -108,20 +114,30 @@ func replacePVTopology(
 		return fmt.Errorf("failed to delete PVC: %v", err)
 	}
 
-	if pv.Spec.PersistentVolumeReclaimPolicy != corev1.PersistentVolumeReclaimDelete {
-		if err := clientset.CoreV1().PersistentVolumes().Delete(ctx, pv.Name, metav1.DeleteOptions{PropagationPolicy: &policy}); err != nil {
-			return fmt.Errorf("failed to delete PV: %v", err)
-		}
+	if err := j.step(ctx, "delete", "persistentvolumes", "", pv.Name); err != nil {
+		return err
+	}
+
+	if err := clientset.CoreV1().PersistentVolumes().Delete(ctx, pv.Name, metav1.DeleteOptions{PropagationPolicy: &policy}); err != nil {
+		return fmt.Errorf("failed to delete PV: %v", err)
 	}
 
 	if err := tools.PVWaitDelete(ctx, clientset, pv.Name); err != nil {
 		return fmt.Errorf("failed to wait for PV deletion: %v", err)
 	}
 
+	if err := j.step(ctx, "create", "persistentvolumes", "", newPV.Name); err != nil {
+		return err
+	}
+
 	if _, err := clientset.CoreV1().PersistentVolumes().Create(ctx, newPV, metav1.CreateOptions{}); err != nil {
 		return fmt.Errorf("failed to create PV: %v", err)
 	}
 
+	if err := j.step(ctx, "create", "persistentvolumeclaims", namespace, newPVC.Name); err != nil {
+		return err
+	}
+
 	if _, err := clientset.CoreV1().PersistentVolumeClaims(namespace).Create(ctx, newPVC, metav1.CreateOptions{}); err != nil {
 		if _, err := clientset.CoreV1().PersistentVolumeClaims(namespace).Update(ctx, newPVC, metav1.UpdateOptions{}); err != nil {
 			return fmt.Errorf("failed to create/update PVC: %v", err)
@@ -131,9 +147,108 @@ func replacePVTopology(
 	return nil
 }
 
+// setPVVolume sets the volume handle, topology and storage attributes of the PersistentVolume to the volume.
+func setPVVolume(pv *corev1.PersistentVolume, dst *volume.Volume, shared bool) {
+	affinity := []corev1.NodeSelectorRequirement{
+		{
+			Key:      corev1.LabelTopologyRegion,
+			Operator: "In",
+			Values:   []string{dst.Region()},
+		},
+	}
+
+	if shared {
+		pv.Spec.CSI.VolumeHandle = dst.VolumeSharedID()
+	} else {
+		pv.Spec.CSI.VolumeHandle = dst.VolumeID()
+		affinity = append(affinity, corev1.NodeSelectorRequirement{
+			Key:      corev1.LabelTopologyZone,
+			Operator: "In",
+			Values:   []string{dst.Node()},
+		})
+	}
+
+	pv.Spec.NodeAffinity = &corev1.VolumeNodeAffinity{
+		Required: &corev1.NodeSelector{
+			NodeSelectorTerms: []corev1.NodeSelectorTerm{{MatchExpressions: affinity}},
+		},
+	}
+
+	if pv.Spec.CSI.VolumeAttributes == nil {
+		pv.Spec.CSI.VolumeAttributes = map[string]string{}
+	}
+
+	pv.Spec.CSI.VolumeAttributes[csi.StorageIDKey] = dst.Storage()
+	if format := strings.TrimPrefix(path.Ext(dst.Disk()), "."); format != "" {
+		pv.Spec.CSI.VolumeAttributes[csi.StorageFormatKey] = format
+	} else {
+		delete(pv.Spec.CSI.VolumeAttributes, csi.StorageFormatKey)
+	}
+}
+
+// replacePVVolume recreates the bound PersistentVolume with the new volume, the PersistentVolumeClaim is not changed.
+// The volume keeps the claim reference, so the PV controller binds it to the same claim again.
+// The volume is still attached to the node, the new volume keeps the attacher finalizer to detach it later.
+func replacePVVolume(
+	ctx context.Context,
+	clientset clientkubernetes.Interface,
+	j *journal,
+	pv *corev1.PersistentVolume,
+	dst *volume.Volume,
+	shared bool,
+) error {
+	newPV := pv.DeepCopy()
+	newPV.ObjectMeta.UID = ""
+	newPV.ObjectMeta.ResourceVersion = ""
+	newPV.ObjectMeta.ManagedFields = nil
+	newPV.ObjectMeta.DeletionTimestamp = nil
+	newPV.ObjectMeta.DeletionGracePeriodSeconds = nil
+	newPV.Status = corev1.PersistentVolumeStatus{}
+
+	if !slices.Contains(newPV.Finalizers, attacherFinalizer) {
+		newPV.Finalizers = append(newPV.Finalizers, attacherFinalizer)
+	}
+
+	setPVVolume(newPV, dst, shared)
+
+	if err := j.step(ctx, "patch", "persistentvolumes", "", pv.Name); err != nil {
+		return err
+	}
+
+	// The source disk does not exist anymore, the provisioner must not try to delete it,
+	// and the attached volume can be deleted only without the finalizers.
+	patch := []byte(`{"metadata":{"finalizers":null},"spec":{"persistentVolumeReclaimPolicy":"` + corev1.PersistentVolumeReclaimRetain + `"}}`)
+	if _, err := clientset.CoreV1().PersistentVolumes().Patch(ctx, pv.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		return fmt.Errorf("failed to patch PV: %v", err)
+	}
+
+	if err := j.step(ctx, "delete", "persistentvolumes", "", pv.Name); err != nil {
+		return err
+	}
+
+	if err := clientset.CoreV1().PersistentVolumes().Delete(ctx, pv.Name, metav1.DeleteOptions{}); err != nil {
+		return fmt.Errorf("failed to delete PV: %v", err)
+	}
+
+	if err := tools.PVWaitDelete(ctx, clientset, pv.Name); err != nil {
+		return fmt.Errorf("failed to wait for PV deletion: %v", err)
+	}
+
+	if err := j.step(ctx, "create", "persistentvolumes", "", newPV.Name); err != nil {
+		return err
+	}
+
+	if _, err := clientset.CoreV1().PersistentVolumes().Create(ctx, newPV, metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("failed to create PV: %v", err)
+	}
+
+	return nil
+}
+
 func renamePVC(
 	ctx context.Context,
-	clientset *clientkubernetes.Clientset,
+	clientset clientkubernetes.Interface,
+	j *journal,
 	namespace string,
 	pvc *corev1.PersistentVolumeClaim,
 	pv *corev1.PersistentVolume,
@@ -151,11 +266,19 @@ func renamePVC(
 	patch := []byte(`{"spec":{"persistentVolumeReclaimPolicy":"` + corev1.PersistentVolumeReclaimRetain + `"}}`)
 
 	if pv.Spec.PersistentVolumeReclaimPolicy == corev1.PersistentVolumeReclaimDelete {
+		if err := j.step(ctx, "patch", "persistentvolumes", "", pvc.Spec.VolumeName); err != nil {
+			return err
+		}
+
 		if _, err := clientset.CoreV1().PersistentVolumes().Patch(ctx, pvc.Spec.VolumeName, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
 			return fmt.Errorf("failed to patch PersistentVolume: %v", err)
 		}
 	}
 
+	if err := j.step(ctx, "delete", "persistentvolumeclaims", namespace, pvc.Name); err != nil {
+		return err
+	}
+
 	policy := metav1.DeletePropagationForeground
 	if err := clientset.CoreV1().PersistentVolumeClaims(namespace).Delete(ctx, pvc.Name, metav1.DeleteOptions{PropagationPolicy: &policy}); err != nil {
 		return fmt.Errorf("failed to delete PersistentVolumeClaim: %v", err)
@@ -163,10 +286,18 @@ func renamePVC(
 
 	patch = []byte(`{"spec":{"claimRef":null}}`)
 
+	if err := j.step(ctx, "patch", "persistentvolumes", "", pvc.Spec.VolumeName); err != nil {
+		return err
+	}
+
 	if _, err := clientset.CoreV1().PersistentVolumes().Patch(ctx, pvc.Spec.VolumeName, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
 		return fmt.Errorf("failed to patch PersistentVolume: %v", err)
 	}
 
+	if err := j.step(ctx, "create", "persistentvolumeclaims", namespace, newPVC.Name); err != nil {
+		return err
+	}
+
 	if _, err := tools.PVCCreateOrUpdate(ctx, clientset, newPVC); err != nil {
 		return fmt.Errorf("failed to create/update PersistentVolumeClaim %s: %v", newPVC.Name, err)
 	}
@@ -176,7 +307,8 @@ func renamePVC(
 
 func swapPVC(
 	ctx context.Context,
-	clientset *clientkubernetes.Clientset,
+	clientset clientkubernetes.Interface,
+	j *journal,
 	namespace string,
 	srcPVC *corev1.PersistentVolumeClaim,
 	srcPV *corev1.PersistentVolume,
@@ -204,12 +336,20 @@ func swapPVC(
 	patch := []byte(`{"spec":{"persistentVolumeReclaimPolicy":"` + corev1.PersistentVolumeReclaimRetain + `"}}`)
 
 	if srcPV.Spec.PersistentVolumeReclaimPolicy == corev1.PersistentVolumeReclaimDelete {
+		if err := j.step(ctx, "patch", "persistentvolumes", "", srcPVC.Spec.VolumeName); err != nil {
+			return err
+		}
+
 		if _, err := clientset.CoreV1().PersistentVolumes().Patch(ctx, srcPVC.Spec.VolumeName, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
 			return fmt.Errorf("failed to patch PersistentVolume: %v", err)
 		}
 	}
 
 	if dstPV.Spec.PersistentVolumeReclaimPolicy == corev1.PersistentVolumeReclaimDelete {
+		if err := j.step(ctx, "patch", "persistentvolumes", "", dstPVC.Spec.VolumeName); err != nil {
+			return err
+		}
+
 		if _, err := clientset.CoreV1().PersistentVolumes().Patch(ctx, dstPVC.Spec.VolumeName, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
 			return fmt.Errorf("failed to patch PersistentVolume: %v", err)
 		}
@@ -217,28 +357,52 @@ func swapPVC(
 
 	policy := metav1.DeletePropagationForeground
 
+	if err := j.step(ctx, "delete", "persistentvolumeclaims", namespace, srcPVC.Name); err != nil {
+		return err
+	}
+
 	if err := clientset.CoreV1().PersistentVolumeClaims(namespace).Delete(ctx, srcPVC.Name, metav1.DeleteOptions{PropagationPolicy: &policy}); err != nil {
 		return fmt.Errorf("failed to delete PersistentVolumeClaim: %v", err)
 	}
 
+	if err := j.step(ctx, "delete", "persistentvolumeclaims", namespace, dstPVC.Name); err != nil {
+		return err
+	}
+
 	if err := clientset.CoreV1().PersistentVolumeClaims(namespace).Delete(ctx, dstPVC.Name, metav1.DeleteOptions{PropagationPolicy: &policy}); err != nil {
 		return fmt.Errorf("failed to delete PersistentVolumeClaim: %v", err)
 	}
 
 	patch = []byte(`{"spec":{"claimRef":null}}`)
 
+	if err := j.step(ctx, "patch", "persistentvolumes", "", srcPVC.Spec.VolumeName); err != nil {
+		return err
+	}
+
 	if _, err := clientset.CoreV1().PersistentVolumes().Patch(ctx, srcPVC.Spec.VolumeName, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
 		return fmt.Errorf("failed to patch PersistentVolume: %v", err)
 	}
 
+	if err := j.step(ctx, "patch", "persistentvolumes", "", dstPVC.Spec.VolumeName); err != nil {
+		return err
+	}
+
 	if _, err := clientset.CoreV1().PersistentVolumes().Patch(ctx, dstPVC.Spec.VolumeName, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
 		return fmt.Errorf("failed to patch PersistentVolume: %v", err)
 	}
 
+	if err := j.step(ctx, "create", "persistentvolumeclaims", namespace, newSrcPVC.Name); err != nil {
+		return err
+	}
+
 	if _, err := tools.PVCCreateOrUpdate(ctx, clientset, newSrcPVC); err != nil {
 		return fmt.Errorf("failed to create/update PersistentVolumeClaim %s: %v", newSrcPVC.Name, err)
 	}
 
+	if err := j.step(ctx, "create", "persistentvolumeclaims", namespace, newDstPVC.Name); err != nil {
+		return err
+	}
+
 	if _, err := tools.PVCCreateOrUpdate(ctx, clientset, newDstPVC); err != nil {
 		return fmt.Errorf("failed to create/update PersistentVolumeClaim %s: %v", newDstPVC.Name, err)
 	}
@@ -246,7 +410,7 @@ func swapPVC(
 	return nil
 }
 
-func checkPermissions(ctx context.Context, clientset *clientkubernetes.Clientset, perms []rbacv1.ResourceAttributes) error {
+func checkPermissions(ctx context.Context, clientset clientkubernetes.Interface, perms []rbacv1.ResourceAttributes) error {
 	for _, a := range perms {
 		sar := &rbacv1.SelfSubjectAccessReview{
 			Spec: rbacv1.SelfSubjectAccessReviewSpec{
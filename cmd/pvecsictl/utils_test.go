@@ -0,0 +1,128 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/jarcoal/httpmock"
+	proxmox "github.com/luthermonson/go-proxmox"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sergelogvinov/proxmox-csi-plugin/pkg/csi"
+	pxpool "github.com/sergelogvinov/proxmox-csi-plugin/pkg/proxmoxpool"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	testTaskOK    = "UPID:pve-1:003B4235:1DF4ABCA:667C1C45:csi:100:root@pam:"
+	testTaskError = "UPID:pve-1:003B4235:1DF4ABCA:667C1C45:csi:101:root@pam:"
+)
+
+func TestMain(m *testing.M) {
+	l := log.New()
+	l.SetOutput(io.Discard)
+
+	logger = log.NewEntry(l)
+
+	os.Exit(m.Run())
+}
+
+// newTestProxmoxPool activates the Proxmox API mock of the region cluster-1 with the completed and the failed tasks.
+func newTestProxmoxPool(t *testing.T) *pxpool.ProxmoxPool {
+	t.Helper()
+
+	httpmock.Activate()
+	t.Cleanup(httpmock.DeactivateAndReset)
+
+	for upid, exitStatus := range map[string]string{testTaskOK: "OK", testTaskError: "ERROR"} {
+		httpmock.RegisterResponder(http.MethodGet, fmt.Sprintf(`=~/nodes/pve-1/tasks/%s/status`, upid),
+			httpmock.NewJsonResponderOrPanic(200, map[string]any{"data": proxmox.Task{
+				UPID:       proxmox.UPID(upid),
+				Node:       "pve-1",
+				Status:     "stopped",
+				ExitStatus: exitStatus,
+			}}))
+	}
+
+	pool, err := pxpool.NewProxmoxPool([]*pxpool.ProxmoxCluster{{
+		URL:         "https://127.0.0.1:8006/api2/json",
+		TokenID:     "user!token",
+		TokenSecret: "secret",
+		Region:      "cluster-1",
+	}})
+	require.NoError(t, err)
+
+	return pool
+}
+
+// registerTestStorages registers the local storage local-lvm and the shared storage ceph on the nodes pve-1 and pve-2.
+func registerTestStorages() {
+	storages := []*proxmox.ClusterResource{}
+
+	for _, node := range []string{"pve-1", "pve-2"} {
+		storages = append(storages,
+			&proxmox.ClusterResource{Type: "storage", Node: node, Storage: "local-lvm", PluginType: "lvmthin", Status: "available"},
+			&proxmox.ClusterResource{Type: "storage", Node: node, Storage: "ceph", PluginType: "rbd", Shared: 1, Status: "available"},
+		)
+	}
+
+	httpmock.RegisterResponder(http.MethodGet, `=~/cluster/status$`,
+		httpmock.NewJsonResponderOrPanic(200, map[string]any{"data": []any{}}))
+	httpmock.RegisterResponder(http.MethodGet, `=~/cluster/resources`,
+		httpmock.NewJsonResponderOrPanic(200, map[string]any{"data": storages}))
+}
+
+// setTestPlan enables the dry-run mode for the test.
+func setTestPlan(t *testing.T) *dryRunPlan {
+	t.Helper()
+
+	plan = &dryRunPlan{}
+	t.Cleanup(func() { plan = nil })
+
+	return plan
+}
+
+func testPVC(name, pvName string) *corev1.PersistentVolumeClaim {
+	return &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec:       corev1.PersistentVolumeClaimSpec{VolumeName: pvName},
+		Status:     corev1.PersistentVolumeClaimStatus{Phase: corev1.ClaimBound},
+	}
+}
+
+func testPV(name, handle string) *corev1.PersistentVolume {
+	return &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: corev1.PersistentVolumeSpec{
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				CSI: &corev1.CSIPersistentVolumeSource{
+					Driver:       csi.DriverName,
+					VolumeHandle: handle,
+				},
+			},
+			PersistentVolumeReclaimPolicy: corev1.PersistentVolumeReclaimDelete,
+		},
+	}
+}
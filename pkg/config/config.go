@@ -24,6 +24,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	yaml "gopkg.in/yaml.v3"
 
@@ -47,6 +48,18 @@ const (
 
 	// MinControllerVMID is the minimum valid VM ID for the controller.
 	MinControllerVMID = 100
+
+	// DefaultTaskTimeout is the default timeout of the Proxmox tasks.
+	DefaultTaskTimeout = 30 * time.Second
+
+	// DefaultVMTaskTimeout is the default timeout of the Proxmox tasks which change the virtual machines and the disks.
+	DefaultVMTaskTimeout = 5 * time.Minute
+
+	// DefaultCopyTaskTimeout is the default timeout of the Proxmox disk copy tasks.
+	DefaultCopyTaskTimeout = 6 * time.Hour
+
+	// DefaultBridge is the default network bridge of the virtual machines migrated to the cluster.
+	DefaultBridge = "vmbr0"
 )
 
 // ClustersFeatures specifies the features for the cloud provider.
@@ -57,12 +70,140 @@ type ClustersFeatures struct {
 	// ControllerVMID is the VM ID used by the controller for volume operations (e.g. volume naming).
 	// Default is 9999.
 	ControllerVMID int `yaml:"controllerVmID,omitempty"`
+	// DefaultStorage is the Proxmox storage ID used when the StorageClass has no storage parameter.
+	DefaultStorage string `yaml:"defaultStorage,omitempty"`
+	// Tags are the tags of the virtual machines created by the controller.
+	Tags []string `yaml:"tags,omitempty"`
+	// TaskTimeout is the timeout of the Proxmox tasks, e.g. waiting for the attached disk or pausing a VM.
+	// Default is 30s.
+	TaskTimeout time.Duration `yaml:"taskTimeout,omitempty"`
+	// VMTaskTimeout is the timeout of the Proxmox tasks which change the virtual machines and the disks,
+	// e.g. updating the VM config or deleting a snapshot.
+	// Default is 5m.
+	VMTaskTimeout time.Duration `yaml:"vmTaskTimeout,omitempty"`
+	// CopyTaskTimeout is the timeout of the Proxmox disk copy tasks.
+	// Default is 6h.
+	CopyTaskTimeout time.Duration `yaml:"copyTaskTimeout,omitempty"`
+	// Bridge is the network bridge of the virtual machines migrated to the cluster,
+	// e.g. the owners of the volumes copied from another region.
+	// Default is vmbr0.
+	Bridge string `yaml:"bridge,omitempty"`
+}
+
+// override returns the features with the values set in the cluster features.
+func (f ClustersFeatures) override(cluster ClustersFeatures) ClustersFeatures {
+	if cluster.Provider != "" {
+		f.Provider = cluster.Provider
+	}
+
+	if cluster.ControllerVMID != 0 {
+		f.ControllerVMID = cluster.ControllerVMID
+	}
+
+	if cluster.DefaultStorage != "" {
+		f.DefaultStorage = cluster.DefaultStorage
+	}
+
+	if cluster.Tags != nil {
+		f.Tags = cluster.Tags
+	}
+
+	if cluster.TaskTimeout != 0 {
+		f.TaskTimeout = cluster.TaskTimeout
+	}
+
+	if cluster.VMTaskTimeout != 0 {
+		f.VMTaskTimeout = cluster.VMTaskTimeout
+	}
+
+	if cluster.CopyTaskTimeout != 0 {
+		f.CopyTaskTimeout = cluster.CopyTaskTimeout
+	}
+
+	if cluster.Bridge != "" {
+		f.Bridge = cluster.Bridge
+	}
+
+	return f
+}
+
+// StorageAlias is the Proxmox storage of the logical storage name in the region.
+type StorageAlias struct {
+	// Storage is the Proxmox storage ID on the nodes of the region.
+	Storage string `yaml:"storage"`
+	// Nodes overrides the Proxmox storage ID on the nodes, the key is the node name.
+	Nodes map[string]string `yaml:"nodes,omitempty"`
+}
+
+// StorageAliases maps the region and the logical storage name to the Proxmox storage.
+type StorageAliases map[string]map[string]StorageAlias
+
+// Resolve returns the Proxmox storage ID of the logical storage name in the region and on the node.
+// The node can be empty, the name is returned as is if it has no alias.
+func (a StorageAliases) Resolve(region, node, name string) string {
+	alias, ok := a[region][name]
+	if !ok {
+		return name
+	}
+
+	if storage := alias.Nodes[node]; node != "" && storage != "" {
+		return storage
+	}
+
+	return alias.Storage
 }
 
 // ClustersConfig is proxmox multi-cluster cloud config.
 type ClustersConfig struct {
 	Features ClustersFeatures         `yaml:"features,omitempty"`
 	Clusters []*pxpool.ProxmoxCluster `yaml:"clusters,omitempty"`
+	// Storages are the storage aliases, one StorageClass can use the same logical name in all regions.
+	Storages StorageAliases `yaml:"storages,omitempty"`
+	// ClusterFeatures are the features of the clusters which override the global features, the key is the region.
+	ClusterFeatures map[string]ClustersFeatures `yaml:"-"`
+}
+
+// UnmarshalYAML decodes the cloud config with the features block of the clusters.
+func (c *ClustersConfig) UnmarshalYAML(value *yaml.Node) error {
+	type plain ClustersConfig
+
+	if err := value.Decode((*plain)(c)); err != nil {
+		return err
+	}
+
+	clusters := struct {
+		Clusters []struct {
+			Region   string            `yaml:"region"`
+			Features *ClustersFeatures `yaml:"features"`
+		} `yaml:"clusters"`
+	}{}
+
+	if err := value.Decode(&clusters); err != nil {
+		return err
+	}
+
+	for _, cluster := range clusters.Clusters {
+		if cluster.Features == nil {
+			continue
+		}
+
+		if c.ClusterFeatures == nil {
+			c.ClusterFeatures = make(map[string]ClustersFeatures)
+		}
+
+		c.ClusterFeatures[cluster.Region] = *cluster.Features
+	}
+
+	return nil
+}
+
+// RegionFeatures returns the features of the cluster in the region.
+func (c ClustersConfig) RegionFeatures(region string) ClustersFeatures {
+	if features, ok := c.ClusterFeatures[region]; ok {
+		return features
+	}
+
+	return c.Features
 }
 
 // Errors for Reading Cloud Config
@@ -73,6 +214,8 @@ var (
 	ErrInvalidAuthCredentials = errors.New("must specify one of user, token or file credentials, not multiple")
 	ErrInvalidCloudConfig     = errors.New("invalid cloud config")
 	ErrInvalidVMID            = errors.New("invalid VM ID, must be greater than 100")
+	ErrInvalidStorageAlias    = errors.New("storage alias must have the storage ID")
+	ErrInvalidTaskTimeout     = errors.New("task timeout must be positive")
 )
 
 // ReadCloudConfig reads cloud config from a reader.
@@ -116,6 +259,14 @@ func ReadCloudConfig(config io.Reader) (ClustersConfig, error) {
 		}
 	}
 
+	for region, aliases := range cfg.Storages {
+		for name, alias := range aliases {
+			if alias.Storage == "" {
+				return ClustersConfig{}, fmt.Errorf("storage alias %s in region %s: %w", name, region, ErrInvalidStorageAlias)
+			}
+		}
+	}
+
 	if cfg.Features.Provider == "" {
 		cfg.Features.Provider = ProviderDefault
 	}
@@ -124,10 +275,44 @@ func ReadCloudConfig(config io.Reader) (ClustersConfig, error) {
 		cfg.Features.ControllerVMID = DefaultControllerVMID
 	}
 
+	if cfg.Features.TaskTimeout == 0 {
+		cfg.Features.TaskTimeout = DefaultTaskTimeout
+	}
+
+	if cfg.Features.VMTaskTimeout == 0 {
+		cfg.Features.VMTaskTimeout = DefaultVMTaskTimeout
+	}
+
+	if cfg.Features.CopyTaskTimeout == 0 {
+		cfg.Features.CopyTaskTimeout = DefaultCopyTaskTimeout
+	}
+
+	if cfg.Features.Bridge == "" {
+		cfg.Features.Bridge = DefaultBridge
+	}
+
 	if cfg.Features.ControllerVMID <= MinControllerVMID {
 		return ClustersConfig{}, fmt.Errorf("invalid VM ID, must be greater than %d", MinControllerVMID)
 	}
 
+	if cfg.Features.TaskTimeout < 0 || cfg.Features.VMTaskTimeout < 0 || cfg.Features.CopyTaskTimeout < 0 {
+		return ClustersConfig{}, ErrInvalidTaskTimeout
+	}
+
+	for region, features := range cfg.ClusterFeatures {
+		features = cfg.Features.override(features)
+
+		if features.ControllerVMID <= MinControllerVMID {
+			return ClustersConfig{}, fmt.Errorf("cluster %s: invalid VM ID, must be greater than %d", region, MinControllerVMID)
+		}
+
+		if features.TaskTimeout < 0 || features.VMTaskTimeout < 0 || features.CopyTaskTimeout < 0 {
+			return ClustersConfig{}, fmt.Errorf("cluster %s: %w", region, ErrInvalidTaskTimeout)
+		}
+
+		cfg.ClusterFeatures[region] = features
+	}
+
 	return cfg, nil
 }
 
@@ -20,6 +20,7 @@ import (
 	"io"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
@@ -115,8 +116,12 @@ clusters:
 `),
 			expected: &providerconfig.ClustersConfig{
 				Features: providerconfig.ClustersFeatures{
-					Provider:       providerconfig.ProviderDefault,
-					ControllerVMID: providerconfig.DefaultControllerVMID,
+					Provider:        providerconfig.ProviderDefault,
+					ControllerVMID:  providerconfig.DefaultControllerVMID,
+					TaskTimeout:     providerconfig.DefaultTaskTimeout,
+					VMTaskTimeout:   providerconfig.DefaultVMTaskTimeout,
+					CopyTaskTimeout: providerconfig.DefaultCopyTaskTimeout,
+					Bridge:          providerconfig.DefaultBridge,
 				},
 				Clusters: []*pxpool.ProxmoxCluster{
 					{
@@ -141,8 +146,12 @@ clusters:
 `),
 			expected: &providerconfig.ClustersConfig{
 				Features: providerconfig.ClustersFeatures{
-					Provider:       providerconfig.ProviderDefault,
-					ControllerVMID: providerconfig.DefaultControllerVMID,
+					Provider:        providerconfig.ProviderDefault,
+					ControllerVMID:  providerconfig.DefaultControllerVMID,
+					TaskTimeout:     providerconfig.DefaultTaskTimeout,
+					VMTaskTimeout:   providerconfig.DefaultVMTaskTimeout,
+					CopyTaskTimeout: providerconfig.DefaultCopyTaskTimeout,
+					Bridge:          providerconfig.DefaultBridge,
 				},
 				Clusters: []*pxpool.ProxmoxCluster{
 					{
@@ -167,8 +176,12 @@ clusters:
 `),
 			expected: &providerconfig.ClustersConfig{
 				Features: providerconfig.ClustersFeatures{
-					Provider:       providerconfig.ProviderDefault,
-					ControllerVMID: providerconfig.DefaultControllerVMID,
+					Provider:        providerconfig.ProviderDefault,
+					ControllerVMID:  providerconfig.DefaultControllerVMID,
+					TaskTimeout:     providerconfig.DefaultTaskTimeout,
+					VMTaskTimeout:   providerconfig.DefaultVMTaskTimeout,
+					CopyTaskTimeout: providerconfig.DefaultCopyTaskTimeout,
+					Bridge:          providerconfig.DefaultBridge,
 				},
 				Clusters: []*pxpool.ProxmoxCluster{
 					{
@@ -195,8 +208,12 @@ clusters:
 `),
 			expected: &providerconfig.ClustersConfig{
 				Features: providerconfig.ClustersFeatures{
-					Provider:       providerconfig.ProviderCapmox,
-					ControllerVMID: providerconfig.DefaultControllerVMID,
+					Provider:        providerconfig.ProviderCapmox,
+					ControllerVMID:  providerconfig.DefaultControllerVMID,
+					TaskTimeout:     providerconfig.DefaultTaskTimeout,
+					VMTaskTimeout:   providerconfig.DefaultVMTaskTimeout,
+					CopyTaskTimeout: providerconfig.DefaultCopyTaskTimeout,
+					Bridge:          providerconfig.DefaultBridge,
 				},
 				Clusters: []*pxpool.ProxmoxCluster{
 					{
@@ -209,6 +226,162 @@ clusters:
 				},
 			},
 		},
+		{
+			msg: "storage aliases",
+			config: strings.NewReader(`
+clusters:
+  - url: https://example.com
+    token_id: "ha"
+    token_secret: "secret"
+    region: cluster-1
+storages:
+  cluster-1:
+    fast:
+      storage: local-zfs
+      nodes:
+        pve-3: tank
+`),
+			expected: &providerconfig.ClustersConfig{
+				Features: providerconfig.ClustersFeatures{
+					Provider:        providerconfig.ProviderDefault,
+					ControllerVMID:  providerconfig.DefaultControllerVMID,
+					TaskTimeout:     providerconfig.DefaultTaskTimeout,
+					VMTaskTimeout:   providerconfig.DefaultVMTaskTimeout,
+					CopyTaskTimeout: providerconfig.DefaultCopyTaskTimeout,
+					Bridge:          providerconfig.DefaultBridge,
+				},
+				Clusters: []*pxpool.ProxmoxCluster{
+					{
+						URL:         "https://example.com",
+						TokenID:     "ha",
+						TokenSecret: "secret",
+						Region:      "cluster-1",
+					},
+				},
+				Storages: providerconfig.StorageAliases{
+					"cluster-1": {
+						"fast": {Storage: "local-zfs", Nodes: map[string]string{"pve-3": "tank"}},
+					},
+				},
+			},
+		},
+		{
+			msg: "storage alias without storage",
+			config: strings.NewReader(`
+clusters:
+  - url: https://example.com
+    token_id: "ha"
+    token_secret: "secret"
+    region: cluster-1
+storages:
+  cluster-1:
+    fast:
+      nodes:
+        pve-3: tank
+`),
+			expectedError: providerconfig.ErrInvalidStorageAlias.Error(),
+		},
+		{
+			msg: "cluster features",
+			config: strings.NewReader(`
+features:
+  controllerVmID: 8888
+  tags: [k8s]
+clusters:
+  - url: https://example.com
+    token_id: "ha"
+    token_secret: "secret"
+    region: cluster-1
+  - url: https://example.org
+    token_id: "ha"
+    token_secret: "secret"
+    region: cluster-2
+    features:
+      provider: capmox
+      controllerVmID: 7777
+      defaultStorage: local-zfs
+      tags: [k8s, csi]
+      vmTaskTimeout: 10m
+      copyTaskTimeout: 12h
+      bridge: vmbr1
+`),
+			expected: &providerconfig.ClustersConfig{
+				Features: providerconfig.ClustersFeatures{
+					Provider:        providerconfig.ProviderDefault,
+					ControllerVMID:  8888,
+					Tags:            []string{"k8s"},
+					TaskTimeout:     providerconfig.DefaultTaskTimeout,
+					VMTaskTimeout:   providerconfig.DefaultVMTaskTimeout,
+					CopyTaskTimeout: providerconfig.DefaultCopyTaskTimeout,
+					Bridge:          providerconfig.DefaultBridge,
+				},
+				Clusters: []*pxpool.ProxmoxCluster{
+					{
+						URL:         "https://example.com",
+						TokenID:     "ha",
+						TokenSecret: "secret",
+						Region:      "cluster-1",
+					},
+					{
+						URL:         "https://example.org",
+						TokenID:     "ha",
+						TokenSecret: "secret",
+						Region:      "cluster-2",
+					},
+				},
+				ClusterFeatures: map[string]providerconfig.ClustersFeatures{
+					"cluster-2": {
+						Provider:        providerconfig.ProviderCapmox,
+						ControllerVMID:  7777,
+						DefaultStorage:  "local-zfs",
+						Tags:            []string{"k8s", "csi"},
+						TaskTimeout:     providerconfig.DefaultTaskTimeout,
+						VMTaskTimeout:   10 * time.Minute,
+						CopyTaskTimeout: 12 * time.Hour,
+						Bridge:          "vmbr1",
+					},
+				},
+			},
+		},
+		{
+			msg: "cluster features with invalid vmID",
+			config: strings.NewReader(`
+clusters:
+  - url: https://example.com
+    token_id: "ha"
+    token_secret: "secret"
+    region: cluster-1
+    features:
+      controllerVmID: 50
+`),
+			expectedError: "cluster cluster-1: invalid VM ID",
+		},
+		{
+			msg: "cluster features with invalid task timeout",
+			config: strings.NewReader(`
+clusters:
+  - url: https://example.com
+    token_id: "ha"
+    token_secret: "secret"
+    region: cluster-1
+    features:
+      taskTimeout: -1s
+`),
+			expectedError: providerconfig.ErrInvalidTaskTimeout.Error(),
+		},
+		{
+			msg: "cluster features with invalid vm task timeout",
+			config: strings.NewReader(`
+clusters:
+  - url: https://example.com
+    token_id: "ha"
+    token_secret: "secret"
+    region: cluster-1
+    features:
+      vmTaskTimeout: -1m
+`),
+			expectedError: providerconfig.ErrInvalidTaskTimeout.Error(),
+		},
 	}
 
 	for _, testCase := range tests {
@@ -229,6 +402,38 @@ clusters:
 	}
 }
 
+func TestStorageAliasesResolve(t *testing.T) {
+	aliases := providerconfig.StorageAliases{
+		"cluster-1": {
+			"fast": {Storage: "local-zfs", Nodes: map[string]string{"pve-3": "tank"}},
+		},
+		"cluster-2": {
+			"fast": {Storage: "tank"},
+		},
+	}
+
+	assert.Equal(t, "local-zfs", aliases.Resolve("cluster-1", "pve-1", "fast"))
+	assert.Equal(t, "local-zfs", aliases.Resolve("cluster-1", "", "fast"))
+	assert.Equal(t, "tank", aliases.Resolve("cluster-1", "pve-3", "fast"))
+	assert.Equal(t, "tank", aliases.Resolve("cluster-2", "pve-1", "fast"))
+	assert.Equal(t, "local-lvm", aliases.Resolve("cluster-1", "pve-1", "local-lvm"))
+	assert.Equal(t, "fast", aliases.Resolve("cluster-3", "pve-1", "fast"))
+	assert.Equal(t, "fast", providerconfig.StorageAliases(nil).Resolve("cluster-1", "pve-1", "fast"))
+}
+
+func TestRegionFeatures(t *testing.T) {
+	cfg := providerconfig.ClustersConfig{
+		Features: providerconfig.ClustersFeatures{ControllerVMID: 9999},
+		ClusterFeatures: map[string]providerconfig.ClustersFeatures{
+			"cluster-2": {ControllerVMID: 7777},
+		},
+	}
+
+	assert.Equal(t, 9999, cfg.RegionFeatures("cluster-1").ControllerVMID)
+	assert.Equal(t, 7777, cfg.RegionFeatures("cluster-2").ControllerVMID)
+	assert.Equal(t, 9999, cfg.RegionFeatures("").ControllerVMID)
+}
+
 func TestReadCloudConfigFromFile(t *testing.T) {
 	cfg, err := providerconfig.ReadCloudConfigFromFile("testdata/cloud-config.yaml")
 	assert.NotNil(t, err)
@@ -23,10 +23,12 @@ import (
 	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/kubernetes-csi/csi-lib-utils/protosanitizer"
+	proxmox "github.com/luthermonson/go-proxmox"
 	"github.com/patrickmn/go-cache"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -38,12 +40,15 @@ import (
 	"github.com/sergelogvinov/proxmox-csi-plugin/pkg/metrics"
 	pxpool "github.com/sergelogvinov/proxmox-csi-plugin/pkg/proxmoxpool"
 	utilsnode "github.com/sergelogvinov/proxmox-csi-plugin/pkg/utils/node"
+	"github.com/sergelogvinov/proxmox-csi-plugin/pkg/utils/provider"
 	volume "github.com/sergelogvinov/proxmox-csi-plugin/pkg/utils/volume"
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	toolscache "k8s.io/client-go/tools/cache"
 	"k8s.io/klog/v2"
 )
 
@@ -64,19 +69,31 @@ var controllerCaps = []csi.ControllerServiceCapability_RPC_Type{
 	csi.ControllerServiceCapability_RPC_GET_VOLUME,
 	csi.ControllerServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER,
 	csi.ControllerServiceCapability_RPC_MODIFY_VOLUME,
+	csi.ControllerServiceCapability_RPC_LIST_VOLUMES,
+	csi.ControllerServiceCapability_RPC_LIST_VOLUMES_PUBLISHED_NODES,
+	csi.ControllerServiceCapability_RPC_VOLUME_CONDITION,
+	csi.ControllerServiceCapability_RPC_LIST_SNAPSHOTS,
 }
 
 // ControllerService is the controller service for the CSI driver
 type ControllerService struct {
 	csi.UnimplementedControllerServer
 
-	pxpool   *pxpool.ProxmoxPool
-	kclient  kubernetes.Interface
-	Provider csiconfig.Provider
-	vmID     int
+	pxpool  *pxpool.ProxmoxPool
+	kclient kubernetes.Interface
+	// features are the global features and the feature overrides of the clusters.
+	features csiconfig.ClustersConfig
 
 	storageCapacity *cache.Cache
 	vmLocks         *VMLocks
+	zoneCounter     *zoneCounter
+	zoneSelections  *cache.Cache
+	placementMu     sync.Mutex
+	storageAliases  csiconfig.StorageAliases
+
+	pvInformerOnce sync.Once
+	pvLister       corelisters.PersistentVolumeLister
+	pvSynced       toolscache.InformerSynced
 }
 
 // NewControllerService returns a new controller service
@@ -92,10 +109,14 @@ func NewControllerService(kclient kubernetes.Interface, cloudConfig string) (*Co
 	}
 
 	d := &ControllerService{
-		pxpool:   px,
-		kclient:  kclient,
-		Provider: cfg.Features.Provider,
-		vmID:     cfg.Features.ControllerVMID,
+		pxpool:  px,
+		kclient: kclient,
+		features: csiconfig.ClustersConfig{
+			Features:        cfg.Features,
+			ClusterFeatures: cfg.ClusterFeatures,
+		},
+
+		storageAliases: cfg.Storages,
 	}
 
 	d.Init()
@@ -112,6 +133,14 @@ func (d *ControllerService) Init() {
 	if d.storageCapacity == nil {
 		d.storageCapacity = cache.New(time.Minute, 5*time.Minute)
 	}
+
+	if d.zoneCounter == nil {
+		d.zoneCounter = newZoneCounter()
+	}
+
+	if d.zoneSelections == nil {
+		d.zoneSelections = cache.New(zoneSelectionTTL, zoneSelectionTTL)
+	}
 }
 
 // CreateVolume creates a volume
@@ -142,10 +171,6 @@ func (d *ControllerService) CreateVolume(ctx context.Context, request *csi.Creat
 
 	klog.V(5).InfoS("CreateVolume: parameters", "parameters", params, "modifyParameters", paramsVAC)
 
-	if params.StorageID == "" {
-		return nil, status.Error(codes.InvalidArgument, "parameter storage must be provided")
-	}
-
 	volSizeBytes := DefaultVolumeSizeBytes
 	if request.GetCapacityRange() != nil {
 		volSizeBytes = RoundUpSizeBytes(request.GetCapacityRange().GetRequiredBytes(), MinChunkSizeBytes)
@@ -161,6 +186,16 @@ func (d *ControllerService) CreateVolume(ctx context.Context, request *csi.Creat
 		return nil, err
 	}
 
+	features := d.features.RegionFeatures(region)
+
+	if params.StorageID == "" {
+		params.StorageID = features.DefaultStorage
+	}
+
+	if len(params.StorageIDs()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "parameter storage must be provided")
+	}
+
 	var srcVol *volume.Volume
 
 	contentSource := request.GetVolumeContentSource()
@@ -181,13 +216,6 @@ func (d *ControllerService) CreateVolume(ctx context.Context, request *csi.Creat
 	}
 
 	if srcVol != nil {
-		if srcVol.Region() != region {
-			err := status.Error(codes.InvalidArgument, "source snapshot region does not match the requested region")
-			klog.ErrorS(err, "CreateVolume: source snapshot region does not match the requested region", "sourceRegion", srcVol.Region(), "requestedRegion", region)
-
-			return nil, err
-		}
-
 		if _, err = d.checkVolume(ctx, srcVol); err != nil {
 			if status.Code(err) == codes.NotFound {
 				klog.ErrorS(err, "CreateVolume: zone or volume not found", "contentSourceID", srcVol.VolumeID())
@@ -208,21 +236,11 @@ func (d *ControllerService) CreateVolume(ctx context.Context, request *csi.Creat
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
-	if zone == "" {
-		zones, err := cl.GetNodesForStorage(ctx, params.StorageID)
-		if err != nil {
-			klog.ErrorS(err, "CreateVolume: failed to get zones with storage", "cluster", region, "storage", params.StorageID)
-
-			return nil, status.Errorf(codes.Internal, "failed to get zones with storage %s: %v", params.StorageID, err)
-		}
-
-		if len(zones) == 0 {
-			klog.ErrorS(err, "CreateVolume: failed to find best zone: no nodes with the storage", "cluster", region, "storage", params.StorageID)
-
-			return nil, status.Errorf(codes.Internal, "failed to find best zone: no nodes with the storage %s", params.StorageID)
-		}
+	zone, err = d.volumeStorageZone(ctx, cl, region, zone, pvc, accessibleTopology, volSizeBytes, &params, request.GetParameters())
+	if err != nil {
+		klog.ErrorS(err, "CreateVolume: failed to find best zone", "cluster", region, "storage", params.StorageID)
 
-		zone = zones[0]
+		return nil, err
 	}
 
 	storageConfig, err := cl.GetClusterStorage(ctx, params.StorageID)
@@ -279,14 +297,14 @@ func (d *ControllerService) CreateVolume(ctx context.Context, request *csi.Creat
 		}
 	}
 
-	id := d.vmID
+	id := features.ControllerVMID
 
 	if params.Replicate {
 		if storageConfig.PluginType != "zfspool" {
 			return nil, status.Error(codes.Internal, "error: storage type is not zfs in replication mode")
 		}
 
-		id, err = prepareReplication(ctx, cl, zone, pvc, d.vmID)
+		id, err = prepareReplication(ctx, cl, zone, pvc, features)
 		if err != nil {
 			klog.ErrorS(err, "CreateVolume: failed to prepare replication", "cluster", region, "zone", zone)
 
@@ -315,6 +333,92 @@ func (d *ControllerService) CreateVolume(ctx context.Context, request *csi.Creat
 
 	vol := volume.NewVolume(region, zone, params.StorageID, fmt.Sprintf("vm-%d-%s", id, pvc), format)
 
+	if srcVol != nil && srcVol.Snapshot() != "" {
+		srcCl, err := d.pxpool.GetProxmoxCluster(srcVol.Region())
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+
+		srcStorage, err := srcCl.GetClusterStorage(ctx, srcVol.Storage())
+		if err != nil {
+			klog.ErrorS(err, "CreateVolume: failed to get proxmox storage config", "cluster", srcVol.Region(), "storage", srcVol.Storage())
+
+			return nil, status.Errorf(codes.Internal, "failed to get proxmox storage config: %v", err)
+		}
+
+		if !slices.Contains(nativeCloneStorages, srcStorage.PluginType) {
+			return nil, status.Errorf(codes.InvalidArgument, "storage type %s does not support restoring snapshots to a new volume, use pvecsictl rollback", srcStorage.PluginType)
+		}
+	}
+
+	switch {
+	case srcVol != nil && isBackupSnapshot(srcVol):
+		if srcVol.Region() != region {
+			return nil, status.Errorf(codes.InvalidArgument, "snapshot %s must be restored in region %s", srcVol.VolumeID(), srcVol.Region())
+		}
+
+		klog.V(5).InfoS("CreateVolume: restoring volume from backup", "cluster", region, "zone", zone, "snapshotID", srcVol.VolumeID())
+
+		vol, err = restoreBackupSnapshot(ctx, cl, srcVol, vol, pvc, features)
+		if err != nil {
+			if err.Error() == ErrorNotFound {
+				return nil, status.Errorf(codes.NotFound, "snapshot %s is not found", srcVol.VolumeID())
+			}
+
+			klog.ErrorS(err, "CreateVolume: failed to restore backup", "cluster", region, "snapshotID", srcVol.VolumeID())
+
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+
+	case srcVol != nil && (srcVol.Region() != region || srcVol.Storage() != vol.Storage()):
+		if srcVol.Region() != region && storageConfig.Shared != 1 {
+			return nil, status.Errorf(codes.InvalidArgument, "storage %s must be shared to copy volumes from another region", vol.Storage())
+		}
+
+		// The disk is moved on the node with the source disk
+		if storageConfig.Shared != 1 && srcVol.Zone() != "" && srcVol.Zone() != zone {
+			return nil, status.Errorf(codes.InvalidArgument, "zone mismatch: requested zone %s does not match source zone %s", zone, srcVol.Zone())
+		}
+
+		klog.V(5).InfoS("CreateVolume: copying volume", "cluster", region, "zone", zone, "storage", vol.Storage(), "contentSourceID", srcVol.VolumeID())
+
+		// Native snapshots are cloned directly to the requested storage
+		if srcVol.Snapshot() != "" && srcVol.Region() == region {
+			vol, err = restoreNativeSnapshot(ctx, cl, srcVol, vol, pvc, features)
+		} else {
+			vol, err = transferVolume(ctx, d.pxpool, srcVol, vol, pvc, features)
+		}
+
+		if err != nil {
+			if err.Error() == ErrorNotFound {
+				return nil, status.Errorf(codes.NotFound, "snapshot %s is not found", srcVol.VolumeID())
+			}
+
+			klog.ErrorS(err, "CreateVolume: failed to copy volume", "cluster", region, "contentSourceID", srcVol.VolumeID())
+
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+
+	case srcVol != nil && srcVol.Snapshot() != "":
+		// The clone is created on the node with the snapshot
+		if storageConfig.Shared != 1 && srcVol.Zone() != zone {
+			return nil, status.Errorf(codes.InvalidArgument, "zone mismatch: requested zone %s does not match snapshot zone %s", zone, srcVol.Zone())
+		}
+
+		klog.V(5).InfoS("CreateVolume: restoring volume from native snapshot", "cluster", region, "zone", zone, "snapshotID", srcVol.VolumeID())
+
+		vol, err = restoreNativeSnapshot(ctx, cl, srcVol, vol, pvc, features)
+		if err != nil {
+			if err.Error() == ErrorNotFound {
+				return nil, status.Errorf(codes.NotFound, "snapshot %s is not found", srcVol.VolumeID())
+			}
+
+			klog.ErrorS(err, "CreateVolume: failed to restore native snapshot", "cluster", region, "snapshotID", srcVol.VolumeID())
+
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+
 	klog.V(5).InfoS("CreateVolume: creating volume", "cluster", region, "zone", zone, "volumeID", vol.VolumeID(), "size", volSizeBytes)
 
 	size, err := getVolumeSize(ctx, cl, vol)
@@ -345,10 +449,6 @@ func (d *ControllerService) CreateVolume(ctx context.Context, request *csi.Creat
 
 			klog.V(5).InfoS("CreateVolume: creating volume from snapshot", "volumeID", vol.VolumeID(), "snapshotID", srcVol.VolumeID())
 
-			if vol.Storage() != srcVol.Storage() {
-				return nil, status.Errorf(codes.InvalidArgument, "storage mismatch: requested storage %s does not match snapshot storage %s", vol.Storage(), srcVol.Storage())
-			}
-
 			if err = copyVolume(ctx, cl, srcVol, vol); mc.ObserveRequest(err) != nil {
 				return nil, status.Error(codes.Internal, err.Error())
 			}
@@ -384,7 +484,7 @@ func (d *ControllerService) CreateVolume(ctx context.Context, request *csi.Creat
 	volumeID := vol.VolumeID()
 
 	if params.Replicate {
-		err = createReplication(ctx, cl, id, vol, params)
+		err = createReplication(ctx, cl, id, vol, params, features)
 		if err != nil {
 			klog.ErrorS(err, "CreateVolume: failed to create replication", "cluster", region, "volumeID", vol.VolumeID(), "vmID", id)
 
@@ -425,7 +525,19 @@ func (d *ControllerService) DeleteVolume(ctx context.Context, request *csi.Delet
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
-	err = deleteReplication(ctx, cl, vol, d.vmID)
+	snapshots, err := getVolumeSnapshots(ctx, cl, vol)
+	if err != nil {
+		klog.ErrorS(err, "DeleteVolume: failed to get volume snapshots", "cluster", vol.Cluster(), "volumeID", vol.VolumeID())
+
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	// Proxmox removes the native snapshots together with the disk
+	if len(snapshots) > 0 {
+		return nil, status.Errorf(codes.FailedPrecondition, "volume %s has %d snapshots", vol.VolumeID(), len(snapshots))
+	}
+
+	err = deleteReplication(ctx, cl, vol, d.features.RegionFeatures(vol.Region()).ControllerVMID)
 	if err != nil {
 		klog.ErrorS(err, "DeleteVolume: failed to delete replication", "cluster", vol.Cluster(), "volumeID", vol.VolumeID())
 
@@ -547,8 +659,10 @@ func (d *ControllerService) ControllerPublishVolume(ctx context.Context, request
 	d.vmLocks.Lock(n.GetNodeName())
 	defer d.vmLocks.Unlock(n.GetNodeName())
 
+	features := d.features.RegionFeatures(vol.Region())
+
 	if params.Replicate {
-		err = migrateReplication(ctx, cl, id, vol, d.vmID)
+		err = migrateReplication(ctx, cl, id, vol, features)
 		if err != nil {
 			klog.ErrorS(err, "ControllerPublishVolume: failed to migrate/sync replication", "cluster", vol.Cluster(), "volumeID", vol.VolumeID(), "vmID", id)
 
@@ -558,7 +672,7 @@ func (d *ControllerService) ControllerPublishVolume(ctx context.Context, request
 
 	mc := metrics.NewMetricContext("attachVolume")
 
-	pvInfo, err := attachVolume(ctx, cl, id, vol, params.ToCFG())
+	pvInfo, err := attachVolume(ctx, cl, id, vol, params.ToCFG(), features)
 	if mc.ObserveRequest(err) != nil {
 		klog.ErrorS(err, "ControllerPublishVolume: failed to attach volume", "cluster", vol.Cluster(), "volumeID", vol.VolumeID(), "vmID", id)
 
@@ -638,14 +752,16 @@ func (d *ControllerService) ControllerUnpublishVolume(ctx context.Context, reque
 	d.vmLocks.Lock(n.GetNodeName())
 	defer d.vmLocks.Unlock(n.GetNodeName())
 
+	features := d.features.RegionFeatures(vol.Region())
+
 	mc := metrics.NewMetricContext("detachVolume")
-	if err := detachVolume(ctx, cl, id, vol); mc.ObserveRequest(err) != nil {
+	if err := detachVolume(ctx, cl, id, vol, features.VMTaskTimeout); mc.ObserveRequest(err) != nil {
 		klog.ErrorS(err, "ControllerUnpublishVolume: failed to detach volume", "cluster", vol.Cluster(), "volumeID", vol.VolumeID(), "vmID", id)
 
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
-	if err := waitDetachVolume(ctx, cl, id, vol); err != nil {
+	if err := waitDetachVolume(ctx, cl, id, vol, features.TaskTimeout); err != nil {
 		klog.ErrorS(err, "ControllerUnpublishVolume: failed to wait for volume detachment", "cluster", vol.Cluster(), "volumeID", vol.VolumeID(), "vmID", id)
 
 		return nil, status.Error(codes.Internal, err.Error())
@@ -657,17 +773,178 @@ func (d *ControllerService) ControllerUnpublishVolume(ctx context.Context, reque
 }
 
 // ValidateVolumeCapabilities validate volume capabilities
-func (d *ControllerService) ValidateVolumeCapabilities(_ context.Context, request *csi.ValidateVolumeCapabilitiesRequest) (*csi.ValidateVolumeCapabilitiesResponse, error) {
+func (d *ControllerService) ValidateVolumeCapabilities(ctx context.Context, request *csi.ValidateVolumeCapabilitiesRequest) (*csi.ValidateVolumeCapabilitiesResponse, error) {
 	klog.V(4).InfoS("ValidateVolumeCapabilities: called", "args", protosanitizer.StripSecrets(request))
 
-	return nil, status.Error(codes.Unimplemented, "")
+	volumeID := request.GetVolumeId()
+	if volumeID == "" {
+		return nil, status.Error(codes.InvalidArgument, "VolumeID must be provided")
+	}
+
+	volCapabilities := request.GetVolumeCapabilities()
+	if len(volCapabilities) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "VolumeCapabilities must be provided")
+	}
+
+	params, err := ExtractParameters(request.GetParameters())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	vol, err := volume.NewVolumeFromVolumeID(volumeID)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	cl, err := d.pxpool.GetProxmoxCluster(vol.Cluster())
+	if err != nil {
+		klog.ErrorS(err, "ValidateVolumeCapabilities: failed to get proxmox cluster", "cluster", vol.Cluster())
+
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	if _, err = d.checkVolume(ctx, vol); err != nil {
+		klog.ErrorS(err, "ValidateVolumeCapabilities: failed to check volume", "cluster", vol.Cluster(), "volumeID", vol.VolumeID())
+
+		return nil, err
+	}
+
+	storageConfig, err := cl.GetClusterStorage(ctx, vol.Storage())
+	if err != nil {
+		klog.ErrorS(err, "ValidateVolumeCapabilities: failed to get proxmox storage config", "cluster", vol.Cluster(), "storage", vol.Storage())
+
+		return nil, status.Errorf(codes.Internal, "failed to get proxmox storage config: %v", err)
+	}
+
+	// The logical storage names of the storage class are compared with the Proxmox storage of the volume
+	if params.StorageID != "" {
+		params.StorageID = strings.Join(d.resolveStorages(vol.Region(), vol.Zone(), params.StorageIDs()), ",")
+	}
+
+	if msg := validateVolumeCapabilities(volCapabilities, params, vol, storageConfig); msg != "" {
+		klog.V(3).InfoS("ValidateVolumeCapabilities: volume capabilities are not supported", "cluster", vol.Cluster(), "volumeID", vol.VolumeID(), "message", msg)
+
+		return &csi.ValidateVolumeCapabilitiesResponse{Message: msg}, nil
+	}
+
+	return &csi.ValidateVolumeCapabilitiesResponse{
+		Confirmed: &csi.ValidateVolumeCapabilitiesResponse_Confirmed{
+			VolumeContext:      request.GetVolumeContext(),
+			VolumeCapabilities: volCapabilities,
+			Parameters:         request.GetParameters(),
+			MutableParameters:  request.GetMutableParameters(),
+		},
+	}, nil
 }
 
 // ListVolumes list volumes
-func (d *ControllerService) ListVolumes(_ context.Context, request *csi.ListVolumesRequest) (*csi.ListVolumesResponse, error) {
+func (d *ControllerService) ListVolumes(ctx context.Context, request *csi.ListVolumesRequest) (*csi.ListVolumesResponse, error) {
 	klog.V(4).InfoS("ListVolumes: called", "args", protosanitizer.StripSecrets(request))
 
-	return nil, status.Error(codes.Unimplemented, "")
+	maxEntries := int(request.GetMaxEntries())
+	if maxEntries < 0 {
+		return nil, status.Error(codes.InvalidArgument, "MaxEntries must not be negative")
+	}
+
+	start, err := parseStartingToken(request.GetStartingToken())
+	if err != nil {
+		return nil, status.Error(codes.Aborted, err.Error())
+	}
+
+	vols := []storageVolume{}
+	vms := map[string]proxmox.ClusterResources{}
+	replicas := map[string]map[uint64]bool{}
+
+	regions := d.pxpool.GetRegions()
+	slices.Sort(regions)
+
+	for _, region := range regions {
+		cl, err := d.pxpool.GetProxmoxCluster(region)
+		if err != nil {
+			klog.ErrorS(err, "ListVolumes: failed to get proxmox cluster", "cluster", region)
+
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+
+		vms[region], err = getClusterVMs(ctx, cl)
+		if err != nil {
+			klog.ErrorS(err, "ListVolumes: failed to list virtual machines", "cluster", region)
+
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+
+		regionVols, regionReplicas, err := d.listVolumes(ctx, cl, region, vms[region])
+		if err != nil {
+			klog.ErrorS(err, "ListVolumes: failed to list volumes", "cluster", region)
+
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+
+		vols = append(vols, regionVols...)
+		replicas[region] = regionReplicas
+	}
+
+	if start > len(vols) {
+		return nil, status.Errorf(codes.Aborted, "starting token %d is out of range", start)
+	}
+
+	end, nextToken := paginate(len(vols), start, maxEntries)
+	vols = vols[start:end]
+
+	attachments := map[string]map[string][]string{}
+	entries := make([]*csi.ListVolumesResponse_Entry, 0, len(vols))
+
+	for _, v := range vols {
+		region := v.vol.Region()
+
+		if _, ok := attachments[region]; !ok {
+			cl, err := d.pxpool.GetProxmoxCluster(region)
+			if err != nil {
+				return nil, status.Error(codes.Internal, err.Error())
+			}
+
+			nodeIDs, err := d.getNodeIDs(ctx, region, vms[region])
+			if err != nil {
+				klog.ErrorS(err, "ListVolumes: failed to get node IDs", "cluster", region)
+
+				return nil, status.Error(codes.Internal, err.Error())
+			}
+
+			attachments[region], err = getVolumeAttachments(ctx, cl, vms[region], nodeIDs, func(rs *proxmox.ClusterResource) bool {
+				return int(rs.VMID) == d.features.RegionFeatures(region).ControllerVMID || replicas[region][rs.VMID]
+			})
+			if err != nil {
+				klog.ErrorS(err, "ListVolumes: failed to get volume attachments", "cluster", region)
+
+				return nil, status.Error(codes.Internal, err.Error())
+			}
+		}
+
+		segments := map[string]string{
+			corev1.LabelTopologyRegion: region,
+		}
+		if !v.shared {
+			segments[corev1.LabelTopologyZone] = v.vol.Zone()
+		}
+
+		entries = append(entries, &csi.ListVolumesResponse_Entry{
+			Volume: &csi.Volume{
+				VolumeId:           v.volumeID(),
+				CapacityBytes:      int64(v.content.Size),
+				AccessibleTopology: []*csi.Topology{{Segments: segments}},
+			},
+			Status: &csi.ListVolumesResponse_VolumeStatus{
+				PublishedNodeIds: attachments[region][v.vol.VolID()],
+			},
+		})
+	}
+
+	klog.V(5).InfoS("ListVolumes: volumes listed", "entries", len(entries), "nextToken", nextToken)
+
+	return &csi.ListVolumesResponse{
+		Entries:   entries,
+		NextToken: nextToken,
+	}, nil
 }
 
 // GetCapacity get capacity
@@ -677,9 +954,10 @@ func (d *ControllerService) GetCapacity(ctx context.Context, request *csi.GetCap
 	topology := request.GetAccessibleTopology()
 	if topology != nil {
 		region, zone := GetNodeTopology(topology.GetSegments())
-		storageID := request.GetParameters()[StorageIDKey]
+		storages := StorageParameters{StorageID: request.GetParameters()[StorageIDKey]}.StorageIDs()
+		storages = d.resolveStorages(region, zone, storages)
 
-		if region == "" || storageID == "" {
+		if region == "" || len(storages) == 0 {
 			return nil, status.Error(codes.InvalidArgument, "region and storage must be provided")
 		}
 
@@ -690,67 +968,98 @@ func (d *ControllerService) GetCapacity(ctx context.Context, request *csi.GetCap
 			return nil, status.Error(codes.Internal, err.Error())
 		}
 
-		storageConfig, err := cl.GetClusterStorage(ctx, storageID)
-		if err != nil {
-			klog.ErrorS(err, "GetCapacity: failed to get proxmox storage config", "cluster", region, "storageID", storageID)
+		if len(storages) == 1 {
+			availableCapacity, err := d.getStorageCapacity(ctx, cl, region, zone, storages[0])
+			if err != nil {
+				return nil, err
+			}
 
-			return nil, status.Error(codes.Internal, err.Error())
+			return &csi.GetCapacityResponse{
+				AvailableCapacity: availableCapacity,
+			}, nil
 		}
 
-		if zone == "" {
-			if storageConfig.Shared == 0 {
-				return nil, status.Error(codes.InvalidArgument, "zone must be provided")
-			}
+		// CreateVolume falls back to the next storage, so the volume fits if it fits in any of the storages
+		availableCapacity := int64(0)
 
-			zones, err := cl.GetNodesForStorage(ctx, storageID)
+		for _, storageID := range storages {
+			capacity, err := d.getStorageCapacity(ctx, cl, region, zone, storageID)
 			if err != nil {
-				klog.ErrorS(err, "GetCapacity: failed to get zones with storage", "cluster", region, "storage", storageID)
+				klog.V(6).InfoS("GetCapacity: storage is skipped", "region", region, "zone", zone, "storageID", storageID, "reason", err)
 
-				return nil, status.Errorf(codes.Internal, "failed to get zones with storage %s: %v", storageID, err)
+				continue
 			}
 
-			if len(zones) == 0 {
-				klog.ErrorS(err, "GetCapacity: failed to find best zone: no nodes with the storage", "cluster", region, "storage", storageID)
+			availableCapacity = max(availableCapacity, capacity)
+		}
 
-				return nil, status.Errorf(codes.Internal, "failed to find best zone: no nodes with the storage %s", storageID)
-			}
+		return &csi.GetCapacityResponse{
+			AvailableCapacity: availableCapacity,
+		}, nil
+	}
+
+	return nil, status.Error(codes.InvalidArgument, "no topology specified")
+}
+
+// getStorageCapacity returns the available capacity of the storage on the node,
+// the zone can be empty for shared storages.
+func (d *ControllerService) getStorageCapacity(ctx context.Context, cl *goproxmox.APIClient, region, zone, storageID string) (int64, error) {
+	storageConfig, err := cl.GetClusterStorage(ctx, storageID)
+	if err != nil {
+		klog.ErrorS(err, "GetCapacity: failed to get proxmox storage config", "cluster", region, "storageID", storageID)
+
+		return 0, status.Error(codes.Internal, err.Error())
+	}
 
-			zone = zones[0]
+	if zone == "" {
+		if storageConfig.Shared == 0 {
+			return 0, status.Error(codes.InvalidArgument, "zone must be provided")
 		}
 
-		availableCapacity := int64(0)
-		key := strings.Join([]string{region, zone, storageID}, "/")
+		zones, err := cl.GetNodesForStorage(ctx, storageID)
+		if err != nil {
+			klog.ErrorS(err, "GetCapacity: failed to get zones with storage", "cluster", region, "storage", storageID)
 
-		if v, ok := d.storageCapacity.Get(key); ok {
-			if capacity, ok := v.(int64); ok {
-				availableCapacity = capacity
-			}
+			return 0, status.Errorf(codes.Internal, "failed to get zones with storage %s: %v", storageID, err)
 		}
 
-		if availableCapacity == 0 {
-			mc := metrics.NewMetricContext("storageStatus")
+		if len(zones) == 0 {
+			klog.ErrorS(err, "GetCapacity: failed to find best zone: no nodes with the storage", "cluster", region, "storage", storageID)
 
-			storage, err := cl.GetStorageStatus(ctx, zone, storageID)
-			if mc.ObserveRequest(err) != nil {
-				klog.ErrorS(err, "GetCapacity: failed to get storage status", "cluster", region, "storageID", storageID, "storageConfig", storageConfig)
+			return 0, status.Errorf(codes.Internal, "failed to find best zone: no nodes with the storage %s", storageID)
+		}
 
-				if !strings.Contains(err.Error(), "Parameter verification failed") {
-					return nil, status.Error(codes.Internal, err.Error())
-				}
-			} else {
-				availableCapacity = int64(storage.Avail)
-				d.storageCapacity.SetDefault(key, availableCapacity)
-			}
+		zone = zones[0]
+	}
+
+	availableCapacity := int64(0)
+	key := strings.Join([]string{region, zone, storageID}, "/")
+
+	if v, ok := d.storageCapacity.Get(key); ok {
+		if capacity, ok := v.(int64); ok {
+			availableCapacity = capacity
 		}
+	}
 
-		klog.V(6).InfoS("GetCapacity: collected", "region", region, "zone", zone, "storageID", storageID, "size", availableCapacity)
+	if availableCapacity == 0 {
+		mc := metrics.NewMetricContext("storageStatus")
 
-		return &csi.GetCapacityResponse{
-			AvailableCapacity: availableCapacity,
-		}, nil
+		storage, err := cl.GetStorageStatus(ctx, zone, storageID)
+		if mc.ObserveRequest(err) != nil {
+			klog.ErrorS(err, "GetCapacity: failed to get storage status", "cluster", region, "storageID", storageID, "storageConfig", storageConfig)
+
+			if !strings.Contains(err.Error(), "Parameter verification failed") {
+				return 0, status.Error(codes.Internal, err.Error())
+			}
+		} else {
+			availableCapacity = int64(storage.Avail)
+			d.storageCapacity.SetDefault(key, availableCapacity)
+		}
 	}
 
-	return nil, status.Error(codes.InvalidArgument, "no topology specified")
+	klog.V(6).InfoS("GetCapacity: collected", "region", region, "zone", zone, "storageID", storageID, "size", availableCapacity)
+
+	return availableCapacity, nil
 }
 
 // CreateSnapshot create a snapshot
@@ -767,9 +1076,9 @@ func (d *ControllerService) CreateSnapshot(ctx context.Context, request *csi.Cre
 		return nil, status.Error(codes.InvalidArgument, "Name must be provided")
 	}
 
-	params := request.GetParameters()
-	if params == nil {
-		params = map[string]string{}
+	params, err := ExtractSnapshotParameters(request.GetParameters())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
 	cl, err := d.pxpool.GetProxmoxCluster(vol.Cluster())
@@ -791,27 +1100,50 @@ func (d *ControllerService) CreateSnapshot(ctx context.Context, request *csi.Cre
 		err = status.Error(codes.Internal, "storage type cifs, pbs do not support snapshot")
 		klog.ErrorS(err, "CreateSnapshot: unsupported storage type for snapshot", "cluster", vol.Cluster(), "storageID", vol.Storage(), "storageType", storageConfig.Type)
 
-		return nil, err
-
-	case "rbd":
-		err = status.Error(codes.Internal, "storage type rbd(ceph) does not support snapshot")
-		klog.ErrorS(err, "CreateSnapshot: unsupported storage type for snapshot", "cluster", vol.Cluster(), "storageID", vol.Storage(), "storageType", storageConfig.Type)
-
 		return nil, err
 	}
 
-	if storageConfig.Shared == 1 {
-		err = status.Error(codes.Internal, "shared storage does not support snapshot")
-		klog.ErrorS(err, "CreateSnapshot: unsupported storage type for snapshot", "cluster", vol.Cluster(), "storageID", vol.Storage(), "storageType", storageConfig.Type)
+	native := slices.Contains(nativeSnapshotStorages, storageConfig.Type)
 
-		return nil, err
-	}
+	if params.BackupStorage != "" {
+		backupConfig, err := cl.Client.ClusterStorage(ctx, params.BackupStorage)
+		if err != nil {
+			klog.ErrorS(err, "CreateSnapshot: failed to get proxmox storage config", "cluster", vol.Cluster(), "storageID", params.BackupStorage)
 
-	_, err = d.checkVolume(ctx, vol)
-	if err != nil {
-		klog.ErrorS(err, "CreateSnapshot: failed to check volume", "cluster", vol.Cluster(), "volumeID", vol.VolumeID())
+			return nil, status.Error(codes.Internal, err.Error())
+		}
 
-		return nil, err
+		if backupConfig.Type != "pbs" {
+			return nil, status.Errorf(codes.InvalidArgument, "storage %s is not a Proxmox Backup Server storage", params.BackupStorage)
+		}
+
+		backup, err := findSnapshotBackup(ctx, cl, params.BackupStorage, name)
+		if err != nil {
+			klog.ErrorS(err, "CreateSnapshot: failed to find snapshot backup", "cluster", vol.Cluster(), "storageID", params.BackupStorage)
+
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+
+		if backup != nil {
+			return &csi.CreateSnapshotResponse{Snapshot: backupSnapshot(vol.Cluster(), params.BackupStorage, backup)}, nil
+		}
+
+		// The export clones native snapshots, storages without the clone support use the full copy
+		native = native && slices.Contains(nativeCloneStorages, storageConfig.Type)
+	}
+
+	if storageConfig.Shared == 1 && !native {
+		err = status.Error(codes.Internal, "shared storage does not support snapshot")
+		klog.ErrorS(err, "CreateSnapshot: unsupported storage type for snapshot", "cluster", vol.Cluster(), "storageID", vol.Storage(), "storageType", storageConfig.Type)
+
+		return nil, err
+	}
+
+	volSize, err := d.checkVolume(ctx, vol)
+	if err != nil {
+		klog.ErrorS(err, "CreateSnapshot: failed to check volume", "cluster", vol.Cluster(), "volumeID", vol.VolumeID())
+
+		return nil, err
 	}
 
 	if vol.Node() == "" {
@@ -825,20 +1157,64 @@ func (d *ControllerService) CreateSnapshot(ctx context.Context, request *csi.Cre
 		vol.SetNode(node)
 	}
 
-	snapshotID := vol.CopyVolume(fmt.Sprintf("vm-%d-%s", d.vmID, name))
+	features := d.features.RegionFeatures(vol.Region())
+
+	if native {
+		snapshotID, err := nativeSnapshotID(vol, name)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+
+		klog.V(5).InfoS("CreateSnapshot: creating native snapshot", "storageConfig", storageConfig, "volumeID", vol.VolumeID(), "snapshotID", snapshotID.VolumeID())
 
-	if params["zone"] != "" {
+		mc := metrics.NewMetricContext("createSnapshot")
+		if err = freezeVolumes(ctx, cl, []*volume.Volume{vol}, params, features.TaskTimeout, func(ctx context.Context) error {
+			return createNativeSnapshot(ctx, cl, vol.Node(), snapshotID, features)
+		}); mc.ObserveRequest(err) != nil {
+			klog.ErrorS(err, "CreateSnapshot: failed to create native snapshot", "cluster", vol.Cluster(), "volumeID", vol.VolumeID(), "snapshotID", snapshotID.VolumeID())
+
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+
+		klog.V(3).InfoS("CreateSnapshot: snapshot created", "cluster", vol.Cluster(), "volumeID", vol.VolumeID(), "snapshotID", snapshotID.VolumeID())
+
+		if params.BackupStorage != "" {
+			return d.archiveSnapshot(ctx, cl, snapshotID, vol, name, params)
+		}
+
+		return &csi.CreateSnapshotResponse{
+			Snapshot: &csi.Snapshot{
+				CreationTime:   timestamppb.New(time.Now()),
+				SnapshotId:     snapshotID.VolumeID(),
+				SourceVolumeId: vol.VolumeID(),
+				SizeBytes:      volSize,
+				ReadyToUse:     true,
+			},
+		}, nil
+	}
+
+	snapshotID := vol.CopyVolume(snapshotDiskName(features.ControllerVMID, name, vol, params.Zone))
+
+	if params.Zone != "" {
 		if storageConfig.Nodes != "" {
 			nodes := strings.Split(storageConfig.Nodes, ",")
-			if !slices.Contains(nodes, params["zone"]) {
+			if !slices.Contains(nodes, params.Zone) {
 				err = status.Error(codes.InvalidArgument, "zone specified in parameters is not valid for the storage")
-				klog.ErrorS(err, "CreateSnapshot: invalid zone in parameters", "cluster", vol.Cluster(), "storageID", vol.Storage(), "zone", params["zone"])
+				klog.ErrorS(err, "CreateSnapshot: invalid zone in parameters", "cluster", vol.Cluster(), "storageID", vol.Storage(), "zone", params.Zone)
 
 				return nil, err
 			}
 		}
 
-		snapshotID.SetZone(params["zone"])
+		snapshotID.SetZone(params.Zone)
+	}
+
+	// The snapshot can be created before the source was recorded in the disk name
+	legacyID := vol.CopyVolume(legacySnapshotDiskName(features.ControllerVMID, name))
+	legacyID.SetZone(snapshotID.Zone())
+
+	if size, err := getVolumeSize(ctx, cl, legacyID); err == nil && size > 0 {
+		snapshotID = legacyID
 	}
 
 	klog.V(5).InfoS("CreateSnapshot", "storageConfig", storageConfig, "volumeID", vol.VolumeID(), "snapshotID", snapshotID.VolumeID(), "params", params)
@@ -851,7 +1227,9 @@ func (d *ControllerService) CreateSnapshot(ctx context.Context, request *csi.Cre
 			return nil, status.Error(codes.Internal, err.Error())
 		}
 
-		err = copyVolume(ctx, cl, vol, snapshotID)
+		err = freezeVolumes(ctx, cl, []*volume.Volume{vol}, params, features.TaskTimeout, func(ctx context.Context) error {
+			return copyVolume(ctx, cl, vol, snapshotID)
+		})
 		if err != nil {
 			klog.ErrorS(err, "CreateSnapshot: failed to create snapshot", "cluster", vol.Cluster(), "volumeID", vol.VolumeID(), "snapshotID", snapshotID.VolumeID())
 
@@ -868,6 +1246,10 @@ func (d *ControllerService) CreateSnapshot(ctx context.Context, request *csi.Cre
 
 	klog.V(3).InfoS("CreateSnapshot: snapshot created", "cluster", vol.Cluster(), "volumeID", vol.VolumeID(), "snapshotID", snapshotID.VolumeID())
 
+	if params.BackupStorage != "" {
+		return d.archiveSnapshot(ctx, cl, snapshotID, vol, name, params)
+	}
+
 	return &csi.CreateSnapshotResponse{
 		Snapshot: &csi.Snapshot{
 			CreationTime:   timestamppb.New(time.Now()),
@@ -895,6 +1277,32 @@ func (d *ControllerService) DeleteSnapshot(ctx context.Context, request *csi.Del
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
+	if isBackupSnapshot(vol) {
+		mc := metrics.NewMetricContext("deleteSnapshot")
+		if err = deleteBackupSnapshot(ctx, cl, vol, d.features.RegionFeatures(vol.Region()).VMTaskTimeout); mc.ObserveRequest(err) != nil {
+			klog.ErrorS(err, "DeleteSnapshot: failed to delete snapshot backup", "cluster", vol.Cluster(), "snapshotID", vol.VolumeID())
+
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+
+		klog.V(3).InfoS("DeleteSnapshot: snapshot deleted", "cluster", vol.Cluster(), "volumeID", vol.VolumeID())
+
+		return &csi.DeleteSnapshotResponse{}, nil
+	}
+
+	if vol.Snapshot() != "" {
+		mc := metrics.NewMetricContext("deleteSnapshot")
+		if err = deleteNativeSnapshot(ctx, cl, vol, d.features.RegionFeatures(vol.Region()).VMTaskTimeout); mc.ObserveRequest(err) != nil {
+			klog.ErrorS(err, "DeleteSnapshot: failed to delete native snapshot", "cluster", vol.Cluster(), "snapshotID", vol.VolumeID())
+
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+
+		klog.V(3).InfoS("DeleteSnapshot: snapshot deleted", "cluster", vol.Cluster(), "volumeID", vol.VolumeID())
+
+		return &csi.DeleteSnapshotResponse{}, nil
+	}
+
 	_, err = d.checkVolume(ctx, vol)
 	if err != nil {
 		if status.Code(err) == codes.NotFound {
@@ -930,10 +1338,135 @@ func (d *ControllerService) DeleteSnapshot(ctx context.Context, request *csi.Del
 }
 
 // ListSnapshots list snapshots
-func (d *ControllerService) ListSnapshots(_ context.Context, request *csi.ListSnapshotsRequest) (*csi.ListSnapshotsResponse, error) {
+func (d *ControllerService) ListSnapshots(ctx context.Context, request *csi.ListSnapshotsRequest) (*csi.ListSnapshotsResponse, error) {
 	klog.V(4).InfoS("ListSnapshots: called", "args", protosanitizer.StripSecrets(request))
 
-	return nil, status.Error(codes.Unimplemented, "")
+	maxEntries := int(request.GetMaxEntries())
+	if maxEntries < 0 {
+		return nil, status.Error(codes.InvalidArgument, "MaxEntries must not be negative")
+	}
+
+	start, err := parseStartingToken(request.GetStartingToken())
+	if err != nil {
+		return nil, status.Error(codes.Aborted, err.Error())
+	}
+
+	regions := d.pxpool.GetRegions()
+	slices.Sort(regions)
+
+	for _, id := range []string{request.GetSnapshotId(), request.GetSourceVolumeId()} {
+		if id == "" {
+			continue
+		}
+
+		vol, err := volume.NewVolumeFromVolumeID(id)
+		if err != nil || !slices.Contains(regions, vol.Region()) {
+			klog.V(5).InfoS("ListSnapshots: snapshot or source volume not found", "id", id)
+
+			return &csi.ListSnapshotsResponse{}, nil
+		}
+
+		regions = []string{vol.Region()}
+	}
+
+	snapshots := []*csi.Snapshot{}
+
+	// Native and backup snapshots are looked up directly by the snapshot ID
+	if snap, err := volume.NewVolumeFromVolumeID(request.GetSnapshotId()); err == nil && (snap.Snapshot() != "" || isBackupSnapshot(snap)) {
+		getSnapshot := d.getNativeSnapshot
+		if isBackupSnapshot(snap) {
+			getSnapshot = d.getBackupSnapshot
+		}
+
+		snapshot, err := getSnapshot(ctx, snap)
+		if err != nil {
+			klog.ErrorS(err, "ListSnapshots: failed to get native snapshot", "cluster", snap.Cluster(), "snapshotID", snap.VolumeID())
+
+			return nil, err
+		}
+
+		if snapshot != nil {
+			snapshots = append(snapshots, snapshot)
+		}
+
+		regions = nil
+	}
+
+	for _, region := range regions {
+		cl, err := d.pxpool.GetProxmoxCluster(region)
+		if err != nil {
+			klog.ErrorS(err, "ListSnapshots: failed to get proxmox cluster", "cluster", region)
+
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+
+		vols, err := listStorageVolumes(ctx, cl, region, func(vol *volume.Volume) bool {
+			_, ok := snapshotSourceVolume(vol)
+
+			return vol.VMID() == strconv.Itoa(d.features.RegionFeatures(region).ControllerVMID) && ok
+		})
+		if err != nil {
+			klog.ErrorS(err, "ListSnapshots: failed to list snapshots", "cluster", region)
+
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+
+		for _, v := range vols {
+			// The source of the legacy snapshots is unknown
+			sourceID := ""
+			if src, _ := snapshotSourceVolume(v.vol); src != nil {
+				sourceID = src.VolumeID()
+			}
+
+			snapshots = append(snapshots, &csi.Snapshot{
+				SnapshotId:     v.volumeID(),
+				SourceVolumeId: sourceID,
+				SizeBytes:      int64(v.content.Size),
+				CreationTime:   timestamppb.New(time.Unix(int64(v.content.Ctime), 0)),
+				ReadyToUse:     v.content.Size > 0,
+			})
+		}
+
+		native, err := d.listNativeSnapshots(ctx, cl, region, request.GetSourceVolumeId())
+		if err != nil {
+			klog.ErrorS(err, "ListSnapshots: failed to list native snapshots", "cluster", region)
+
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+
+		backups, err := listBackupSnapshots(ctx, cl, region)
+		if err != nil {
+			klog.ErrorS(err, "ListSnapshots: failed to list backup snapshots", "cluster", region)
+
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+
+		snapshots = append(snapshots, native...)
+		snapshots = append(snapshots, backups...)
+	}
+
+	snapshots = slices.DeleteFunc(snapshots, func(snapshot *csi.Snapshot) bool {
+		return (request.GetSnapshotId() != "" && request.GetSnapshotId() != snapshot.GetSnapshotId()) ||
+			(request.GetSourceVolumeId() != "" && request.GetSourceVolumeId() != snapshot.GetSourceVolumeId())
+	})
+
+	if start > len(snapshots) {
+		return nil, status.Errorf(codes.Aborted, "starting token %d is out of range", start)
+	}
+
+	end, nextToken := paginate(len(snapshots), start, maxEntries)
+
+	entries := make([]*csi.ListSnapshotsResponse_Entry, 0, end-start)
+	for _, snapshot := range snapshots[start:end] {
+		entries = append(entries, &csi.ListSnapshotsResponse_Entry{Snapshot: snapshot})
+	}
+
+	klog.V(5).InfoS("ListSnapshots: snapshots listed", "entries", len(entries), "nextToken", nextToken)
+
+	return &csi.ListSnapshotsResponse{
+		Entries:   entries,
+		NextToken: nextToken,
+	}, nil
 }
 
 // ControllerExpandVolume expand a volume
@@ -973,29 +1506,14 @@ func (d *ControllerService) ControllerExpandVolume(ctx context.Context, request
 
 	// FIXME: check current size and skip resize if not needed
 
-	id, lun, err := getVMByAttachedVolume(ctx, cl, vol)
-	if err != nil || id == 0 {
-		if err == goproxmox.ErrVirtualMachineNotFound {
-			klog.V(3).InfoS("ControllerExpandVolume: volume is not published, cannot resize unpublished volumeID", "cluster", vol.Cluster(), "volumeID", vol.VolumeID())
-
-			return nil, status.Error(codes.Internal, "cannot resize unpublished")
-		}
-
-		klog.ErrorS(err, "ControllerExpandVolume: failed to get vm by attached volume", "cluster", vol.Cluster(), "volumeID", vol.VolumeID())
-
-		return nil, status.Error(codes.Internal, err.Error())
-	}
-
 	mc := metrics.NewMetricContext("expandVolume")
-
-	device := deviceNamePrefix + strconv.Itoa(lun)
-	if err = cl.ResizeVMDisk(ctx, id, vol.Node(), device, fmt.Sprintf("%dM", volSizeBytes/MiB)); mc.ObserveRequest(err) != nil {
-		klog.ErrorS(err, "ControllerExpandVolume: failed to resize vm disk", "cluster", vol.Cluster(), "volumeID", vol.VolumeID(), "vmID", id)
+	if err = ResizeVolume(ctx, cl, vol, volSizeBytes, d.features.RegionFeatures(vol.Region())); mc.ObserveRequest(err) != nil {
+		klog.ErrorS(err, "ControllerExpandVolume: failed to resize volume", "cluster", vol.Cluster(), "volumeID", vol.VolumeID())
 
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
-	klog.V(3).InfoS("ControllerExpandVolume: volume expanded", "cluster", vol.Cluster(), "volumeID", vol.VolumeID(), "vmID", id, "size", volSizeBytes)
+	klog.V(3).InfoS("ControllerExpandVolume: volume expanded", "cluster", vol.Cluster(), "volumeID", vol.VolumeID(), "size", volSizeBytes)
 
 	return &csi.ControllerExpandVolumeResponse{
 		CapacityBytes:         volSizeBytes,
@@ -1004,10 +1522,70 @@ func (d *ControllerService) ControllerExpandVolume(ctx context.Context, request
 }
 
 // ControllerGetVolume get a volume
-func (d *ControllerService) ControllerGetVolume(_ context.Context, request *csi.ControllerGetVolumeRequest) (*csi.ControllerGetVolumeResponse, error) {
+func (d *ControllerService) ControllerGetVolume(ctx context.Context, request *csi.ControllerGetVolumeRequest) (*csi.ControllerGetVolumeResponse, error) {
 	klog.V(4).InfoS("ControllerGetVolume: called", "args", protosanitizer.StripSecrets(request))
 
-	return nil, status.Error(codes.Unimplemented, "")
+	volumeID := request.GetVolumeId()
+	if volumeID == "" {
+		return nil, status.Error(codes.InvalidArgument, "VolumeID must be provided")
+	}
+
+	vol, err := volume.NewVolumeFromVolumeID(volumeID)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	cl, err := d.pxpool.GetProxmoxCluster(vol.Cluster())
+	if err != nil {
+		klog.ErrorS(err, "ControllerGetVolume: failed to get proxmox cluster", "cluster", vol.Cluster())
+
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	size, condition, err := d.getVolumeCondition(ctx, cl, vol)
+	if err != nil {
+		klog.ErrorS(err, "ControllerGetVolume: failed to check volume", "cluster", vol.Cluster(), "volumeID", vol.VolumeID())
+
+		return nil, err
+	}
+
+	vms, err := getClusterVMs(ctx, cl)
+	if err != nil {
+		klog.ErrorS(err, "ControllerGetVolume: failed to list virtual machines", "cluster", vol.Cluster())
+
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	nodeIDs, err := d.getNodeIDs(ctx, vol.Region(), vms)
+	if err != nil {
+		klog.ErrorS(err, "ControllerGetVolume: failed to get node IDs", "cluster", vol.Cluster())
+
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	attachments, err := getVolumeAttachments(ctx, cl, vms, nodeIDs, func(rs *proxmox.ClusterResource) bool {
+		return int(rs.VMID) == d.features.RegionFeatures(vol.Region()).ControllerVMID || vol.VMID() == strconv.FormatUint(rs.VMID, 10)
+	})
+	if err != nil {
+		klog.ErrorS(err, "ControllerGetVolume: failed to get volume attachments", "cluster", vol.Cluster(), "volumeID", vol.VolumeID())
+
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	if condition.GetAbnormal() {
+		klog.V(3).InfoS("ControllerGetVolume: volume is abnormal", "cluster", vol.Cluster(), "volumeID", vol.VolumeID(), "message", condition.GetMessage())
+	}
+
+	return &csi.ControllerGetVolumeResponse{
+		Volume: &csi.Volume{
+			VolumeId:      volumeID,
+			CapacityBytes: size,
+		},
+		Status: &csi.ControllerGetVolumeResponse_VolumeStatus{
+			PublishedNodeIds: attachments[vol.VolID()],
+			VolumeCondition:  condition,
+		},
+	}, nil
 }
 
 // ControllerModifyVolume modify a volume
@@ -1059,7 +1637,7 @@ func (d *ControllerService) ControllerModifyVolume(ctx context.Context, request
 	klog.V(5).InfoS("ControllerModifyVolume: update volume", "cluster", vol.Cluster(), "volumeID", vol.VolumeID(), "vmID", id, "parameters", params.ToCFG())
 
 	mc := metrics.NewMetricContext("updateVolume")
-	if err = updateVolume(ctx, cl, id, vol, params.ToCFG()); mc.ObserveRequest(err) != nil {
+	if err = updateVolume(ctx, cl, id, vol, params.ToCFG(), d.features.RegionFeatures(vol.Region()).VMTaskTimeout); mc.ObserveRequest(err) != nil {
 		klog.ErrorS(err, "ControllerModifyVolume: failed to update volume", "cluster", vol.Cluster(), "volumeID", vol.VolumeID(), "vmID", id)
 
 		return nil, status.Error(codes.Internal, err.Error())
@@ -1070,6 +1648,60 @@ func (d *ControllerService) ControllerModifyVolume(ctx context.Context, request
 	return &csi.ControllerModifyVolumeResponse{}, nil
 }
 
+// getNodeIDs returns the CSI node IDs of the kubernetes nodes in the region keyed by the Proxmox VM ID,
+// so the attachments are reported with the node IDs known by the CO.
+func (d *ControllerService) getNodeIDs(ctx context.Context, region string, vms proxmox.ClusterResources) (map[uint64]string, error) {
+	nodes, err := d.kclient.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %v", err)
+	}
+
+	names := make(map[string]uint64, len(vms))
+	for _, rs := range vms {
+		if rs.Type == "qemu" {
+			names[rs.Name] = rs.VMID
+		}
+	}
+
+	ids := make(map[uint64]string, len(nodes.Items))
+
+	for i := range nodes.Items {
+		node := &nodes.Items[i]
+
+		if r := node.Labels[corev1.LabelTopologyRegion]; r != "" && r != region {
+			continue
+		}
+
+		nodeID := csiNodeID(node)
+
+		id, r, err := provider.ParseProviderID(node.Spec.ProviderID)
+		if err == nil && r != region {
+			continue
+		}
+
+		if err != nil {
+			id, err = ProxmoxVMIDbyNode(node)
+		}
+
+		if err != nil || id == 0 {
+			if n, err := utilsnode.ParseNodeID(nodeID); err == nil {
+				id, _ = n.GetVMID() //nolint: errcheck
+			}
+		}
+
+		vmID := uint64(id) //nolint: gosec
+		if id == 0 {
+			if vmID = names[node.Name]; vmID == 0 {
+				continue
+			}
+		}
+
+		ids[vmID] = nodeID
+	}
+
+	return ids, nil
+}
+
 func (d *ControllerService) getVMIDbyNode(ctx context.Context, nodeName string) (int, string, error) { // nolint:unparam
 	node, err := d.kclient.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
 	if err != nil {
@@ -1078,7 +1710,7 @@ func (d *ControllerService) getVMIDbyNode(ctx context.Context, nodeName string)
 
 	id, err := ProxmoxVMIDbyNode(node)
 	if err != nil {
-		if d.Provider == csiconfig.ProviderCapmox {
+		if d.features.RegionFeatures(node.Labels[corev1.LabelTopologyRegion]).Provider == csiconfig.ProviderCapmox {
 			id, region, err := d.pxpool.FindVMByUUID(ctx, node.Status.NodeInfo.SystemUUID)
 			if err != nil {
 				return 0, "", status.Error(codes.Internal, err.Error())
@@ -1162,3 +1794,420 @@ func (d *ControllerService) checkVolume(ctx context.Context, vol *volume.Volume)
 
 	return size, nil
 }
+
+// getNativeSnapshot returns the native snapshot, or nil if the snapshot does not exist.
+func (d *ControllerService) getNativeSnapshot(ctx context.Context, snap *volume.Volume) (*csi.Snapshot, error) {
+	cl, err := d.pxpool.GetProxmoxCluster(snap.Cluster())
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	snapshots, err := getVolumeSnapshots(ctx, cl, snap)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	idx := slices.IndexFunc(snapshots, func(s *proxmox.Snapshot) bool { return s.Name == snap.Snapshot() })
+	if idx < 0 {
+		return nil, nil
+	}
+
+	src, err := volume.NewVolumeFromVolumeID(snap.VolumeID())
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	src.SetSnapshot("")
+
+	size, err := d.checkVolume(ctx, src)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return &csi.Snapshot{
+		SnapshotId:     snap.VolumeID(),
+		SourceVolumeId: src.VolumeID(),
+		SizeBytes:      size,
+		CreationTime:   timestamppb.New(time.Unix(snapshots[idx].Snaptime, 0)),
+		ReadyToUse:     true,
+	}, nil
+}
+
+// listNativeSnapshots returns the native snapshots in the region, the snapshots of one volume if sourceID is set.
+// The snapshots are taken of the holder VMs named after the PV and of the VMs which own the restored volumes.
+func (d *ControllerService) listNativeSnapshots(ctx context.Context, cl *goproxmox.APIClient, region string, sourceID string) ([]*csi.Snapshot, error) {
+	vms, err := getClusterVMs(ctx, cl)
+	if err != nil {
+		return nil, err
+	}
+
+	vmID := strconv.Itoa(d.features.RegionFeatures(region).ControllerVMID)
+	holders := map[string]*proxmox.ClusterResource{}
+
+	vols, err := listStorageVolumes(ctx, cl, region, func(vol *volume.Volume) bool {
+		if _, ok := snapshotSourceVolume(vol); ok {
+			return false
+		}
+
+		for _, rs := range vms {
+			if rs.Type == "qemu" && isVolumeHolder(rs, vol) && (vol.VMID() == vmID || isVolumeOwner(rs, vol)) {
+				holders[vol.VolID()] = rs
+
+				return true
+			}
+		}
+
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := []*csi.Snapshot{}
+
+	for _, v := range vols {
+		if sourceID != "" && sourceID != v.volumeID() {
+			continue
+		}
+
+		vm, err := cl.GetVMConfig(ctx, int(holders[v.vol.VolID()].VMID))
+		if err != nil {
+			if err == goproxmox.ErrVirtualMachineNotFound {
+				continue
+			}
+
+			return nil, fmt.Errorf("failed to get vm config: %v", err)
+		}
+
+		vmSnapshots, err := vm.Snapshots(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list vm snapshots: %v", err)
+		}
+
+		for _, s := range vmSnapshots {
+			// The current state of the VM is listed as a snapshot
+			if s.Name == "current" {
+				continue
+			}
+
+			snap := volume.NewVolume(region, v.vol.Zone(), v.vol.Storage(), v.vol.Disk())
+			snap.SetSnapshot(s.Name)
+
+			snapshots = append(snapshots, &csi.Snapshot{
+				SnapshotId:     snap.VolumeID(),
+				SourceVolumeId: v.volumeID(),
+				SizeBytes:      int64(v.content.Size),
+				CreationTime:   timestamppb.New(time.Unix(s.Snaptime, 0)),
+				ReadyToUse:     true,
+			})
+		}
+	}
+
+	return snapshots, nil
+}
+
+// getBackupSnapshot returns the snapshot archived to Proxmox Backup Server, or nil if it does not exist.
+func (d *ControllerService) getBackupSnapshot(ctx context.Context, snap *volume.Volume) (*csi.Snapshot, error) {
+	cl, err := d.pxpool.GetProxmoxCluster(snap.Cluster())
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	_, backup, err := findBackup(ctx, cl, snap.Storage(), func(content *proxmox.StorageContent) bool {
+		return content.Volid == snap.VolID()
+	})
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	if backup == nil {
+		return nil, nil
+	}
+
+	return backupSnapshot(snap.Cluster(), snap.Storage(), backup), nil
+}
+
+// archiveSnapshot archives the snapshot to Proxmox Backup Server, the local snapshot is deleted after the export.
+func (d *ControllerService) archiveSnapshot(ctx context.Context, cl *goproxmox.APIClient, snap *volume.Volume, vol *volume.Volume, name string, params SnapshotParameters) (*csi.CreateSnapshotResponse, error) {
+	klog.V(5).InfoS("CreateSnapshot: exporting snapshot", "cluster", vol.Cluster(), "snapshotID", snap.VolumeID(), "storageID", params.BackupStorage)
+
+	mc := metrics.NewMetricContext("exportSnapshot")
+
+	backup, err := exportSnapshot(ctx, cl, snap, vol, name, params.BackupStorage, d.features.RegionFeatures(vol.Region()))
+	if mc.ObserveRequest(err) != nil {
+		klog.ErrorS(err, "CreateSnapshot: failed to export snapshot", "cluster", vol.Cluster(), "snapshotID", snap.VolumeID(), "storageID", params.BackupStorage)
+
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	snapshot := backupSnapshot(vol.Cluster(), params.BackupStorage, backup)
+
+	klog.V(3).InfoS("CreateSnapshot: snapshot exported", "cluster", vol.Cluster(), "volumeID", vol.VolumeID(), "snapshotID", snapshot.GetSnapshotId())
+
+	return &csi.CreateSnapshotResponse{Snapshot: snapshot}, nil
+}
+
+// backupSnapshot returns the CSI snapshot of the backup, the snapshot ID is the backup volume on the Proxmox Backup Server storage,
+// e.g. cluster-1//pbs/backup/vm/100/2025-01-01T00:00:00Z.
+func backupSnapshot(region string, storage string, backup *proxmox.StorageContent) *csi.Snapshot {
+	snapshot := &csi.Snapshot{
+		SnapshotId:   volume.NewVolume(region, "", storage, strings.TrimPrefix(backup.Volid, storage+":")).VolumeID(),
+		SizeBytes:    int64(backup.Size),
+		CreationTime: timestamppb.New(time.Unix(int64(backup.Ctime), 0)),
+		ReadyToUse:   true,
+	}
+
+	if _, src := parseBackupNotes(backup.Notes); src != nil {
+		snapshot.SourceVolumeId = src.VolumeID()
+	}
+
+	return snapshot
+}
+
+// listBackupSnapshots returns the snapshots archived to the Proxmox Backup Server storages of the region.
+func listBackupSnapshots(ctx context.Context, cl *goproxmox.APIClient, region string) ([]*csi.Snapshot, error) {
+	storages, err := cl.Client.ClusterStorages(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list storages: %v", err)
+	}
+
+	slices.SortFunc(storages, func(a, b *proxmox.ClusterStorage) int {
+		return strings.Compare(a.Storage, b.Storage)
+	})
+
+	snapshots := []*csi.Snapshot{}
+
+	for _, storage := range storages {
+		if storage.Type != "pbs" {
+			continue
+		}
+
+		nodes, err := cl.GetNodesForStorage(ctx, storage.Storage)
+		if err != nil {
+			if err.Error() == ErrorNotFound {
+				continue
+			}
+
+			return nil, fmt.Errorf("failed to find zones for storage %s: %v", storage.Storage, err)
+		}
+
+		slices.Sort(nodes)
+
+		contents, err := cl.GetStorageContent(ctx, nodes[0], storage.Storage)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get content of storage %s on node %s: %v", storage.Storage, nodes[0], err)
+		}
+
+		for _, content := range contents {
+			if _, src := parseBackupNotes(content.Notes); src != nil {
+				snapshots = append(snapshots, backupSnapshot(region, storage.Storage, content))
+			}
+		}
+	}
+
+	return snapshots, nil
+}
+
+// validateVolumeCapabilities returns the reason why the volume does not support the capabilities,
+// or an empty string if all of them are supported.
+func validateVolumeCapabilities(volCaps []*csi.VolumeCapability, params StorageParameters, vol *volume.Volume, storage *proxmox.ClusterResource) string {
+	if params.StorageID != "" && !slices.Contains(params.StorageIDs(), vol.Storage()) {
+		return fmt.Sprintf("volume storage %s does not match the requested storage %s", vol.Storage(), params.StorageID)
+	}
+
+	// Replicated volumes have no zone, they are available on all nodes with the replicas
+	replicated := storage.PluginType == "zfspool" && storage.Shared == 0 && vol.Zone() == ""
+	if params.Replicate && !replicated {
+		if storage.PluginType != "zfspool" {
+			return fmt.Sprintf("storage %s of type %s does not support replication", vol.Storage(), storage.PluginType)
+		}
+
+		return fmt.Sprintf("volume %s is not replicated", vol.VolumeID())
+	}
+
+	if vol.Zone() == "" && storage.Shared == 0 && !replicated {
+		return fmt.Sprintf("volume %s has no zone, but storage %s is not shared", vol.VolumeID(), vol.Storage())
+	}
+
+	for _, c := range volCaps {
+		mode := c.GetAccessMode().GetMode()
+		if !slices.Contains(volumeCaps, mode) {
+			return fmt.Sprintf("access mode %s is not supported", mode)
+		}
+
+		switch {
+		case c.GetBlock() != nil:
+		case c.GetMount() != nil:
+			if fsType := c.GetMount().GetFsType(); fsType != "" && fsType != FSTypeExt4 && fsType != FSTypeXfs {
+				return fmt.Sprintf("filesystem %s is not supported", fsType)
+			}
+		default:
+			return "access type must be block or mount"
+		}
+	}
+
+	return ""
+}
+
+// getVolumeCondition returns the volume size and its condition on the Proxmox side.
+// The condition is abnormal if the node is offline, the storage is unavailable,
+// the disk is gone or the disk is smaller than the persistent volume capacity.
+func (d *ControllerService) getVolumeCondition(ctx context.Context, cl *goproxmox.APIClient, vol *volume.Volume) (int64, *csi.VolumeCondition, error) {
+	abnormal := func(format string, args ...any) (int64, *csi.VolumeCondition, error) {
+		return 0, &csi.VolumeCondition{Abnormal: true, Message: fmt.Sprintf(format, args...)}, nil
+	}
+
+	if vol.Zone() != "" {
+		nodes, err := cl.Client.Nodes(ctx)
+		if err != nil {
+			if isTransportError(err) {
+				return 0, nil, status.Error(codes.Internal, err.Error())
+			}
+
+			return abnormal("failed to get nodes of cluster %s: %v", vol.Cluster(), err)
+		}
+
+		idx := slices.IndexFunc(nodes, func(n *proxmox.NodeStatus) bool { return n.Node == vol.Zone() })
+		if idx < 0 {
+			return abnormal("zone %s not found in cluster %s", vol.Zone(), vol.Cluster())
+		}
+
+		if nodes[idx].Status != "online" {
+			return abnormal("node %s is %s", vol.Zone(), nodes[idx].Status)
+		}
+	}
+
+	// The volume problems are reported in the condition, only the failed requests are errors
+	node, err := getNodeForVolume(ctx, cl, vol)
+	if err != nil {
+		if isTransportError(err) {
+			return 0, nil, status.Error(codes.Internal, err.Error())
+		}
+
+		return abnormal("%v", err)
+	}
+
+	st, err := cl.GetStorageStatus(ctx, node, vol.Storage())
+	if err != nil {
+		if isTransportError(err) {
+			return 0, nil, status.Error(codes.Internal, err.Error())
+		}
+
+		if strings.Contains(err.Error(), "No such storage") {
+			return abnormal("storage %s not found on node %s", vol.Storage(), node)
+		}
+
+		return abnormal("failed to get status of storage %s on node %s: %v", vol.Storage(), node, err)
+	}
+
+	if st.Enabled == 0 {
+		return abnormal("storage %s is disabled on node %s", vol.Storage(), node)
+	}
+
+	if st.Active == 0 {
+		return abnormal("storage %s is not active on node %s", vol.Storage(), node)
+	}
+
+	size, err := d.checkVolume(ctx, vol)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return abnormal("%s", status.Convert(err).Message())
+		}
+
+		return 0, nil, err
+	}
+
+	pvName := vol.PV()
+	if vol.VMID() != strconv.Itoa(d.features.RegionFeatures(vol.Region()).ControllerVMID) {
+		vms, err := getClusterVMs(ctx, cl)
+		if err != nil {
+			return 0, nil, status.Error(codes.Internal, err.Error())
+		}
+
+		pvName = volumePV(vms, vol)
+	}
+
+	pv, err := d.kclient.CoreV1().PersistentVolumes().Get(ctx, pvName, metav1.GetOptions{})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			klog.ErrorS(err, "getVolumeCondition: failed to get persistent volume", "cluster", vol.Cluster(), "volumeID", vol.VolumeID(), "pv", pvName)
+		}
+	} else if capacity, ok := pv.Spec.Capacity[corev1.ResourceStorage]; ok && size < capacity.Value() {
+		return size, &csi.VolumeCondition{
+			Abnormal: true,
+			Message:  fmt.Sprintf("volume size %d is smaller than the persistent volume capacity %d", size, capacity.Value()),
+		}, nil
+	}
+
+	return size, &csi.VolumeCondition{Abnormal: false, Message: "volume is healthy"}, nil
+}
+
+// listVolumes returns the volumes owned by the controller in the region and the IDs of the replication VMs.
+func (d *ControllerService) listVolumes(ctx context.Context, cl *goproxmox.APIClient, region string, vms proxmox.ClusterResources) ([]storageVolume, map[uint64]bool, error) {
+	names := make(map[uint64]string, len(vms))
+	owners := map[uint64]bool{}
+
+	for _, rs := range vms {
+		names[rs.VMID] = rs.Name
+		owners[rs.VMID] = rs.Type == "qemu" && hasTag(rs.Tags, volumeOwnerTag)
+	}
+
+	replicas := map[uint64]bool{}
+	vmID := d.features.RegionFeatures(region).ControllerVMID
+
+	vols, err := listStorageVolumes(ctx, cl, region, func(vol *volume.Volume) bool {
+		id, err := strconv.ParseUint(vol.VMID(), 10, 64)
+		if err != nil {
+			return false
+		}
+
+		if _, ok := snapshotSourceVolume(vol); ok {
+			return false
+		}
+
+		if int(id) == vmID {
+			return true
+		}
+
+		// Restored and copied volumes belong to the owner VM named after the PV
+		if owners[id] && names[id] != "" {
+			return true
+		}
+
+		// Replicated volumes belong to the VM named after the PV
+		if names[id] != "" && names[id] == vol.PV() {
+			replicas[id] = true
+
+			return true
+		}
+
+		return false
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	res := make([]storageVolume, 0, len(vols))
+	seen := map[string]bool{}
+
+	for _, v := range vols {
+		if id, err := strconv.ParseUint(v.vol.VMID(), 10, 64); err == nil && replicas[id] {
+			v.shared = true
+		}
+
+		// Shared and replicated volumes are visible on several nodes
+		if seen[v.volumeID()] {
+			continue
+		}
+
+		seen[v.volumeID()] = true
+
+		res = append(res, v)
+	}
+
+	return res, replicas, nil
+}
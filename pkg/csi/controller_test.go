@@ -18,21 +18,27 @@ package csi_test
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"maps"
+	"net/http"
 	"testing"
+	"time"
 
 	proto "github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/jarcoal/httpmock"
+	proxmox "github.com/luthermonson/go-proxmox"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 
 	"github.com/sergelogvinov/proxmox-csi-plugin/pkg/csi"
 	testcluster "github.com/sergelogvinov/proxmox-csi-plugin/test/cluster"
 
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	clientkubernetes "k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/fake"
@@ -134,6 +140,40 @@ func (ts *baseCSITestSuite) setupTestSuite(config string) error {
 					Annotations: map[string]string{},
 				},
 			},
+			{
+				TypeMeta: metav1.TypeMeta{
+					Kind:       "PersistentVolume",
+					APIVersion: "v1",
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "pvc-exist",
+				},
+				Spec: corev1.PersistentVolumeSpec{
+					Capacity: corev1.ResourceList{
+						corev1.ResourceStorage: resource.MustParse("10Gi"),
+					},
+				},
+			},
+			{
+				TypeMeta: metav1.TypeMeta{
+					Kind:       "PersistentVolume",
+					APIVersion: "v1",
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "pvc-placement-group",
+				},
+				Spec: corev1.PersistentVolumeSpec{
+					PersistentVolumeSource: corev1.PersistentVolumeSource{
+						CSI: &corev1.CSIPersistentVolumeSource{
+							Driver:       csi.DriverName,
+							VolumeHandle: "cluster-1/pve-1/local-lvm/vm-9999-pvc-placement-group",
+							VolumeAttributes: map[string]string{
+								csi.PlacementGroupKey: "db",
+							},
+						},
+					},
+				},
+			},
 		},
 	}
 
@@ -297,6 +337,44 @@ func (ts *configuredTestSuite) TestCreateVolume() {
 			},
 			expectedError: status.Error(codes.InvalidArgument, "parameters inodeSize must be a number"),
 		},
+		{
+			msg: "VolumeParametersZoneStrategy",
+			request: &proto.CreateVolumeRequest{
+				Name: "volume-id",
+				Parameters: map[string]string{
+					"storage":      "local-lvm",
+					"zoneStrategy": "first",
+				},
+				VolumeCapabilities:        []*proto.VolumeCapability{volcap},
+				CapacityRange:             volsize,
+				AccessibilityRequirements: topology,
+			},
+			expectedError: status.Error(codes.InvalidArgument, "parameters zoneStrategy must be one of: most-free, round-robin, random"),
+		},
+		{
+			msg: "ZoneNotEnoughCapacity",
+			request: &proto.CreateVolumeRequest{
+				Name: "volume-id",
+				Parameters: map[string]string{
+					"storage":      "local-lvm",
+					"zoneStrategy": "most-free",
+				},
+				VolumeCapabilities: []*proto.VolumeCapability{volcap},
+				CapacityRange: &proto.CapacityRange{
+					RequiredBytes: 60 * 1024 * 1024 * 1024,
+				},
+				AccessibilityRequirements: &proto.TopologyRequirement{
+					Preferred: []*proto.Topology{
+						{
+							Segments: map[string]string{
+								corev1.LabelTopologyRegion: "cluster-1",
+							},
+						},
+					},
+				},
+			},
+			expectedError: status.Error(codes.ResourceExhausted, "failed to find best zone: no online nodes with enough capacity on the storage local-lvm"),
+		},
 		{
 			msg: "RegionZone",
 			request: &proto.CreateVolumeRequest{
@@ -461,6 +539,114 @@ func (ts *configuredTestSuite) TestCreateVolume() {
 				},
 			},
 		},
+		{
+			msg: "CopyVolumeZoneMismatch",
+			request: &proto.CreateVolumeRequest{
+				Name:               "pvc-copy",
+				Parameters:         map[string]string{"storage": "zfs"},
+				VolumeCapabilities: []*proto.VolumeCapability{volcap},
+				CapacityRange:      volsize,
+				VolumeContentSource: &proto.VolumeContentSource{
+					Type: &proto.VolumeContentSource_Volume{
+						Volume: &proto.VolumeContentSource_VolumeSource{
+							VolumeId: "cluster-1/pve-1/local-lvm/vm-9999-pvc-123",
+						},
+					},
+				},
+				AccessibilityRequirements: &proto.TopologyRequirement{
+					Preferred: []*proto.Topology{
+						{
+							Segments: map[string]string{
+								corev1.LabelTopologyRegion: "cluster-1",
+								corev1.LabelTopologyZone:   "pve-2",
+							},
+						},
+					},
+				},
+			},
+			expectedError: status.Error(codes.InvalidArgument, "zone mismatch: requested zone pve-2 does not match source zone pve-1"),
+		},
+		{
+			msg: "CopyVolumeRemoteLocalStorage",
+			request: &proto.CreateVolumeRequest{
+				Name:               "pvc-copy",
+				Parameters:         map[string]string{"storage": "local-lvm"},
+				VolumeCapabilities: []*proto.VolumeCapability{volcap},
+				CapacityRange:      volsize,
+				VolumeContentSource: &proto.VolumeContentSource{
+					Type: &proto.VolumeContentSource_Volume{
+						Volume: &proto.VolumeContentSource_VolumeSource{
+							VolumeId: "cluster-1/pve-1/local-lvm/vm-9999-pvc-123",
+						},
+					},
+				},
+				AccessibilityRequirements: &proto.TopologyRequirement{
+					Preferred: []*proto.Topology{
+						{
+							Segments: map[string]string{
+								corev1.LabelTopologyRegion: "cluster-2",
+								corev1.LabelTopologyZone:   "pve-1",
+							},
+						},
+					},
+				},
+			},
+			expectedError: status.Error(codes.InvalidArgument, "storage local-lvm must be shared to copy volumes from another region"),
+		},
+		{
+			msg: "VolumeParametersClusterDefaultStorage",
+			request: &proto.CreateVolumeRequest{
+				Name:               "pvc-copy",
+				Parameters:         map[string]string{},
+				VolumeCapabilities: []*proto.VolumeCapability{volcap},
+				CapacityRange:      volsize,
+				VolumeContentSource: &proto.VolumeContentSource{
+					Type: &proto.VolumeContentSource_Volume{
+						Volume: &proto.VolumeContentSource_VolumeSource{
+							VolumeId: "cluster-1/pve-1/local-lvm/vm-9999-pvc-123",
+						},
+					},
+				},
+				AccessibilityRequirements: &proto.TopologyRequirement{
+					Preferred: []*proto.Topology{
+						{
+							Segments: map[string]string{
+								corev1.LabelTopologyRegion: "cluster-2",
+								corev1.LabelTopologyZone:   "pve-1",
+							},
+						},
+					},
+				},
+			},
+			expectedError: status.Error(codes.InvalidArgument, "storage local-lvm must be shared to copy volumes from another region"),
+		},
+		{
+			msg: "NativeSnapshotUnsupportedStorage",
+			request: &proto.CreateVolumeRequest{
+				Name:               "pvc-restore",
+				Parameters:         volParam,
+				VolumeCapabilities: []*proto.VolumeCapability{volcap},
+				CapacityRange:      volsize,
+				VolumeContentSource: &proto.VolumeContentSource{
+					Type: &proto.VolumeContentSource_Snapshot{
+						Snapshot: &proto.VolumeContentSource_SnapshotSource{
+							SnapshotId: "cluster-1/pve-1/local-lvm/vm-9999-pvc-123@snap",
+						},
+					},
+				},
+				AccessibilityRequirements: &proto.TopologyRequirement{
+					Preferred: []*proto.Topology{
+						{
+							Segments: map[string]string{
+								corev1.LabelTopologyRegion: "cluster-1",
+								corev1.LabelTopologyZone:   "pve-1",
+							},
+						},
+					},
+				},
+			},
+			expectedError: status.Error(codes.InvalidArgument, "storage type lvm does not support restoring snapshots to a new volume, use pvecsictl rollback"),
+		},
 		{
 			msg: "CreateVolume",
 			request: &proto.CreateVolumeRequest{
@@ -495,6 +681,156 @@ func (ts *configuredTestSuite) TestCreateVolume() {
 				},
 			},
 		},
+		{
+			msg: "PlacementGroupZoneUsed",
+			request: &proto.CreateVolumeRequest{
+				Name: "pvc-123",
+				Parameters: map[string]string{
+					"storage":        "local-lvm",
+					"placementGroup": "db",
+				},
+				VolumeCapabilities: []*proto.VolumeCapability{volcap},
+				CapacityRange:      volsize,
+				AccessibilityRequirements: &proto.TopologyRequirement{
+					Requisite: []*proto.Topology{
+						{
+							Segments: map[string]string{
+								corev1.LabelTopologyRegion: "cluster-1",
+								corev1.LabelTopologyZone:   "pve-1",
+							},
+						},
+					},
+				},
+			},
+			expectedError: status.Error(codes.ResourceExhausted,
+				"failed to find best zone: no nodes with the storage local-lvm, zones pve-1 are used by placement group db"),
+		},
+		{
+			msg: "PlacementGroupLabelMetadata",
+			request: &proto.CreateVolumeRequest{
+				Name: "pvc-123",
+				Parameters: map[string]string{
+					"storage":             "local-lvm",
+					"placementGroupLabel": "app",
+				},
+				VolumeCapabilities: []*proto.VolumeCapability{volcap},
+				CapacityRange:      volsize,
+				AccessibilityRequirements: &proto.TopologyRequirement{
+					Preferred: []*proto.Topology{
+						{
+							Segments: map[string]string{
+								corev1.LabelTopologyRegion: "cluster-1",
+							},
+						},
+					},
+				},
+			},
+			expectedError: status.Error(codes.InvalidArgument, "parameter placementGroupLabel requires csi-provisioner with --extra-create-metadata"),
+		},
+		{
+			msg: "PlacementGroup",
+			request: &proto.CreateVolumeRequest{
+				Name: "pvc-123",
+				Parameters: map[string]string{
+					"storage":        "local-lvm",
+					"placementGroup": "db",
+				},
+				VolumeCapabilities: []*proto.VolumeCapability{volcap},
+				CapacityRange:      volsize,
+				AccessibilityRequirements: &proto.TopologyRequirement{
+					Preferred: []*proto.Topology{
+						{
+							Segments: map[string]string{
+								corev1.LabelTopologyRegion: "cluster-1",
+							},
+						},
+					},
+				},
+			},
+			expected: &proto.CreateVolumeResponse{
+				Volume: &proto.Volume{
+					VolumeId: "cluster-1/pve-2/local-lvm/vm-9999-pvc-123",
+					VolumeContext: map[string]string{
+						"backup":         "0",
+						"iothread":       "1",
+						"storage":        "local-lvm",
+						"replicate":      "0",
+						"placementGroup": "db",
+					},
+					CapacityBytes: csi.MinChunkSizeBytes,
+					AccessibleTopology: []*proto.Topology{
+						{
+							Segments: map[string]string{
+								corev1.LabelTopologyRegion: "cluster-1",
+								corev1.LabelTopologyZone:   "pve-2",
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			msg: "MultipleStorages",
+			request: &proto.CreateVolumeRequest{
+				Name: "pvc-123",
+				Parameters: map[string]string{
+					"storage": "fake-storage,local-lvm",
+				},
+				VolumeCapabilities: []*proto.VolumeCapability{volcap},
+				CapacityRange:      volsize,
+				AccessibilityRequirements: &proto.TopologyRequirement{
+					Preferred: []*proto.Topology{
+						{
+							Segments: map[string]string{
+								corev1.LabelTopologyRegion: "cluster-1",
+								corev1.LabelTopologyZone:   "pve-1",
+							},
+						},
+					},
+				},
+			},
+			expected: &proto.CreateVolumeResponse{
+				Volume: &proto.Volume{
+					VolumeId:      "cluster-1/pve-1/local-lvm/vm-9999-pvc-123",
+					VolumeContext: volParamDefaults,
+					CapacityBytes: csi.MinChunkSizeBytes,
+					AccessibleTopology: []*proto.Topology{
+						{
+							Segments: map[string]string{
+								corev1.LabelTopologyRegion: "cluster-1",
+								corev1.LabelTopologyZone:   "pve-1",
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			msg: "MultipleStoragesNotEnoughCapacity",
+			request: &proto.CreateVolumeRequest{
+				Name: "volume-id",
+				Parameters: map[string]string{
+					"storage":      "fake-storage,local-lvm",
+					"zoneStrategy": "most-free",
+				},
+				VolumeCapabilities: []*proto.VolumeCapability{volcap},
+				CapacityRange: &proto.CapacityRange{
+					RequiredBytes: 60 * 1024 * 1024 * 1024,
+				},
+				AccessibilityRequirements: &proto.TopologyRequirement{
+					Preferred: []*proto.Topology{
+						{
+							Segments: map[string]string{
+								corev1.LabelTopologyRegion: "cluster-1",
+							},
+						},
+					},
+				},
+			},
+			expectedError: status.Error(codes.ResourceExhausted, "failed to find storage for the volume: "+
+				"failed to get zones with storage fake-storage: not found; "+
+				"failed to find best zone: no online nodes with enough capacity on the storage local-lvm"),
+		},
 	}
 
 	for _, testCase := range tests {
@@ -511,24 +847,102 @@ func (ts *configuredTestSuite) TestCreateVolume() {
 	}
 }
 
-//nolint:dupl
-func (ts *configuredTestSuite) TestDeleteVolume() {
+func (ts *configuredTestSuite) TestCreateVolumeFromZFSSnapshot() {
 	httpmock.Activate()
 	defer httpmock.DeactivateAndReset() //nolint: wsl_v5
 
-	tests := []struct {
-		msg           string
-		request       *proto.DeleteVolumeRequest
-		expected      *proto.DeleteVolumeResponse
-		expectedError error
-	}{
-		{
-			msg: "VolumeID",
-			request: &proto.DeleteVolumeRequest{
-				VolumeId: "volume-id",
-			},
-			expectedError: status.Error(codes.InvalidArgument, "VolumeID must be in the format of region/zone/storageName/diskName"),
-		},
+	task := &proxmox.Task{
+		UPID:       "UPID:pve-1:003B4235:1DF4ABCA:667C1C45:csi:105:root@pam:",
+		Type:       "imgcopy",
+		Status:     "stopped",
+		ExitStatus: "OK",
+		Node:       "pve-1",
+	}
+	copied := false
+
+	httpmock.RegisterResponder(http.MethodGet, `=~/nodes/pve-1/tasks/`+string(task.UPID)+`/status`,
+		httpmock.NewJsonResponderOrPanic(200, map[string]any{"data": task}))
+
+	httpmock.RegisterResponder(http.MethodGet, `=~/nodes/\S+/storage/zfs/content`,
+		func(_ *http.Request) (*http.Response, error) {
+			content := []proxmox.StorageContent{
+				{Format: "raw", Size: uint64(csi.MinChunkSizeBytes), Volid: "zfs:vm-9999-pvc-123"},
+				{Format: "raw", Size: uint64(csi.MinChunkSizeBytes), Volid: "zfs:vm-9999-snapshot-123_vm-9999-pvc-123"},
+			}
+			if copied {
+				content = append(content, proxmox.StorageContent{Format: "raw", Size: uint64(csi.MinChunkSizeBytes), Volid: "zfs:vm-9999-pvc-restore"})
+			}
+
+			return httpmock.NewJsonResponse(200, map[string]any{"data": content})
+		},
+	)
+	httpmock.RegisterResponder(http.MethodPost, `=~/nodes/pve-1/storage/zfs/content/vm-9999-snapshot-123_vm-9999-pvc-123$`,
+		func(req *http.Request) (*http.Response, error) {
+			params := map[string]any{}
+			if err := json.NewDecoder(req.Body).Decode(&params); err != nil {
+				return nil, err
+			}
+
+			ts.Require().Equal("vm-9999-pvc-restore", params["target"])
+
+			copied = true
+
+			return httpmock.NewJsonResponse(200, map[string]any{"data": task.UPID})
+		},
+	)
+
+	request := func(snapshotID string) *proto.CreateVolumeRequest {
+		return &proto.CreateVolumeRequest{
+			Name:       "pvc-restore",
+			Parameters: map[string]string{"storage": "zfs"},
+			VolumeCapabilities: []*proto.VolumeCapability{{
+				AccessMode: &proto.VolumeCapability_AccessMode{Mode: proto.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+				AccessType: &proto.VolumeCapability_Mount{Mount: &proto.VolumeCapability_MountVolume{FsType: "ext4"}},
+			}},
+			CapacityRange: &proto.CapacityRange{RequiredBytes: 1},
+			VolumeContentSource: &proto.VolumeContentSource{
+				Type: &proto.VolumeContentSource_Snapshot{
+					Snapshot: &proto.VolumeContentSource_SnapshotSource{SnapshotId: snapshotID},
+				},
+			},
+			AccessibilityRequirements: &proto.TopologyRequirement{
+				Preferred: []*proto.Topology{{Segments: map[string]string{
+					corev1.LabelTopologyRegion: "cluster-1",
+					corev1.LabelTopologyZone:   "pve-1",
+				}}},
+			},
+		}
+	}
+
+	// The native ZFS snapshots can only be rolled back
+	_, err := ts.s.CreateVolume(context.Background(), request("cluster-1/pve-1/zfs/vm-9999-pvc-123@snap"))
+	ts.Require().Equal(status.Error(codes.InvalidArgument, "storage type zfspool does not support restoring snapshots to a new volume, use pvecsictl rollback"), err)
+	ts.Require().False(copied)
+
+	resp, err := ts.s.CreateVolume(context.Background(), request("cluster-1/pve-1/zfs/vm-9999-snapshot-123_vm-9999-pvc-123"))
+	ts.Require().NoError(err)
+	ts.Require().True(copied)
+	ts.Require().Equal("cluster-1/pve-1/zfs/vm-9999-pvc-restore", resp.GetVolume().GetVolumeId())
+}
+
+//nolint:dupl
+func (ts *configuredTestSuite) TestDeleteVolume() {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset() //nolint: wsl_v5
+
+	tests := []struct {
+		msg           string
+		request       *proto.DeleteVolumeRequest
+		expected      *proto.DeleteVolumeResponse
+		expectedError error
+	}{
+		{
+			msg: "VolumeID",
+			request: &proto.DeleteVolumeRequest{
+				VolumeId: "volume-id",
+			},
+			expectedError: status.Error(codes.InvalidArgument, "VolumeID must be in the format of region/zone/storageName/diskName"),
+		},
 		{
 			msg: "WrongCluster",
 			request: &proto.DeleteVolumeRequest{
@@ -592,7 +1006,7 @@ func (ts *configuredTestSuite) TestControllerServiceControllerGetCapabilities()
 	ts.Require().NoError(err)
 	ts.Require().NotNil(resp)
 
-	if len(resp.GetCapabilities()) != 9 {
+	if len(resp.GetCapabilities()) != 13 {
 		ts.T().Fatalf("unexpected number of capabilities: %d", len(resp.GetCapabilities()))
 	}
 }
@@ -775,15 +1189,276 @@ func (ts *configuredTestSuite) TestControllerUnpublishVolumeError() {
 }
 
 func (ts *configuredTestSuite) TestValidateVolumeCapabilities() {
-	_, err := ts.s.ValidateVolumeCapabilities(context.Background(), &proto.ValidateVolumeCapabilitiesRequest{})
-	ts.Require().Error(err)
-	ts.Require().Equal(status.Error(codes.Unimplemented, ""), err)
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset() //nolint: wsl_v5
+
+	volcap := []*proto.VolumeCapability{
+		{
+			AccessMode: &proto.VolumeCapability_AccessMode{
+				Mode: proto.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			},
+			AccessType: &proto.VolumeCapability_Mount{
+				Mount: &proto.VolumeCapability_MountVolume{
+					FsType: "ext4",
+				},
+			},
+		},
+		{
+			AccessMode: &proto.VolumeCapability_AccessMode{
+				Mode: proto.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER,
+			},
+			AccessType: &proto.VolumeCapability_Block{
+				Block: &proto.VolumeCapability_BlockVolume{},
+			},
+		},
+	}
+
+	tests := []struct {
+		msg           string
+		request       *proto.ValidateVolumeCapabilitiesRequest
+		expected      *proto.ValidateVolumeCapabilitiesResponse
+		expectedError error
+	}{
+		{
+			msg:           "EmptyRequest",
+			request:       &proto.ValidateVolumeCapabilitiesRequest{},
+			expectedError: status.Error(codes.InvalidArgument, "VolumeID must be provided"),
+		},
+		{
+			msg: "EmptyCapabilities",
+			request: &proto.ValidateVolumeCapabilitiesRequest{
+				VolumeId: "cluster-1/pve-1/local-lvm/vm-9999-pvc-123",
+			},
+			expectedError: status.Error(codes.InvalidArgument, "VolumeCapabilities must be provided"),
+		},
+		{
+			msg: "WrongCluster",
+			request: &proto.ValidateVolumeCapabilitiesRequest{
+				VolumeId:           "fake-region/node/data/volume-id",
+				VolumeCapabilities: volcap,
+			},
+			expectedError: status.Error(codes.Internal, "region not found"),
+		},
+		{
+			msg: "VolumeNotFound",
+			request: &proto.ValidateVolumeCapabilitiesRequest{
+				VolumeId:           "cluster-1/pve-1/local-lvm/vm-9999-pvc-none",
+				VolumeCapabilities: volcap,
+			},
+			expectedError: status.Error(codes.NotFound, "volume cluster-1/pve-1/local-lvm/vm-9999-pvc-none not found"),
+		},
+		{
+			msg: "Confirmed",
+			request: &proto.ValidateVolumeCapabilitiesRequest{
+				VolumeId:           "cluster-1/pve-1/local-lvm/vm-9999-pvc-123",
+				VolumeCapabilities: volcap,
+				Parameters: map[string]string{
+					"storage": "local-lvm",
+				},
+			},
+			expected: &proto.ValidateVolumeCapabilitiesResponse{
+				Confirmed: &proto.ValidateVolumeCapabilitiesResponse_Confirmed{
+					VolumeCapabilities: volcap,
+					Parameters: map[string]string{
+						"storage": "local-lvm",
+					},
+				},
+			},
+		},
+		{
+			msg: "SharedStorage",
+			request: &proto.ValidateVolumeCapabilitiesRequest{
+				VolumeId:           "cluster-1//rbd/9999/vm-9999-volume-rbd.raw",
+				VolumeCapabilities: volcap,
+			},
+			expected: &proto.ValidateVolumeCapabilitiesResponse{
+				Confirmed: &proto.ValidateVolumeCapabilitiesResponse_Confirmed{
+					VolumeCapabilities: volcap,
+				},
+			},
+		},
+		{
+			msg: "StorageMismatch",
+			request: &proto.ValidateVolumeCapabilitiesRequest{
+				VolumeId:           "cluster-1/pve-1/local-lvm/vm-9999-pvc-123",
+				VolumeCapabilities: volcap,
+				Parameters: map[string]string{
+					"storage": "zfs",
+				},
+			},
+			expected: &proto.ValidateVolumeCapabilitiesResponse{
+				Message: "volume storage local-lvm does not match the requested storage zfs",
+			},
+		},
+		{
+			msg: "Replication",
+			request: &proto.ValidateVolumeCapabilitiesRequest{
+				VolumeId:           "cluster-1/pve-1/local-lvm/vm-9999-pvc-123",
+				VolumeCapabilities: volcap,
+				Parameters: map[string]string{
+					"replicate": "true",
+				},
+			},
+			expected: &proto.ValidateVolumeCapabilitiesResponse{
+				Message: "storage local-lvm of type lvm does not support replication",
+			},
+		},
+		{
+			msg: "AccessMode",
+			request: &proto.ValidateVolumeCapabilitiesRequest{
+				VolumeId: "cluster-1/pve-1/local-lvm/vm-9999-pvc-123",
+				VolumeCapabilities: []*proto.VolumeCapability{
+					{
+						AccessMode: &proto.VolumeCapability_AccessMode{
+							Mode: proto.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+						},
+						AccessType: &proto.VolumeCapability_Block{
+							Block: &proto.VolumeCapability_BlockVolume{},
+						},
+					},
+				},
+			},
+			expected: &proto.ValidateVolumeCapabilitiesResponse{
+				Message: "access mode MULTI_NODE_MULTI_WRITER is not supported",
+			},
+		},
+		{
+			msg: "FsType",
+			request: &proto.ValidateVolumeCapabilitiesRequest{
+				VolumeId: "cluster-1/pve-1/local-lvm/vm-9999-pvc-123",
+				VolumeCapabilities: []*proto.VolumeCapability{
+					{
+						AccessMode: &proto.VolumeCapability_AccessMode{
+							Mode: proto.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+						},
+						AccessType: &proto.VolumeCapability_Mount{
+							Mount: &proto.VolumeCapability_MountVolume{
+								FsType: "btrfs",
+							},
+						},
+					},
+				},
+			},
+			expected: &proto.ValidateVolumeCapabilitiesResponse{
+				Message: "filesystem btrfs is not supported",
+			},
+		},
+	}
+
+	for _, testCase := range tests {
+		ts.Run(fmt.Sprint(testCase.msg), func() {
+			resp, err := ts.s.ValidateVolumeCapabilities(context.Background(), testCase.request)
+			if testCase.expectedError == nil {
+				ts.Require().NoError(err)
+				ts.Require().Equal(testCase.expected, resp)
+			} else {
+				ts.Require().Error(err)
+				ts.Require().Equal(testCase.expectedError, err)
+			}
+		})
+	}
 }
 
 func (ts *configuredTestSuite) TestListVolumes() {
-	_, err := ts.s.ListVolumes(context.Background(), &proto.ListVolumesRequest{})
-	ts.Require().Error(err)
-	ts.Require().Equal(status.Error(codes.Unimplemented, ""), err)
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset() //nolint: wsl_v5
+
+	tests := []struct {
+		msg           string
+		request       *proto.ListVolumesRequest
+		expected      *proto.ListVolumesResponse
+		expectedError error
+	}{
+		{
+			msg: "NegativeMaxEntries",
+			request: &proto.ListVolumesRequest{
+				MaxEntries: -1,
+			},
+			expectedError: status.Error(codes.InvalidArgument, "MaxEntries must not be negative"),
+		},
+		{
+			msg: "InvalidStartingToken",
+			request: &proto.ListVolumesRequest{
+				StartingToken: "token",
+			},
+			expectedError: status.Error(codes.Aborted, "invalid starting token token"),
+		},
+		{
+			msg: "StartingTokenOutOfRange",
+			request: &proto.ListVolumesRequest{
+				StartingToken: "1000",
+			},
+			expectedError: status.Error(codes.Aborted, "starting token 1000 is out of range"),
+		},
+		{
+			msg: "FirstPage",
+			request: &proto.ListVolumesRequest{
+				MaxEntries: 1,
+			},
+			expected: &proto.ListVolumesResponse{
+				Entries: []*proto.ListVolumesResponse_Entry{
+					{
+						Volume: &proto.Volume{
+							VolumeId:      "cluster-1/pve-1/local-lvm/vm-9999-pvc-123",
+							CapacityBytes: csi.MinChunkSizeBytes,
+							AccessibleTopology: []*proto.Topology{
+								{
+									Segments: map[string]string{
+										corev1.LabelTopologyRegion: "cluster-1",
+										corev1.LabelTopologyZone:   "pve-1",
+									},
+								},
+							},
+						},
+						Status: &proto.ListVolumesResponse_VolumeStatus{
+							PublishedNodeIds: []string{"cluster-1-node-1"},
+						},
+					},
+				},
+				NextToken: "1",
+			},
+		},
+		{
+			msg: "NextPage",
+			request: &proto.ListVolumesRequest{
+				MaxEntries:    1,
+				StartingToken: "1",
+			},
+			expected: &proto.ListVolumesResponse{
+				Entries: []*proto.ListVolumesResponse_Entry{
+					{
+						Volume: &proto.Volume{
+							VolumeId:      "cluster-1/pve-1/local-lvm/vm-9999-pvc-exist",
+							CapacityBytes: 5 * csi.GiB,
+							AccessibleTopology: []*proto.Topology{
+								{
+									Segments: map[string]string{
+										corev1.LabelTopologyRegion: "cluster-1",
+										corev1.LabelTopologyZone:   "pve-1",
+									},
+								},
+							},
+						},
+						Status: &proto.ListVolumesResponse_VolumeStatus{},
+					},
+				},
+				NextToken: "2",
+			},
+		},
+	}
+
+	for _, testCase := range tests {
+		ts.Run(fmt.Sprint(testCase.msg), func() {
+			resp, err := ts.s.ListVolumes(context.Background(), testCase.request)
+			if testCase.expectedError == nil {
+				ts.Require().NoError(err)
+				ts.Require().Equal(testCase.expected, resp)
+			} else {
+				ts.Require().Error(err)
+				ts.Require().Equal(testCase.expectedError, err)
+			}
+		})
+	}
 }
 
 func (ts *configuredTestSuite) TestGetCapacity() {
@@ -895,6 +1570,38 @@ func (ts *configuredTestSuite) TestGetCapacity() {
 				AvailableCapacity: 50 * 1024 * 1024 * 1024,
 			},
 		},
+		{
+			msg: "MultipleStorages",
+			request: &proto.GetCapacityRequest{
+				AccessibleTopology: &proto.Topology{
+					Segments: map[string]string{
+						corev1.LabelTopologyRegion: "cluster-1",
+						corev1.LabelTopologyZone:   "pve-1",
+					},
+				},
+				Parameters: map[string]string{
+					csi.StorageIDKey: "storage,local-lvm",
+				},
+			},
+			expected: &proto.GetCapacityResponse{
+				AvailableCapacity: 50 * 1024 * 1024 * 1024,
+			},
+		},
+		{
+			msg: "MultipleStoragesNotExist",
+			request: &proto.GetCapacityRequest{
+				AccessibleTopology: &proto.Topology{
+					Segments: map[string]string{
+						corev1.LabelTopologyRegion: "cluster-1",
+						corev1.LabelTopologyZone:   "pve-1",
+					},
+				},
+				Parameters: map[string]string{
+					csi.StorageIDKey: "storage,fake-storage",
+				},
+			},
+			expected: &proto.GetCapacityResponse{},
+		},
 	}
 
 	for _, testCase := range tests {
@@ -937,6 +1644,24 @@ func (ts *configuredTestSuite) TestCreateSnapshot() {
 			},
 			expectedError: status.Error(codes.Internal, "region not found"),
 		},
+		{
+			msg: "FreezeTimeout",
+			request: &proto.CreateSnapshotRequest{
+				Name:           "snapshot-123",
+				SourceVolumeId: "cluster-1/pve-1/local-lvm/vm-9999-pvc-123",
+				Parameters:     map[string]string{"freeze": "true", "freezeTimeout": "0"},
+			},
+			expectedError: status.Error(codes.InvalidArgument, "parameters freezeTimeout must be between 1 and 600 seconds"),
+		},
+		{
+			msg: "BackupStorageNotPBS",
+			request: &proto.CreateSnapshotRequest{
+				Name:           "snapshot-123",
+				SourceVolumeId: "cluster-1//rbd/vm-9999-pvc-123",
+				Parameters:     map[string]string{"backupStorage": "rbd"},
+			},
+			expectedError: status.Error(codes.InvalidArgument, "storage rbd is not a Proxmox Backup Server storage"),
+		},
 	}
 
 	for _, testCase := range tests {
@@ -953,6 +1678,28 @@ func (ts *configuredTestSuite) TestCreateSnapshot() {
 	}
 }
 
+func (ts *configuredTestSuite) TestCreateSnapshotLegacy() {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset() //nolint: wsl_v5
+
+	httpmock.RegisterResponder(http.MethodGet, `=~/storage/local-lvm$`,
+		func(_ *http.Request) (*http.Response, error) {
+			return httpmock.NewJsonResponse(200, map[string]any{
+				"data": proxmox.ClusterStorage{Type: "lvm", Storage: "local-lvm", Content: "images"},
+			})
+		},
+	)
+
+	resp, err := ts.s.CreateSnapshot(context.Background(), &proto.CreateSnapshotRequest{
+		Name:           "snapshot-6bd1c8b4",
+		SourceVolumeId: "cluster-1/pve-1/local-lvm/vm-9999-pvc-123",
+	})
+	ts.Require().NoError(err)
+	ts.Require().Equal("cluster-1/pve-1/local-lvm/vm-9999-snapshot-6bd1c8b4", resp.GetSnapshot().GetSnapshotId())
+	ts.Require().Equal(int64(csi.MinChunkSizeBytes), resp.GetSnapshot().GetSizeBytes())
+	ts.Require().True(resp.GetSnapshot().GetReadyToUse())
+}
+
 func (ts *configuredTestSuite) TestDeleteSnapshot() {
 	httpmock.Activate()
 	defer httpmock.DeactivateAndReset() //nolint: wsl_v5
@@ -982,6 +1729,13 @@ func (ts *configuredTestSuite) TestDeleteSnapshot() {
 			},
 			expected: &proto.DeleteSnapshotResponse{},
 		},
+		{
+			msg: "NativeSnapshotNonExist",
+			request: &proto.DeleteSnapshotRequest{
+				SnapshotId: "cluster-1//rbd/vm-9999-pvc-123@snap",
+			},
+			expected: &proto.DeleteSnapshotResponse{},
+		},
 	}
 
 	for _, testCase := range tests {
@@ -999,9 +1753,243 @@ func (ts *configuredTestSuite) TestDeleteSnapshot() {
 }
 
 func (ts *configuredTestSuite) TestListSnapshots() {
-	_, err := ts.s.ListSnapshots(context.Background(), &proto.ListSnapshotsRequest{})
-	ts.Require().Error(err)
-	ts.Require().Equal(status.Error(codes.Unimplemented, ""), err)
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset() //nolint: wsl_v5
+
+	snapshot := &proto.Snapshot{
+		SnapshotId:     "cluster-1/pve-1/local-lvm/vm-9999-snapshot-123_vm-9999-pvc-123",
+		SourceVolumeId: "cluster-1/pve-1/local-lvm/vm-9999-pvc-123",
+		SizeBytes:      csi.MinChunkSizeBytes,
+		CreationTime:   timestamppb.New(time.Unix(1700000000, 0)),
+		ReadyToUse:     true,
+	}
+
+	tests := []struct {
+		msg           string
+		request       *proto.ListSnapshotsRequest
+		expected      *proto.ListSnapshotsResponse
+		expectedError error
+	}{
+		{
+			msg: "NegativeMaxEntries",
+			request: &proto.ListSnapshotsRequest{
+				MaxEntries: -1,
+			},
+			expectedError: status.Error(codes.InvalidArgument, "MaxEntries must not be negative"),
+		},
+		{
+			msg: "InvalidStartingToken",
+			request: &proto.ListSnapshotsRequest{
+				StartingToken: "token",
+			},
+			expectedError: status.Error(codes.Aborted, "invalid starting token token"),
+		},
+		{
+			msg: "StartingTokenOutOfRange",
+			request: &proto.ListSnapshotsRequest{
+				StartingToken: "1000",
+			},
+			expectedError: status.Error(codes.Aborted, "starting token 1000 is out of range"),
+		},
+		{
+			msg: "FirstPage",
+			request: &proto.ListSnapshotsRequest{
+				MaxEntries: 1,
+			},
+			expected: &proto.ListSnapshotsResponse{
+				Entries:   []*proto.ListSnapshotsResponse_Entry{{Snapshot: snapshot}},
+				NextToken: "1",
+			},
+		},
+		{
+			msg: "SnapshotID",
+			request: &proto.ListSnapshotsRequest{
+				SnapshotId: "cluster-1/pve-1/local-lvm/vm-9999-snapshot-123_vm-9999-pvc-123",
+			},
+			expected: &proto.ListSnapshotsResponse{
+				Entries: []*proto.ListSnapshotsResponse_Entry{{Snapshot: snapshot}},
+			},
+		},
+		{
+			msg: "SourceVolumeID",
+			request: &proto.ListSnapshotsRequest{
+				SourceVolumeId: "cluster-1/pve-1/local-lvm/vm-9999-pvc-123",
+			},
+			expected: &proto.ListSnapshotsResponse{
+				Entries: []*proto.ListSnapshotsResponse_Entry{{Snapshot: snapshot}},
+			},
+		},
+		{
+			msg: "LegacySnapshotID",
+			request: &proto.ListSnapshotsRequest{
+				SnapshotId: "cluster-1/pve-1/local-lvm/vm-9999-snapshot-6bd1c8b4",
+			},
+			expected: &proto.ListSnapshotsResponse{
+				Entries: []*proto.ListSnapshotsResponse_Entry{{Snapshot: &proto.Snapshot{
+					SnapshotId:   "cluster-1/pve-1/local-lvm/vm-9999-snapshot-6bd1c8b4",
+					SizeBytes:    csi.MinChunkSizeBytes,
+					CreationTime: timestamppb.New(time.Unix(1600000000, 0)),
+					ReadyToUse:   true,
+				}}},
+			},
+		},
+		{
+			msg: "SnapshotIDNotFound",
+			request: &proto.ListSnapshotsRequest{
+				SnapshotId: "cluster-1/pve-1/local-lvm/vm-9999-snapshot-none_vm-9999-pvc-123",
+			},
+			expected: &proto.ListSnapshotsResponse{
+				Entries: []*proto.ListSnapshotsResponse_Entry{},
+			},
+		},
+		{
+			msg: "NativeSnapshotNotFound",
+			request: &proto.ListSnapshotsRequest{
+				SnapshotId: "cluster-1//rbd/vm-9999-pvc-123@snap",
+			},
+			expected: &proto.ListSnapshotsResponse{
+				Entries: []*proto.ListSnapshotsResponse_Entry{},
+			},
+		},
+		{
+			msg: "WrongCluster",
+			request: &proto.ListSnapshotsRequest{
+				SourceVolumeId: "fake-region/node/data/volume-id",
+			},
+			expected: &proto.ListSnapshotsResponse{},
+		},
+	}
+
+	for _, testCase := range tests {
+		ts.Run(fmt.Sprint(testCase.msg), func() {
+			resp, err := ts.s.ListSnapshots(context.Background(), testCase.request)
+			if testCase.expectedError == nil {
+				ts.Require().NoError(err)
+				ts.Require().Equal(testCase.expected, resp)
+			} else {
+				ts.Require().Error(err)
+				ts.Require().Equal(testCase.expectedError, err)
+			}
+		})
+	}
+}
+
+func (ts *configuredTestSuite) TestListSnapshotsNative() {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset() //nolint: wsl_v5
+
+	// The holder VM of the volume pvc-123 has a native snapshot, and one snapshot is archived to Proxmox Backup Server
+	httpmock.RegisterResponder(http.MethodGet, `=~/cluster/resources`,
+		httpmock.NewJsonResponderOrPanic(200, map[string]any{"data": proxmox.ClusterResources{
+			{Node: "pve-1", Type: "qemu", VMID: 100, Name: "cluster-1-node-1"},
+			{Node: "pve-1", Type: "qemu", VMID: 102, Name: "pvc-123"},
+			{Type: "storage", PluginType: "lvm", Node: "pve-1", Storage: "local-lvm", Content: "images", Status: "available"},
+			{Type: "storage", PluginType: "pbs", Node: "pve-1", Storage: "pbs", Content: "backup", Shared: 1, Status: "available"},
+		}}))
+	httpmock.RegisterResponder(http.MethodGet, `=~/api2/json/storage$`,
+		httpmock.NewJsonResponderOrPanic(200, map[string]any{"data": proxmox.ClusterStorages{
+			{Type: "lvmthin", Storage: "local-lvm", Content: "images,rootdir"},
+			{Type: "pbs", Storage: "pbs", Shared: 1, Content: "backup"},
+		}}))
+	httpmock.RegisterResponder(http.MethodGet, `=~/nodes/pve-1/qemu/102/status/current$`,
+		httpmock.NewJsonResponderOrPanic(200, map[string]any{"data": map[string]any{"vmid": 102, "name": "pvc-123"}}))
+	httpmock.RegisterResponder(http.MethodGet, `=~/nodes/pve-1/qemu/102/config$`,
+		httpmock.NewJsonResponderOrPanic(200, map[string]any{"data": map[string]any{"scsi1": "local-lvm:vm-9999-pvc-123,backup=0"}}))
+	httpmock.RegisterResponder(http.MethodGet, `=~/nodes/pve-1/qemu/102/snapshot$`,
+		httpmock.NewJsonResponderOrPanic(200, map[string]any{"data": []proxmox.Snapshot{
+			{Name: "snap-1", Snaptime: 1710000000},
+			{Name: "current"},
+		}}))
+	httpmock.RegisterResponder(http.MethodGet, "https://127.0.0.1:8006/api2/json/nodes/pve-1/storage/pbs/content",
+		httpmock.NewJsonResponderOrPanic(200, map[string]any{"data": []proxmox.StorageContent{
+			{
+				Volid: "pbs:backup/vm/200/2025-01-01T00:00:00Z",
+				Size:  uint64(csi.MinChunkSizeBytes),
+				Ctime: 1720000000,
+				Notes: "csi-snapshot snapshot-456 cluster-1/pve-1/local-lvm/vm-9999-pvc-123",
+			},
+			{
+				Volid: "pbs:backup/vm/300/2025-01-01T00:00:00Z",
+				Notes: "manual backup",
+			},
+		}}))
+
+	copied := &proto.Snapshot{
+		SnapshotId:     "cluster-1/pve-1/local-lvm/vm-9999-snapshot-123_vm-9999-pvc-123",
+		SourceVolumeId: "cluster-1/pve-1/local-lvm/vm-9999-pvc-123",
+		SizeBytes:      csi.MinChunkSizeBytes,
+		CreationTime:   timestamppb.New(time.Unix(1700000000, 0)),
+		ReadyToUse:     true,
+	}
+	native := &proto.Snapshot{
+		SnapshotId:     "cluster-1/pve-1/local-lvm/vm-9999-pvc-123@snap-1",
+		SourceVolumeId: "cluster-1/pve-1/local-lvm/vm-9999-pvc-123",
+		SizeBytes:      csi.MinChunkSizeBytes,
+		CreationTime:   timestamppb.New(time.Unix(1710000000, 0)),
+		ReadyToUse:     true,
+	}
+	backup := &proto.Snapshot{
+		SnapshotId:     "cluster-1//pbs/backup/vm/200/2025-01-01T00:00:00Z",
+		SourceVolumeId: "cluster-1/pve-1/local-lvm/vm-9999-pvc-123",
+		SizeBytes:      csi.MinChunkSizeBytes,
+		CreationTime:   timestamppb.New(time.Unix(1720000000, 0)),
+		ReadyToUse:     true,
+	}
+
+	tests := []struct {
+		msg      string
+		request  *proto.ListSnapshotsRequest
+		expected []*proto.Snapshot
+	}{
+		{
+			msg:      "SourceVolumeID",
+			request:  &proto.ListSnapshotsRequest{SourceVolumeId: "cluster-1/pve-1/local-lvm/vm-9999-pvc-123"},
+			expected: []*proto.Snapshot{copied, native, backup},
+		},
+		{
+			msg:      "SourceVolumeIDWithoutSnapshots",
+			request:  &proto.ListSnapshotsRequest{SourceVolumeId: "cluster-1/pve-1/local-lvm/vm-9999-pvc-exist"},
+			expected: []*proto.Snapshot{},
+		},
+		{
+			msg: "NativeSnapshotID",
+			request: &proto.ListSnapshotsRequest{
+				SnapshotId:     "cluster-1/pve-1/local-lvm/vm-9999-pvc-123@snap-1",
+				SourceVolumeId: "cluster-1/pve-1/local-lvm/vm-9999-pvc-123",
+			},
+			expected: []*proto.Snapshot{native},
+		},
+		{
+			msg: "NativeSnapshotIDOfAnotherSource",
+			request: &proto.ListSnapshotsRequest{
+				SnapshotId:     "cluster-1/pve-1/local-lvm/vm-9999-pvc-123@snap-1",
+				SourceVolumeId: "cluster-1/pve-1/local-lvm/vm-9999-pvc-exist",
+			},
+			expected: []*proto.Snapshot{},
+		},
+		{
+			msg: "BackupSnapshotIDOfAnotherSource",
+			request: &proto.ListSnapshotsRequest{
+				SnapshotId:     "cluster-1//pbs/backup/vm/200/2025-01-01T00:00:00Z",
+				SourceVolumeId: "cluster-1/pve-1/local-lvm/vm-9999-pvc-exist",
+			},
+			expected: []*proto.Snapshot{},
+		},
+	}
+
+	for _, testCase := range tests {
+		ts.Run(fmt.Sprint(testCase.msg), func() {
+			resp, err := ts.s.ListSnapshots(context.Background(), testCase.request)
+			ts.Require().NoError(err)
+
+			snapshots := []*proto.Snapshot{}
+			for _, entry := range resp.GetEntries() {
+				snapshots = append(snapshots, entry.GetSnapshot())
+			}
+
+			ts.Require().Equal(testCase.expected, snapshots)
+		})
+	}
 }
 
 func (ts *configuredTestSuite) TestControllerExpandVolumeError() {
@@ -1013,6 +2001,12 @@ func (ts *configuredTestSuite) TestControllerExpandVolumeError() {
 		LimitBytes:    150 * csi.GiB,
 	}
 
+	// The unpublished volume is attached to a temporary VM for the resize
+	httpmock.RegisterResponder(http.MethodGet, `=~/cluster/nextid`,
+		httpmock.NewJsonResponderOrPanic(200, map[string]any{"data": "10000"}))
+	httpmock.RegisterResponder(http.MethodPost, `=~/nodes/pve-1/qemu$`,
+		httpmock.NewStringResponder(500, ""))
+
 	tests := []struct {
 		msg           string
 		request       *proto.ControllerExpandVolumeRequest
@@ -1074,7 +2068,7 @@ func (ts *configuredTestSuite) TestControllerExpandVolumeError() {
 				VolumeId:      "cluster-1/pve-1/local-lvm/vm-9999-pvc-unpublished",
 				CapacityRange: capRange,
 			},
-			expectedError: status.Error(codes.Internal, "cannot resize unpublished"),
+			expectedError: status.Error(codes.Internal, "failed to create resize vm: 500 Internal Server Error"),
 		},
 		{
 			msg: "ExpandVolume",
@@ -1118,7 +2112,129 @@ func (ts *configuredTestSuite) TestControllerExpandVolumeError() {
 }
 
 func (ts *configuredTestSuite) TestControllerGetVolume() {
-	_, err := ts.s.ControllerGetVolume(context.Background(), &proto.ControllerGetVolumeRequest{})
-	ts.Require().Error(err)
-	ts.Require().Equal(status.Error(codes.Unimplemented, ""), err)
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset() //nolint: wsl_v5
+
+	tests := []struct {
+		msg           string
+		request       *proto.ControllerGetVolumeRequest
+		expected      *proto.ControllerGetVolumeResponse
+		expectedError error
+	}{
+		{
+			msg:           "EmptyRequest",
+			request:       &proto.ControllerGetVolumeRequest{},
+			expectedError: status.Error(codes.InvalidArgument, "VolumeID must be provided"),
+		},
+		{
+			msg: "WrongCluster",
+			request: &proto.ControllerGetVolumeRequest{
+				VolumeId: "fake-region/node/data/volume-id",
+			},
+			expectedError: status.Error(codes.Internal, "region not found"),
+		},
+		{
+			msg: "HealthyVolume",
+			request: &proto.ControllerGetVolumeRequest{
+				VolumeId: "cluster-1/pve-1/local-lvm/vm-9999-pvc-123",
+			},
+			expected: &proto.ControllerGetVolumeResponse{
+				Volume: &proto.Volume{
+					VolumeId:      "cluster-1/pve-1/local-lvm/vm-9999-pvc-123",
+					CapacityBytes: csi.MinChunkSizeBytes,
+				},
+				Status: &proto.ControllerGetVolumeResponse_VolumeStatus{
+					PublishedNodeIds: []string{"cluster-1-node-1"},
+					VolumeCondition:  &proto.VolumeCondition{Message: "volume is healthy"},
+				},
+			},
+		},
+		{
+			msg: "RemovedZone",
+			request: &proto.ControllerGetVolumeRequest{
+				VolumeId: "cluster-1/pve-removed/local-lvm/vm-9999-pvc-exist",
+			},
+			expected: &proto.ControllerGetVolumeResponse{
+				Volume: &proto.Volume{
+					VolumeId: "cluster-1/pve-removed/local-lvm/vm-9999-pvc-exist",
+				},
+				Status: &proto.ControllerGetVolumeResponse_VolumeStatus{
+					VolumeCondition: &proto.VolumeCondition{Abnormal: true, Message: "zone pve-removed not found in cluster cluster-1"},
+				},
+			},
+		},
+		{
+			msg: "MissingStorage",
+			request: &proto.ControllerGetVolumeRequest{
+				VolumeId: "cluster-1/pve-1/fake-storage/vm-9999-pvc-exist",
+			},
+			expected: &proto.ControllerGetVolumeResponse{
+				Volume: &proto.Volume{
+					VolumeId: "cluster-1/pve-1/fake-storage/vm-9999-pvc-exist",
+				},
+				Status: &proto.ControllerGetVolumeResponse_VolumeStatus{
+					VolumeCondition: &proto.VolumeCondition{Abnormal: true, Message: "storage fake-storage not found on node pve-1"},
+				},
+			},
+		},
+		{
+			msg: "MissingSharedStorage",
+			request: &proto.ControllerGetVolumeRequest{
+				VolumeId: "cluster-1//fake-storage/vm-9999-pvc-exist",
+			},
+			expected: &proto.ControllerGetVolumeResponse{
+				Volume: &proto.Volume{
+					VolumeId: "cluster-1//fake-storage/vm-9999-pvc-exist",
+				},
+				Status: &proto.ControllerGetVolumeResponse_VolumeStatus{
+					VolumeCondition: &proto.VolumeCondition{Abnormal: true, Message: "failed to find zones for storage fake-storage: not found"},
+				},
+			},
+		},
+		{
+			msg: "MissingDisk",
+			request: &proto.ControllerGetVolumeRequest{
+				VolumeId: "cluster-1/pve-1/local-lvm/vm-9999-pvc-none",
+			},
+			expected: &proto.ControllerGetVolumeResponse{
+				Volume: &proto.Volume{
+					VolumeId: "cluster-1/pve-1/local-lvm/vm-9999-pvc-none",
+				},
+				Status: &proto.ControllerGetVolumeResponse_VolumeStatus{
+					VolumeCondition: &proto.VolumeCondition{Abnormal: true, Message: "volume cluster-1/pve-1/local-lvm/vm-9999-pvc-none not found"},
+				},
+			},
+		},
+		{
+			msg: "SizeMismatch",
+			request: &proto.ControllerGetVolumeRequest{
+				VolumeId: "cluster-1/pve-1/local-lvm/vm-9999-pvc-exist",
+			},
+			expected: &proto.ControllerGetVolumeResponse{
+				Volume: &proto.Volume{
+					VolumeId:      "cluster-1/pve-1/local-lvm/vm-9999-pvc-exist",
+					CapacityBytes: 5 * csi.GiB,
+				},
+				Status: &proto.ControllerGetVolumeResponse_VolumeStatus{
+					VolumeCondition: &proto.VolumeCondition{
+						Abnormal: true,
+						Message:  fmt.Sprintf("volume size %d is smaller than the persistent volume capacity %d", 5*csi.GiB, 10*csi.GiB),
+					},
+				},
+			},
+		},
+	}
+
+	for _, testCase := range tests {
+		ts.Run(fmt.Sprint(testCase.msg), func() {
+			resp, err := ts.s.ControllerGetVolume(context.Background(), testCase.request)
+			if testCase.expectedError == nil {
+				ts.Require().NoError(err)
+				ts.Require().Equal(testCase.expected, resp)
+			} else {
+				ts.Require().Error(err)
+				ts.Require().Equal(testCase.expectedError, err)
+			}
+		})
+	}
 }
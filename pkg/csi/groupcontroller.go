@@ -0,0 +1,375 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csi
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/kubernetes-csi/csi-lib-utils/protosanitizer"
+	proxmox "github.com/luthermonson/go-proxmox"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	goproxmox "github.com/sergelogvinov/go-proxmox"
+	"github.com/sergelogvinov/proxmox-csi-plugin/pkg/metrics"
+	volume "github.com/sergelogvinov/proxmox-csi-plugin/pkg/utils/volume"
+
+	"k8s.io/klog/v2"
+)
+
+var groupControllerCaps = []csi.GroupControllerServiceCapability_RPC_Type{
+	csi.GroupControllerServiceCapability_RPC_CREATE_DELETE_GET_VOLUME_GROUP_SNAPSHOT,
+}
+
+// GroupControllerService is the group controller service for the CSI driver
+type GroupControllerService struct {
+	csi.UnimplementedGroupControllerServer
+
+	controller *ControllerService
+}
+
+// NewGroupControllerService returns a new group controller service
+func NewGroupControllerService(controller *ControllerService) *GroupControllerService {
+	return &GroupControllerService{
+		controller: controller,
+	}
+}
+
+// GroupControllerGetCapabilities get group controller capabilities.
+func (d *GroupControllerService) GroupControllerGetCapabilities(_ context.Context, _ *csi.GroupControllerGetCapabilitiesRequest) (*csi.GroupControllerGetCapabilitiesResponse, error) {
+	klog.V(4).InfoS("GroupControllerGetCapabilities: called")
+
+	caps := make([]*csi.GroupControllerServiceCapability, 0, len(groupControllerCaps))
+
+	for _, cap := range groupControllerCaps {
+		c := &csi.GroupControllerServiceCapability{
+			Type: &csi.GroupControllerServiceCapability_Rpc{
+				Rpc: &csi.GroupControllerServiceCapability_RPC{
+					Type: cap,
+				},
+			},
+		}
+		caps = append(caps, c)
+	}
+
+	return &csi.GroupControllerGetCapabilitiesResponse{Capabilities: caps}, nil
+}
+
+// CreateVolumeGroupSnapshot creates native snapshots of all volumes in the group.
+// The virtual machines which use the volumes are paused while the snapshots are taken.
+//
+//nolint:gocyclo,cyclop
+func (d *GroupControllerService) CreateVolumeGroupSnapshot(ctx context.Context, request *csi.CreateVolumeGroupSnapshotRequest) (*csi.CreateVolumeGroupSnapshotResponse, error) {
+	klog.V(4).InfoS("CreateVolumeGroupSnapshot: called", "args", protosanitizer.StripSecrets(request))
+
+	name := request.GetName()
+	if name == "" {
+		return nil, status.Error(codes.InvalidArgument, "Name must be provided")
+	}
+
+	if len(request.GetSourceVolumeIds()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "SourceVolumeIds must be provided")
+	}
+
+	vols := make([]*volume.Volume, 0, len(request.GetSourceVolumeIds()))
+
+	for _, id := range request.GetSourceVolumeIds() {
+		vol, err := volume.NewVolumeFromVolumeID(id)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+
+		if len(vols) > 0 && vol.Cluster() != vols[0].Cluster() {
+			return nil, status.Error(codes.InvalidArgument, "volumes of the group must be in the same region")
+		}
+
+		vols = append(vols, vol)
+	}
+
+	params, err := ExtractSnapshotParameters(request.GetParameters())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if params.BackupStorage != "" {
+		return nil, status.Errorf(codes.InvalidArgument, "parameter %s is not supported for group snapshots", SnapshotBackupStorageKey)
+	}
+
+	region := vols[0].Cluster()
+
+	cl, err := d.controller.pxpool.GetProxmoxCluster(region)
+	if err != nil {
+		klog.ErrorS(err, "CreateVolumeGroupSnapshot: failed to get proxmox cluster", "cluster", region)
+
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	sizes := make([]int64, len(vols))
+
+	for i, vol := range vols {
+		storageConfig, err := cl.Client.ClusterStorage(ctx, vol.Storage())
+		if err != nil {
+			klog.ErrorS(err, "CreateVolumeGroupSnapshot: failed to get proxmox storage config", "cluster", region, "storageID", vol.Storage())
+
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+
+		if !slices.Contains(nativeSnapshotStorages, storageConfig.Type) {
+			return nil, status.Errorf(codes.InvalidArgument, "storage %s of type %s does not support group snapshots", vol.Storage(), storageConfig.Type)
+		}
+
+		if sizes[i], err = d.controller.checkVolume(ctx, vol); err != nil {
+			klog.ErrorS(err, "CreateVolumeGroupSnapshot: failed to check volume", "cluster", region, "volumeID", vol.VolumeID())
+
+			return nil, err
+		}
+
+		if vol.Node() == "" {
+			node, err := getNodeForVolume(ctx, cl, vol)
+			if err != nil {
+				klog.ErrorS(err, "CreateVolumeGroupSnapshot: failed to get node for volume", "cluster", region, "volumeID", vol.VolumeID())
+
+				return nil, status.Error(codes.Internal, fmt.Sprintf("failed to get node for volume: %s, %v", vol.VolumeID(), err))
+			}
+
+			vol.SetNode(node)
+		}
+	}
+
+	sameStorage := !slices.ContainsFunc(vols, func(v *volume.Volume) bool { return v.Storage() != vols[0].Storage() })
+	sameNode := !slices.ContainsFunc(vols, func(v *volume.Volume) bool { return v.Node() != vols[0].Node() })
+
+	if !sameStorage && !sameNode {
+		return nil, status.Error(codes.InvalidArgument, "volumes of the group must be on the same Proxmox node or storage")
+	}
+
+	groupSnapshotID := fmt.Sprintf("%s/%s", region, nativeSnapshotName(name))
+	snapshotIDs := make([]*volume.Volume, len(vols))
+	existing := []*volume.Volume{}
+
+	for i, vol := range vols {
+		if snapshotIDs[i], err = nativeSnapshotID(vol, name); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+
+		snapshots, err := getVolumeSnapshots(ctx, cl, vol)
+		if err != nil {
+			klog.ErrorS(err, "CreateVolumeGroupSnapshot: failed to get volume snapshots", "cluster", region, "volumeID", vol.VolumeID())
+
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+
+		if slices.ContainsFunc(snapshots, func(s *proxmox.Snapshot) bool { return s.Name == snapshotIDs[i].Snapshot() }) {
+			existing = append(existing, snapshotIDs[i])
+		}
+	}
+
+	if len(existing) < len(vols) {
+		features := d.controller.features.RegionFeatures(region)
+
+		// The snapshots of the failed request were taken under another pause, they are taken again with the others
+		if len(existing) > 0 {
+			klog.V(5).InfoS("CreateVolumeGroupSnapshot: deleting partial group snapshot", "cluster", region, "groupSnapshotID", groupSnapshotID, "volumes", len(existing))
+
+			if err = deleteGroupSnapshots(ctx, cl, existing, features.VMTaskTimeout); err != nil {
+				klog.ErrorS(err, "CreateVolumeGroupSnapshot: failed to delete partial group snapshot", "cluster", region, "groupSnapshotID", groupSnapshotID)
+
+				return nil, status.Error(codes.Internal, err.Error())
+			}
+		}
+
+		klog.V(5).InfoS("CreateVolumeGroupSnapshot: creating native snapshots", "cluster", region, "groupSnapshotID", groupSnapshotID, "volumes", len(vols))
+
+		created := []*volume.Volume{}
+
+		err = freezeVolumes(ctx, cl, vols, params, features.TaskTimeout, func(ctx context.Context) error {
+			paused, err := pauseVolumeVMs(ctx, cl, vols, features.TaskTimeout)
+			if err == nil {
+				for _, snap := range snapshotIDs {
+					mc := metrics.NewMetricContext("createSnapshot")
+					if err = createNativeSnapshot(ctx, cl, snap.Node(), snap, features); mc.ObserveRequest(err) != nil {
+						break
+					}
+
+					created = append(created, snap)
+				}
+			}
+
+			// The VMs must be resumed even if the request has been canceled
+			if rerr := resumeVMs(context.WithoutCancel(ctx), paused, features.TaskTimeout); rerr != nil {
+				klog.ErrorS(rerr, "CreateVolumeGroupSnapshot: failed to resume vms", "cluster", region, "groupSnapshotID", groupSnapshotID)
+
+				if err == nil {
+					err = rerr
+				}
+			}
+
+			return err
+		})
+		if err != nil {
+			klog.ErrorS(err, "CreateVolumeGroupSnapshot: failed to create group snapshot", "cluster", region, "groupSnapshotID", groupSnapshotID)
+
+			// The group snapshot must not stay partial, the next request takes all snapshots again
+			if derr := deleteGroupSnapshots(context.WithoutCancel(ctx), cl, created, features.VMTaskTimeout); derr != nil {
+				klog.ErrorS(derr, "CreateVolumeGroupSnapshot: failed to delete partial group snapshot", "cluster", region, "groupSnapshotID", groupSnapshotID)
+			}
+
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+
+	klog.V(3).InfoS("CreateVolumeGroupSnapshot: group snapshot created", "cluster", region, "groupSnapshotID", groupSnapshotID)
+
+	now := timestamppb.New(time.Now())
+	snapshots := make([]*csi.Snapshot, len(vols))
+
+	for i, vol := range vols {
+		snapshots[i] = &csi.Snapshot{
+			SnapshotId:      snapshotIDs[i].VolumeID(),
+			SourceVolumeId:  vol.VolumeID(),
+			SizeBytes:       sizes[i],
+			CreationTime:    now,
+			ReadyToUse:      true,
+			GroupSnapshotId: groupSnapshotID,
+		}
+	}
+
+	return &csi.CreateVolumeGroupSnapshotResponse{
+		GroupSnapshot: &csi.VolumeGroupSnapshot{
+			GroupSnapshotId: groupSnapshotID,
+			Snapshots:       snapshots,
+			CreationTime:    now,
+			ReadyToUse:      true,
+		},
+	}, nil
+}
+
+// DeleteVolumeGroupSnapshot deletes the native snapshots of the group.
+func (d *GroupControllerService) DeleteVolumeGroupSnapshot(ctx context.Context, request *csi.DeleteVolumeGroupSnapshotRequest) (*csi.DeleteVolumeGroupSnapshotResponse, error) {
+	klog.V(4).InfoS("DeleteVolumeGroupSnapshot: called", "args", protosanitizer.StripSecrets(request))
+
+	snaps, err := groupSnapshotMembers(request.GetGroupSnapshotId(), request.GetSnapshotIds())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, snap := range snaps {
+		cl, err := d.controller.pxpool.GetProxmoxCluster(snap.Cluster())
+		if err != nil {
+			klog.ErrorS(err, "DeleteVolumeGroupSnapshot: failed to get proxmox cluster", "cluster", snap.Cluster())
+
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+
+		mc := metrics.NewMetricContext("deleteSnapshot")
+		if err = deleteNativeSnapshot(ctx, cl, snap, d.controller.features.RegionFeatures(snap.Region()).VMTaskTimeout); mc.ObserveRequest(err) != nil {
+			klog.ErrorS(err, "DeleteVolumeGroupSnapshot: failed to delete native snapshot", "cluster", snap.Cluster(), "snapshotID", snap.VolumeID())
+
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+
+	klog.V(3).InfoS("DeleteVolumeGroupSnapshot: group snapshot deleted", "groupSnapshotID", request.GetGroupSnapshotId())
+
+	return &csi.DeleteVolumeGroupSnapshotResponse{}, nil
+}
+
+// GetVolumeGroupSnapshot returns the group snapshot.
+func (d *GroupControllerService) GetVolumeGroupSnapshot(ctx context.Context, request *csi.GetVolumeGroupSnapshotRequest) (*csi.GetVolumeGroupSnapshotResponse, error) {
+	klog.V(4).InfoS("GetVolumeGroupSnapshot: called", "args", protosanitizer.StripSecrets(request))
+
+	snaps, err := groupSnapshotMembers(request.GetGroupSnapshotId(), request.GetSnapshotIds())
+	if err != nil {
+		return nil, err
+	}
+
+	groupSnapshot := &csi.VolumeGroupSnapshot{
+		GroupSnapshotId: request.GetGroupSnapshotId(),
+		ReadyToUse:      true,
+	}
+
+	for _, snap := range snaps {
+		snapshot, err := d.controller.getNativeSnapshot(ctx, snap)
+		if err != nil {
+			klog.ErrorS(err, "GetVolumeGroupSnapshot: failed to get native snapshot", "cluster", snap.Cluster(), "snapshotID", snap.VolumeID())
+
+			return nil, err
+		}
+
+		if snapshot == nil {
+			return nil, status.Errorf(codes.NotFound, "snapshot %s is not found", snap.VolumeID())
+		}
+
+		snapshot.GroupSnapshotId = groupSnapshot.GetGroupSnapshotId()
+
+		if groupSnapshot.GetCreationTime() == nil || snapshot.GetCreationTime().AsTime().Before(groupSnapshot.GetCreationTime().AsTime()) {
+			groupSnapshot.CreationTime = snapshot.GetCreationTime()
+		}
+
+		groupSnapshot.Snapshots = append(groupSnapshot.Snapshots, snapshot)
+	}
+
+	return &csi.GetVolumeGroupSnapshotResponse{GroupSnapshot: groupSnapshot}, nil
+}
+
+// groupSnapshotMembers parses the snapshot IDs of the group snapshot.
+// The group snapshot ID is region/name, the member snapshots have the same region and snapshot name.
+func groupSnapshotMembers(groupSnapshotID string, snapshotIDs []string) ([]*volume.Volume, error) {
+	region, name, ok := strings.Cut(groupSnapshotID, "/")
+	if !ok || region == "" || name == "" {
+		return nil, status.Error(codes.InvalidArgument, "GroupSnapshotId must be in the format of region/name")
+	}
+
+	if len(snapshotIDs) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "SnapshotIds must be provided")
+	}
+
+	snaps := make([]*volume.Volume, 0, len(snapshotIDs))
+
+	for _, id := range snapshotIDs {
+		snap, err := volume.NewVolumeFromVolumeID(id)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+
+		if snap.Cluster() != region || snap.Snapshot() != name {
+			return nil, status.Errorf(codes.InvalidArgument, "snapshot %s does not belong to group snapshot %s", id, groupSnapshotID)
+		}
+
+		snaps = append(snaps, snap)
+	}
+
+	return snaps, nil
+}
+
+// deleteGroupSnapshots deletes the native snapshots of the group members.
+func deleteGroupSnapshots(ctx context.Context, cl *goproxmox.APIClient, snaps []*volume.Volume, timeout time.Duration) error {
+	for _, snap := range snaps {
+		mc := metrics.NewMetricContext("deleteSnapshot")
+		if err := deleteNativeSnapshot(ctx, cl, snap, timeout); mc.ObserveRequest(err) != nil {
+			return fmt.Errorf("failed to delete snapshot %s: %v", snap.VolumeID(), err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,178 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csi_test
+
+import (
+	"context"
+	"fmt"
+
+	proto "github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/jarcoal/httpmock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/sergelogvinov/proxmox-csi-plugin/pkg/csi"
+)
+
+var _ proto.GroupControllerServer = (*csi.GroupControllerService)(nil)
+
+func (ts *configuredTestSuite) TestGroupControllerGetCapabilities() {
+	resp, err := csi.NewGroupControllerService(ts.s).GroupControllerGetCapabilities(context.Background(), &proto.GroupControllerGetCapabilitiesRequest{})
+	ts.Require().NoError(err)
+	ts.Require().NotNil(resp)
+	ts.Require().Len(resp.GetCapabilities(), 1)
+}
+
+func (ts *configuredTestSuite) TestCreateVolumeGroupSnapshot() {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset() //nolint: wsl_v5
+
+	tests := []struct {
+		msg           string
+		request       *proto.CreateVolumeGroupSnapshotRequest
+		expectedError error
+	}{
+		{
+			msg: "EmptyName",
+			request: &proto.CreateVolumeGroupSnapshotRequest{
+				SourceVolumeIds: []string{"cluster-1/pve-1/local-lvm/vm-9999-pvc-123"},
+			},
+			expectedError: status.Error(codes.InvalidArgument, "Name must be provided"),
+		},
+		{
+			msg: "EmptySourceVolumeIds",
+			request: &proto.CreateVolumeGroupSnapshotRequest{
+				Name: "groupsnapshot-123",
+			},
+			expectedError: status.Error(codes.InvalidArgument, "SourceVolumeIds must be provided"),
+		},
+		{
+			msg: "DifferentRegions",
+			request: &proto.CreateVolumeGroupSnapshotRequest{
+				Name:            "groupsnapshot-123",
+				SourceVolumeIds: []string{"cluster-1/pve-1/local-lvm/vm-9999-pvc-123", "cluster-2/pve-1/local-lvm/vm-9999-pvc-456"},
+			},
+			expectedError: status.Error(codes.InvalidArgument, "volumes of the group must be in the same region"),
+		},
+		{
+			msg: "WrongCluster",
+			request: &proto.CreateVolumeGroupSnapshotRequest{
+				Name:            "groupsnapshot-123",
+				SourceVolumeIds: []string{"fake-region/node/data/volume-id"},
+			},
+			expectedError: status.Error(codes.Internal, "region not found"),
+		},
+		{
+			msg: "UnsupportedStorage",
+			request: &proto.CreateVolumeGroupSnapshotRequest{
+				Name:            "groupsnapshot-123",
+				SourceVolumeIds: []string{"cluster-1//rbd/vm-9999-pvc-123"},
+			},
+			expectedError: status.Error(codes.InvalidArgument, "storage rbd of type dir does not support group snapshots"),
+		},
+		{
+			msg: "BackupStorage",
+			request: &proto.CreateVolumeGroupSnapshotRequest{
+				Name:            "groupsnapshot-123",
+				SourceVolumeIds: []string{"cluster-1//rbd/vm-9999-pvc-123"},
+				Parameters:      map[string]string{"backupStorage": "pbs"},
+			},
+			expectedError: status.Error(codes.InvalidArgument, "parameter backupStorage is not supported for group snapshots"),
+		},
+	}
+
+	for _, testCase := range tests {
+		ts.Run(fmt.Sprint(testCase.msg), func() {
+			resp, err := csi.NewGroupControllerService(ts.s).CreateVolumeGroupSnapshot(context.Background(), testCase.request)
+
+			ts.Require().Error(err)
+			ts.Require().Nil(resp)
+			ts.Require().Equal(testCase.expectedError, err)
+		})
+	}
+}
+
+func (ts *configuredTestSuite) TestDeleteVolumeGroupSnapshot() {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset() //nolint: wsl_v5
+
+	tests := []struct {
+		msg           string
+		request       *proto.DeleteVolumeGroupSnapshotRequest
+		expected      *proto.DeleteVolumeGroupSnapshotResponse
+		expectedError error
+	}{
+		{
+			msg: "GroupSnapshotId",
+			request: &proto.DeleteVolumeGroupSnapshotRequest{
+				GroupSnapshotId: "groupsnapshot-123",
+				SnapshotIds:     []string{"cluster-1//rbd/vm-9999-pvc-123@groupsnapshot-123"},
+			},
+			expectedError: status.Error(codes.InvalidArgument, "GroupSnapshotId must be in the format of region/name"),
+		},
+		{
+			msg: "EmptySnapshotIds",
+			request: &proto.DeleteVolumeGroupSnapshotRequest{
+				GroupSnapshotId: "cluster-1/groupsnapshot-123",
+			},
+			expectedError: status.Error(codes.InvalidArgument, "SnapshotIds must be provided"),
+		},
+		{
+			msg: "ForeignSnapshot",
+			request: &proto.DeleteVolumeGroupSnapshotRequest{
+				GroupSnapshotId: "cluster-1/groupsnapshot-123",
+				SnapshotIds:     []string{"cluster-1//rbd/vm-9999-pvc-123@snap"},
+			},
+			expectedError: status.Error(codes.InvalidArgument, "snapshot cluster-1//rbd/vm-9999-pvc-123@snap does not belong to group snapshot cluster-1/groupsnapshot-123"),
+		},
+		{
+			msg: "SnapshotNonExist",
+			request: &proto.DeleteVolumeGroupSnapshotRequest{
+				GroupSnapshotId: "cluster-1/groupsnapshot-123",
+				SnapshotIds:     []string{"cluster-1//rbd/vm-9999-pvc-123@groupsnapshot-123"},
+			},
+			expected: &proto.DeleteVolumeGroupSnapshotResponse{},
+		},
+	}
+
+	for _, testCase := range tests {
+		ts.Run(fmt.Sprint(testCase.msg), func() {
+			resp, err := csi.NewGroupControllerService(ts.s).DeleteVolumeGroupSnapshot(context.Background(), testCase.request)
+
+			if testCase.expectedError == nil {
+				ts.Require().NoError(err)
+				ts.Require().Equal(testCase.expected, resp)
+			} else {
+				ts.Require().Error(err)
+				ts.Require().Equal(testCase.expectedError, err)
+			}
+		})
+	}
+}
+
+func (ts *configuredTestSuite) TestGetVolumeGroupSnapshot() {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset() //nolint: wsl_v5
+
+	resp, err := csi.NewGroupControllerService(ts.s).GetVolumeGroupSnapshot(context.Background(), &proto.GetVolumeGroupSnapshotRequest{
+		GroupSnapshotId: "cluster-1/groupsnapshot-123",
+		SnapshotIds:     []string{"cluster-1//rbd/vm-9999-pvc-123@groupsnapshot-123"},
+	})
+	ts.Require().Error(err)
+	ts.Require().Nil(resp)
+	ts.Require().Equal(status.Error(codes.NotFound, "snapshot cluster-1//rbd/vm-9999-pvc-123@groupsnapshot-123 is not found"), err)
+}
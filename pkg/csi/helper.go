@@ -17,11 +17,13 @@ limitations under the License.
 package csi
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -49,6 +51,9 @@ const (
 
 	// AnnotationProxmoxInstanceID is the annotation used to store the Proxmox node virtual machine ID.
 	AnnotationProxmoxInstanceID = Group + "/instance-id"
+
+	// annotationCSINodeID is the annotation where kubelet stores the node IDs of the CSI drivers.
+	annotationCSINodeID = "csi.volume.kubernetes.io/nodeid"
 )
 
 // VMLocks is a structure that protects to multiple VMs changes.
@@ -110,6 +115,16 @@ func ProxmoxVMIDbyNode(node *corev1.Node) (int, error) {
 	return vmID, err
 }
 
+// csiNodeID returns the node ID registered by the node plugin, the node name is used if the node is not registered yet.
+func csiNodeID(node *corev1.Node) string {
+	ids := map[string]string{}
+	if err := json.Unmarshal([]byte(node.Annotations[annotationCSINodeID]), &ids); err == nil && ids[DriverName] != "" {
+		return ids[DriverName]
+	}
+
+	return node.Name
+}
+
 // GetNodeTopology extracts region and zone from the provided labels map.
 func GetNodeTopology(labels map[string]string) (region, zone string) {
 	region = labels[ProxmoxRegion]
@@ -159,6 +174,21 @@ func locationFromTopologyRequirement(tr *proto.TopologyRequirement) (region, zon
 	return region, ""
 }
 
+// zonesFromTopologyRequirement returns the zones of the region allowed by the requisite topology.
+// The empty list means that any zone of the region is allowed.
+func zonesFromTopologyRequirement(tr *proto.TopologyRequirement, region string) []string {
+	zones := []string{}
+
+	for _, top := range tr.GetRequisite() {
+		tsr, tsz := GetNodeTopology(top.GetSegments())
+		if tsr == region && tsz != "" && !slices.Contains(zones, tsz) {
+			zones = append(zones, tsz)
+		}
+	}
+
+	return zones
+}
+
 func getDevicePath(deviceContext map[string]string) (string, error) {
 	sysPath := "/sys/bus/scsi/devices"
 
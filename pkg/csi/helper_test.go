@@ -17,13 +17,17 @@ limitations under the License.
 package csi
 
 import (
+	"context"
 	"fmt"
 	"testing"
 
 	proto "github.com/container-storage-interface/spec/lib/go/csi"
+	proxmox "github.com/luthermonson/go-proxmox"
 	"github.com/stretchr/testify/assert"
 
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
 )
 
 func TestParseEndpoint(t *testing.T) {
@@ -167,6 +171,24 @@ func TestLocationFromTopologyRequirement(t *testing.T) {
 	}
 }
 
+func TestZonesFromTopologyRequirement(t *testing.T) {
+	t.Parallel()
+
+	topology := &proto.TopologyRequirement{
+		Requisite: []*proto.Topology{
+			{Segments: map[string]string{corev1.LabelTopologyRegion: "region1", corev1.LabelTopologyZone: "zone1"}},
+			{Segments: map[string]string{corev1.LabelTopologyRegion: "region1", corev1.LabelTopologyZone: "zone2"}},
+			{Segments: map[string]string{corev1.LabelTopologyRegion: "region1", corev1.LabelTopologyZone: "zone1"}},
+			{Segments: map[string]string{corev1.LabelTopologyRegion: "region2", corev1.LabelTopologyZone: "zone3"}},
+			{Segments: map[string]string{corev1.LabelTopologyRegion: "region1"}},
+		},
+	}
+
+	assert.Equal(t, []string{"zone1", "zone2"}, zonesFromTopologyRequirement(topology, "region1"))
+	assert.Equal(t, []string{}, zonesFromTopologyRequirement(&proto.TopologyRequirement{}, "region1"))
+	assert.Equal(t, []string{}, zonesFromTopologyRequirement(nil, "region1"))
+}
+
 func TestRoundUpSizeBytes(t *testing.T) {
 	t.Parallel()
 
@@ -225,3 +247,40 @@ func TestRoundUpSizeBytes(t *testing.T) {
 		})
 	}
 }
+
+func TestGetNodeIDs(t *testing.T) {
+	t.Parallel()
+
+	node := func(name, providerID string, labels, annotations map[string]string) *corev1.Node {
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels, Annotations: annotations},
+			Spec:       corev1.NodeSpec{ProviderID: providerID},
+		}
+	}
+
+	d := &ControllerService{
+		kclient: fake.NewClientset(
+			node("worker-1", "proxmox://cluster-1/100", nil, nil),
+			node("worker-2", "proxmox://cluster-1/101", nil, map[string]string{
+				annotationCSINodeID: `{"` + DriverName + `":"worker-2/101"}`,
+			}),
+			node("worker-3", "proxmox://cluster-2/100", nil, nil),
+			node("worker-4", "", map[string]string{corev1.LabelTopologyRegion: "cluster-1"}, nil),
+			node("worker-5", "", map[string]string{corev1.LabelTopologyRegion: "cluster-1"}, nil),
+		),
+	}
+
+	vms := proxmox.ClusterResources{
+		{Type: "qemu", VMID: 100, Name: "vm-1"},
+		{Type: "qemu", VMID: 101, Name: "vm-2"},
+		{Type: "qemu", VMID: 102, Name: "worker-4"},
+	}
+
+	ids, err := d.getNodeIDs(context.Background(), "cluster-1", vms)
+	assert.NoError(t, err)
+	assert.Equal(t, map[uint64]string{
+		100: "worker-1",
+		101: "worker-2/101",
+		102: "worker-4",
+	}, ids)
+}
@@ -69,6 +69,7 @@ func TestGetPluginCapabilities(t *testing.T) {
 		if capability.GetService() != nil {
 			switch capability.GetService().GetType() { //nolint:exhaustive
 			case proto.PluginCapability_Service_CONTROLLER_SERVICE:
+			case proto.PluginCapability_Service_GROUP_CONTROLLER_SERVICE:
 			case proto.PluginCapability_Service_VOLUME_ACCESSIBILITY_CONSTRAINTS:
 			default:
 				t.Fatalf("Unknown capability: %v", capability.GetService().GetType())
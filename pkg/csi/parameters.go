@@ -19,6 +19,7 @@ package csi
 import (
 	"fmt"
 	"reflect"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -45,6 +46,39 @@ const (
 
 	// StorageInodeSizeKey the inode size when formatting a volume
 	StorageInodeSizeKey = "inodeSize"
+
+	// ZoneStrategyKey is the strategy to choose the Proxmox node when the topology requirement has no zone,
+	// can be one of "most-free", "round-robin", "random"
+	ZoneStrategyKey = "zoneStrategy"
+
+	// ZoneStrategyMostFree chooses the node with the most available capacity of the storage
+	ZoneStrategyMostFree = "most-free"
+	// ZoneStrategyRoundRobin chooses the nodes in turn
+	ZoneStrategyRoundRobin = "round-robin"
+	// ZoneStrategyRandom chooses a random node
+	ZoneStrategyRandom = "random"
+
+	// PlacementGroupKey is the name of the placement group, the volumes of the group are created on different Proxmox nodes
+	PlacementGroupKey = "placementGroup"
+	// PlacementGroupLabelKey is the label of the PersistentVolumeClaim, its value is the name of the placement group
+	PlacementGroupLabelKey = "placementGroupLabel"
+
+	// PVCNameKey is the name of the PersistentVolumeClaim, it is passed by the csi-provisioner with --extra-create-metadata
+	PVCNameKey = "csi.storage.k8s.io/pvc/name"
+	// PVCNamespaceKey is the namespace of the PersistentVolumeClaim, it is passed by the csi-provisioner with --extra-create-metadata
+	PVCNamespaceKey = "csi.storage.k8s.io/pvc/namespace"
+
+	// SnapshotFreezeKey freezes the guest filesystems through the QEMU guest agent while the snapshot is taken
+	SnapshotFreezeKey = "freeze"
+	// SnapshotFreezeTimeoutKey is the maximum time in seconds to freeze the guest filesystems
+	SnapshotFreezeTimeoutKey = "freezeTimeout"
+	// SnapshotBackupStorageKey is the Proxmox Backup Server storage ID to archive the snapshots
+	SnapshotBackupStorageKey = "backupStorage"
+
+	// defaultFreezeTimeout is the default freeze timeout in seconds
+	defaultFreezeTimeout = 60
+	// maxFreezeTimeout is the maximum freeze timeout in seconds
+	maxFreezeTimeout = 600
 )
 
 // StorageParameters contains storage parameters
@@ -75,6 +109,10 @@ type StorageParameters struct {
 	ReplicateSchedule string `json:"replicateSchedule,omitempty"`
 	ReplicateZones    string `json:"replicateZones,omitempty"`
 
+	ZoneStrategy        string `json:"zoneStrategy,omitempty"`
+	PlacementGroup      string `json:"placementGroup,omitempty"`
+	PlacementGroupLabel string `json:"placementGroupLabel,omitempty"`
+
 	ResizeRequired  *bool `json:"resizeRequired,omitempty"`
 	ResizeSizeBytes int64 `json:"resizeSizeBytes,omitempty"`
 }
@@ -95,6 +133,14 @@ type ModifyVolumeParameters struct {
 	ReplicateSchedule string `json:"replicateSchedule,omitempty"`
 }
 
+// SnapshotParameters contains volume snapshot class parameters
+type SnapshotParameters struct {
+	Zone          string `json:"zone,omitempty"`
+	Freeze        bool   `json:"freeze,omitempty"`
+	FreezeTimeout int    `json:"freezeTimeout,omitempty"`
+	BackupStorage string `json:"backupStorage,omitempty"`
+}
+
 // ExtractParameters extracts storage parameters from a map and sets default values.
 func ExtractParameters(parameters map[string]string) (StorageParameters, error) {
 	p := StorageParameters{
@@ -108,6 +154,12 @@ func ExtractParameters(parameters map[string]string) (StorageParameters, error)
 		return p, err
 	}
 
+	switch p.ZoneStrategy {
+	case "", ZoneStrategyMostFree, ZoneStrategyRoundRobin, ZoneStrategyRandom:
+	default:
+		return p, fmt.Errorf("parameters %s must be one of: %s, %s, %s", ZoneStrategyKey, ZoneStrategyMostFree, ZoneStrategyRoundRobin, ZoneStrategyRandom)
+	}
+
 	if p.SSD != nil && *p.SSD {
 		p.Discard = "on"
 	}
@@ -125,6 +177,19 @@ func ExtractParameters(parameters map[string]string) (StorageParameters, error)
 	return p, nil
 }
 
+// StorageIDs returns the storages in the priority order, the storage parameter can list several storages separated by commas.
+func (p StorageParameters) StorageIDs() []string {
+	storages := []string{}
+
+	for storage := range strings.SplitSeq(p.StorageID, ",") {
+		if storage = strings.TrimSpace(storage); storage != "" && !slices.Contains(storages, storage) {
+			storages = append(storages, storage)
+		}
+	}
+
+	return storages
+}
+
 // ToMap converts storage parameters to kubernetes map of string.
 func (p StorageParameters) ToMap() map[string]string {
 	m := make(map[string]string)
@@ -163,6 +228,24 @@ func ExtractModifyVolumeParameters(parameters map[string]string) (ModifyVolumePa
 	return p, nil
 }
 
+// ExtractSnapshotParameters extracts snapshot parameters from a map and sets default values.
+func ExtractSnapshotParameters(parameters map[string]string) (SnapshotParameters, error) {
+	p := SnapshotParameters{
+		FreezeTimeout: defaultFreezeTimeout,
+	}
+
+	err := unmarshalTag(parameters, &p, "json")
+	if err != nil {
+		return p, err
+	}
+
+	if p.FreezeTimeout <= 0 || p.FreezeTimeout > maxFreezeTimeout {
+		return p, fmt.Errorf("parameters %s must be between 1 and %d seconds", SnapshotFreezeTimeoutKey, maxFreezeTimeout)
+	}
+
+	return p, nil
+}
+
 // MergeMap converts ModifyVolumeParameters to a map of string and merge it with the provided map.
 func (p ModifyVolumeParameters) MergeMap(orig map[string]string) map[string]string {
 	m := map[string]string{}
@@ -114,6 +114,19 @@ func Test_ExtractAndDefaultParameters(t *testing.T) {
 				ReplicateZones: "zone1,zone2",
 			},
 		},
+		{
+			msg: "zone strategy",
+			params: map[string]string{
+				csi.StorageIDKey:    "local-lvm",
+				csi.ZoneStrategyKey: csi.ZoneStrategyRoundRobin,
+			},
+			storage: csi.StorageParameters{
+				StorageID:    "local-lvm",
+				Backup:       ptr.Ptr(false),
+				IOThread:     true,
+				ZoneStrategy: csi.ZoneStrategyRoundRobin,
+			},
+		},
 	}
 
 	for _, testCase := range tests {
@@ -128,6 +141,14 @@ func Test_ExtractAndDefaultParameters(t *testing.T) {
 	}
 }
 
+func Test_StorageIDs(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, []string{"local-lvm"}, csi.StorageParameters{StorageID: "local-lvm"}.StorageIDs())
+	assert.Equal(t, []string{"nvme-zfs", "sata-lvm"}, csi.StorageParameters{StorageID: "nvme-zfs, sata-lvm,,nvme-zfs"}.StorageIDs())
+	assert.Equal(t, []string{}, csi.StorageParameters{StorageID: ","}.StorageIDs())
+}
+
 func Test_ToMap(t *testing.T) {
 	t.Parallel()
 
@@ -256,6 +277,79 @@ func Test_ExtractModifyVolumeParameters(t *testing.T) {
 	}
 }
 
+func Test_ExtractSnapshotParameters(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		msg      string
+		params   map[string]string
+		snapshot csi.SnapshotParameters
+		err      string
+	}{
+		{
+			msg:    "Empty params",
+			params: map[string]string{},
+			snapshot: csi.SnapshotParameters{
+				FreezeTimeout: 60,
+			},
+		},
+		{
+			msg: "Freeze",
+			params: map[string]string{
+				"zone":          "pve-1",
+				"freeze":        "true",
+				"freezeTimeout": "10",
+			},
+			snapshot: csi.SnapshotParameters{
+				Zone:          "pve-1",
+				Freeze:        true,
+				FreezeTimeout: 10,
+			},
+		},
+		{
+			msg: "Backup storage",
+			params: map[string]string{
+				"backupStorage": "pbs",
+			},
+			snapshot: csi.SnapshotParameters{
+				FreezeTimeout: 60,
+				BackupStorage: "pbs",
+			},
+		},
+		{
+			msg: "Freeze timeout is too long",
+			params: map[string]string{
+				"freeze":        "true",
+				"freezeTimeout": "3600",
+			},
+			err: "parameters freezeTimeout must be between 1 and 600 seconds",
+		},
+		{
+			msg: "Freeze timeout is not a number",
+			params: map[string]string{
+				"freezeTimeout": "1m",
+			},
+			err: "parameters freezeTimeout must be a number",
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(fmt.Sprint(testCase.msg), func(t *testing.T) {
+			t.Parallel()
+
+			snapshot, err := csi.ExtractSnapshotParameters(testCase.params)
+			if testCase.err != "" {
+				assert.EqualError(t, err, testCase.err)
+
+				return
+			}
+
+			assert.Nil(t, err)
+			assert.Equal(t, testCase.snapshot, snapshot)
+		})
+	}
+}
+
 func Test_MergeMap(t *testing.T) {
 	t.Parallel()
 
@@ -18,9 +18,15 @@ package csi
 
 import (
 	"context"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"maps"
+	"net"
+	"net/url"
+	"path"
+	"regexp"
 	"slices"
 	"strconv"
 	"strings"
@@ -30,18 +36,46 @@ import (
 	"github.com/siderolabs/go-retry/retry"
 
 	goproxmox "github.com/sergelogvinov/go-proxmox"
+	csiconfig "github.com/sergelogvinov/proxmox-csi-plugin/pkg/config"
 	"github.com/sergelogvinov/proxmox-csi-plugin/pkg/metrics"
+	pxpool "github.com/sergelogvinov/proxmox-csi-plugin/pkg/proxmoxpool"
 	volume "github.com/sergelogvinov/proxmox-csi-plugin/pkg/utils/volume"
+
+	"k8s.io/klog/v2"
 )
 
+// nativeSnapshotStorages are the storage types which support native snapshots of the disks.
+var nativeSnapshotStorages = []string{"rbd", "zfspool", "lvmthin"}
+
+// nativeCloneStorages are the storage types which can clone a new disk from a native snapshot.
+// Proxmox does not clone or copy ZFS snapshots, they can only be rolled back.
+var nativeCloneStorages = []string{"rbd", "lvmthin"}
+
+// nativeSnapshotNameRe is the Proxmox snapshot name format.
+var nativeSnapshotNameRe = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_-]{1,39}$`)
+
+// legacySnapshotDiskRe matches the snapshot disks created before the source was recorded in the name,
+// vm-<vmID>-<name>, the external-snapshotter names the snapshots snapshot-<uid>.
+var legacySnapshotDiskRe = regexp.MustCompile(`^vm-[0-9]+-snapshot-[0-9a-f-]+$`)
+
 const (
 	// TaskStatusCheckInterval is the interval in seconds to check the status of a task
 	TaskStatusCheckInterval = 5
-	// TaskTimeout is the timeout in seconds for all task
-	TaskTimeout = 30
 
 	// ErrorNotFound not found error message
 	ErrorNotFound string = "not found"
+
+	// volumeOwnerTag is the tag of the virtual machines which own the volumes restored from native snapshots
+	volumeOwnerTag = "csi-volume"
+
+	// snapshotSourceSeparator separates the snapshot name and its source in the snapshot disk name.
+	// Kubernetes object names and Proxmox node names cannot contain it.
+	snapshotSourceSeparator = "_"
+
+	// backupSnapshotPrefix is the disk prefix of the snapshots archived to Proxmox Backup Server, backup/vm/<vmid>/<time>
+	backupSnapshotPrefix = "backup/"
+	// backupNotesPrefix starts the notes of the snapshot backups, which record the snapshot name and the source volume
+	backupNotesPrefix = "csi-snapshot"
 )
 
 // nolint:unused
@@ -50,7 +84,7 @@ func getNodeForVolume(ctx context.Context, cl *goproxmox.APIClient, vol *volume.
 	if node == "" {
 		nodes, err := cl.GetNodesForStorage(ctx, vol.Storage())
 		if err != nil {
-			return "", fmt.Errorf("failed to find zones for storage %s: %v", vol.Storage(), err)
+			return "", fmt.Errorf("failed to find zones for storage %s: %w", vol.Storage(), err)
 		}
 
 		if len(nodes) == 0 {
@@ -63,6 +97,13 @@ func getNodeForVolume(ctx context.Context, cl *goproxmox.APIClient, vol *volume.
 	return
 }
 
+// isTransportError reports whether the Proxmox API request has failed without the response, e.g. the connection is refused.
+func isTransportError(err error) bool {
+	var netErr net.Error
+
+	return errors.As(err, &netErr) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
 func getVMByAttachedVolume(ctx context.Context, cl *goproxmox.APIClient, vol *volume.Volume) (int, int, error) {
 	var err error
 
@@ -94,6 +135,11 @@ func getVMByAttachedVolume(ctx context.Context, cl *goproxmox.APIClient, vol *vo
 			return false, nil
 		}
 
+		// Skip the VM which holds the native snapshots of the volume
+		if isVolumeHolder(rs, vol) {
+			return false, nil
+		}
+
 		if !slices.Contains(nodes, rs.Node) {
 			return false, nil
 		}
@@ -176,6 +222,293 @@ func getVolumeSize(ctx context.Context, cl *goproxmox.APIClient, vol *volume.Vol
 	return int64(st.Size), nil
 }
 
+// ResizeVolume resizes the volume to the size in bytes.
+// The attached volume is resized in the workload VM, the detached volume is resized by resizeDetachedVolume.
+func ResizeVolume(ctx context.Context, cl *goproxmox.APIClient, vol *volume.Volume, size int64, features csiconfig.ClustersFeatures) error {
+	diskSize := fmt.Sprintf("%dM", size/MiB)
+
+	id, lun, err := getVMByAttachedVolume(ctx, cl, vol)
+	if err != nil && !errors.Is(err, goproxmox.ErrVirtualMachineNotFound) {
+		return fmt.Errorf("failed to get vm by attached volume: %v", err)
+	}
+
+	if id == 0 {
+		return resizeDetachedVolume(ctx, cl, vol, diskSize, features)
+	}
+
+	if err = cl.ResizeVMDisk(ctx, id, vol.Node(), deviceNamePrefix+strconv.Itoa(lun), diskSize); err != nil {
+		return fmt.Errorf("failed to resize disk in vm %d: %v", id, err)
+	}
+
+	return nil
+}
+
+// resizeDetachedVolume resizes the volume which is not attached to a workload VM.
+// Proxmox resizes disks only through the VM config, so the disk is resized in the VM which holds the volume,
+// or it is attached to a temporary VM named after the PV, which is deleted after the resize.
+func resizeDetachedVolume(ctx context.Context, cl *goproxmox.APIClient, vol *volume.Volume, size string, features csiconfig.ClustersFeatures) error {
+	vm, err := getSnapshotVM(ctx, cl, vol)
+	if err != nil && !errors.Is(err, goproxmox.ErrVirtualMachineNotFound) {
+		return err
+	}
+
+	if vm == nil {
+		// The restored and copied volumes are the disks of the owner VM, they are resized only in that VM
+		if vol.VMID() != strconv.Itoa(features.ControllerVMID) {
+			return fmt.Errorf("owner vm of volume %s not found", vol.VolumeID())
+		}
+
+		if vol.PV() == "" {
+			return fmt.Errorf("cannot resize unpublished volume %s, the disk name has no PV name", vol.Disk())
+		}
+
+		node, err := getNodeForVolume(ctx, cl, vol)
+		if err != nil {
+			return err
+		}
+
+		id, err := prepareReplication(ctx, cl, node, vol.PV(), features)
+		if err != nil {
+			return fmt.Errorf("failed to create resize vm: %v", err)
+		}
+
+		// The temporary VM is deleted even if the request is canceled,
+		// the disk is detached first, otherwise Proxmox deletes it with the VM.
+		defer func() {
+			ctx := context.WithoutCancel(ctx)
+
+			if err := detachVolume(ctx, cl, id, vol, features.VMTaskTimeout); err != nil {
+				klog.ErrorS(err, "Failed to detach volume from resize vm", "volumeID", vol.VolumeID(), "vmID", id)
+
+				return
+			}
+
+			if err := cl.DeleteVMByID(ctx, node, id); err != nil {
+				klog.ErrorS(err, "Failed to delete resize vm", "volumeID", vol.VolumeID(), "vmID", id)
+			}
+		}()
+
+		if vm, err = cl.GetVMConfig(ctx, id); err != nil {
+			return fmt.Errorf("failed to get vm config: %v", err)
+		}
+	}
+
+	device, err := attachVolume(ctx, cl, int(vm.VMID), vol, map[string]string{"backup": "0"}, features)
+	if err != nil {
+		return fmt.Errorf("failed to attach volume to resize vm: %v", err)
+	}
+
+	if err = cl.ResizeVMDisk(ctx, int(vm.VMID), vm.Node, deviceNamePrefix+device["lun"], size); err != nil {
+		return fmt.Errorf("failed to resize vm disk: %v", err)
+	}
+
+	return nil
+}
+
+// storageVolume is a disk found in the Proxmox storage content.
+type storageVolume struct {
+	vol     *volume.Volume
+	content *proxmox.StorageContent
+	shared  bool
+}
+
+// volumeID returns the CSI volume ID of the disk.
+func (v storageVolume) volumeID() string {
+	if v.shared {
+		return v.vol.VolumeSharedID()
+	}
+
+	return v.vol.VolumeID()
+}
+
+// listStorageVolumes returns the disks of all image storages in the cluster accepted by the filter.
+// Local storages are listed on every node, shared storages only once with an empty zone.
+func listStorageVolumes(ctx context.Context, cl *goproxmox.APIClient, region string, filter func(vol *volume.Volume) bool) ([]storageVolume, error) {
+	storages, err := cl.Client.ClusterStorages(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list storages: %v", err)
+	}
+
+	slices.SortFunc(storages, func(a, b *proxmox.ClusterStorage) int {
+		return strings.Compare(a.Storage, b.Storage)
+	})
+
+	vols := []storageVolume{}
+
+	for _, storage := range storages {
+		if !slices.Contains(strings.Split(storage.Content, ","), "images") {
+			continue
+		}
+
+		switch storage.Type {
+		case "cifs", "pbs": // nolint: goconst
+			continue
+		}
+
+		nodes, err := cl.GetNodesForStorage(ctx, storage.Storage)
+		if err != nil {
+			if err.Error() == ErrorNotFound {
+				continue
+			}
+
+			return nil, fmt.Errorf("failed to find zones for storage %s: %v", storage.Storage, err)
+		}
+
+		slices.Sort(nodes)
+
+		for _, node := range nodes {
+			contents, err := cl.GetStorageContent(ctx, node, storage.Storage)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get content of storage %s on node %s: %v", storage.Storage, node, err)
+			}
+
+			for _, content := range contents {
+				disk := strings.TrimPrefix(content.Volid, storage.Storage+":")
+
+				vol := volume.NewVolume(region, node, storage.Storage, disk)
+				if storage.Shared == 1 {
+					vol = volume.NewVolume(region, "", storage.Storage, disk)
+				}
+
+				if filter(vol) {
+					vols = append(vols, storageVolume{vol: vol, content: content, shared: storage.Shared == 1})
+				}
+			}
+
+			if storage.Shared == 1 {
+				break
+			}
+		}
+	}
+
+	return vols, nil
+}
+
+// getClusterVMs returns all virtual machines in the cluster.
+func getClusterVMs(ctx context.Context, cl *goproxmox.APIClient) (proxmox.ClusterResources, error) {
+	cluster, err := cl.Client.Cluster(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cluster info: %v", err)
+	}
+
+	vms, err := cluster.Resources(ctx, "vm")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get list of VMs: %v", err)
+	}
+
+	return vms, nil
+}
+
+// getVolumeAttachments returns the CSI node IDs of the virtual machines which have the disks attached, keyed by Proxmox VolID.
+// The nodeIDs are the CSI node IDs keyed by the VM ID, the VMs which are not kubernetes nodes are skipped.
+func getVolumeAttachments(ctx context.Context, cl *goproxmox.APIClient, vms proxmox.ClusterResources, nodeIDs map[uint64]string, skip func(rs *proxmox.ClusterResource) bool) (map[string][]string, error) {
+	attachments := map[string][]string{}
+
+	for _, rs := range vms {
+		nodeID, ok := nodeIDs[rs.VMID]
+		if rs.Type != "qemu" || rs.Template == 1 || !ok || skip(rs) {
+			continue
+		}
+
+		vm, err := cl.GetVMConfig(ctx, int(rs.VMID))
+		if err != nil {
+			if errors.Is(err, goproxmox.ErrVirtualMachineNotFound) {
+				continue
+			}
+
+			return nil, fmt.Errorf("failed to get vm config: %v", err)
+		}
+
+		for _, disk := range vm.VirtualMachineConfig.MergeSCSIs() {
+			volID := strings.Split(disk, ",")[0]
+
+			storage, disk, _ := strings.Cut(volID, ":")
+			if isVolumeHolder(rs, volume.NewVolume("", "", storage, disk)) {
+				continue
+			}
+
+			attachments[volID] = append(attachments[volID], nodeID)
+		}
+	}
+
+	return attachments, nil
+}
+
+// parseStartingToken returns the list offset encoded in the pagination token.
+func parseStartingToken(token string) (int, error) {
+	if token == "" {
+		return 0, nil
+	}
+
+	start, err := strconv.Atoi(token)
+	if err != nil || start < 0 {
+		return 0, fmt.Errorf("invalid starting token %s", token)
+	}
+
+	return start, nil
+}
+
+// paginate returns the end of the page and the next token for a list of total entries.
+func paginate(total, start, maxEntries int) (int, string) {
+	if maxEntries > 0 && start+maxEntries < total {
+		return start + maxEntries, strconv.Itoa(start + maxEntries)
+	}
+
+	return total, ""
+}
+
+// snapshotDiskName returns the disk name of the snapshot, vm-<vmID>-<name>_[<zone>_]<source disk>.
+// The source zone is recorded only if the snapshot is created in another zone.
+func snapshotDiskName(vmID int, name string, src *volume.Volume, zone string) string {
+	parts := []string{fmt.Sprintf("vm-%d-%s", vmID, name)}
+	if zone != "" && zone != src.Zone() {
+		parts = append(parts, src.Zone())
+	}
+
+	disk := src.Disk()
+	if i := strings.Index(disk, "/"); i >= 0 {
+		disk = disk[i+1:]
+		disk = strings.TrimSuffix(disk, path.Ext(disk))
+	}
+
+	return strings.Join(append(parts, disk), snapshotSourceSeparator)
+}
+
+// snapshotSourceVolume returns the source volume recorded in the snapshot disk name,
+// ok is false if the disk is not a snapshot. The source of the legacy snapshot disks is unknown, it is nil.
+func snapshotSourceVolume(snap *volume.Volume) (src *volume.Volume, ok bool) {
+	disk, format := snap.Disk(), ""
+	if i := strings.Index(disk, "/"); i >= 0 {
+		disk = disk[i+1:]
+		format = strings.TrimPrefix(path.Ext(disk), ".")
+		disk = strings.TrimSuffix(disk, path.Ext(disk))
+	}
+
+	zone := snap.Zone()
+
+	parts := strings.Split(disk, snapshotSourceSeparator)
+	switch len(parts) {
+	case 1:
+		return nil, legacySnapshotDiskRe.MatchString(disk)
+	case 2:
+	case 3:
+		zone = parts[1]
+	default:
+		return nil, false
+	}
+
+	if parts[len(parts)-1] == "" {
+		return nil, false
+	}
+
+	return volume.NewVolume(snap.Region(), zone, snap.Storage(), parts[len(parts)-1], format), true
+}
+
+// legacySnapshotDiskName returns the disk name of the snapshot created before the source was recorded in the name.
+func legacySnapshotDiskName(vmID int, name string) string {
+	return fmt.Sprintf("vm-%d-%s", vmID, name)
+}
+
 func isVolumeAttached(vm *proxmox.VirtualMachineConfig, pvc string) (int, bool) {
 	if pvc == "" {
 		return 0, false
@@ -196,12 +529,12 @@ func isVolumeAttached(vm *proxmox.VirtualMachineConfig, pvc string) (int, bool)
 	return 0, false
 }
 
-func prepareReplication(ctx context.Context, cl *goproxmox.APIClient, node string, name string, vmID int) (int, error) {
+func prepareReplication(ctx context.Context, cl *goproxmox.APIClient, node string, name string, features csiconfig.ClustersFeatures) (int, error) {
 	vmr, err := cl.GetVMByFilter(ctx, func(r *proxmox.ClusterResource) (bool, error) {
 		return r.Name == name, nil
 	})
 	if err != nil || vmr.VMID == 0 {
-		id, err := cl.GetNextID(ctx, vmID+1)
+		id, err := cl.GetNextID(ctx, features.ControllerVMID+1)
 		if err != nil {
 			return 0, err
 		}
@@ -210,6 +543,10 @@ func prepareReplication(ctx context.Context, cl *goproxmox.APIClient, node strin
 		vm["name"] = name
 		vm["vmid"] = id
 
+		if len(features.Tags) > 0 {
+			vm["tags"] = strings.Join(features.Tags, ";")
+		}
+
 		mc := metrics.NewMetricContext("createVm")
 		if err = cl.CreateVM(ctx, node, vm); mc.ObserveRequest(err) != nil {
 			return 0, err
@@ -221,12 +558,12 @@ func prepareReplication(ctx context.Context, cl *goproxmox.APIClient, node strin
 	return int(vmr.VMID), nil
 }
 
-func createReplication(ctx context.Context, cl *goproxmox.APIClient, id int, vol *volume.Volume, params StorageParameters) error {
+func createReplication(ctx context.Context, cl *goproxmox.APIClient, id int, vol *volume.Volume, params StorageParameters, features csiconfig.ClustersFeatures) error {
 	cfg := map[string]string{
 		"replicate": "1",
 		"backup":    "1",
 	}
-	if _, err := attachVolume(ctx, cl, id, vol, cfg); err != nil {
+	if _, err := attachVolume(ctx, cl, id, vol, cfg, features); err != nil {
 		return err
 	}
 
@@ -257,13 +594,13 @@ func createReplication(ctx context.Context, cl *goproxmox.APIClient, id int, vol
 	return nil
 }
 
-func migrateReplication(ctx context.Context, cl *goproxmox.APIClient, target int, vol *volume.Volume, vmID int) error {
+func migrateReplication(ctx context.Context, cl *goproxmox.APIClient, target int, vol *volume.Volume, features csiconfig.ClustersFeatures) error {
 	volid, err := strconv.Atoi(vol.VMID())
 	if err != nil {
 		return fmt.Errorf("failed to parse volumeID %s: %v", vol.VolumeID(), err)
 	}
 
-	if volid == vmID {
+	if volid == features.ControllerVMID {
 		return nil
 	}
 
@@ -302,7 +639,7 @@ func migrateReplication(ctx context.Context, cl *goproxmox.APIClient, target int
 	}
 
 	if task != nil {
-		if err = task.WaitFor(ctx, 5*60); err != nil {
+		if err = task.WaitFor(ctx, int(features.VMTaskTimeout.Seconds())); err != nil {
 			return fmt.Errorf("unable to migrate virtual machine: %w", err)
 		}
 
@@ -322,7 +659,7 @@ func deleteReplication(ctx context.Context, cl *goproxmox.APIClient, vol *volume
 
 	if id != vmID {
 		vmr, err := cl.GetVMByFilter(ctx, func(r *proxmox.ClusterResource) (bool, error) {
-			return r.VMID == uint64(id) && r.Name == vol.PV(), nil
+			return r.VMID == uint64(id) && (r.Name == vol.PV() || hasTag(r.Tags, volumeOwnerTag)), nil
 		})
 		if err != nil {
 			return err
@@ -356,57 +693,1132 @@ func deleteReplication(ctx context.Context, cl *goproxmox.APIClient, vol *volume
 	return nil
 }
 
-func createVolume(ctx context.Context, cl *goproxmox.APIClient, vol *volume.Volume, sizeBytes int64) error {
-	if vol.Node() == "" {
-		return errors.New("node is required")
+// hasTag returns true if the Proxmox tag list contains the tag.
+func hasTag(tags string, tag string) bool {
+	return slices.Contains(strings.FieldsFunc(tags, func(r rune) bool {
+		return r == ';' || r == ',' || r == ' '
+	}), tag)
+}
+
+// isVolumeHolder returns true if the virtual machine exists only to hold the volume:
+// the replication and snapshot VMs named after the PV, and the VMs which own restored volumes.
+func isVolumeHolder(rs *proxmox.ClusterResource, vol *volume.Volume) bool {
+	if rs.Name != "" && rs.Name == vol.PV() {
+		return true
 	}
 
-	filename := strings.Split(vol.Disk(), "/")
+	return isVolumeOwner(rs, vol)
+}
 
-	id, err := strconv.Atoi(vol.VMID())
+// isVolumeOwner returns true if the virtual machine owns the volume restored from a snapshot or copied to another storage.
+// The disks of these VMs are named vm-<vmID>-disk-<n>, the VM is named after the PV.
+func isVolumeOwner(rs *proxmox.ClusterResource, vol *volume.Volume) bool {
+	return hasTag(rs.Tags, volumeOwnerTag) && vol.VMID() == strconv.FormatUint(rs.VMID, 10)
+}
+
+// volumePV returns the PV name of the volume, the volumes of the owner VMs are mapped to the PV by the VM name.
+func volumePV(vms proxmox.ClusterResources, vol *volume.Volume) string {
+	for _, rs := range vms {
+		if rs.Type == "qemu" && rs.Name != "" && isVolumeOwner(rs, vol) {
+			return rs.Name
+		}
+	}
+
+	return vol.PV()
+}
+
+// nativeSnapshotName returns the Proxmox snapshot name for the CSI snapshot name.
+// Proxmox limits snapshot names to 40 characters, so longer names are hashed.
+func nativeSnapshotName(name string) string {
+	if nativeSnapshotNameRe.MatchString(name) {
+		return name
+	}
+
+	sum := sha256.Sum256([]byte(name))
+
+	return "csi-" + hex.EncodeToString(sum[:16])
+}
+
+// getSnapshotVM returns the virtual machine which holds the native snapshots of the volume.
+func getSnapshotVM(ctx context.Context, cl *goproxmox.APIClient, vol *volume.Volume) (*proxmox.VirtualMachine, error) {
+	vmr, err := cl.GetVMByFilter(ctx, func(rs *proxmox.ClusterResource) (bool, error) {
+		return rs.Type == "qemu" && isVolumeHolder(rs, vol), nil
+	})
 	if err != nil {
-		return fmt.Errorf("failed to parse volume vm id: %v", err)
+		return nil, err
 	}
 
-	disk, err := cl.CreateVMDisk(ctx, id, vol.Node(), vol.Storage(), filename[len(filename)-1], sizeBytes)
+	if vmr == nil || vmr.VMID == 0 {
+		return nil, goproxmox.ErrVirtualMachineNotFound
+	}
+
+	return cl.GetVMConfig(ctx, int(vmr.VMID))
+}
+
+// prepareSnapshotVM returns the virtual machine which holds the native snapshots of the volume.
+// Volumes owned by the controller are attached to a stopped VM named after the PV, it is created if it does not exist.
+func prepareSnapshotVM(ctx context.Context, cl *goproxmox.APIClient, node string, vol *volume.Volume, features csiconfig.ClustersFeatures) (*proxmox.VirtualMachine, error) {
+	vm, err := getSnapshotVM(ctx, cl, vol)
 	if err != nil {
-		return fmt.Errorf("failed to create vm disk: %v", err)
+		if !errors.Is(err, goproxmox.ErrVirtualMachineNotFound) {
+			return nil, err
+		}
+
+		id, err := prepareReplication(ctx, cl, node, vol.PV(), features)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create snapshot vm: %v", err)
+		}
+
+		if vm, err = cl.GetVMConfig(ctx, id); err != nil {
+			return nil, fmt.Errorf("failed to get vm config: %v", err)
+		}
 	}
 
-	diskName := strings.Split(disk, ":")
-	if len(diskName) > 1 {
-		vol.SetDisk(diskName[1])
+	if _, err = attachVolume(ctx, cl, int(vm.VMID), vol, map[string]string{"backup": "0"}, features); err != nil {
+		return nil, fmt.Errorf("failed to attach volume to snapshot vm: %v", err)
+	}
+
+	return vm, nil
+}
+
+// getVolumeSnapshots returns the native snapshots of the volume.
+func getVolumeSnapshots(ctx context.Context, cl *goproxmox.APIClient, vol *volume.Volume) ([]*proxmox.Snapshot, error) {
+	vm, err := getSnapshotVM(ctx, cl, vol)
+	if err != nil {
+		if errors.Is(err, goproxmox.ErrVirtualMachineNotFound) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	snapshots, err := vm.Snapshots(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list vm snapshots: %v", err)
+	}
+
+	// The current state of the VM is listed as a snapshot
+	return slices.DeleteFunc(snapshots, func(s *proxmox.Snapshot) bool { return s.Name == "current" }), nil
+}
+
+// createNativeSnapshot creates the native snapshot of the volume, vol.Snapshot() is the snapshot name.
+func createNativeSnapshot(ctx context.Context, cl *goproxmox.APIClient, node string, vol *volume.Volume, features csiconfig.ClustersFeatures) error {
+	vm, err := prepareSnapshotVM(ctx, cl, node, vol, features)
+	if err != nil {
+		return err
+	}
+
+	snapshots, err := vm.Snapshots(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list vm snapshots: %v", err)
+	}
+
+	if slices.ContainsFunc(snapshots, func(s *proxmox.Snapshot) bool { return s.Name == vol.Snapshot() }) {
+		return nil
+	}
+
+	task, err := vm.NewSnapshot(ctx, vol.Snapshot())
+	if err != nil {
+		return fmt.Errorf("failed to create vm snapshot: %v", err)
+	}
+
+	if err = task.WaitFor(ctx, int(features.VMTaskTimeout.Seconds())); err != nil {
+		return fmt.Errorf("unable to create vm snapshot: %w", err)
+	}
+
+	if task.IsFailed {
+		return fmt.Errorf("unable to create vm snapshot: %s", task.ExitStatus)
 	}
 
 	return nil
 }
 
-func attachVolume(ctx context.Context, cl *goproxmox.APIClient, id int, vol *volume.Volume, options map[string]string) (map[string]string, error) {
-	vm, err := cl.GetVMConfig(ctx, id)
+// deleteNativeSnapshot deletes the native snapshot of the volume,
+// and the snapshot VM after the last snapshot of the volume has been deleted.
+func deleteNativeSnapshot(ctx context.Context, cl *goproxmox.APIClient, vol *volume.Volume, timeout time.Duration) error {
+	vm, err := getSnapshotVM(ctx, cl, vol)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get vm config: %v", err)
+		if errors.Is(err, goproxmox.ErrVirtualMachineNotFound) {
+			return nil
+		}
+
+		return err
 	}
 
-	wwm := ""
+	snapshots, err := getVolumeSnapshots(ctx, cl, vol)
+	if err != nil {
+		return err
+	}
 
-	lun, exist := isVolumeAttached(vm.VirtualMachineConfig, vol.Disk())
-	if exist {
-		wwm = hex.EncodeToString([]byte(fmt.Sprintf("PVC-ID%02d", lun)))
-	} else {
-		disks := vm.VirtualMachineConfig.MergeSCSIs()
+	if slices.ContainsFunc(snapshots, func(s *proxmox.Snapshot) bool { return s.Name == vol.Snapshot() }) {
+		task, err := vm.DeleteSnapshot(ctx, vol.Snapshot())
+		if err != nil {
+			return fmt.Errorf("failed to delete vm snapshot: %v", err)
+		}
 
-		for lun = 1; lun < 30; lun++ {
-			device := deviceNamePrefix + strconv.Itoa(lun)
+		if err = task.WaitFor(ctx, int(timeout.Seconds())); err != nil {
+			return fmt.Errorf("unable to delete vm snapshot: %w", err)
+		}
 
-			if disks[device] == "" {
-				wwm = hex.EncodeToString([]byte(fmt.Sprintf("PVC-ID%02d", lun)))
+		if task.IsFailed {
+			return fmt.Errorf("unable to delete vm snapshot: %s", task.ExitStatus)
+		}
+	}
 
-				options["wwn"] = "0x" + wwm
+	// The volume owner VM must stay, it is deleted with the volume
+	if len(snapshots) > 1 || vol.VMID() == strconv.Itoa(int(vm.VMID)) {
+		return nil
+	}
 
-				opt := make([]string, 0, len(options))
-				for k := range options {
-					opt = append(opt, fmt.Sprintf("%s=%s", k, options[k]))
-				}
+	if err = detachVolume(ctx, cl, int(vm.VMID), vol, timeout); err != nil {
+		return fmt.Errorf("failed to detach volume from snapshot vm: %v", err)
+	}
+
+	if err = cl.DeleteVMByID(ctx, vm.Node, int(vm.VMID)); err != nil {
+		return fmt.Errorf("failed to delete snapshot vm: %v", err)
+	}
+
+	return nil
+}
+
+// isAgentEnabled returns true if the QEMU guest agent is enabled in the VM config, e.g. "1" or "enabled=1,fstrim_cloned_disks=1".
+func isAgentEnabled(vm *proxmox.VirtualMachine) bool {
+	if vm.VirtualMachineConfig == nil {
+		return false
+	}
+
+	for opt := range strings.SplitSeq(vm.VirtualMachineConfig.Agent, ",") {
+		key, value, found := strings.Cut(opt, "=")
+		if !found {
+			value = key
+		} else if key != "enabled" {
+			continue
+		}
+
+		enabled, _ := strconv.ParseBool(value) //nolint: errcheck
+
+		return enabled
+	}
+
+	return false
+}
+
+// freezeVolumes freezes the guest filesystems of the VMs which use the volumes through the QEMU guest agent,
+// runs fn and thaws the filesystems even if fn fails or ctx is canceled. The freeze timeout bounds only the freeze requests,
+// each thaw request has its own timeout.
+// VMs without the guest agent are not frozen, their snapshots are crash-consistent only.
+func freezeVolumes(ctx context.Context, cl *goproxmox.APIClient, vols []*volume.Volume, params SnapshotParameters, timeout time.Duration, fn func(ctx context.Context) error) error {
+	if !params.Freeze {
+		return fn(ctx)
+	}
+
+	frozen := []*proxmox.VirtualMachine{}
+
+	vms, err := getVolumeVMs(ctx, cl, vols)
+	if err == nil {
+		fctx, cancel := context.WithTimeout(ctx, time.Duration(params.FreezeTimeout)*time.Second)
+		defer cancel()
+
+		for _, vm := range vms {
+			if !isAgentEnabled(vm) {
+				klog.InfoS("QEMU guest agent is not enabled, snapshot is crash-consistent", "vmID", vm.VMID)
+
+				continue
+			}
+
+			// The thaw is safe even if the freeze request has failed in the middle
+			frozen = append(frozen, vm)
+
+			if err = cl.Client.Post(fctx, fmt.Sprintf("/nodes/%s/qemu/%d/agent/fsfreeze-freeze", vm.Node, vm.VMID), nil, nil); err != nil {
+				err = fmt.Errorf("failed to freeze vm %d filesystems: %v", vm.VMID, err)
+
+				break
+			}
+		}
+	}
+
+	if err == nil {
+		err = fn(ctx)
+	}
+
+	// The filesystems must be thawed even if the request has been canceled
+	for _, vm := range frozen {
+		if terr := thawVM(ctx, cl, vm, timeout); terr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to thaw vm %d filesystems: %v", vm.VMID, terr))
+		}
+	}
+
+	return err
+}
+
+// thawVM thaws the guest filesystems of the virtual machine, the parent context cancellation is ignored.
+func thawVM(ctx context.Context, cl *goproxmox.APIClient, vm *proxmox.VirtualMachine, timeout time.Duration) error {
+	tctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+	defer cancel()
+
+	return cl.Client.Post(tctx, fmt.Sprintf("/nodes/%s/qemu/%d/agent/fsfreeze-thaw", vm.Node, vm.VMID), nil, nil)
+}
+
+// nativeSnapshotID returns the native snapshot ID of the volume.
+func nativeSnapshotID(vol *volume.Volume, name string) (*volume.Volume, error) {
+	snap, err := volume.NewVolumeFromVolumeID(vol.VolumeID())
+	if err != nil {
+		return nil, err
+	}
+
+	snap.SetNode(vol.Node())
+	snap.SetSnapshot(nativeSnapshotName(name))
+
+	return snap, nil
+}
+
+// getVolumeVMs returns the running virtual machines which use the volumes.
+func getVolumeVMs(ctx context.Context, cl *goproxmox.APIClient, vols []*volume.Volume) ([]*proxmox.VirtualMachine, error) {
+	vms := []*proxmox.VirtualMachine{}
+	ids := []int{}
+
+	for _, vol := range vols {
+		id, _, err := getVMByAttachedVolume(ctx, cl, vol)
+		if err != nil {
+			if errors.Is(err, goproxmox.ErrVirtualMachineNotFound) {
+				continue
+			}
+
+			return nil, err
+		}
+
+		if slices.Contains(ids, id) {
+			continue
+		}
+
+		ids = append(ids, id)
+
+		vm, err := cl.GetVMConfig(ctx, id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get vm config: %v", err)
+		}
+
+		if vm.IsRunning() {
+			vms = append(vms, vm)
+		}
+	}
+
+	return vms, nil
+}
+
+// pauseVolumeVMs pauses the running virtual machines which use the volumes, so the volume writes stop
+// while the snapshots are taken. The paused VMs are returned even on error and must be resumed.
+func pauseVolumeVMs(ctx context.Context, cl *goproxmox.APIClient, vols []*volume.Volume, timeout time.Duration) ([]*proxmox.VirtualMachine, error) {
+	paused := []*proxmox.VirtualMachine{}
+
+	vms, err := getVolumeVMs(ctx, cl, vols)
+	if err != nil {
+		return paused, err
+	}
+
+	for _, vm := range vms {
+		task, err := vm.Pause(ctx)
+		if err != nil {
+			return paused, fmt.Errorf("failed to pause vm %d: %v", vm.VMID, err)
+		}
+
+		paused = append(paused, vm)
+
+		if err = task.WaitFor(ctx, int(timeout.Seconds())); err != nil {
+			return paused, fmt.Errorf("unable to pause vm %d: %w", vm.VMID, err)
+		}
+	}
+
+	return paused, nil
+}
+
+// resumeVMs resumes the virtual machines paused by pauseVolumeVMs.
+func resumeVMs(ctx context.Context, vms []*proxmox.VirtualMachine, timeout time.Duration) error {
+	var errs []error
+
+	for _, vm := range vms {
+		task, err := vm.Resume(ctx)
+		if err == nil {
+			err = task.WaitFor(ctx, int(timeout.Seconds()))
+		}
+
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to resume vm %d: %v", vm.VMID, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// restoreNativeSnapshot creates the volume from the native snapshot. Proxmox clones the snapshot VM to a new VM
+// named after the PV, which owns the new disk. The new disk name is chosen by Proxmox.
+func restoreNativeSnapshot(ctx context.Context, cl *goproxmox.APIClient, snap *volume.Volume, vol *volume.Volume, pvc string, features csiconfig.ClustersFeatures) (*volume.Volume, error) {
+	vm, err := getVolumeOwnerVM(ctx, cl, pvc)
+	if err != nil {
+		if !errors.Is(err, goproxmox.ErrVirtualMachineNotFound) {
+			return nil, err
+		}
+
+		src, err := getSnapshotVM(ctx, cl, snap)
+		if err != nil {
+			if errors.Is(err, goproxmox.ErrVirtualMachineNotFound) {
+				return nil, errors.New(ErrorNotFound)
+			}
+
+			return nil, err
+		}
+
+		snapshots, err := getVolumeSnapshots(ctx, cl, snap)
+		if err != nil {
+			return nil, err
+		}
+
+		if !slices.ContainsFunc(snapshots, func(s *proxmox.Snapshot) bool { return s.Name == snap.Snapshot() }) {
+			return nil, errors.New(ErrorNotFound)
+		}
+
+		id, err := cl.GetNextID(ctx, features.ControllerVMID+1)
+		if err != nil {
+			return nil, err
+		}
+
+		options := &proxmox.VirtualMachineCloneOptions{
+			NewID:       id,
+			Name:        pvc,
+			Full:        1,
+			SnapName:    snap.Snapshot(),
+			Storage:     vol.Storage(),
+			Description: fmt.Sprintf("CSI volume restored from %s", snap.VolumeID()),
+		}
+
+		mc := metrics.NewMetricContext("cloneVm")
+
+		_, task, err := src.Clone(ctx, options)
+		if mc.ObserveRequest(err) != nil {
+			return nil, fmt.Errorf("failed to clone vm: %v", err)
+		}
+
+		if err = waitCopyTask(ctx, task, pvc, features.CopyTaskTimeout); err != nil {
+			return nil, fmt.Errorf("unable to clone vm: %w", err)
+		}
+
+		if vm, err = cl.GetVMConfig(ctx, id); err != nil {
+			return nil, fmt.Errorf("failed to get vm config: %v", err)
+		}
+	}
+
+	if err = tagVolumeOwner(ctx, vm, features.Tags, features.VMTaskTimeout); err != nil {
+		return nil, err
+	}
+
+	if restored := ownedVolume(vm, vol.Region(), vol.Zone(), vol.Storage()); restored != nil {
+		return restored, nil
+	}
+
+	return nil, fmt.Errorf("restored disk not found in vm %d", vm.VMID)
+}
+
+// getVolumeOwnerVM returns the VM named after the PV, which owns the restored or copied volume.
+func getVolumeOwnerVM(ctx context.Context, cl *goproxmox.APIClient, pvc string) (*proxmox.VirtualMachine, error) {
+	vmr, err := cl.GetVMByFilter(ctx, func(rs *proxmox.ClusterResource) (bool, error) {
+		return rs.Type == "qemu" && rs.Name == pvc, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if vmr == nil || vmr.VMID == 0 {
+		return nil, goproxmox.ErrVirtualMachineNotFound
+	}
+
+	return cl.GetVMConfig(ctx, int(vmr.VMID))
+}
+
+// tagVolumeOwner marks the VM as the volume owner, so it is not treated as a kubernetes node.
+// The tags of the cluster features are added to the VM as well.
+func tagVolumeOwner(ctx context.Context, vm *proxmox.VirtualMachine, tags []string, timeout time.Duration) error {
+	for _, tag := range append([]string{volumeOwnerTag}, tags...) {
+		if vm.HasTag(tag) {
+			continue
+		}
+
+		task, err := vm.AddTag(ctx, tag)
+		if err != nil {
+			return fmt.Errorf("failed to tag vm: %v", err)
+		}
+
+		if err = task.WaitFor(ctx, int(timeout.Seconds())); err != nil {
+			return fmt.Errorf("unable to tag vm: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// ownedVolume returns the disk on the storage which belongs to the VM, or nil if the VM has no such disk.
+func ownedVolume(vm *proxmox.VirtualMachine, region, zone, storage string) *volume.Volume {
+	for _, disk := range vm.VirtualMachineConfig.MergeSCSIs() {
+		s, name, _ := strings.Cut(strings.Split(disk, ",")[0], ":")
+
+		owned := volume.NewVolume(region, zone, s, name)
+		if s == storage && owned.VMID() == strconv.Itoa(int(vm.VMID)) {
+			return owned
+		}
+	}
+
+	return nil
+}
+
+// copyProgressRe matches the progress in the Proxmox task log, e.g. "transferred 1.0 GiB of 10.0 GiB (10.00%)".
+var copyProgressRe = regexp.MustCompile(`\(([0-9.]+)%\)`)
+
+// waitCopyTask waits for the disk copy task and reports the copy progress from the task log to the metrics.
+func waitCopyTask(ctx context.Context, task *proxmox.Task, pvc string, timeout time.Duration) error {
+	defer metrics.DeleteCopyProgress(pvc)
+
+	deadline := time.After(timeout)
+	start := 0
+
+	for {
+		if err := task.Ping(ctx); err != nil {
+			return err
+		}
+
+		if logs, err := task.Log(ctx, start, 500); err == nil {
+			lines := slices.Sorted(maps.Keys(logs))
+			for _, n := range lines {
+				if m := copyProgressRe.FindStringSubmatch(logs[n]); m != nil {
+					if percent, err := strconv.ParseFloat(m[1], 64); err == nil {
+						metrics.ObserveCopyProgress(pvc, percent)
+					}
+				}
+
+				start = max(start, n+1)
+			}
+		}
+
+		if task.IsCompleted {
+			if task.IsFailed {
+				return fmt.Errorf("task failed: %s", task.ExitStatus)
+			}
+
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-deadline:
+			return proxmox.ErrTimeout
+		case <-time.After(TaskStatusCheckInterval * time.Second):
+		}
+	}
+}
+
+// transferVolume copies the volume or the snapshot to another storage or region.
+// The copy is owned by the VM named after the PV, which is created in the source region and moves the disk
+// to the destination storage, or migrates to the destination region with the disk.
+// Every step checks the result of the previous one, so the retries continue the interrupted transfer.
+//
+//nolint:gocyclo,cyclop
+func transferVolume(ctx context.Context, pool *pxpool.ProxmoxPool, srcVol *volume.Volume, vol *volume.Volume, pvc string, features csiconfig.ClustersFeatures) (*volume.Volume, error) {
+	src, err := pool.GetProxmoxCluster(srcVol.Region())
+	if err != nil {
+		return nil, err
+	}
+
+	dst, err := pool.GetProxmoxCluster(vol.Region())
+	if err != nil {
+		return nil, err
+	}
+
+	remote := srcVol.Region() != vol.Region()
+
+	vm, err := getVolumeOwnerVM(ctx, src, pvc)
+	if err != nil && !errors.Is(err, goproxmox.ErrVirtualMachineNotFound) {
+		return nil, err
+	}
+
+	if vm == nil && remote {
+		if vm, err = getVolumeOwnerVM(ctx, dst, pvc); err == nil {
+			if copied := ownedVolume(vm, vol.Region(), vm.Node, vol.Storage()); copied != nil {
+				return copied, nil
+			}
+
+			return nil, fmt.Errorf("copied disk not found in vm %d in region %s", vm.VMID, vol.Region())
+		}
+
+		if !errors.Is(err, goproxmox.ErrVirtualMachineNotFound) {
+			return nil, err
+		}
+
+		vm = nil
+	}
+
+	if vm == nil {
+		if vm, err = prepareTransferVM(ctx, src, srcVol, vol, pvc, features); err != nil {
+			return nil, err
+		}
+	}
+
+	if !remote {
+		if copied := ownedVolume(vm, vol.Region(), vm.Node, vol.Storage()); copied != nil {
+			return copied, nil
+		}
+
+		disk := ""
+
+		for device, d := range vm.VirtualMachineConfig.MergeSCSIs() {
+			if strings.HasPrefix(d, srcVol.Storage()+":") {
+				disk = device
+			}
+		}
+
+		if disk == "" {
+			return nil, fmt.Errorf("source disk not found in vm %d", vm.VMID)
+		}
+
+		mc := metrics.NewMetricContext("moveDisk")
+
+		task, err := vm.MoveDisk(ctx, disk, &proxmox.VirtualMachineMoveDiskOptions{Storage: vol.Storage()})
+		if mc.ObserveRequest(err) != nil {
+			return nil, fmt.Errorf("failed to move disk: %v", err)
+		}
+
+		if err = waitCopyTask(ctx, task, pvc, features.CopyTaskTimeout); err != nil {
+			return nil, fmt.Errorf("unable to move disk: %w", err)
+		}
+
+		if vm, err = src.GetVMConfig(ctx, int(vm.VMID)); err != nil {
+			return nil, fmt.Errorf("failed to get vm config: %v", err)
+		}
+
+		// The source disk stays in the config as unused, it must not be deleted with the VM
+		for device, d := range vm.VirtualMachineConfig.MergeUnuseds() {
+			if !strings.HasPrefix(d, srcVol.Storage()+":") {
+				continue
+			}
+
+			if err = unlinkDisk(ctx, vm, device, features.VMTaskTimeout); err != nil {
+				return nil, err
+			}
+		}
+
+		if copied := ownedVolume(vm, vol.Region(), vm.Node, vol.Storage()); copied != nil {
+			return copied, nil
+		}
+
+		return nil, fmt.Errorf("copied disk not found in vm %d", vm.VMID)
+	}
+
+	// The migration started by the previous attempt may be still running
+	upid := transferTask(vm)
+	if upid == "" {
+		// The disk is already migrated if the previous attempt has failed to delete the transfer VM
+		if migrated, err := getVolumeOwnerVM(ctx, dst, pvc); err == nil {
+			if err = deleteTransferVM(ctx, src, srcVol, vm, features); err != nil {
+				return nil, err
+			}
+
+			if copied := ownedVolume(migrated, vol.Region(), migrated.Node, vol.Storage()); copied != nil {
+				return copied, nil
+			}
+
+			return nil, fmt.Errorf("copied disk not found in vm %d in region %s", migrated.VMID, vol.Region())
+		} else if !errors.Is(err, goproxmox.ErrVirtualMachineNotFound) {
+			return nil, err
+		}
+
+		if upid, err = remoteMigrate(ctx, pool, src, dst, vm, vol, features); err != nil {
+			return nil, err
+		}
+	}
+
+	task := proxmox.NewTask(upid, src.Client)
+	if err = waitCopyTask(ctx, task, pvc, features.CopyTaskTimeout); err != nil {
+		// The failed migration is started again by the next attempt
+		if task.IsFailed {
+			if serr := setTransferTask(ctx, vm, "", features.VMTaskTimeout); serr != nil {
+				klog.ErrorS(serr, "failed to reset the transfer task", "vmID", vm.VMID)
+			}
+		}
+
+		return nil, fmt.Errorf("unable to migrate vm to region %s: %w", vol.Region(), err)
+	}
+
+	if err = deleteTransferVM(ctx, src, srcVol, vm, features); err != nil {
+		return nil, err
+	}
+
+	if vm, err = getVolumeOwnerVM(ctx, dst, pvc); err != nil {
+		return nil, fmt.Errorf("failed to get vm config: %v", err)
+	}
+
+	if copied := ownedVolume(vm, vol.Region(), vm.Node, vol.Storage()); copied != nil {
+		return copied, nil
+	}
+
+	return nil, fmt.Errorf("copied disk not found in vm %d in region %s", vm.VMID, vol.Region())
+}
+
+// transferTaskPrefix marks the remote migration task in the description of the transfer VM.
+const transferTaskPrefix = "csi-transfer-task: "
+
+// transferTask returns the remote migration task stored in the description of the transfer VM.
+func transferTask(vm *proxmox.VirtualMachine) proxmox.UPID {
+	for _, line := range strings.Split(vm.VirtualMachineConfig.Description, "\n") {
+		if upid, ok := strings.CutPrefix(strings.TrimSpace(line), transferTaskPrefix); ok {
+			return proxmox.UPID(upid)
+		}
+	}
+
+	return ""
+}
+
+// setTransferTask stores the remote migration task in the description of the transfer VM,
+// so the retries wait for the running task instead of starting another one.
+func setTransferTask(ctx context.Context, vm *proxmox.VirtualMachine, upid proxmox.UPID, timeout time.Duration) error {
+	description := ""
+	if upid != "" {
+		description = transferTaskPrefix + string(upid)
+	}
+
+	task, err := vm.Config(ctx, proxmox.VirtualMachineOption{Name: "description", Value: description})
+	if err != nil {
+		return fmt.Errorf("failed to update vm description: %v", err)
+	}
+
+	if err = task.WaitFor(ctx, int(timeout.Seconds())); err != nil {
+		return fmt.Errorf("unable to update vm description: %w", err)
+	}
+
+	vm.VirtualMachineConfig.Description = description
+
+	return nil
+}
+
+// remoteMigrate starts the migration of the transfer VM to the destination region and stores the task in the VM.
+func remoteMigrate(
+	ctx context.Context,
+	pool *pxpool.ProxmoxPool,
+	src, dst *goproxmox.APIClient,
+	vm *proxmox.VirtualMachine,
+	vol *volume.Volume,
+	features csiconfig.ClustersFeatures,
+) (proxmox.UPID, error) {
+	endpoint, err := pool.GetRemoteEndpoint(vol.Region())
+	if err != nil {
+		return "", err
+	}
+
+	id, err := dst.GetNextID(ctx, features.ControllerVMID+1)
+	if err != nil {
+		return "", err
+	}
+
+	params := map[string]interface{}{
+		"target-endpoint": endpoint,
+		"target-storage":  vol.Storage(),
+		"target-bridge":   features.Bridge,
+		"target-vmid":     id,
+	}
+
+	mc := metrics.NewMetricContext("remoteMigrate")
+
+	// POST https://pve.proxmox.com/pve-docs/api-viewer/index.html#/nodes/{node}/qemu/{vmid}/remote_migrate
+	// The source VM is kept after the migration.
+	var upid proxmox.UPID
+	if err = src.Client.Post(ctx, fmt.Sprintf("/nodes/%s/qemu/%d/remote_migrate", vm.Node, vm.VMID), params, &upid); mc.ObserveRequest(err) != nil {
+		return "", fmt.Errorf("failed to migrate vm to region %s: %v", vol.Region(), err)
+	}
+
+	if err = setTransferTask(ctx, vm, upid, features.VMTaskTimeout); err != nil {
+		return "", err
+	}
+
+	return upid, nil
+}
+
+// deleteTransferVM deletes the transfer VM in the source region after the migration.
+// The source disk is not owned by the VM, and the copy of the snapshot is deleted with the VM.
+func deleteTransferVM(ctx context.Context, src *goproxmox.APIClient, srcVol *volume.Volume, vm *proxmox.VirtualMachine, features csiconfig.ClustersFeatures) error {
+	for device, d := range vm.VirtualMachineConfig.MergeSCSIs() {
+		owned := volume.NewVolume(srcVol.Region(), "", srcVol.Storage(), strings.TrimPrefix(strings.Split(d, ",")[0], srcVol.Storage()+":"))
+		if owned.VMID() == strconv.Itoa(int(vm.VMID)) {
+			continue
+		}
+
+		if err := unlinkDisk(ctx, vm, device, features.VMTaskTimeout); err != nil {
+			return err
+		}
+	}
+
+	if err := src.DeleteVMByID(ctx, vm.Node, int(vm.VMID)); err != nil {
+		return fmt.Errorf("failed to delete transfer vm: %v", err)
+	}
+
+	return nil
+}
+
+// prepareTransferVM creates the VM named after the PV in the source region, which holds the source disk
+// or the clone of the native snapshot during the transfer.
+func prepareTransferVM(ctx context.Context, cl *goproxmox.APIClient, srcVol *volume.Volume, vol *volume.Volume, pvc string, features csiconfig.ClustersFeatures) (*proxmox.VirtualMachine, error) {
+	if srcVol.Snapshot() != "" {
+		if _, err := restoreNativeSnapshot(ctx, cl, srcVol, srcVol, pvc, features); err != nil {
+			return nil, err
+		}
+
+		return getVolumeOwnerVM(ctx, cl, pvc)
+	}
+
+	// Shared storage disks are copied on the destination node
+	node := srcVol.Zone()
+	if node == "" {
+		node = vol.Zone()
+	}
+
+	id, err := prepareReplication(ctx, cl, node, pvc, features)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create transfer vm: %v", err)
+	}
+
+	if _, err = attachVolume(ctx, cl, id, srcVol, map[string]string{"backup": "0"}, features); err != nil {
+		return nil, fmt.Errorf("failed to attach volume to transfer vm: %v", err)
+	}
+
+	vm, err := cl.GetVMConfig(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get vm config: %v", err)
+	}
+
+	if err = tagVolumeOwner(ctx, vm, features.Tags, features.VMTaskTimeout); err != nil {
+		return nil, err
+	}
+
+	return vm, nil
+}
+
+// unlinkDisk removes the disk from the VM config, the disk itself is kept.
+func unlinkDisk(ctx context.Context, vm *proxmox.VirtualMachine, device string, timeout time.Duration) error {
+	task, err := vm.UnlinkDisk(ctx, device, false)
+	if err != nil {
+		return fmt.Errorf("failed to unlink disk: %v", err)
+	}
+
+	if task != nil {
+		if err = task.WaitFor(ctx, int(timeout.Seconds())); err != nil {
+			return fmt.Errorf("unable to unlink disk: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// isBackupSnapshot returns true if the snapshot is archived to Proxmox Backup Server.
+func isBackupSnapshot(snap *volume.Volume) bool {
+	return strings.HasPrefix(snap.Disk(), backupSnapshotPrefix)
+}
+
+// backupNotes returns the notes of the snapshot backup, e.g. "csi-snapshot snapshot-0123 cluster-1/pve-1/local-lvm/vm-9999-pvc-4567".
+func backupNotes(name string, src *volume.Volume) string {
+	return strings.Join([]string{backupNotesPrefix, name, src.VolumeID()}, " ")
+}
+
+// parseBackupNotes returns the snapshot name and the source volume recorded in the backup notes,
+// or nil if the backup is not a snapshot.
+func parseBackupNotes(notes string) (string, *volume.Volume) {
+	parts := strings.Fields(notes)
+	if len(parts) != 3 || parts[0] != backupNotesPrefix {
+		return "", nil
+	}
+
+	src, err := volume.NewVolumeFromVolumeID(parts[2])
+	if err != nil {
+		return "", nil
+	}
+
+	return parts[1], src
+}
+
+// findBackup returns the node with the Proxmox Backup Server storage and the first backup which matches the filter.
+func findBackup(ctx context.Context, cl *goproxmox.APIClient, storage string, filter func(content *proxmox.StorageContent) bool) (string, *proxmox.StorageContent, error) {
+	nodes, err := cl.GetNodesForStorage(ctx, storage)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to find zones for storage %s: %v", storage, err)
+	}
+
+	slices.Sort(nodes)
+
+	contents, err := cl.GetStorageContent(ctx, nodes[0], storage)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to get content of storage %s on node %s: %v", storage, nodes[0], err)
+	}
+
+	for _, content := range contents {
+		if filter(content) {
+			return nodes[0], content, nil
+		}
+	}
+
+	return nodes[0], nil, nil
+}
+
+// findSnapshotBackup returns the backup of the snapshot, or nil if the snapshot has not been archived yet.
+func findSnapshotBackup(ctx context.Context, cl *goproxmox.APIClient, storage string, name string) (*proxmox.StorageContent, error) {
+	_, content, err := findBackup(ctx, cl, storage, func(content *proxmox.StorageContent) bool {
+		n, src := parseBackupNotes(content.Notes)
+
+		return src != nil && n == name
+	})
+
+	return content, err
+}
+
+// exportSnapshot archives the snapshot to the Proxmox Backup Server storage and deletes the local snapshot.
+// Proxmox backs up only virtual machines, so the snapshot disk is attached to a stopped export VM named after the snapshot,
+// native snapshots are cloned to the export VM. The export VM is deleted after the backup.
+func exportSnapshot(ctx context.Context, cl *goproxmox.APIClient, snap *volume.Volume, src *volume.Volume, name string, storage string, features csiconfig.ClustersFeatures) (*proxmox.StorageContent, error) {
+	vmName := nativeSnapshotName(name)
+
+	var (
+		vm   *proxmox.VirtualMachine
+		disk *volume.Volume
+		err  error
+	)
+
+	if snap.Snapshot() != "" {
+		if disk, err = restoreNativeSnapshot(ctx, cl, snap, snap, vmName, features); err != nil {
+			return nil, err
+		}
+
+		if vm, err = getVolumeOwnerVM(ctx, cl, vmName); err != nil {
+			return nil, err
+		}
+	} else {
+		id, err := prepareReplication(ctx, cl, snap.Node(), vmName, features)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create export vm: %v", err)
+		}
+
+		if _, err = attachVolume(ctx, cl, id, snap, map[string]string{"backup": "1"}, features); err != nil {
+			return nil, fmt.Errorf("failed to attach snapshot to export vm: %v", err)
+		}
+
+		if vm, err = cl.GetVMConfig(ctx, id); err != nil {
+			return nil, fmt.Errorf("failed to get vm config: %v", err)
+		}
+
+		disk = snap
+	}
+
+	// The holder VM attaches the volumes without backup, the clone keeps the option
+	if err = updateVolume(ctx, cl, int(vm.VMID), disk, map[string]string{"backup": "1"}, features.VMTaskTimeout); err != nil {
+		return nil, err
+	}
+
+	node, err := cl.Client.Node(ctx, vm.Node)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get node %s: %v", vm.Node, err)
+	}
+
+	mc := metrics.NewMetricContext("backupVm")
+
+	task, err := node.Vzdump(ctx, &proxmox.VirtualMachineBackupOptions{
+		VMID:          uint64(vm.VMID),
+		Storage:       storage,
+		Mode:          proxmox.VirtualMachineBackupModeStop,
+		NotesTemplate: backupNotes(name, src),
+	})
+	if mc.ObserveRequest(err) != nil {
+		return nil, fmt.Errorf("failed to backup vm: %v", err)
+	}
+
+	if err = waitCopyTask(ctx, task, vmName, features.CopyTaskTimeout); err != nil {
+		return nil, fmt.Errorf("unable to backup vm: %w", err)
+	}
+
+	backup, err := findSnapshotBackup(ctx, cl, storage, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if backup == nil {
+		return nil, fmt.Errorf("backup of vm %d not found in storage %s", vm.VMID, storage)
+	}
+
+	if snap.Snapshot() == "" {
+		if err = detachVolume(ctx, cl, int(vm.VMID), snap, features.VMTaskTimeout); err != nil {
+			return nil, fmt.Errorf("failed to detach snapshot from export vm: %v", err)
+		}
+	}
+
+	if err = cl.DeleteVMByID(ctx, vm.Node, int(vm.VMID)); err != nil {
+		return nil, fmt.Errorf("failed to delete export vm: %v", err)
+	}
+
+	if snap.Snapshot() != "" {
+		err = deleteNativeSnapshot(ctx, cl, snap, features.VMTaskTimeout)
+	} else {
+		err = cl.DeleteVMDisk(ctx, snap.Node(), snap.Storage(), snap.Disk())
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete local snapshot: %v", err)
+	}
+
+	return backup, nil
+}
+
+// restoreBackupSnapshot creates the volume from the snapshot archived to Proxmox Backup Server.
+// Proxmox restores the export VM to a new VM named after the PV, which owns the new disk.
+func restoreBackupSnapshot(ctx context.Context, cl *goproxmox.APIClient, snap *volume.Volume, vol *volume.Volume, pvc string, features csiconfig.ClustersFeatures) (*volume.Volume, error) {
+	vm, err := getVolumeOwnerVM(ctx, cl, pvc)
+	if err != nil {
+		if !errors.Is(err, goproxmox.ErrVirtualMachineNotFound) {
+			return nil, err
+		}
+
+		_, backup, err := findBackup(ctx, cl, snap.Storage(), func(content *proxmox.StorageContent) bool {
+			return content.Volid == snap.VolID()
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if backup == nil {
+			return nil, errors.New(ErrorNotFound)
+		}
+
+		id, err := cl.GetNextID(ctx, features.ControllerVMID+1)
+		if err != nil {
+			return nil, err
+		}
+
+		params := map[string]interface{}{
+			"vmid":        id,
+			"archive":     snap.VolID(),
+			"storage":     vol.Storage(),
+			"unique":      1,
+			"name":        pvc,
+			"description": fmt.Sprintf("CSI volume restored from %s", snap.VolumeID()),
+		}
+
+		mc := metrics.NewMetricContext("restoreVm")
+
+		// POST https://pve.proxmox.com/pve-docs/api-viewer/index.html#/nodes/{node}/qemu
+		var upid proxmox.UPID
+		if err = cl.Client.Post(ctx, fmt.Sprintf("/nodes/%s/qemu", vol.Zone()), params, &upid); mc.ObserveRequest(err) != nil {
+			return nil, fmt.Errorf("failed to restore vm: %v", err)
+		}
+
+		if err = waitCopyTask(ctx, proxmox.NewTask(upid, cl.Client), pvc, features.CopyTaskTimeout); err != nil {
+			return nil, fmt.Errorf("unable to restore vm: %w", err)
+		}
+
+		if vm, err = cl.GetVMConfig(ctx, id); err != nil {
+			return nil, fmt.Errorf("failed to get vm config: %v", err)
+		}
+	}
+
+	if err = tagVolumeOwner(ctx, vm, features.Tags, features.VMTaskTimeout); err != nil {
+		return nil, err
+	}
+
+	if restored := ownedVolume(vm, vol.Region(), vol.Zone(), vol.Storage()); restored != nil {
+		return restored, nil
+	}
+
+	return nil, fmt.Errorf("restored disk not found in vm %d", vm.VMID)
+}
+
+// deleteBackupSnapshot deletes the snapshot archived to Proxmox Backup Server.
+func deleteBackupSnapshot(ctx context.Context, cl *goproxmox.APIClient, snap *volume.Volume, timeout time.Duration) error {
+	node, backup, err := findBackup(ctx, cl, snap.Storage(), func(content *proxmox.StorageContent) bool {
+		return content.Volid == snap.VolID()
+	})
+	if err != nil {
+		return err
+	}
+
+	if backup == nil {
+		return nil
+	}
+
+	// DELETE https://pve.proxmox.com/pve-docs/api-viewer/index.html#/nodes/{node}/storage/{storage}/content/{volume}
+	var upid proxmox.UPID
+	if err = cl.Client.Delete(ctx, fmt.Sprintf("/nodes/%s/storage/%s/content/%s", node, snap.Storage(), url.PathEscape(snap.VolID())), &upid); err != nil {
+		return fmt.Errorf("failed to delete backup: %v", err)
+	}
+
+	if upid != "" {
+		if err = proxmox.NewTask(upid, cl.Client).WaitFor(ctx, int(timeout.Seconds())); err != nil {
+			return fmt.Errorf("unable to delete backup: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func createVolume(ctx context.Context, cl *goproxmox.APIClient, vol *volume.Volume, sizeBytes int64) error {
+	if vol.Node() == "" {
+		return errors.New("node is required")
+	}
+
+	filename := strings.Split(vol.Disk(), "/")
+
+	id, err := strconv.Atoi(vol.VMID())
+	if err != nil {
+		return fmt.Errorf("failed to parse volume vm id: %v", err)
+	}
+
+	disk, err := cl.CreateVMDisk(ctx, id, vol.Node(), vol.Storage(), filename[len(filename)-1], sizeBytes)
+	if err != nil {
+		return fmt.Errorf("failed to create vm disk: %v", err)
+	}
+
+	diskName := strings.Split(disk, ":")
+	if len(diskName) > 1 {
+		vol.SetDisk(diskName[1])
+	}
+
+	return nil
+}
+
+func attachVolume(
+	ctx context.Context,
+	cl *goproxmox.APIClient,
+	id int,
+	vol *volume.Volume,
+	options map[string]string,
+	features csiconfig.ClustersFeatures,
+) (map[string]string, error) {
+	vm, err := cl.GetVMConfig(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get vm config: %v", err)
+	}
+
+	wwm := ""
+
+	lun, exist := isVolumeAttached(vm.VirtualMachineConfig, vol.Disk())
+	if exist {
+		wwm = hex.EncodeToString([]byte(fmt.Sprintf("PVC-ID%02d", lun)))
+	} else {
+		disks := vm.VirtualMachineConfig.MergeSCSIs()
+
+		for lun = 1; lun < 30; lun++ {
+			device := deviceNamePrefix + strconv.Itoa(lun)
+
+			if disks[device] == "" {
+				wwm = hex.EncodeToString([]byte(fmt.Sprintf("PVC-ID%02d", lun)))
+
+				options["wwn"] = "0x" + wwm
+
+				opt := make([]string, 0, len(options))
+				for k := range options {
+					opt = append(opt, fmt.Sprintf("%s=%s", k, options[k]))
+				}
+
+				slices.Sort(opt)
 
 				vmOptions := proxmox.VirtualMachineOption{
 					Name:  device,
@@ -418,11 +1830,11 @@ func attachVolume(ctx context.Context, cl *goproxmox.APIClient, id int, vol *vol
 					return nil, fmt.Errorf("unable to attach disk: %v, options=%+v", err, vmOptions)
 				}
 
-				if err := task.WaitFor(ctx, 5*60); err != nil {
+				if err := task.WaitFor(ctx, int(features.VMTaskTimeout.Seconds())); err != nil {
 					return nil, fmt.Errorf("unable to attach virtual machine disk: %w", err)
 				}
 
-				if err := waitAttachVolume(ctx, cl, id, vol); err != nil {
+				if err := waitAttachVolume(ctx, cl, id, vol, features.TaskTimeout); err != nil {
 					return nil, err
 				}
 
@@ -441,7 +1853,7 @@ func attachVolume(ctx context.Context, cl *goproxmox.APIClient, id int, vol *vol
 	return nil, fmt.Errorf("no free lun found")
 }
 
-func detachVolume(ctx context.Context, cl *goproxmox.APIClient, id int, vol *volume.Volume) error {
+func detachVolume(ctx context.Context, cl *goproxmox.APIClient, id int, vol *volume.Volume, timeout time.Duration) error {
 	vm, err := cl.GetVMConfig(ctx, id)
 	if err != nil {
 		if errors.Is(err, goproxmox.ErrVirtualMachineNotFound) {
@@ -458,7 +1870,7 @@ func detachVolume(ctx context.Context, cl *goproxmox.APIClient, id int, vol *vol
 		}
 
 		if task != nil {
-			if err := task.WaitFor(ctx, 5*60); err != nil {
+			if err := task.WaitFor(ctx, int(timeout.Seconds())); err != nil {
 				return fmt.Errorf("unable to detach virtual machine disk: %w", err)
 			}
 		}
@@ -467,7 +1879,7 @@ func detachVolume(ctx context.Context, cl *goproxmox.APIClient, id int, vol *vol
 	return nil
 }
 
-func updateVolume(ctx context.Context, cl *goproxmox.APIClient, id int, vol *volume.Volume, options map[string]string) error {
+func updateVolume(ctx context.Context, cl *goproxmox.APIClient, id int, vol *volume.Volume, options map[string]string, timeout time.Duration) error {
 	vm, err := cl.GetVMConfig(ctx, id)
 	if err != nil {
 		return fmt.Errorf("failed to get vm config: %v", err)
@@ -500,7 +1912,7 @@ func updateVolume(ctx context.Context, cl *goproxmox.APIClient, id int, vol *vol
 			return fmt.Errorf("unable to update disk: %v, options=%+v", err, vmOptions)
 		}
 
-		if err := task.WaitFor(ctx, 5*60); err != nil {
+		if err := task.WaitFor(ctx, int(timeout.Seconds())); err != nil {
 			return fmt.Errorf("unable to update virtual machine disk: %w", err)
 		}
 
@@ -551,8 +1963,8 @@ func copyVolume(ctx context.Context, cl *goproxmox.APIClient, srcVol *volume.Vol
 	return nil
 }
 
-func waitAttachVolume(ctx context.Context, cl *goproxmox.APIClient, id int, vol *volume.Volume) error {
-	err := retry.Constant(TaskTimeout*time.Second, retry.WithUnits(TaskStatusCheckInterval*time.Second)).Retry(func() error {
+func waitAttachVolume(ctx context.Context, cl *goproxmox.APIClient, id int, vol *volume.Volume, timeout time.Duration) error {
+	err := retry.Constant(timeout, retry.WithUnits(TaskStatusCheckInterval*time.Second)).Retry(func() error {
 		vm, err := cl.GetVMConfig(ctx, id)
 		if err != nil {
 			return fmt.Errorf("failed to get vm config: %v", err)
@@ -575,8 +1987,8 @@ func waitAttachVolume(ctx context.Context, cl *goproxmox.APIClient, id int, vol
 	return nil
 }
 
-func waitDetachVolume(ctx context.Context, cl *goproxmox.APIClient, id int, vol *volume.Volume) error {
-	err := retry.Constant(TaskTimeout*time.Second, retry.WithUnits(TaskStatusCheckInterval*time.Second)).Retry(func() error {
+func waitDetachVolume(ctx context.Context, cl *goproxmox.APIClient, id int, vol *volume.Volume, timeout time.Duration) error {
+	err := retry.Constant(timeout, retry.WithUnits(TaskStatusCheckInterval*time.Second)).Retry(func() error {
 		vm, err := cl.GetVMConfig(ctx, id)
 		if err != nil {
 			if errors.Is(err, goproxmox.ErrVirtualMachineNotFound) {
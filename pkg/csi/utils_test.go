@@ -17,11 +17,23 @@ limitations under the License.
 package csi
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"maps"
+	"net/http"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/jarcoal/httpmock"
 	proxmox "github.com/luthermonson/go-proxmox"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	goproxmox "github.com/sergelogvinov/go-proxmox"
+	csiconfig "github.com/sergelogvinov/proxmox-csi-plugin/pkg/config"
+	volume "github.com/sergelogvinov/proxmox-csi-plugin/pkg/utils/volume"
 )
 
 func TestIsVolumeAttached(t *testing.T) {
@@ -81,3 +93,466 @@ func TestIsVolumeAttached(t *testing.T) {
 		})
 	}
 }
+
+func TestSnapshotDiskName(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		msg            string
+		volumeID       string
+		name           string
+		zone           string
+		expectedDisk   string
+		expectedSource string
+	}{
+		{
+			msg:            "Block storage",
+			volumeID:       "cluster-1/pve-1/local-lvm/vm-9999-pvc-123",
+			name:           "snapshot-123",
+			expectedDisk:   "vm-9999-snapshot-123_vm-9999-pvc-123",
+			expectedSource: "cluster-1/pve-1/local-lvm/vm-9999-pvc-123",
+		},
+		{
+			msg:            "File storage",
+			volumeID:       "cluster-1/pve-1/local/9999/vm-9999-pvc-123.raw",
+			name:           "snapshot-123",
+			expectedDisk:   "9999/vm-9999-snapshot-123_vm-9999-pvc-123.raw",
+			expectedSource: "cluster-1/pve-1/local/9999/vm-9999-pvc-123.raw",
+		},
+		{
+			msg:            "Same zone",
+			volumeID:       "cluster-1/pve-1/local-lvm/vm-9999-pvc-123",
+			name:           "snapshot-123",
+			zone:           "pve-1",
+			expectedDisk:   "vm-9999-snapshot-123_vm-9999-pvc-123",
+			expectedSource: "cluster-1/pve-1/local-lvm/vm-9999-pvc-123",
+		},
+		{
+			msg:            "Other zone",
+			volumeID:       "cluster-1/pve-1/local-lvm/vm-9999-pvc-123",
+			name:           "snapshot-123",
+			zone:           "pve-2",
+			expectedDisk:   "vm-9999-snapshot-123_pve-1_vm-9999-pvc-123",
+			expectedSource: "cluster-1/pve-1/local-lvm/vm-9999-pvc-123",
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(fmt.Sprint(testCase.msg), func(t *testing.T) {
+			t.Parallel()
+
+			vol, err := volume.NewVolumeFromVolumeID(testCase.volumeID)
+			assert.NoError(t, err)
+
+			snap := vol.CopyVolume(snapshotDiskName(9999, testCase.name, vol, testCase.zone))
+			assert.Equal(t, testCase.expectedDisk, snap.Disk())
+
+			if testCase.zone != "" {
+				snap.SetZone(testCase.zone)
+			}
+
+			src, ok := snapshotSourceVolume(snap)
+			assert.True(t, ok)
+			assert.NotNil(t, src)
+			assert.Equal(t, testCase.expectedSource, src.VolumeID())
+		})
+	}
+}
+
+func TestSnapshotSourceVolume(t *testing.T) {
+	t.Parallel()
+
+	for _, volumeID := range []string{
+		"cluster-1/pve-1/local-lvm/vm-9999-pvc-123",
+		"cluster-1/pve-1/local/9999/vm-9999-pvc-123.raw",
+		"cluster-1/pve-1/local-lvm/vm-9999-snapshot-123_",
+		"cluster-1/pve-1/local-lvm/vm-9999-snapshot-data",
+	} {
+		vol, err := volume.NewVolumeFromVolumeID(volumeID)
+		assert.NoError(t, err)
+
+		src, ok := snapshotSourceVolume(vol)
+		assert.False(t, ok, volumeID)
+		assert.Nil(t, src, volumeID)
+	}
+}
+
+func TestSnapshotSourceVolumeLegacy(t *testing.T) {
+	t.Parallel()
+
+	for _, volumeID := range []string{
+		"cluster-1/pve-1/local-lvm/" + legacySnapshotDiskName(9999, "snapshot-6bd1c8b4-ce03-4f23-8c3f-8c5a8d1f8b0f"),
+		"cluster-1/pve-1/local/9999/vm-9999-snapshot-6bd1c8b4-ce03-4f23-8c3f-8c5a8d1f8b0f.raw",
+	} {
+		vol, err := volume.NewVolumeFromVolumeID(volumeID)
+		assert.NoError(t, err)
+
+		src, ok := snapshotSourceVolume(vol)
+		assert.True(t, ok, volumeID)
+		assert.Nil(t, src, volumeID)
+	}
+}
+
+func TestNativeSnapshotName(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "snap1", nativeSnapshotName("snap1"))
+	assert.Len(t, nativeSnapshotName("snapshot-6bd1c8b4-ce03-4f23-8c3f-8c5a8d1f8b0f"), 36)
+	assert.Regexp(t, nativeSnapshotNameRe, nativeSnapshotName("snapshot-6bd1c8b4-ce03-4f23-8c3f-8c5a8d1f8b0f"))
+	assert.Equal(t, nativeSnapshotName("1-snapshot"), nativeSnapshotName("1-snapshot"))
+	assert.NotEqual(t, "1-snapshot", nativeSnapshotName("1-snapshot"))
+}
+
+func TestIsVolumeHolder(t *testing.T) {
+	t.Parallel()
+
+	vol := volume.NewVolume("region", "zone", "storage", "vm-9999-pvc-123")
+	restored := volume.NewVolume("region", "zone", "storage", "vm-101-disk-0")
+
+	assert.True(t, isVolumeHolder(&proxmox.ClusterResource{VMID: 100, Name: "pvc-123"}, vol))
+	assert.False(t, isVolumeHolder(&proxmox.ClusterResource{VMID: 100, Name: "worker-1"}, vol))
+	assert.True(t, isVolumeHolder(&proxmox.ClusterResource{VMID: 101, Name: "pvc-456", Tags: "csi-volume"}, restored))
+	assert.False(t, isVolumeHolder(&proxmox.ClusterResource{VMID: 101, Name: "worker-1"}, restored))
+	assert.False(t, isVolumeHolder(&proxmox.ClusterResource{VMID: 102, Name: "pvc-456", Tags: "prod;csi-volume"}, restored))
+}
+
+func TestVolumePV(t *testing.T) {
+	t.Parallel()
+
+	vms := proxmox.ClusterResources{
+		{Type: "qemu", VMID: 100, Name: "worker-1"},
+		{Type: "qemu", VMID: 101, Name: "pvc-456", Tags: "csi-volume"},
+	}
+
+	assert.Equal(t, "pvc-123", volumePV(vms, volume.NewVolume("region", "zone", "storage", "vm-9999-pvc-123")))
+	assert.Equal(t, "pvc-456", volumePV(vms, volume.NewVolume("region", "zone", "storage", "vm-101-disk-0")))
+	assert.Equal(t, "disk-0", volumePV(vms, volume.NewVolume("region", "zone", "storage", "vm-100-disk-0")))
+}
+
+func TestIsAgentEnabled(t *testing.T) {
+	t.Parallel()
+
+	for agent, expected := range map[string]bool{
+		"":                                false,
+		"0":                               false,
+		"1":                               true,
+		"1,fstrim_cloned_disks=1":         true,
+		"enabled=1,fstrim_cloned_disks=1": true,
+		"fstrim_cloned_disks=1,enabled=0": false,
+		"type=virtio":                     false,
+	} {
+		vm := &proxmox.VirtualMachine{VirtualMachineConfig: &proxmox.VirtualMachineConfig{Agent: agent}}
+
+		assert.Equal(t, expected, isAgentEnabled(vm), agent)
+	}
+}
+
+func TestOwnedVolume(t *testing.T) {
+	t.Parallel()
+
+	vm := &proxmox.VirtualMachine{
+		VMID: 101,
+		VirtualMachineConfig: &proxmox.VirtualMachineConfig{
+			SCSI0: "local-lvm:vm-9999-pvc-123,backup=0",
+			SCSI1: "rbd:vm-101-disk-0,backup=0",
+		},
+	}
+
+	assert.Equal(t, volume.NewVolume("region", "zone", "rbd", "vm-101-disk-0"), ownedVolume(vm, "region", "zone", "rbd"))
+	assert.Nil(t, ownedVolume(vm, "region", "zone", "local-lvm"))
+}
+
+func TestCopyProgressRe(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, []string{"(10.00%)", "10.00"}, copyProgressRe.FindStringSubmatch("drive-scsi1: transferred 1.0 GiB of 10.0 GiB (10.00%) in 5s"))
+	assert.Nil(t, copyProgressRe.FindStringSubmatch("create full clone of drive scsi1 (rbd:vm-9999-pvc-123)"))
+}
+
+func TestTransferTask(t *testing.T) {
+	t.Parallel()
+
+	upid := proxmox.UPID("UPID:pve-1:0000A1B2:00C3D4E5:65000000:qmigrate:101:root@pam:")
+
+	vm := &proxmox.VirtualMachine{
+		VMID:                 101,
+		VirtualMachineConfig: &proxmox.VirtualMachineConfig{Description: "CSI volume restored from snapshot"},
+	}
+	assert.Equal(t, proxmox.UPID(""), transferTask(vm))
+
+	vm.VirtualMachineConfig.Description = transferTaskPrefix + string(upid)
+	assert.Equal(t, upid, transferTask(vm))
+}
+
+func TestBackupNotes(t *testing.T) {
+	t.Parallel()
+
+	src := volume.NewVolume("cluster-1", "pve-1", "local-lvm", "vm-9999-pvc-123")
+
+	notes := backupNotes("snapshot-123", src)
+	assert.Equal(t, "csi-snapshot snapshot-123 cluster-1/pve-1/local-lvm/vm-9999-pvc-123", notes)
+
+	name, vol := parseBackupNotes(notes)
+	assert.Equal(t, "snapshot-123", name)
+	assert.Equal(t, src.VolumeID(), vol.VolumeID())
+
+	_, vol = parseBackupNotes("manual backup")
+	assert.Nil(t, vol)
+}
+
+func TestIsBackupSnapshot(t *testing.T) {
+	t.Parallel()
+
+	assert.True(t, isBackupSnapshot(volume.NewVolume("cluster-1", "", "pbs", "backup/vm/100/2025-01-01T00:00:00Z")))
+	assert.False(t, isBackupSnapshot(volume.NewVolume("cluster-1", "pve-1", "local", "9999/vm-9999-pvc-123.raw")))
+}
+
+// fakeNode is the state of the virtual machines on the Proxmox node pve-1, the responders change it like the Proxmox API.
+type fakeNode struct {
+	mu      sync.Mutex
+	names   map[int]string
+	tags    map[int]string
+	configs map[int]map[string]string
+}
+
+func (n *fakeNode) register(t *testing.T, task string) {
+	t.Helper()
+
+	httpmock.RegisterResponder(http.MethodGet, `=~/nodes/pve-1/tasks/[^/]+/status$`,
+		httpmock.NewJsonResponderOrPanic(200, map[string]any{"data": proxmox.Task{
+			UPID: proxmox.UPID(task), Node: "pve-1", Status: "stopped", ExitStatus: "OK",
+		}}))
+	httpmock.RegisterResponder(http.MethodGet, `=~/cluster/status$`,
+		httpmock.NewJsonResponderOrPanic(200, map[string]any{"data": []any{}}))
+	httpmock.RegisterResponder(http.MethodGet, `=~/cluster/resources`, func(_ *http.Request) (*http.Response, error) {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+
+		resources := []*proxmox.ClusterResource{}
+		for id, name := range n.names {
+			resources = append(resources, &proxmox.ClusterResource{Type: "qemu", Node: "pve-1", VMID: uint64(id), Name: name, Tags: n.tags[id]})
+		}
+
+		return httpmock.NewJsonResponse(200, map[string]any{"data": resources})
+	})
+	httpmock.RegisterResponder(http.MethodGet, `=~/nodes/pve-1/qemu/(\d+)/status/current$`, func(req *http.Request) (*http.Response, error) {
+		id := httpmock.MustGetSubmatchAsInt(req, 1)
+
+		n.mu.Lock()
+		defer n.mu.Unlock()
+
+		return httpmock.NewJsonResponse(200, map[string]any{"data": map[string]any{"vmid": id, "name": n.names[int(id)]}})
+	})
+	httpmock.RegisterResponder(http.MethodGet, `=~/nodes/pve-1/qemu/(\d+)/config$`, func(req *http.Request) (*http.Response, error) {
+		id := httpmock.MustGetSubmatchAsInt(req, 1)
+
+		n.mu.Lock()
+		defer n.mu.Unlock()
+
+		return httpmock.NewJsonResponse(200, map[string]any{"data": n.configs[int(id)]})
+	})
+	httpmock.RegisterResponder(http.MethodPost, `=~/nodes/pve-1/qemu$`, func(req *http.Request) (*http.Response, error) {
+		params := map[string]any{}
+		if err := json.NewDecoder(req.Body).Decode(&params); err != nil {
+			return nil, err
+		}
+
+		n.mu.Lock()
+		defer n.mu.Unlock()
+
+		id := int(params["vmid"].(float64))   //nolint: forcetypeassert
+		n.names[id] = params["name"].(string) //nolint: forcetypeassert
+		n.configs[id] = map[string]string{}
+
+		return httpmock.NewJsonResponse(200, map[string]any{"data": task})
+	})
+	httpmock.RegisterResponder(http.MethodPost, `=~/nodes/pve-1/qemu/(\d+)/config$`, func(req *http.Request) (*http.Response, error) {
+		id := httpmock.MustGetSubmatchAsInt(req, 1)
+
+		params := map[string]string{}
+		if err := json.NewDecoder(req.Body).Decode(&params); err != nil {
+			return nil, err
+		}
+
+		n.mu.Lock()
+		defer n.mu.Unlock()
+
+		for k, v := range params {
+			n.configs[int(id)][k] = v
+		}
+
+		return httpmock.NewJsonResponse(200, map[string]any{"data": task})
+	})
+	httpmock.RegisterResponder(http.MethodPut, `=~/nodes/pve-1/qemu/(\d+)/unlink$`, func(req *http.Request) (*http.Response, error) {
+		id := httpmock.MustGetSubmatchAsInt(req, 1)
+
+		params := map[string]string{}
+		if err := json.NewDecoder(req.Body).Decode(&params); err != nil {
+			return nil, err
+		}
+
+		n.mu.Lock()
+		defer n.mu.Unlock()
+
+		delete(n.configs[int(id)], params["idlist"])
+
+		return httpmock.NewJsonResponse(200, map[string]any{"data": task})
+	})
+	httpmock.RegisterResponder(http.MethodDelete, `=~/nodes/pve-1/qemu/(\d+)$`, func(req *http.Request) (*http.Response, error) {
+		id := httpmock.MustGetSubmatchAsInt(req, 1)
+
+		n.mu.Lock()
+		defer n.mu.Unlock()
+
+		delete(n.names, int(id))
+		delete(n.configs, int(id))
+
+		return httpmock.NewJsonResponse(200, map[string]any{"data": task})
+	})
+}
+
+func TestResizeVolume(t *testing.T) {
+	task := "UPID:pve-1:003B4235:1DF4ABCA:667C1C45:csi:100:root@pam:"
+
+	tests := []struct {
+		msg             string
+		volumeID        string
+		names           map[int]string
+		tags            map[int]string
+		configs         map[int]map[string]string
+		attachFailed    bool
+		resizeResponder httpmock.Responder
+		expectedError   string
+		expectedResize  string
+		expectedNames   map[int]string
+		expectedConfigs map[int]map[string]string
+	}{
+		{
+			msg:             "Attached",
+			volumeID:        "cluster-1/pve-1/local-lvm/vm-9999-pvc-1",
+			names:           map[int]string{100: "worker-1"},
+			configs:         map[int]map[string]string{100: {"scsi3": "local-lvm:vm-9999-pvc-1,size=1G"}},
+			resizeResponder: httpmock.NewJsonResponderOrPanic(200, map[string]any{"data": nil}),
+			expectedResize:  "/api2/json/nodes/pve-1/qemu/100/resize",
+			expectedNames:   map[int]string{100: "worker-1"},
+			expectedConfigs: map[int]map[string]string{100: {"scsi3": "local-lvm:vm-9999-pvc-1,size=1G"}},
+		},
+		{
+			msg:             "HolderVM",
+			volumeID:        "cluster-1/pve-1/local-lvm/vm-9999-pvc-1",
+			names:           map[int]string{100: "worker-1", 200: "pvc-1"},
+			resizeResponder: httpmock.NewJsonResponderOrPanic(200, map[string]any{"data": nil}),
+			expectedResize:  "/api2/json/nodes/pve-1/qemu/200/resize",
+			expectedNames:   map[int]string{100: "worker-1", 200: "pvc-1"},
+			expectedConfigs: map[int]map[string]string{
+				100: {},
+				200: {"scsi1": "local-lvm:vm-9999-pvc-1,backup=0,wwn=0x5056432d49443031"},
+			},
+		},
+		{
+			msg:             "OwnerVM",
+			volumeID:        "cluster-1/pve-1/local-lvm/vm-300-disk-0",
+			names:           map[int]string{100: "worker-1", 300: "pvc-1"},
+			tags:            map[int]string{300: "csi-volume"},
+			configs:         map[int]map[string]string{300: {"scsi0": "local-lvm:vm-300-disk-0,backup=0"}},
+			resizeResponder: httpmock.NewJsonResponderOrPanic(200, map[string]any{"data": nil}),
+			expectedResize:  "/api2/json/nodes/pve-1/qemu/300/resize",
+			expectedNames:   map[int]string{100: "worker-1", 300: "pvc-1"},
+			expectedConfigs: map[int]map[string]string{
+				100: {},
+				300: {"scsi0": "local-lvm:vm-300-disk-0,backup=0"},
+			},
+		},
+		{
+			msg:             "OwnerVMNotFound",
+			volumeID:        "cluster-1/pve-1/local-lvm/vm-300-disk-0",
+			names:           map[int]string{100: "worker-1", 300: "pvc-1"},
+			expectedError:   "owner vm of volume cluster-1/pve-1/local-lvm/vm-300-disk-0 not found",
+			expectedNames:   map[int]string{100: "worker-1", 300: "pvc-1"},
+			expectedConfigs: map[int]map[string]string{100: {}, 300: {}},
+		},
+		{
+			msg:             "TemporaryVM",
+			volumeID:        "cluster-1/pve-1/local-lvm/vm-9999-pvc-1",
+			names:           map[int]string{100: "worker-1"},
+			resizeResponder: httpmock.NewJsonResponderOrPanic(200, map[string]any{"data": nil}),
+			expectedResize:  "/api2/json/nodes/pve-1/qemu/10000/resize",
+			expectedNames:   map[int]string{100: "worker-1"},
+			expectedConfigs: map[int]map[string]string{100: {}},
+		},
+		{
+			msg:             "TemporaryVMResizeFailed",
+			volumeID:        "cluster-1/pve-1/local-lvm/vm-9999-pvc-1",
+			names:           map[int]string{100: "worker-1"},
+			resizeResponder: httpmock.NewStringResponder(500, ""),
+			expectedError:   "failed to resize vm disk: 500 Internal Server Error",
+			expectedResize:  "/api2/json/nodes/pve-1/qemu/10000/resize",
+			expectedNames:   map[int]string{100: "worker-1"},
+			expectedConfigs: map[int]map[string]string{100: {}},
+		},
+		{
+			msg:             "TemporaryVMAttachFailed",
+			volumeID:        "cluster-1/pve-1/local-lvm/vm-9999-pvc-1",
+			names:           map[int]string{100: "worker-1"},
+			attachFailed:    true,
+			expectedError:   "failed to attach volume to resize vm: volume cluster-1/pve-1/local-lvm/vm-9999-pvc-1 is not attached to VM 10000",
+			expectedNames:   map[int]string{100: "worker-1"},
+			expectedConfigs: map[int]map[string]string{100: {}},
+		},
+		{
+			msg:             "NoPVName",
+			volumeID:        "cluster-1/pve-1/local-lvm/vm-9999",
+			names:           map[int]string{100: "worker-1"},
+			expectedError:   "cannot resize unpublished volume vm-9999, the disk name has no PV name",
+			expectedNames:   map[int]string{100: "worker-1"},
+			expectedConfigs: map[int]map[string]string{100: {}},
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.msg, func(t *testing.T) {
+			httpmock.Activate()
+			t.Cleanup(httpmock.DeactivateAndReset)
+
+			node := &fakeNode{names: testCase.names, tags: testCase.tags, configs: map[int]map[string]string{}}
+			for id := range testCase.names {
+				node.configs[id] = map[string]string{}
+				maps.Copy(node.configs[id], testCase.configs[id])
+			}
+
+			// The regexp responders are matched in the registration order, the disk is never attached to the temporary vm
+			if testCase.attachFailed {
+				httpmock.RegisterResponder(http.MethodPost, `=~/nodes/pve-1/qemu/10000/config$`,
+					httpmock.NewJsonResponderOrPanic(200, map[string]any{"data": task}))
+			}
+
+			node.register(t, task)
+
+			resized := ""
+
+			httpmock.RegisterResponder(http.MethodPut, `=~/nodes/pve-1/qemu/\d+/resize$`, func(req *http.Request) (*http.Response, error) {
+				resized = req.URL.Path
+
+				return testCase.resizeResponder(req)
+			})
+
+			cl, err := goproxmox.NewAPIClient("https://127.0.0.1:8006/api2/json", proxmox.WithAPIToken("user!token", "secret"))
+			require.NoError(t, err)
+
+			vol, err := volume.NewVolumeFromVolumeID(testCase.volumeID)
+			require.NoError(t, err)
+
+			err = ResizeVolume(context.Background(), cl, vol, 2048*MiB, csiconfig.ClustersFeatures{ControllerVMID: 9999, TaskTimeout: time.Second, VMTaskTimeout: time.Minute})
+			if testCase.expectedError == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, testCase.expectedError)
+			}
+
+			// The temporary vm is deleted after the resize or the failure, the vm which holds the volume is kept
+			assert.Equal(t, testCase.expectedResize, resized)
+			assert.Equal(t, testCase.expectedNames, node.names)
+			assert.Equal(t, testCase.expectedConfigs, node.configs)
+		})
+	}
+}
@@ -0,0 +1,574 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csi
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"maps"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	goproxmox "github.com/sergelogvinov/go-proxmox"
+	csiconfig "github.com/sergelogvinov/proxmox-csi-plugin/pkg/config"
+	volume "github.com/sergelogvinov/proxmox-csi-plugin/pkg/utils/volume"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	toolscache "k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// zoneSelectionTTL is how long the selected zone is kept for the retries of CreateVolume.
+const zoneSelectionTTL = 10 * time.Minute
+
+// zoneCandidate is the Proxmox node which can be used to create a volume on the storage.
+type zoneCandidate struct {
+	zone  string
+	avail int64
+}
+
+// zoneSelection is the zone selected for the volume, it is kept for the retries of CreateVolume
+// and reserves the zone for the placement group until the persistent volume is created.
+type zoneSelection struct {
+	region  string
+	storage string
+	name    string
+	zone    string
+	group   string
+}
+
+// zoneCounter keeps the position of the round-robin zone selection per storage.
+type zoneCounter struct {
+	mu    sync.Mutex
+	count map[string]uint64
+}
+
+func newZoneCounter() *zoneCounter {
+	return &zoneCounter{count: map[string]uint64{}}
+}
+
+// next returns the current position for the key and moves it forward.
+func (c *zoneCounter) next(key string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := c.count[key]
+	c.count[key]++
+
+	return n
+}
+
+// storageTarget is the Proxmox storage of the logical storage name in the region.
+// The storage is used on the allowed nodes only, or on all nodes except the excluded ones if no nodes are allowed.
+type storageTarget struct {
+	storage  string
+	allowed  []string
+	excluded []string
+}
+
+// permits returns true if the storage of the target is used on the node.
+func (t storageTarget) permits(node string) bool {
+	if len(t.allowed) > 0 {
+		return slices.Contains(t.allowed, node)
+	}
+
+	return !slices.Contains(t.excluded, node)
+}
+
+// storageTargets returns the Proxmox storages of the logical storage name in the region,
+// the storage of the region goes first, then the storages overridden on the nodes.
+func storageTargets(aliases csiconfig.StorageAliases, region, name string) []storageTarget {
+	alias, ok := aliases[region][name]
+	if !ok {
+		return []storageTarget{{storage: name}}
+	}
+
+	targets := []storageTarget{{storage: alias.Storage}}
+	overrides := map[string][]string{}
+
+	for node, storage := range alias.Nodes {
+		if storage == alias.Storage {
+			continue
+		}
+
+		targets[0].excluded = append(targets[0].excluded, node)
+		overrides[storage] = append(overrides[storage], node)
+	}
+
+	slices.Sort(targets[0].excluded)
+
+	for _, storage := range slices.Sorted(maps.Keys(overrides)) {
+		nodes := overrides[storage]
+		slices.Sort(nodes)
+
+		targets = append(targets, storageTarget{storage: storage, allowed: nodes})
+	}
+
+	return targets
+}
+
+// resolveStorages returns the Proxmox storages of the logical storage names in the region and on the node.
+func (d *ControllerService) resolveStorages(region, node string, names []string) []string {
+	storages := make([]string, 0, len(names))
+
+	for _, name := range names {
+		if storage := d.storageAliases.Resolve(region, node, name); !slices.Contains(storages, storage) {
+			storages = append(storages, storage)
+		}
+	}
+
+	return storages
+}
+
+// volumeStorageZone returns the zone to create the volume, and sets the storage of the volume in the parameters.
+// The storages are tried in the priority order, the first storage which exists on the node and has capacity is used.
+// The logical storage names are resolved to the Proxmox storages of the region and the node by the storage aliases.
+func (d *ControllerService) volumeStorageZone(
+	ctx context.Context,
+	cl *goproxmox.APIClient,
+	region string,
+	zone string,
+	name string,
+	tr *csi.TopologyRequirement,
+	size int64,
+	params *StorageParameters,
+	parameters map[string]string,
+) (string, error) {
+	topologyZones := zonesFromTopologyRequirement(tr, region)
+	targets := []storageTarget{}
+
+	for _, storage := range params.StorageIDs() {
+		for _, target := range storageTargets(d.storageAliases, region, storage) {
+			if zone != "" && !target.permits(zone) {
+				continue
+			}
+
+			if len(target.allowed) > 0 && len(topologyZones) > 0 {
+				target.allowed = slices.DeleteFunc(slices.Clone(target.allowed), func(n string) bool { return !slices.Contains(topologyZones, n) })
+				if len(target.allowed) == 0 {
+					continue
+				}
+			} else if len(target.allowed) == 0 {
+				target.allowed = topologyZones
+			}
+
+			targets = append(targets, target)
+		}
+	}
+
+	if len(targets) == 1 {
+		params.StorageID = targets[0].storage
+
+		return d.volumeZone(ctx, cl, region, zone, name, targets[0].allowed, targets[0].excluded, size, params, parameters)
+	}
+
+	if len(targets) == 0 {
+		return "", status.Errorf(codes.ResourceExhausted, "failed to find storage for the volume: no storages of %s in the allowed zones", params.StorageID)
+	}
+
+	// The retries of CreateVolume must not create the disk on another storage
+	storageID := d.selectedStorage(region, name)
+	if i := slices.IndexFunc(targets, func(t storageTarget) bool { return t.storage == storageID }); i > 0 {
+		target := targets[i]
+		targets = slices.Insert(slices.Delete(targets, i, i+1), 0, target)
+	}
+
+	errs := []string{}
+
+	for _, target := range targets {
+		p := *params
+		p.StorageID = target.storage
+
+		if zone != "" {
+			if err := checkStorageZone(ctx, cl, zone, target.storage, size); err != nil {
+				klog.V(4).InfoS("CreateVolume: storage is skipped", "cluster", region, "zone", zone, "storage", target.storage, "reason", err)
+
+				errs = append(errs, err.Error())
+
+				continue
+			}
+		}
+
+		z, err := d.volumeZone(ctx, cl, region, zone, name, target.allowed, target.excluded, size, &p, parameters)
+		if err != nil {
+			if status.Code(err) == codes.InvalidArgument {
+				return "", err
+			}
+
+			klog.V(4).InfoS("CreateVolume: storage is skipped", "cluster", region, "storage", target.storage, "reason", err)
+
+			errs = append(errs, status.Convert(err).Message())
+
+			continue
+		}
+
+		d.reserveZone(region, target.storage, name, z, p.PlacementGroup)
+		*params = p
+
+		return z, nil
+	}
+
+	return "", status.Errorf(codes.ResourceExhausted, "failed to find storage for the volume: %s", strings.Join(errs, "; "))
+}
+
+// checkStorageZone checks that the storage is active on the node and has enough available capacity.
+func checkStorageZone(ctx context.Context, cl *goproxmox.APIClient, zone, storageID string, size int64) error {
+	st, err := cl.GetStorageStatus(ctx, zone, storageID)
+	if err != nil {
+		return fmt.Errorf("storage %s is not available on node %s: %v", storageID, zone, err)
+	}
+
+	if st.Enabled == 0 || st.Active == 0 {
+		return fmt.Errorf("storage %s is not active on node %s", storageID, zone)
+	}
+
+	if int64(st.Avail) < size {
+		return fmt.Errorf("storage %s has not enough capacity on node %s", storageID, zone)
+	}
+
+	return nil
+}
+
+// selectedStorage returns the storage selected for the volume by the previous attempt of CreateVolume.
+func (d *ControllerService) selectedStorage(region, name string) string {
+	for _, item := range d.zoneSelections.Items() {
+		if sel, ok := item.Object.(zoneSelection); ok && sel.region == region && sel.name == name {
+			return sel.storage
+		}
+	}
+
+	return ""
+}
+
+// volumeZone returns the zone to create the volume.
+// The zone of the topology requirement is used, if it does not host a volume of the same placement group,
+// otherwise the zone is selected from the allowed nodes with the storage, the excluded nodes are skipped.
+func (d *ControllerService) volumeZone(
+	ctx context.Context,
+	cl *goproxmox.APIClient,
+	region string,
+	zone string,
+	name string,
+	allowed []string,
+	excluded []string,
+	size int64,
+	params *StorageParameters,
+	parameters map[string]string,
+) (string, error) {
+	group, err := d.placementGroup(ctx, *params, parameters)
+	if err != nil {
+		return "", err
+	}
+
+	if group != "" {
+		storageConfig, err := cl.GetClusterStorage(ctx, params.StorageID)
+		if err != nil {
+			return "", status.Errorf(codes.Internal, "failed to get proxmox storage config: %v", err)
+		}
+
+		// The disks on shared storages are not bound to the nodes
+		if storageConfig.Shared == 1 {
+			klog.V(4).InfoS("CreateVolume: placement group is ignored on shared storage", "cluster", region, "storage", params.StorageID, "placementGroup", group)
+
+			group = ""
+		}
+	}
+
+	params.PlacementGroup = group
+
+	if group == "" {
+		if zone != "" {
+			return zone, nil
+		}
+
+		return d.selectZone(ctx, cl, region, params.StorageID, name, allowed, excluded, size, params.ZoneStrategy, "", nil)
+	}
+
+	pvs, err := d.persistentVolumeLister(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	// The zones of the group are reserved one by one, the volumes of the group can be created concurrently
+	d.placementMu.Lock()
+	defer d.placementMu.Unlock()
+
+	groupZones, err := d.placementGroupZones(pvs, region, group, name)
+	if err != nil {
+		return "", err
+	}
+
+	if zone != "" {
+		if !slices.Contains(groupZones, zone) {
+			d.reserveZone(region, params.StorageID, name, zone, group)
+
+			return zone, nil
+		}
+
+		klog.V(4).InfoS("CreateVolume: zone hosts a volume of the placement group", "cluster", region, "zone", zone, "placementGroup", group)
+	}
+
+	return d.selectZone(ctx, cl, region, params.StorageID, name, allowed, excluded, size, params.ZoneStrategy, group, groupZones)
+}
+
+// selectZone chooses the Proxmox node to create the volume, when the topology requirement has no zone.
+// The zones which are not allowed, the excluded zones and the zones used by the placement group are skipped.
+// By default the first node with the storage is used, the strategy chooses the node by the storage capacity:
+// the offline nodes, the nodes where the storage is disabled or inactive and the nodes without enough available capacity are skipped.
+// The zone is kept for the volume name, so the retries of CreateVolume create the disk on the same node.
+func (d *ControllerService) selectZone(
+	ctx context.Context,
+	cl *goproxmox.APIClient,
+	region string,
+	storageID string,
+	name string,
+	allowed []string,
+	excluded []string,
+	size int64,
+	strategy string,
+	group string,
+	groupZones []string,
+) (string, error) {
+	key := region + "/" + storageID + "/" + name
+	if v, ok := d.zoneSelections.Get(key); ok {
+		if sel, ok := v.(zoneSelection); ok {
+			return sel.zone, nil
+		}
+	}
+
+	zones, err := cl.GetNodesForStorage(ctx, storageID)
+	if err != nil {
+		return "", status.Errorf(codes.Internal, "failed to get zones with storage %s: %v", storageID, err)
+	}
+
+	zones = slices.DeleteFunc(zones, func(zone string) bool {
+		return (len(allowed) > 0 && !slices.Contains(allowed, zone)) || slices.Contains(excluded, zone) || slices.Contains(groupZones, zone)
+	})
+
+	var zone string
+
+	if strategy == "" {
+		if len(zones) == 0 {
+			if group != "" && len(groupZones) > 0 {
+				return "", status.Errorf(codes.ResourceExhausted,
+					"failed to find best zone: no nodes with the storage %s, zones %s are used by placement group %s",
+					storageID, strings.Join(groupZones, ","), group)
+			}
+
+			return "", status.Errorf(codes.Internal, "failed to find best zone: no nodes with the storage %s", storageID)
+		}
+
+		zone = zones[0]
+	} else {
+		candidates, err := zoneCandidates(ctx, cl, region, storageID, zones, size)
+		if err != nil {
+			return "", err
+		}
+
+		if len(candidates) == 0 {
+			if group != "" && len(groupZones) > 0 {
+				return "", status.Errorf(codes.ResourceExhausted,
+					"failed to find best zone: no online nodes with enough capacity on the storage %s, zones %s are used by placement group %s",
+					storageID, strings.Join(groupZones, ","), group)
+			}
+
+			return "", status.Errorf(codes.ResourceExhausted, "failed to find best zone: no online nodes with enough capacity on the storage %s", storageID)
+		}
+
+		var n uint64
+		if strategy == ZoneStrategyRoundRobin {
+			n = d.zoneCounter.next(region + "/" + storageID)
+		}
+
+		zone = pickZone(candidates, strategy, n)
+	}
+
+	d.zoneSelections.SetDefault(key, zoneSelection{region: region, storage: storageID, name: name, zone: zone, group: group})
+
+	klog.V(4).InfoS("CreateVolume: zone selected", "cluster", region, "zone", zone, "storage", storageID, "strategy", strategy)
+
+	return zone, nil
+}
+
+// zoneCandidates returns the online zones where the storage is active and has enough available capacity.
+func zoneCandidates(ctx context.Context, cl *goproxmox.APIClient, region, storageID string, zones []string, size int64) ([]zoneCandidate, error) {
+	nodes, err := cl.Client.Nodes(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to get nodes: %v", err)
+	}
+
+	online := map[string]bool{}
+
+	for _, node := range nodes {
+		if node.Status == "online" {
+			online[node.Node] = true
+		}
+	}
+
+	candidates := []zoneCandidate{}
+
+	for _, zone := range zones {
+		if !online[zone] {
+			continue
+		}
+
+		st, err := cl.GetStorageStatus(ctx, zone, storageID)
+		if err != nil {
+			klog.V(4).InfoS("CreateVolume: failed to get storage status, skipping zone", "cluster", region, "zone", zone, "storage", storageID, "error", err)
+
+			continue
+		}
+
+		if st.Enabled == 0 || st.Active == 0 {
+			continue
+		}
+
+		if int64(st.Avail) < size {
+			klog.V(4).InfoS("CreateVolume: not enough capacity, skipping zone", "cluster", region, "zone", zone, "storage", storageID, "available", st.Avail, "size", size)
+
+			continue
+		}
+
+		candidates = append(candidates, zoneCandidate{zone: zone, avail: int64(st.Avail)})
+	}
+
+	return candidates, nil
+}
+
+// reserveZone keeps the zone of the volume for the placement group, when the zone is defined by the topology requirement.
+func (d *ControllerService) reserveZone(region, storageID, name, zone, group string) {
+	d.zoneSelections.SetDefault(region+"/"+storageID+"/"+name, zoneSelection{region: region, storage: storageID, name: name, zone: zone, group: group})
+}
+
+// placementGroup returns the placement group of the volume.
+// The value of the PersistentVolumeClaim label defined by placementGroupLabel has the priority over placementGroup,
+// such groups are scoped by the namespace of the claim.
+func (d *ControllerService) placementGroup(ctx context.Context, params StorageParameters, parameters map[string]string) (string, error) {
+	if params.PlacementGroupLabel == "" {
+		return params.PlacementGroup, nil
+	}
+
+	name, namespace := parameters[PVCNameKey], parameters[PVCNamespaceKey]
+	if name == "" || namespace == "" {
+		return "", status.Errorf(codes.InvalidArgument, "parameter %s requires csi-provisioner with --extra-create-metadata", PlacementGroupLabelKey)
+	}
+
+	pvc, err := d.kclient.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return "", status.Errorf(codes.Internal, "failed to get persistentvolumeclaim %s/%s: %v", namespace, name, err)
+	}
+
+	if value := pvc.Labels[params.PlacementGroupLabel]; value != "" {
+		return namespace + "/" + value, nil
+	}
+
+	return params.PlacementGroup, nil
+}
+
+// persistentVolumeLister returns the lister of the persistent volumes.
+// The informer is started on the first use, so the persistent volumes are watched only if the placement groups are used.
+func (d *ControllerService) persistentVolumeLister(ctx context.Context) (corelisters.PersistentVolumeLister, error) {
+	d.pvInformerOnce.Do(func() {
+		factory := informers.NewSharedInformerFactory(d.kclient, 0)
+		informer := factory.Core().V1().PersistentVolumes()
+
+		d.pvLister = informer.Lister()
+		d.pvSynced = informer.Informer().HasSynced
+
+		factory.Start(wait.NeverStop)
+	})
+
+	if !toolscache.WaitForCacheSync(ctx.Done(), d.pvSynced) {
+		return nil, status.Error(codes.Internal, "failed to sync persistentvolumes cache")
+	}
+
+	return d.pvLister, nil
+}
+
+// placementGroupZones returns the zones in the region which host the volumes of the placement group,
+// the zones selected for the volumes in progress are included, the zone of the volume itself is not.
+func (d *ControllerService) placementGroupZones(pvs corelisters.PersistentVolumeLister, region, group, name string) ([]string, error) {
+	zones := []string{}
+
+	add := func(zone string) {
+		if zone != "" && !slices.Contains(zones, zone) {
+			zones = append(zones, zone)
+		}
+	}
+
+	items, err := pvs.List(labels.Everything())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list persistentvolumes: %v", err)
+	}
+
+	for _, pv := range items {
+		if pv.Name == name || pv.Spec.CSI == nil || pv.Spec.CSI.Driver != DriverName || pv.Spec.CSI.VolumeAttributes[PlacementGroupKey] != group {
+			continue
+		}
+
+		vol, err := volume.NewVolumeFromVolumeID(pv.Spec.CSI.VolumeHandle)
+		if err != nil || vol.Region() != region {
+			continue
+		}
+
+		add(vol.Zone())
+	}
+
+	for _, item := range d.zoneSelections.Items() {
+		if sel, ok := item.Object.(zoneSelection); ok && sel.region == region && sel.group == group && sel.name != name {
+			add(sel.zone)
+		}
+	}
+
+	slices.Sort(zones)
+
+	return zones, nil
+}
+
+// pickZone returns the zone from the candidates by the strategy, n is the round-robin position.
+// The most-free strategy keeps the order of the candidates with the same capacity.
+func pickZone(candidates []zoneCandidate, strategy string, n uint64) string {
+	switch strategy {
+	case ZoneStrategyRoundRobin:
+		zones := make([]string, 0, len(candidates))
+		for _, c := range candidates {
+			zones = append(zones, c.zone)
+		}
+
+		slices.Sort(zones)
+
+		return zones[n%uint64(len(zones))]
+	case ZoneStrategyRandom:
+		return candidates[rand.IntN(len(candidates))].zone //nolint: gosec
+	default: // ZoneStrategyMostFree
+		best := slices.MaxFunc(candidates, func(a, b zoneCandidate) int { return cmp.Compare(a.avail, b.avail) })
+
+		return best.zone
+	}
+}
@@ -0,0 +1,297 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csi
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/jarcoal/httpmock"
+	proxmox "github.com/luthermonson/go-proxmox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	goproxmox "github.com/sergelogvinov/go-proxmox"
+	csiconfig "github.com/sergelogvinov/proxmox-csi-plugin/pkg/config"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestPickZone(t *testing.T) {
+	t.Parallel()
+
+	candidates := []zoneCandidate{
+		{zone: "pve-3", avail: 10 * GiB},
+		{zone: "pve-1", avail: 50 * GiB},
+		{zone: "pve-2", avail: 50 * GiB},
+	}
+
+	tests := []struct {
+		msg      string
+		strategy string
+		n        uint64
+		expected string
+	}{
+		{
+			msg:      "Default",
+			expected: "pve-1",
+		},
+		{
+			msg:      "MostFree",
+			strategy: ZoneStrategyMostFree,
+			expected: "pve-1",
+		},
+		{
+			msg:      "RoundRobinFirst",
+			strategy: ZoneStrategyRoundRobin,
+			n:        0,
+			expected: "pve-1",
+		},
+		{
+			msg:      "RoundRobinNext",
+			strategy: ZoneStrategyRoundRobin,
+			n:        2,
+			expected: "pve-3",
+		},
+		{
+			msg:      "RoundRobinWrap",
+			strategy: ZoneStrategyRoundRobin,
+			n:        4,
+			expected: "pve-2",
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.msg, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, testCase.expected, pickZone(candidates, testCase.strategy, testCase.n))
+		})
+	}
+
+	t.Run("Random", func(t *testing.T) {
+		t.Parallel()
+
+		assert.Contains(t, []string{"pve-1", "pve-2", "pve-3"}, pickZone(candidates, ZoneStrategyRandom, 0))
+	})
+}
+
+func TestSelectZone(t *testing.T) {
+	tests := []struct {
+		msg            string
+		strategy       string
+		allowed        []string
+		groupZones     []string
+		expected       string
+		expectedStatus int
+	}{
+		{
+			msg:      "Default",
+			expected: "pve-1",
+		},
+		{
+			msg:      "DefaultAllowed",
+			allowed:  []string{"pve-2", "pve-3"},
+			expected: "pve-2",
+		},
+		{
+			msg:        "DefaultPlacementGroup",
+			groupZones: []string{"pve-1"},
+			expected:   "pve-2",
+		},
+		{
+			msg:            "MostFree",
+			strategy:       ZoneStrategyMostFree,
+			expected:       "pve-2",
+			expectedStatus: 2,
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.msg, func(t *testing.T) {
+			httpmock.Activate()
+			t.Cleanup(httpmock.DeactivateAndReset)
+
+			storages := []*proxmox.ClusterResource{}
+			for _, node := range []string{"pve-1", "pve-2", "pve-3"} {
+				storages = append(storages, &proxmox.ClusterResource{Type: "storage", Node: node, Storage: "local-lvm", Status: "available"})
+			}
+
+			httpmock.RegisterResponder(http.MethodGet, `=~/cluster/status$`,
+				httpmock.NewJsonResponderOrPanic(200, map[string]any{"data": []any{}}))
+			httpmock.RegisterResponder(http.MethodGet, `=~/cluster/resources`,
+				httpmock.NewJsonResponderOrPanic(200, map[string]any{"data": storages}))
+			httpmock.RegisterResponder(http.MethodGet, `=~/nodes$`,
+				httpmock.NewJsonResponderOrPanic(200, map[string]any{"data": []proxmox.NodeStatus{
+					{Node: "pve-1", Status: "online"},
+					{Node: "pve-2", Status: "online"},
+					{Node: "pve-3", Status: "offline"},
+				}}))
+			httpmock.RegisterResponder(http.MethodGet, `=~/nodes/pve-1/storage/local-lvm/status$`,
+				httpmock.NewJsonResponderOrPanic(200, map[string]any{"data": proxmox.Storage{Enabled: 1, Active: 1, Avail: uint64(10 * GiB)}}))
+			httpmock.RegisterResponder(http.MethodGet, `=~/nodes/pve-2/storage/local-lvm/status$`,
+				httpmock.NewJsonResponderOrPanic(200, map[string]any{"data": proxmox.Storage{Enabled: 1, Active: 1, Avail: uint64(50 * GiB)}}))
+
+			cl, err := goproxmox.NewAPIClient("https://127.0.0.1:8006/api2/json", proxmox.WithAPIToken("user!token", "secret"))
+			require.NoError(t, err)
+
+			d := &ControllerService{}
+			d.Init()
+
+			// The first node with the storage is used by default, even if it has not enough capacity
+			zone, err := d.selectZone(context.Background(), cl, "cluster-1", "local-lvm", "pvc-1",
+				testCase.allowed, nil, 20*GiB, testCase.strategy, "", testCase.groupZones)
+			require.NoError(t, err)
+			assert.Equal(t, testCase.expected, zone)
+
+			calls := httpmock.GetCallCountInfo()
+			assert.Equal(t, testCase.expectedStatus,
+				calls["GET =~/nodes/pve-1/storage/local-lvm/status$"]+calls["GET =~/nodes/pve-2/storage/local-lvm/status$"])
+		})
+	}
+}
+
+func TestZoneCounter(t *testing.T) {
+	t.Parallel()
+
+	c := newZoneCounter()
+
+	assert.Equal(t, uint64(0), c.next("cluster-1/local-lvm"))
+	assert.Equal(t, uint64(1), c.next("cluster-1/local-lvm"))
+	assert.Equal(t, uint64(0), c.next("cluster-1/zfs"))
+}
+
+func TestStorageTargets(t *testing.T) {
+	t.Parallel()
+
+	aliases := csiconfig.StorageAliases{
+		"cluster-1": {
+			"fast": {Storage: "local-zfs", Nodes: map[string]string{"pve-3": "tank", "pve-2": "tank", "pve-1": "local-zfs"}},
+		},
+	}
+
+	assert.Equal(t, []storageTarget{{storage: "local-lvm"}}, storageTargets(aliases, "cluster-1", "local-lvm"))
+	assert.Equal(t, []storageTarget{{storage: "fast"}}, storageTargets(aliases, "cluster-2", "fast"))
+	assert.Equal(t, []storageTarget{
+		{storage: "local-zfs", excluded: []string{"pve-2", "pve-3"}},
+		{storage: "tank", allowed: []string{"pve-2", "pve-3"}},
+	}, storageTargets(aliases, "cluster-1", "fast"))
+
+	assert.True(t, storageTarget{storage: "local-zfs", excluded: []string{"pve-2"}}.permits("pve-1"))
+	assert.False(t, storageTarget{storage: "local-zfs", excluded: []string{"pve-2"}}.permits("pve-2"))
+	assert.True(t, storageTarget{storage: "tank", allowed: []string{"pve-2"}}.permits("pve-2"))
+	assert.False(t, storageTarget{storage: "tank", allowed: []string{"pve-2"}}.permits("pve-1"))
+}
+
+func TestPlacementGroup(t *testing.T) {
+	t.Parallel()
+
+	d := &ControllerService{
+		kclient: fake.NewClientset(&corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "storage-db-0",
+				Namespace: "default",
+				Labels:    map[string]string{"app": "db"},
+			},
+		}),
+	}
+
+	metadata := map[string]string{
+		PVCNameKey:      "storage-db-0",
+		PVCNamespaceKey: "default",
+	}
+
+	tests := []struct {
+		msg        string
+		params     StorageParameters
+		parameters map[string]string
+		expected   string
+	}{
+		{
+			msg: "Empty",
+		},
+		{
+			msg:      "StorageClass",
+			params:   StorageParameters{PlacementGroup: "db"},
+			expected: "db",
+		},
+		{
+			msg:        "Label",
+			params:     StorageParameters{PlacementGroup: "db", PlacementGroupLabel: "app"},
+			parameters: metadata,
+			expected:   "default/db",
+		},
+		{
+			msg:        "LabelNotFound",
+			params:     StorageParameters{PlacementGroup: "common", PlacementGroupLabel: "tier"},
+			parameters: metadata,
+			expected:   "common",
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.msg, func(t *testing.T) {
+			t.Parallel()
+
+			group, err := d.placementGroup(context.Background(), testCase.params, testCase.parameters)
+			require.NoError(t, err)
+			assert.Equal(t, testCase.expected, group)
+		})
+	}
+}
+
+func TestPlacementGroupZones(t *testing.T) {
+	t.Parallel()
+
+	pv := func(name, handle, group string) *corev1.PersistentVolume {
+		return &corev1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: corev1.PersistentVolumeSpec{
+				PersistentVolumeSource: corev1.PersistentVolumeSource{
+					CSI: &corev1.CSIPersistentVolumeSource{
+						Driver:           DriverName,
+						VolumeHandle:     handle,
+						VolumeAttributes: map[string]string{PlacementGroupKey: group},
+					},
+				},
+			},
+		}
+	}
+
+	d := &ControllerService{
+		kclient: fake.NewClientset(
+			pv("pvc-1", "cluster-1/pve-1/local-lvm/vm-9999-pvc-1", "db"),
+			pv("pvc-2", "cluster-1/pve-2/local-lvm/vm-9999-pvc-2", "web"),
+			pv("pvc-3", "cluster-2/pve-3/local-lvm/vm-9999-pvc-3", "db"),
+			pv("pvc-4", "cluster-1/pve-4/local-lvm/vm-9999-pvc-4", "db"),
+		),
+	}
+	d.Init()
+
+	d.reserveZone("cluster-1", "local-lvm", "pvc-5", "pve-5", "db")
+	d.reserveZone("cluster-1", "local-lvm", "pvc-6", "pve-6", "web")
+
+	pvs, err := d.persistentVolumeLister(context.Background())
+	require.NoError(t, err)
+
+	zones, err := d.placementGroupZones(pvs, "cluster-1", "db", "pvc-4")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"pve-1", "pve-5"}, zones)
+}
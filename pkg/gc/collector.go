@@ -0,0 +1,438 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package gc implements the garbage collector of the disks which are left on Proxmox storages
+// after their persistent volumes have been deleted.
+package gc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	goproxmox "github.com/sergelogvinov/go-proxmox"
+	csiconfig "github.com/sergelogvinov/proxmox-csi-plugin/pkg/config"
+	"github.com/sergelogvinov/proxmox-csi-plugin/pkg/csi"
+	pxpool "github.com/sergelogvinov/proxmox-csi-plugin/pkg/proxmoxpool"
+	volume "github.com/sergelogvinov/proxmox-csi-plugin/pkg/utils/volume"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// VolumeSnapshotContentResource is the resource of the VolumeSnapshotContents, the snapshots reference the disks.
+var VolumeSnapshotContentResource = schema.GroupVersionResource{
+	Group:    "snapshot.storage.k8s.io",
+	Version:  "v1",
+	Resource: "volumesnapshotcontents",
+}
+
+// stateKey is the key of the ConfigMap with the times when the orphaned disks have been found.
+const stateKey = "orphans.json"
+
+// Orphan is the disk of the controller which is not referenced by any persistent volume or volume snapshot.
+type Orphan struct {
+	// Volume is the disk, the node is the Proxmox node where the disk has been found.
+	Volume *volume.Volume
+	Size   int64
+	Shared bool
+	// Created is the creation time of the disk, it is zero if the storage does not report it.
+	Created time.Time
+	// FirstSeen is the time when the collector has found the disk for the first time.
+	FirstSeen time.Time
+}
+
+// Age returns how long the disk exists without references, from the creation time or from the time it has been found.
+func (o Orphan) Age(now time.Time) time.Duration {
+	since := o.FirstSeen
+	if !o.Created.IsZero() && o.Created.Before(since) {
+		since = o.Created
+	}
+
+	return now.Sub(since)
+}
+
+// Collector finds the orphaned disks of the controller on all Proxmox storages.
+// The disks are named vm-<controllerVMID>-pvc-*, the disks of other VMs are never collected.
+// The controller VM ID can be overridden per region in the cluster features.
+type Collector struct {
+	pxpool   *pxpool.ProxmoxPool
+	kclient  kubernetes.Interface
+	dclient  dynamic.Interface
+	features csiconfig.ClustersConfig
+
+	mu   sync.Mutex
+	seen map[string]time.Time
+	now  func() time.Time
+
+	// The times when the disks have been found are kept in the ConfigMap if the namespace is set,
+	// so the grace period is not reset by the restarts of the controller.
+	stateNamespace string
+	stateName      string
+}
+
+// NewCollector returns a new collector of the orphaned disks.
+func NewCollector(px *pxpool.ProxmoxPool, kclient kubernetes.Interface, dclient dynamic.Interface, features csiconfig.ClustersConfig) *Collector {
+	return &Collector{
+		pxpool:   px,
+		kclient:  kclient,
+		dclient:  dclient,
+		features: features,
+		seen:     map[string]time.Time{},
+		now:      time.Now,
+	}
+}
+
+// Orphans returns the orphaned disks in all regions.
+// The storages are listed before the persistent volumes, so the disk created during the listing is referenced already.
+func (c *Collector) Orphans(ctx context.Context) ([]Orphan, error) {
+	disks := []Orphan{}
+	attached := map[string]bool{}
+
+	regions := c.pxpool.GetRegions()
+	slices.Sort(regions)
+
+	for _, region := range regions {
+		cl, err := c.pxpool.GetProxmoxCluster(region)
+		if err != nil {
+			return nil, err
+		}
+
+		vols, err := c.storageDisks(ctx, cl, region)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list disks in region %s: %v", region, err)
+		}
+
+		disks = append(disks, vols...)
+
+		if err = c.attachedDisks(ctx, cl, region, attached); err != nil {
+			return nil, fmt.Errorf("failed to list attached disks in region %s: %v", region, err)
+		}
+	}
+
+	refs, err := c.references(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	orphans := findOrphans(disks, refs, attached)
+
+	state, err := c.loadState(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if state != nil {
+		c.seen = state
+	}
+
+	now := c.now()
+	seen := make(map[string]time.Time, len(orphans))
+
+	for i := range orphans {
+		key := diskKey(orphans[i].Volume)
+
+		first, ok := c.seen[key]
+		if !ok {
+			first = now
+		}
+
+		seen[key] = first
+		orphans[i].FirstSeen = first
+	}
+
+	// The disks which are referenced again, or deleted, are forgotten
+	c.seen = seen
+
+	if err = c.saveState(ctx, seen); err != nil {
+		return nil, err
+	}
+
+	return orphans, nil
+}
+
+// Delete deletes the orphaned disk.
+func (c *Collector) Delete(ctx context.Context, orphan Orphan) error {
+	vol := orphan.Volume
+
+	cl, err := c.pxpool.GetProxmoxCluster(vol.Region())
+	if err != nil {
+		return err
+	}
+
+	if err = cl.DeleteVMDisk(ctx, vol.Node(), vol.Storage(), vol.Disk()); err != nil {
+		return fmt.Errorf("failed to delete disk %s on node %s: %v", vol.VolID(), vol.Node(), err)
+	}
+
+	c.mu.Lock()
+	delete(c.seen, diskKey(vol))
+	c.mu.Unlock()
+
+	return nil
+}
+
+// loadState returns the times when the orphaned disks have been found from the ConfigMap, nil if the state is not kept.
+func (c *Collector) loadState(ctx context.Context) (map[string]time.Time, error) {
+	if c.stateNamespace == "" {
+		return nil, nil
+	}
+
+	seen := map[string]time.Time{}
+
+	cm, err := c.kclient.CoreV1().ConfigMaps(c.stateNamespace).Get(ctx, c.stateName, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return seen, nil
+		}
+
+		return nil, fmt.Errorf("failed to get configmap %s/%s: %v", c.stateNamespace, c.stateName, err)
+	}
+
+	if data := cm.Data[stateKey]; data != "" {
+		if err = json.Unmarshal([]byte(data), &seen); err != nil {
+			return nil, fmt.Errorf("failed to parse configmap %s/%s: %v", c.stateNamespace, c.stateName, err)
+		}
+	}
+
+	return seen, nil
+}
+
+// saveState writes the times when the orphaned disks have been found to the ConfigMap.
+func (c *Collector) saveState(ctx context.Context, seen map[string]time.Time) error {
+	if c.stateNamespace == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(seen)
+	if err != nil {
+		return err
+	}
+
+	cms := c.kclient.CoreV1().ConfigMaps(c.stateNamespace)
+
+	cm, err := cms.Get(ctx, c.stateName, metav1.GetOptions{})
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to get configmap %s/%s: %v", c.stateNamespace, c.stateName, err)
+		}
+
+		cm = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: c.stateName, Namespace: c.stateNamespace},
+			Data:       map[string]string{stateKey: string(data)},
+		}
+
+		if _, err = cms.Create(ctx, cm, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("failed to create configmap %s/%s: %v", c.stateNamespace, c.stateName, err)
+		}
+
+		return nil
+	}
+
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+
+	cm.Data[stateKey] = string(data)
+
+	if _, err = cms.Update(ctx, cm, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to update configmap %s/%s: %v", c.stateNamespace, c.stateName, err)
+	}
+
+	return nil
+}
+
+// storageDisks returns the disks of the controller on the storages with disk images, the shared storages are listed once.
+func (c *Collector) storageDisks(ctx context.Context, cl *goproxmox.APIClient, region string) ([]Orphan, error) {
+	storages, err := cl.Client.ClusterStorages(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list storages: %v", err)
+	}
+
+	vmID := strconv.Itoa(c.features.RegionFeatures(region).ControllerVMID)
+	disks := []Orphan{}
+
+	for _, storage := range storages {
+		if !slices.Contains(strings.Split(storage.Content, ","), "images") || storage.Type == "pbs" {
+			continue
+		}
+
+		nodes, err := cl.GetNodesForStorage(ctx, storage.Storage)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find nodes for storage %s: %v", storage.Storage, err)
+		}
+
+		slices.Sort(nodes)
+
+		for _, node := range nodes {
+			contents, err := cl.GetStorageContent(ctx, node, storage.Storage)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get content of storage %s on node %s: %v", storage.Storage, node, err)
+			}
+
+			for _, content := range contents {
+				vol := volume.NewVolume(region, node, storage.Storage, strings.TrimPrefix(content.Volid, storage.Storage+":"))
+				if vol.VMID() != vmID || !strings.HasPrefix(vol.PV(), "pvc-") {
+					continue
+				}
+
+				disk := Orphan{
+					Volume: vol,
+					Size:   int64(content.Size),
+					Shared: storage.Shared == 1,
+				}
+
+				if content.Ctime > 0 {
+					disk.Created = time.Unix(int64(content.Ctime), 0)
+				}
+
+				disks = append(disks, disk)
+			}
+
+			if storage.Shared == 1 {
+				break
+			}
+		}
+	}
+
+	return disks, nil
+}
+
+// attachedDisks adds the disks attached to the virtual machines, including the unused disks, to the attached map.
+func (c *Collector) attachedDisks(ctx context.Context, cl *goproxmox.APIClient, region string, attached map[string]bool) error {
+	cluster, err := cl.Client.Cluster(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get cluster info: %v", err)
+	}
+
+	vms, err := cluster.Resources(ctx, "vm")
+	if err != nil {
+		return fmt.Errorf("failed to get list of VMs: %v", err)
+	}
+
+	for _, rs := range vms {
+		if rs.Type != "qemu" {
+			continue
+		}
+
+		vm, err := cl.GetVMConfig(ctx, int(rs.VMID))
+		if err != nil {
+			if errors.Is(err, goproxmox.ErrVirtualMachineNotFound) {
+				continue
+			}
+
+			return fmt.Errorf("failed to get vm config: %v", err)
+		}
+
+		for _, disks := range []map[string]string{vm.VirtualMachineConfig.MergeSCSIs(), vm.VirtualMachineConfig.MergeUnuseds()} {
+			for _, disk := range disks {
+				storage, name, _ := strings.Cut(strings.Split(disk, ",")[0], ":")
+				attached[diskKey(volume.NewVolume(region, "", storage, name))] = true
+			}
+		}
+	}
+
+	return nil
+}
+
+// references returns the disks referenced by the persistent volumes and the volume snapshot contents of the driver.
+func (c *Collector) references(ctx context.Context) (map[string]bool, error) {
+	refs := map[string]bool{}
+
+	add := func(handle string) {
+		if vol, err := volume.NewVolumeFromVolumeID(handle); err == nil {
+			refs[diskKey(vol)] = true
+		}
+	}
+
+	pvs, err := c.kclient.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list persistentvolumes: %v", err)
+	}
+
+	for _, pv := range pvs.Items {
+		if pv.Spec.CSI != nil && pv.Spec.CSI.Driver == csi.DriverName {
+			add(pv.Spec.CSI.VolumeHandle)
+		}
+	}
+
+	if c.dclient == nil {
+		return refs, nil
+	}
+
+	contents, err := c.dclient.Resource(VolumeSnapshotContentResource).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		// The snapshot CRDs are not installed
+		if apierrors.IsNotFound(err) {
+			return refs, nil
+		}
+
+		return nil, fmt.Errorf("failed to list volumesnapshotcontents: %v", err)
+	}
+
+	for _, content := range contents.Items {
+		if driver, _, _ := unstructured.NestedString(content.Object, "spec", "driver"); driver != csi.DriverName {
+			continue
+		}
+
+		for _, field := range [][]string{
+			{"spec", "source", "volumeHandle"},
+			{"spec", "source", "snapshotHandle"},
+			{"status", "snapshotHandle"},
+		} {
+			if handle, _, _ := unstructured.NestedString(content.Object, field...); handle != "" {
+				add(handle)
+			}
+		}
+	}
+
+	return refs, nil
+}
+
+// findOrphans returns the disks which are neither referenced nor attached to a virtual machine.
+func findOrphans(disks []Orphan, refs map[string]bool, attached map[string]bool) []Orphan {
+	orphans := []Orphan{}
+
+	for _, disk := range disks {
+		key := diskKey(disk.Volume)
+		if refs[key] || attached[key] {
+			continue
+		}
+
+		orphans = append(orphans, disk)
+	}
+
+	return orphans
+}
+
+// diskKey returns the key of the disk in the region, the node is not a part of it:
+// the disks on shared storages have no node, and the replicas of the disk have the same name on all nodes.
+func diskKey(vol *volume.Volume) string {
+	return vol.Region() + "/" + vol.Storage() + "/" + vol.Disk()
+}
@@ -0,0 +1,231 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	csiconfig "github.com/sergelogvinov/proxmox-csi-plugin/pkg/config"
+	"github.com/sergelogvinov/proxmox-csi-plugin/pkg/csi"
+	pxpool "github.com/sergelogvinov/proxmox-csi-plugin/pkg/proxmoxpool"
+	volume "github.com/sergelogvinov/proxmox-csi-plugin/pkg/utils/volume"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testPV(name, driver, handle string) *corev1.PersistentVolume {
+	return &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: corev1.PersistentVolumeSpec{
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				CSI: &corev1.CSIPersistentVolumeSource{
+					Driver:       driver,
+					VolumeHandle: handle,
+				},
+			},
+		},
+	}
+}
+
+func testVolumeSnapshotContent(name, driver, handle string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{
+		Object: map[string]any{
+			"apiVersion": "snapshot.storage.k8s.io/v1",
+			"kind":       "VolumeSnapshotContent",
+			"metadata": map[string]any{
+				"name": name,
+			},
+			"spec": map[string]any{
+				"driver": driver,
+				"source": map[string]any{
+					"volumeHandle": handle,
+				},
+			},
+		},
+	}
+}
+
+func TestReferences(t *testing.T) {
+	t.Parallel()
+
+	features := csiconfig.ClustersConfig{Features: csiconfig.ClustersFeatures{ControllerVMID: 9999}}
+
+	kclient := fake.NewSimpleClientset(
+		testPV("pvc-1", csi.DriverName, "cluster-1/pve-1/local-lvm/vm-9999-pvc-1"),
+		testPV("pvc-2", csi.DriverName, "cluster-1//shared/vm-9999-pvc-2"),
+		testPV("pvc-3", "other.csi.driver", "cluster-1/pve-1/local-lvm/vm-9999-pvc-3"),
+	)
+
+	scheme := runtime.NewScheme()
+	dclient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(scheme,
+		map[schema.GroupVersionResource]string{VolumeSnapshotContentResource: "VolumeSnapshotContentList"},
+		testVolumeSnapshotContent("snapcontent-1", csi.DriverName, "cluster-1/pve-2/local-lvm/vm-9999-pvc-4"),
+		testVolumeSnapshotContent("snapcontent-2", "other.csi.driver", "cluster-1/pve-2/local-lvm/vm-9999-pvc-5"),
+	)
+
+	tests := []struct {
+		msg       string
+		collector *Collector
+		expected  map[string]bool
+	}{
+		{
+			msg:       "PersistentVolumes",
+			collector: NewCollector(nil, kclient, nil, features),
+			expected: map[string]bool{
+				"cluster-1/local-lvm/vm-9999-pvc-1": true,
+				"cluster-1/shared/vm-9999-pvc-2":    true,
+			},
+		},
+		{
+			msg:       "VolumeSnapshotContents",
+			collector: NewCollector(nil, kclient, dclient, features),
+			expected: map[string]bool{
+				"cluster-1/local-lvm/vm-9999-pvc-1": true,
+				"cluster-1/shared/vm-9999-pvc-2":    true,
+				"cluster-1/local-lvm/vm-9999-pvc-4": true,
+			},
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.msg, func(t *testing.T) {
+			t.Parallel()
+
+			refs, err := testCase.collector.references(context.Background())
+			require.NoError(t, err)
+			assert.Equal(t, testCase.expected, refs)
+		})
+	}
+}
+
+func TestFindOrphans(t *testing.T) {
+	t.Parallel()
+
+	disks := []Orphan{
+		{Volume: volume.NewVolume("cluster-1", "pve-1", "local-lvm", "vm-9999-pvc-1")},
+		{Volume: volume.NewVolume("cluster-1", "pve-2", "local-lvm", "vm-9999-pvc-1")},
+		{Volume: volume.NewVolume("cluster-1", "pve-1", "local-lvm", "vm-9999-pvc-2")},
+		{Volume: volume.NewVolume("cluster-1", "pve-1", "local-lvm", "vm-9999-pvc-3")},
+		{Volume: volume.NewVolume("cluster-2", "pve-1", "local-lvm", "vm-9999-pvc-1")},
+	}
+
+	refs := map[string]bool{"cluster-1/local-lvm/vm-9999-pvc-1": true}
+	attached := map[string]bool{"cluster-1/local-lvm/vm-9999-pvc-2": true}
+
+	orphans := findOrphans(disks, refs, attached)
+	assert.Equal(t, []Orphan{disks[3], disks[4]}, orphans)
+}
+
+func TestOrphanAge(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		msg      string
+		orphan   Orphan
+		expected time.Duration
+	}{
+		{
+			msg:      "UnknownCreation",
+			orphan:   Orphan{FirstSeen: now.Add(-time.Hour)},
+			expected: time.Hour,
+		},
+		{
+			msg:      "CreatedBefore",
+			orphan:   Orphan{FirstSeen: now.Add(-time.Hour), Created: now.Add(-24 * time.Hour)},
+			expected: 24 * time.Hour,
+		},
+		{
+			msg:      "CreatedAfter",
+			orphan:   Orphan{FirstSeen: now.Add(-time.Hour), Created: now.Add(-time.Minute)},
+			expected: time.Hour,
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.msg, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, testCase.expected, testCase.orphan.Age(now))
+		})
+	}
+}
+
+func TestState(t *testing.T) {
+	t.Parallel()
+
+	seen := map[string]time.Time{
+		"cluster-1/local-lvm/vm-9999-pvc-1": time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	kclient := fake.NewSimpleClientset()
+
+	c := NewCollector(nil, kclient, nil, csiconfig.ClustersConfig{})
+
+	state, err := c.loadState(context.Background())
+	require.NoError(t, err)
+	assert.Nil(t, state)
+	require.NoError(t, c.saveState(context.Background(), seen))
+
+	c.stateNamespace = "kube-system"
+	c.stateName = ControllerName
+
+	state, err = c.loadState(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]time.Time{}, state)
+
+	require.NoError(t, c.saveState(context.Background(), seen))
+	require.NoError(t, c.saveState(context.Background(), seen))
+
+	// The new collector, after the restart of the controller, has the same times
+	restarted := NewCollector(nil, kclient, nil, csiconfig.ClustersConfig{})
+	restarted.stateNamespace = "kube-system"
+	restarted.stateName = ControllerName
+
+	state, err = restarted.loadState(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, seen, state)
+}
+
+func TestMinGracePeriod(t *testing.T) {
+	t.Parallel()
+
+	cfg := csiconfig.ClustersConfig{
+		Features: csiconfig.ClustersFeatures{CopyTaskTimeout: 6 * time.Hour},
+		Clusters: []*pxpool.ProxmoxCluster{{Region: "cluster-1"}, {Region: "cluster-2"}},
+		ClusterFeatures: map[string]csiconfig.ClustersFeatures{
+			"cluster-2": {CopyTaskTimeout: 12 * time.Hour},
+		},
+	}
+
+	assert.Equal(t, 12*time.Hour, MinGracePeriod(cfg))
+
+	delete(cfg.ClusterFeatures, "cluster-2")
+	assert.Equal(t, 6*time.Hour, MinGracePeriod(cfg))
+}
@@ -0,0 +1,137 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gc
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	csiconfig "github.com/sergelogvinov/proxmox-csi-plugin/pkg/config"
+	"github.com/sergelogvinov/proxmox-csi-plugin/pkg/metrics"
+	pxpool "github.com/sergelogvinov/proxmox-csi-plugin/pkg/proxmoxpool"
+
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+// ControllerName is the name of the lease and of the ConfigMap with the state of the orphaned disk collector.
+const ControllerName = "proxmox-csi-orphan-gc"
+
+// Controller reports the orphaned disks periodically, and deletes them after the grace period if the deletion is enabled.
+type Controller struct {
+	collector *Collector
+
+	interval    time.Duration
+	gracePeriod time.Duration
+	delete      bool
+}
+
+// NewController returns a new controller of the orphaned disks.
+// The times when the disks have been found are kept in the ConfigMap in the namespace.
+func NewController(kclient kubernetes.Interface, dclient dynamic.Interface, cloudConfig, namespace string, interval, gracePeriod time.Duration, deleteOrphans bool) (*Controller, error) {
+	cfg, err := csiconfig.ReadCloudConfigFromFile(cloudConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %v", err)
+	}
+
+	if minGracePeriod := MinGracePeriod(cfg); deleteOrphans && gracePeriod < minGracePeriod {
+		return nil, fmt.Errorf("grace period %s is shorter than the copy task timeout %s", gracePeriod, minGracePeriod)
+	}
+
+	px, err := pxpool.NewProxmoxPool(cfg.Clusters)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create proxmox cluster client: %v", err)
+	}
+
+	collector := NewCollector(px, kclient, dclient, cfg)
+	collector.stateNamespace = namespace
+	collector.stateName = ControllerName
+
+	return &Controller{
+		collector:   collector,
+		interval:    interval,
+		gracePeriod: gracePeriod,
+		delete:      deleteOrphans,
+	}, nil
+}
+
+// MinGracePeriod returns the longest copy task timeout of the regions.
+// The disk which is being copied is not referenced yet, so it must not be deleted before the copy times out.
+func MinGracePeriod(cfg csiconfig.ClustersConfig) time.Duration {
+	period := cfg.Features.CopyTaskTimeout
+
+	for _, cluster := range cfg.Clusters {
+		period = max(period, cfg.RegionFeatures(cluster.Region).CopyTaskTimeout)
+	}
+
+	return period
+}
+
+// Run collects the orphaned disks every interval until the context is done.
+// Only one replica may run the controller, see tools.RunWithLease.
+func (c *Controller) Run(ctx context.Context) {
+	defer utilruntime.HandleCrash()
+
+	klog.InfoS("Starting orphaned disk collector", "interval", c.interval, "gracePeriod", c.gracePeriod, "delete", c.delete)
+
+	wait.UntilWithContext(ctx, c.collect, c.interval)
+
+	klog.InfoS("Shutting down orphaned disk collector")
+}
+
+func (c *Controller) collect(ctx context.Context) {
+	orphans, err := c.collector.Orphans(ctx)
+	if err != nil {
+		klog.ErrorS(err, "Failed to find orphaned disks")
+
+		return
+	}
+
+	now := time.Now()
+	count := map[string]int{}
+
+	for _, orphan := range orphans {
+		vol := orphan.Volume
+		age := orphan.Age(now)
+
+		count[vol.Region()]++
+
+		if !c.delete || age < c.gracePeriod {
+			klog.InfoS("Found orphaned disk", "cluster", vol.Cluster(), "node", vol.Node(), "volID", vol.VolID(), "size", orphan.Size, "age", age.Round(time.Second))
+
+			continue
+		}
+
+		if err := c.collector.Delete(ctx, orphan); err != nil {
+			klog.ErrorS(err, "Failed to delete orphaned disk", "cluster", vol.Cluster(), "node", vol.Node(), "volID", vol.VolID())
+
+			continue
+		}
+
+		count[vol.Region()]--
+
+		klog.InfoS("Orphaned disk deleted", "cluster", vol.Cluster(), "node", vol.Node(), "volID", vol.VolID(), "size", orphan.Size, "age", age.Round(time.Second))
+	}
+
+	for _, region := range c.collector.pxpool.GetRegions() {
+		metrics.ObserveOrphanedDisks(region, count[region])
+	}
+}
@@ -25,8 +25,10 @@ import (
 
 // CSIMetrics contains the metrics for Talos API calls.
 type CSIMetrics struct {
-	Duration *metrics.HistogramVec
-	Errors   *metrics.CounterVec
+	Duration     *metrics.HistogramVec
+	Errors       *metrics.CounterVec
+	CopyProgress *metrics.GaugeVec
+	Orphans      *metrics.GaugeVec
 }
 
 var apiMetrics = registerAPIMetrics()
@@ -43,6 +45,21 @@ func (mc *MetricContext) ObserveRequest(err error) error {
 	return err
 }
 
+// ObserveCopyProgress records the progress of the volume copy in percents.
+func ObserveCopyProgress(volume string, percent float64) {
+	apiMetrics.CopyProgress.WithLabelValues(volume).Set(percent)
+}
+
+// DeleteCopyProgress removes the progress of the finished volume copy.
+func DeleteCopyProgress(volume string) {
+	apiMetrics.CopyProgress.DeleteLabelValues(volume)
+}
+
+// ObserveOrphanedDisks records the number of the orphaned disks in the region.
+func ObserveOrphanedDisks(region string, count int) {
+	apiMetrics.Orphans.WithLabelValues(region).Set(float64(count))
+}
+
 func registerAPIMetrics() *CSIMetrics {
 	metrics := &CSIMetrics{
 		Duration: metrics.NewHistogramVec(
@@ -56,11 +73,23 @@ func registerAPIMetrics() *CSIMetrics {
 				Name: "proxmox_api_request_errors_total",
 				Help: "Total number of errors for an Proxmox API call",
 			}, []string{"request"}),
+		CopyProgress: metrics.NewGaugeVec(
+			&metrics.GaugeOpts{
+				Name: "proxmox_volume_copy_progress_percent",
+				Help: "Progress of the volume copy between Proxmox storages or clusters",
+			}, []string{"volume"}),
+		Orphans: metrics.NewGaugeVec(
+			&metrics.GaugeOpts{
+				Name: "proxmox_orphaned_disks",
+				Help: "Number of the disks of the controller which are not referenced by any persistent volume or volume snapshot",
+			}, []string{"region"}),
 	}
 
 	legacyregistry.MustRegister(
 		metrics.Duration,
 		metrics.Errors,
+		metrics.CopyProgress,
+		metrics.Orphans,
 	)
 
 	return metrics
@@ -0,0 +1,199 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package migration implements the controller which moves local persistent volumes
+// between Proxmox nodes when the PersistentVolumeClaim is annotated.
+package migration
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	csiconfig "github.com/sergelogvinov/proxmox-csi-plugin/pkg/config"
+	pxpool "github.com/sergelogvinov/proxmox-csi-plugin/pkg/proxmoxpool"
+
+	corev1 "k8s.io/api/core/v1"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// ControllerName is the name of the migration controller, used as the event source.
+	ControllerName = "proxmox-csi-migration"
+
+	informerResync = 10 * time.Minute
+)
+
+// Controller migrates persistent volumes to the Proxmox node set in the PersistentVolumeClaim annotation.
+type Controller struct {
+	pxpool   *pxpool.ProxmoxPool
+	kclient  kubernetes.Interface
+	recorder record.EventRecorder
+
+	factory  informers.SharedInformerFactory
+	pvLister corelisters.PersistentVolumeLister
+	synced   []cache.InformerSynced
+
+	queue workqueue.TypedRateLimitingInterface[string]
+}
+
+// NewController returns a new migration controller
+func NewController(kclient kubernetes.Interface, cloudConfig string) (*Controller, error) {
+	cfg, err := csiconfig.ReadCloudConfigFromFile(cloudConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %v", err)
+	}
+
+	for _, c := range cfg.Clusters {
+		if c.Username == "" || c.Password == "" {
+			return nil, fmt.Errorf("migration requires Proxmox root account, please provide username and password in config file (cluster=%s)", c.Region)
+		}
+	}
+
+	px, err := pxpool.NewProxmoxPool(cfg.Clusters)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create proxmox cluster client: %v", err)
+	}
+
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kclient.CoreV1().Events("")})
+
+	return newController(kclient, px, broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: ControllerName}))
+}
+
+func newController(kclient kubernetes.Interface, px *pxpool.ProxmoxPool, recorder record.EventRecorder) (*Controller, error) {
+	factory := informers.NewSharedInformerFactory(kclient, informerResync)
+	pvcInformer := factory.Core().V1().PersistentVolumeClaims()
+	pvInformer := factory.Core().V1().PersistentVolumes()
+
+	c := &Controller{
+		pxpool:   px,
+		kclient:  kclient,
+		recorder: recorder,
+		factory:  factory,
+		pvLister: pvInformer.Lister(),
+		synced:   []cache.InformerSynced{pvcInformer.Informer().HasSynced, pvInformer.Informer().HasSynced},
+		queue: workqueue.NewTypedRateLimitingQueueWithConfig(
+			workqueue.DefaultTypedControllerRateLimiter[string](),
+			workqueue.TypedRateLimitingQueueConfig[string]{Name: ControllerName},
+		),
+	}
+
+	if _, err := pvcInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.enqueueClaim,
+		UpdateFunc: func(_, obj interface{}) { c.enqueueClaim(obj) },
+	}); err != nil {
+		return nil, fmt.Errorf("failed to add persistentvolumeclaims event handler: %v", err)
+	}
+
+	if _, err := pvInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.enqueueVolume,
+		UpdateFunc: func(_, obj interface{}) { c.enqueueVolume(obj) },
+	}); err != nil {
+		return nil, fmt.Errorf("failed to add persistentvolumes event handler: %v", err)
+	}
+
+	return c, nil
+}
+
+// Run starts the controller and blocks until the context is done.
+// Only one replica may run the controller, see tools.RunWithLease.
+func (c *Controller) Run(ctx context.Context) {
+	defer utilruntime.HandleCrash()
+	defer c.queue.ShutDown()
+
+	klog.InfoS("Starting migration controller")
+
+	c.factory.Start(ctx.Done())
+
+	if !cache.WaitForCacheSync(ctx.Done(), c.synced...) {
+		klog.ErrorS(nil, "Failed to sync migration controller caches")
+
+		return
+	}
+
+	go wait.UntilWithContext(ctx, c.runWorker, time.Second)
+
+	<-ctx.Done()
+
+	klog.InfoS("Shutting down migration controller")
+}
+
+func (c *Controller) runWorker(ctx context.Context) {
+	for c.processNextItem(ctx) {
+	}
+}
+
+func (c *Controller) processNextItem(ctx context.Context) bool {
+	key, quit := c.queue.Get()
+	if quit {
+		return false
+	}
+
+	defer c.queue.Done(key)
+
+	requeue, err := c.sync(ctx, key)
+	if err != nil {
+		klog.ErrorS(err, "Failed to migrate persistentvolumeclaim", "pvc", key)
+
+		c.queue.AddRateLimited(key)
+
+		return true
+	}
+
+	c.queue.Forget(key)
+
+	if requeue > 0 {
+		c.queue.AddAfter(key, requeue)
+	}
+
+	return true
+}
+
+func (c *Controller) enqueueClaim(obj interface{}) {
+	pvc, ok := obj.(*corev1.PersistentVolumeClaim)
+	if !ok || pvc.Annotations[MigrateNodeAnnotation] == "" {
+		return
+	}
+
+	key, err := cache.MetaNamespaceKeyFunc(pvc)
+	if err != nil {
+		utilruntime.HandleError(err)
+
+		return
+	}
+
+	c.queue.Add(key)
+}
+
+func (c *Controller) enqueueVolume(obj interface{}) {
+	pv, ok := obj.(*corev1.PersistentVolume)
+	if !ok || pv.Annotations[migrateClaimAnnotation] == "" || pv.Spec.ClaimRef == nil {
+		return
+	}
+
+	c.queue.Add(pv.Spec.ClaimRef.Namespace + "/" + pv.Spec.ClaimRef.Name)
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package migration
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+	proxmox "github.com/luthermonson/go-proxmox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	pxpool "github.com/sergelogvinov/proxmox-csi-plugin/pkg/proxmoxpool"
+	tools "github.com/sergelogvinov/proxmox-csi-plugin/pkg/tools/kubernetes"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestRunWithLease(t *testing.T) {
+	httpmock.Activate()
+	t.Cleanup(httpmock.DeactivateAndReset)
+
+	httpmock.RegisterResponder(http.MethodGet, `=~/nodes$`,
+		httpmock.NewJsonResponderOrPanic(200, map[string]any{"data": []proxmox.NodeStatus{{Node: "pve-1"}, {Node: "pve-2"}}}))
+	httpmock.RegisterResponder(http.MethodGet, `=~/nodes/pve-2/storage/local-lvm/content$`,
+		httpmock.NewJsonResponderOrPanic(200, map[string]any{"data": []proxmox.StorageContent{}}))
+	// The copy request is slow, so two workers without the lease would both start the copy.
+	httpmock.RegisterResponder(http.MethodPost, `=~/nodes/pve-1/storage/local-lvm/content/vm-9999-pvc-123$`,
+		func(_ *http.Request) (*http.Response, error) {
+			time.Sleep(500 * time.Millisecond)
+
+			return httpmock.NewJsonResponse(200, map[string]any{"data": "UPID:pve-1:003B4235:1DF4ABCA:667C1C45:imgcopy::root@pam:"})
+		})
+
+	px, err := pxpool.NewProxmoxPool([]*pxpool.ProxmoxCluster{{
+		URL:         "https://127.0.0.1:8006/api2/json",
+		TokenID:     "user!token",
+		TokenSecret: "secret",
+		Region:      "cluster-1",
+	}})
+	require.NoError(t, err)
+
+	pvc, pv := testResources()
+	delete(pvc.Annotations, migrateTaskAnnotation)
+	delete(pvc.Annotations, migrateCordonedAnnotation)
+
+	kclient := fake.NewClientset(pvc, pv)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var wg sync.WaitGroup
+
+	// Both workers receive the same persistentvolumeclaim, only the lease holder copies the disk.
+	for i := range 2 {
+		c, err := newController(kclient, px, record.NewFakeRecorder(10))
+		require.NoError(t, err)
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			assert.NoError(t, tools.RunWithLease(ctx, kclient, tools.LeaseConfig{
+				Namespace:     "kube-system",
+				Name:          ControllerName,
+				Identity:      fmt.Sprintf("worker-%d", i),
+				LeaseDuration: 2 * time.Second,
+				RenewDeadline: time.Second,
+				RetryPeriod:   100 * time.Millisecond,
+			}, c.Run))
+		}()
+	}
+
+	require.Eventually(t, func() bool {
+		res, err := kclient.CoreV1().PersistentVolumeClaims(pvc.Namespace).Get(ctx, pvc.Name, metav1.GetOptions{})
+
+		return err == nil && res.Annotations[migrateTaskAnnotation] != ""
+	}, 10*time.Second, 50*time.Millisecond)
+
+	// Give the second worker the time to act if it has the lease too.
+	time.Sleep(time.Second)
+
+	assert.Equal(t, 1, httpmock.GetCallCountInfo()["POST =~/nodes/pve-1/storage/local-lvm/content/vm-9999-pvc-123$"])
+
+	lease, err := kclient.CoordinationV1().Leases("kube-system").Get(ctx, ControllerName, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Contains(t, []string{"worker-0", "worker-1"}, *lease.Spec.HolderIdentity)
+
+	cancel()
+	wg.Wait()
+}
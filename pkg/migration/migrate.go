@@ -0,0 +1,586 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package migration
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/luthermonson/go-proxmox"
+
+	goproxmox "github.com/sergelogvinov/go-proxmox"
+	"github.com/sergelogvinov/proxmox-csi-plugin/pkg/csi"
+	tools "github.com/sergelogvinov/proxmox-csi-plugin/pkg/tools/kubernetes"
+	toolsproxmox "github.com/sergelogvinov/proxmox-csi-plugin/pkg/tools/proxmox"
+	volume "github.com/sergelogvinov/proxmox-csi-plugin/pkg/utils/volume"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// MigrateNodeAnnotation is the Proxmox node the volume of the PersistentVolumeClaim has to be moved to.
+	MigrateNodeAnnotation = csi.DriverName + "/migrate-node"
+	// MigrateAnnotation set to "force" allows the controller to terminate the pods which use the volume.
+	MigrateAnnotation = csi.DriverName + "/migrate"
+
+	// MigrationCondition is the PersistentVolumeClaim condition type which reports the migration progress.
+	MigrationCondition corev1.PersistentVolumeClaimConditionType = "Migrating"
+
+	// Migration condition reasons, they are also used as event reasons.
+	ReasonWaitingForPods   = "WaitingForPods"
+	ReasonWaitingForDetach = "WaitingForDetach"
+	ReasonCopyingDisk      = "CopyingDisk"
+	ReasonReplacingVolume  = "ReplacingVolume"
+	ReasonMigrated         = "Migrated"
+	ReasonFailed           = "MigrationFailed"
+
+	migrateForce = "force"
+
+	// The progress of the migration is kept in the objects, so the migration can be resumed after a restart.
+	migrateTaskAnnotation     = csi.DriverName + "/migrate-task"
+	migrateCordonedAnnotation = csi.DriverName + "/migrate-cordoned"
+	migrateClaimAnnotation    = csi.DriverName + "/migrate-claim"
+	migrateFromLabel          = csi.DriverName + "/migrate-from"
+
+	pollInterval = 30 * time.Second
+	waitInterval = 2 * time.Second
+)
+
+func (c *Controller) sync(ctx context.Context, key string) (time.Duration, error) {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return 0, nil //nolint: nilerr
+	}
+
+	newPV, err := c.replacementVolume(ctx, namespace, name)
+	if err != nil {
+		return 0, err
+	}
+
+	if newPV != nil {
+		return c.finishMigration(ctx, namespace, name, newPV)
+	}
+
+	// The migration progress is kept in the annotations, so the claim is read from the API server instead of the cache.
+	pvc, err := c.kclient.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return 0, nil
+		}
+
+		return 0, err
+	}
+
+	if pvc.DeletionTimestamp != nil || pvc.Annotations[MigrateNodeAnnotation] == "" {
+		return 0, nil
+	}
+
+	return c.migrate(ctx, pvc)
+}
+
+// nolint: cyclop, gocyclo
+func (c *Controller) migrate(ctx context.Context, pvc *corev1.PersistentVolumeClaim) (time.Duration, error) {
+	node := pvc.Annotations[MigrateNodeAnnotation]
+
+	klog.V(4).InfoS("migrate: called", "pvc", klog.KObj(pvc), "node", node)
+
+	if pvc.Spec.VolumeName == "" {
+		return c.fail(ctx, pvc, "persistentvolumeclaim is not bound")
+	}
+
+	pv, err := c.pvLister.Get(pvc.Spec.VolumeName)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get persistentvolume %s: %v", pvc.Spec.VolumeName, err)
+	}
+
+	if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != csi.DriverName {
+		return c.fail(ctx, pvc, fmt.Sprintf("persistentvolume %s is not provisioned by Proxmox CSI driver", pv.Name))
+	}
+
+	vol, err := volume.NewVolumeFromVolumeID(pv.Spec.CSI.VolumeHandle)
+	if err != nil {
+		return c.fail(ctx, pvc, fmt.Sprintf("failed to parse volume ID: %v", err))
+	}
+
+	if vol.Node() == "" {
+		return c.fail(ctx, pvc, fmt.Sprintf("volume %s is on shared storage, it does not need migration", vol.VolID()))
+	}
+
+	if vol.Node() == node {
+		c.recorder.Eventf(pvc, corev1.EventTypeNormal, ReasonMigrated, "Volume is already on proxmox node %s", node)
+
+		return 0, c.patchAnnotations(ctx, pvc, map[string]interface{}{MigrateNodeAnnotation: nil, MigrateAnnotation: nil})
+	}
+
+	cluster, err := c.pxpool.GetProxmoxCluster(vol.Cluster())
+	if err != nil {
+		return c.fail(ctx, pvc, fmt.Sprintf("failed to get proxmox cluster %s: %v", vol.Cluster(), err))
+	}
+
+	nodes, err := cluster.GetNodeList(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get node list: %v", err)
+	}
+
+	if !slices.Contains(nodes, node) {
+		return c.fail(ctx, pvc, fmt.Sprintf("proxmox node %s not found in cluster %s", node, vol.Cluster()))
+	}
+
+	pods, _, err := tools.PVCPodUsage(ctx, c.kclient, pvc.Namespace, pvc.Name)
+	if err != nil {
+		return 0, fmt.Errorf("failed to find pods using pvc: %v", err)
+	}
+
+	if len(pods) > 0 {
+		if pvc.Annotations[MigrateAnnotation] != migrateForce {
+			return pollInterval, c.setCondition(ctx, pvc, ReasonWaitingForPods,
+				fmt.Sprintf("Waiting for pods %s to terminate", strings.Join(pods, ",")))
+		}
+
+		if err = c.cordonNodes(ctx, pvc); err != nil {
+			return 0, err
+		}
+
+		for _, pod := range pods {
+			if err = c.kclient.CoreV1().Pods(pvc.Namespace).Delete(ctx, pod, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+				return 0, fmt.Errorf("failed to delete pod %s: %v", pod, err)
+			}
+		}
+
+		return waitInterval, c.setCondition(ctx, pvc, ReasonWaitingForPods,
+			fmt.Sprintf("Terminating pods %s", strings.Join(pods, ",")))
+	}
+
+	attached, err := c.volumeAttached(ctx, pv.Name)
+	if err != nil {
+		return 0, err
+	}
+
+	if attached {
+		return waitInterval, c.setCondition(ctx, pvc, ReasonWaitingForDetach, "Waiting for volume to be detached")
+	}
+
+	if upid := pvc.Annotations[migrateTaskAnnotation]; upid != "" {
+		task := proxmox.NewTask(proxmox.UPID(upid), cluster.Client)
+		if err = task.Ping(ctx); err != nil {
+			return 0, fmt.Errorf("failed to get task %s status: %v", upid, err)
+		}
+
+		if task.IsRunning {
+			return pollInterval, c.setCondition(ctx, pvc, ReasonCopyingDisk,
+				fmt.Sprintf("Copying disk %s to proxmox node %s", vol.Disk(), node))
+		}
+
+		if task.IsFailed {
+			if err = cluster.DeleteVMDisk(ctx, node, vol.Storage(), vol.Disk()); err != nil {
+				klog.ErrorS(err, "Failed to delete incomplete disk copy", "pvc", klog.KObj(pvc), "node", node, "volumeID", vol.VolID())
+			}
+
+			return c.fail(ctx, pvc, fmt.Sprintf("failed to copy disk %s to proxmox node %s: %s", vol.Disk(), node, task.ExitStatus))
+		}
+	} else {
+		exists, err := volumeExists(ctx, cluster, node, vol)
+		if err != nil {
+			return 0, err
+		}
+
+		if exists {
+			return c.fail(ctx, pvc, fmt.Sprintf("disk %s already exists on proxmox node %s", vol.Disk(), node))
+		}
+
+		upid, err := toolsproxmox.CopyQemuDisk(ctx, cluster, vol, volume.NewVolume(vol.Region(), node, vol.Storage(), vol.Disk()))
+		if err != nil {
+			return 0, err
+		}
+
+		klog.InfoS("Copying volume", "pvc", klog.KObj(pvc), "volumeID", vol.VolumeID(), "node", node, "task", upid)
+
+		if err = c.patchAnnotations(ctx, pvc, map[string]interface{}{migrateTaskAnnotation: string(upid)}); err != nil {
+			return 0, err
+		}
+
+		return pollInterval, c.setCondition(ctx, pvc, ReasonCopyingDisk,
+			fmt.Sprintf("Copying disk %s to proxmox node %s", vol.Disk(), node))
+	}
+
+	newPV, err := replacementResources(pvc, pv, vol, node)
+	if err != nil {
+		return c.fail(ctx, pvc, err.Error())
+	}
+
+	if err = c.setCondition(ctx, pvc, ReasonReplacingVolume,
+		fmt.Sprintf("Replacing persistentvolume %s with %s", pv.Name, newPV.Name)); err != nil {
+		return 0, err
+	}
+
+	if _, err = c.kclient.CoreV1().PersistentVolumes().Create(ctx, newPV, metav1.CreateOptions{}); err != nil {
+		return 0, fmt.Errorf("failed to create persistentvolume %s: %v", newPV.Name, err)
+	}
+
+	klog.InfoS("Replacing persistentvolume", "pvc", klog.KObj(pvc), "pv", pv.Name, "newPV", newPV.Name)
+
+	return c.finishMigration(ctx, pvc.Namespace, pvc.Name, newPV)
+}
+
+// finishMigration replaces the PersistentVolumeClaim bound to the old volume with the claim
+// kept in the annotation of the new volume. It is safe to call it several times.
+func (c *Controller) finishMigration(ctx context.Context, namespace, name string, newPV *corev1.PersistentVolume) (time.Duration, error) {
+	oldPV := newPV.Labels[migrateFromLabel]
+
+	pvc, err := c.kclient.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return 0, fmt.Errorf("failed to get persistentvolumeclaim: %v", err)
+	}
+
+	if err == nil && pvc.Spec.VolumeName == oldPV {
+		if pvc.DeletionTimestamp == nil {
+			err = c.kclient.CoreV1().PersistentVolumeClaims(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+			if err != nil && !apierrors.IsNotFound(err) {
+				return 0, fmt.Errorf("failed to delete persistentvolumeclaim: %v", err)
+			}
+		}
+
+		return waitInterval, nil
+	}
+
+	// The volume with the Delete reclaim policy is deleted by the provisioner together with the source disk.
+	pv, err := c.kclient.CoreV1().PersistentVolumes().Get(ctx, oldPV, metav1.GetOptions{})
+	if err == nil && pv.DeletionTimestamp == nil && pv.Spec.PersistentVolumeReclaimPolicy != corev1.PersistentVolumeReclaimDelete {
+		if err = c.kclient.CoreV1().PersistentVolumes().Delete(ctx, oldPV, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			return 0, fmt.Errorf("failed to delete persistentvolume %s: %v", oldPV, err)
+		}
+	} else if err != nil && !apierrors.IsNotFound(err) {
+		return 0, fmt.Errorf("failed to get persistentvolume %s: %v", oldPV, err)
+	}
+
+	newPVC := &corev1.PersistentVolumeClaim{}
+	if err = json.Unmarshal([]byte(newPV.Annotations[migrateClaimAnnotation]), newPVC); err != nil {
+		return 0, fmt.Errorf("failed to unmarshal persistentvolumeclaim from persistentvolume %s: %v", newPV.Name, err)
+	}
+
+	pvc, err = tools.PVCCreateOrUpdate(ctx, c.kclient, newPVC)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create/update persistentvolumeclaim: %v", err)
+	}
+
+	if pvc.Spec.VolumeName != newPV.Name {
+		return 0, fmt.Errorf("persistentvolumeclaim %s/%s is bound to persistentvolume %s", namespace, name, pvc.Spec.VolumeName)
+	}
+
+	if nodes := newPV.Annotations[migrateCordonedAnnotation]; nodes != "" {
+		if err = tools.UncondonNodes(ctx, c.kclient, strings.Split(nodes, ",")); err != nil {
+			return 0, err
+		}
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels":      map[string]interface{}{migrateFromLabel: nil},
+			"annotations": map[string]interface{}{migrateClaimAnnotation: nil, migrateCordonedAnnotation: nil},
+		},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err = c.kclient.CoreV1().PersistentVolumes().Patch(ctx, newPV.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		return 0, fmt.Errorf("failed to patch persistentvolume %s: %v", newPV.Name, err)
+	}
+
+	c.recorder.Eventf(pvc, corev1.EventTypeNormal, ReasonMigrated, "Volume has been migrated to persistentvolume %s", newPV.Name)
+
+	klog.InfoS("Persistentvolumeclaim has been migrated", "pvc", klog.KObj(pvc), "pv", newPV.Name)
+
+	return 0, nil
+}
+
+// fail stops the migration, the annotations are removed so the migration is not retried.
+func (c *Controller) fail(ctx context.Context, pvc *corev1.PersistentVolumeClaim, message string) (time.Duration, error) {
+	klog.ErrorS(nil, "Failed to migrate persistentvolumeclaim", "pvc", klog.KObj(pvc), "reason", message)
+
+	if err := c.setCondition(ctx, pvc, ReasonFailed, message); err != nil {
+		return 0, err
+	}
+
+	if nodes := pvc.Annotations[migrateCordonedAnnotation]; nodes != "" {
+		if err := tools.UncondonNodes(ctx, c.kclient, strings.Split(nodes, ",")); err != nil {
+			return 0, err
+		}
+	}
+
+	return 0, c.patchAnnotations(ctx, pvc, map[string]interface{}{
+		MigrateNodeAnnotation:     nil,
+		MigrateAnnotation:         nil,
+		migrateTaskAnnotation:     nil,
+		migrateCordonedAnnotation: nil,
+	})
+}
+
+// setCondition updates the migration condition of the PersistentVolumeClaim and records an event when the reason changes.
+func (c *Controller) setCondition(ctx context.Context, pvc *corev1.PersistentVolumeClaim, reason, message string) error {
+	cond := corev1.PersistentVolumeClaimCondition{
+		Type:               MigrationCondition,
+		Status:             corev1.ConditionTrue,
+		Reason:             reason,
+		Message:            message,
+		LastTransitionTime: metav1.Now(),
+	}
+
+	eventType := corev1.EventTypeNormal
+
+	if reason == ReasonFailed {
+		cond.Status = corev1.ConditionFalse
+		eventType = corev1.EventTypeWarning
+	}
+
+	conditions := []corev1.PersistentVolumeClaimCondition{}
+	changed := true
+
+	for _, c := range pvc.Status.Conditions {
+		if c.Type != MigrationCondition {
+			conditions = append(conditions, c)
+
+			continue
+		}
+
+		if c.Reason == reason {
+			if c.Message == message {
+				return nil
+			}
+
+			cond.LastTransitionTime = c.LastTransitionTime
+			changed = false
+		}
+	}
+
+	pvc.Status.Conditions = append(conditions, cond)
+
+	res, err := c.kclient.CoreV1().PersistentVolumeClaims(pvc.Namespace).UpdateStatus(ctx, pvc, metav1.UpdateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to update persistentvolumeclaim status: %v", err)
+	}
+
+	if changed {
+		c.recorder.Event(res, eventType, reason, message)
+	}
+
+	res.DeepCopyInto(pvc)
+
+	return nil
+}
+
+func (c *Controller) patchAnnotations(ctx context.Context, pvc *corev1.PersistentVolumeClaim, annotations map[string]interface{}) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": annotations},
+	})
+	if err != nil {
+		return err
+	}
+
+	res, err := c.kclient.CoreV1().PersistentVolumeClaims(pvc.Namespace).Patch(ctx, pvc.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to patch persistentvolumeclaim: %v", err)
+	}
+
+	res.DeepCopyInto(pvc)
+
+	return nil
+}
+
+// cordonNodes cordons the nodes with the CSI driver, so the terminated pods are not started again with the old volume.
+// The list of the cordoned nodes is stored before cordoning, so the nodes are uncordoned even after a restart.
+func (c *Controller) cordonNodes(ctx context.Context, pvc *corev1.PersistentVolumeClaim) error {
+	if _, ok := pvc.Annotations[migrateCordonedAnnotation]; ok {
+		return nil
+	}
+
+	csiNodes, err := tools.CSINodes(ctx, c.kclient, csi.DriverName)
+	if err != nil {
+		return err
+	}
+
+	nodes := []string{}
+
+	for _, name := range csiNodes {
+		node, err := c.kclient.CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to get node %s: %v", name, err)
+		}
+
+		if !node.Spec.Unschedulable {
+			nodes = append(nodes, name)
+		}
+	}
+
+	if err = c.patchAnnotations(ctx, pvc, map[string]interface{}{migrateCordonedAnnotation: strings.Join(nodes, ",")}); err != nil {
+		return err
+	}
+
+	klog.InfoS("Cordoning nodes", "pvc", klog.KObj(pvc), "nodes", nodes)
+
+	if _, err = tools.CondonNodes(ctx, c.kclient, nodes); err != nil {
+		return fmt.Errorf("failed to cordon nodes: %v", err)
+	}
+
+	return nil
+}
+
+func (c *Controller) volumeAttached(ctx context.Context, pvName string) (bool, error) {
+	vas, err := c.kclient.StorageV1().VolumeAttachments().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return false, fmt.Errorf("failed to list volumeattachments: %v", err)
+	}
+
+	for _, va := range vas.Items {
+		if va.Spec.Source.PersistentVolumeName != nil && *va.Spec.Source.PersistentVolumeName == pvName {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// replacementVolume returns the new PersistentVolume of the interrupted migration of the PersistentVolumeClaim.
+func (c *Controller) replacementVolume(ctx context.Context, namespace, name string) (*corev1.PersistentVolume, error) {
+	pvs, err := c.kclient.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{LabelSelector: migrateFromLabel})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list persistentvolumes: %v", err)
+	}
+
+	for i := range pvs.Items {
+		pv := &pvs.Items[i]
+
+		if pv.Annotations[migrateClaimAnnotation] != "" && pv.Spec.ClaimRef != nil &&
+			pv.Spec.ClaimRef.Namespace == namespace && pv.Spec.ClaimRef.Name == name {
+			return pv, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// replacementResources returns the new PersistentVolume on the target node.
+// The new PersistentVolumeClaim is stored in the annotation of the volume until it is created.
+func replacementResources(
+	pvc *corev1.PersistentVolumeClaim,
+	pv *corev1.PersistentVolume,
+	vol *volume.Volume,
+	node string,
+) (*corev1.PersistentVolume, error) {
+	newPV := pv.DeepCopy()
+	newPV.ObjectMeta = metav1.ObjectMeta{
+		Name:        "pvc-" + string(uuid.NewUUID()),
+		Labels:      newPV.Labels,
+		Annotations: newPV.Annotations,
+		Finalizers:  newPV.Finalizers,
+	}
+
+	newPVC := pvc.DeepCopy()
+	newPVC.ObjectMeta = metav1.ObjectMeta{
+		Name:            pvc.Name,
+		Namespace:       pvc.Namespace,
+		Labels:          newPVC.Labels,
+		Annotations:     newPVC.Annotations,
+		Finalizers:      newPVC.Finalizers,
+		OwnerReferences: newPVC.OwnerReferences,
+	}
+	newPVC.Status = corev1.PersistentVolumeClaimStatus{}
+	newPVC.Spec.VolumeName = newPV.Name
+	newPVC.Spec.Resources.Requests = corev1.ResourceList{
+		corev1.ResourceStorage: pvc.Status.Capacity[corev1.ResourceStorage],
+	}
+
+	for _, key := range []string{MigrateNodeAnnotation, MigrateAnnotation, migrateTaskAnnotation, migrateCordonedAnnotation} {
+		delete(newPVC.Annotations, key)
+	}
+
+	claim, err := json.Marshal(newPVC)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal persistentvolumeclaim: %v", err)
+	}
+
+	if newPV.Labels == nil {
+		newPV.Labels = map[string]string{}
+	}
+
+	if newPV.Annotations == nil {
+		newPV.Annotations = map[string]string{}
+	}
+
+	newPV.Labels[migrateFromLabel] = pv.Name
+	newPV.Annotations[migrateClaimAnnotation] = string(claim)
+
+	if nodes, ok := pvc.Annotations[migrateCordonedAnnotation]; ok && nodes != "" {
+		newPV.Annotations[migrateCordonedAnnotation] = nodes
+	}
+
+	newPV.Status = corev1.PersistentVolumeStatus{}
+	newPV.Spec.ClaimRef = &corev1.ObjectReference{
+		Kind:       "PersistentVolumeClaim",
+		APIVersion: "v1",
+		Namespace:  pvc.Namespace,
+		Name:       pvc.Name,
+	}
+	newPV.Spec.CSI.VolumeHandle = volume.NewVolume(vol.Region(), node, vol.Storage(), vol.Disk()).VolumeID()
+	newPV.Spec.NodeAffinity = &corev1.VolumeNodeAffinity{
+		Required: &corev1.NodeSelector{
+			NodeSelectorTerms: []corev1.NodeSelectorTerm{
+				{
+					MatchExpressions: []corev1.NodeSelectorRequirement{
+						{
+							Key:      corev1.LabelTopologyRegion,
+							Operator: corev1.NodeSelectorOpIn,
+							Values:   []string{vol.Region()},
+						},
+						{
+							Key:      corev1.LabelTopologyZone,
+							Operator: corev1.NodeSelectorOpIn,
+							Values:   []string{node},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	return newPV, nil
+}
+
+func volumeExists(ctx context.Context, cluster *goproxmox.APIClient, node string, vol *volume.Volume) (bool, error) {
+	contents, err := cluster.GetStorageContent(ctx, node, vol.Storage())
+	if err != nil {
+		return false, fmt.Errorf("failed to get content of storage %s on node %s: %v", vol.Storage(), node, err)
+	}
+
+	for _, content := range contents {
+		if content.Volid == vol.VolID() {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
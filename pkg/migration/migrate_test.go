@@ -0,0 +1,200 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package migration
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sergelogvinov/proxmox-csi-plugin/pkg/csi"
+	volume "github.com/sergelogvinov/proxmox-csi-plugin/pkg/utils/volume"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func testResources() (*corev1.PersistentVolumeClaim, *corev1.PersistentVolume) {
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "storage-test-0",
+			Namespace: "default",
+			UID:       "uid-1",
+			Annotations: map[string]string{
+				MigrateNodeAnnotation:     "pve-2",
+				MigrateAnnotation:         migrateForce,
+				migrateTaskAnnotation:     "UPID:pve-1:00001:00002:00003:imgcopy::root@pam:",
+				migrateCordonedAnnotation: "node-1,node-2",
+				"custom":                  "value",
+			},
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			VolumeName: "pvc-123",
+		},
+		Status: corev1.PersistentVolumeClaimStatus{
+			Capacity: corev1.ResourceList{
+				corev1.ResourceStorage: resource.MustParse("1Gi"),
+			},
+		},
+	}
+
+	pv := &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "pvc-123",
+			UID:  "uid-2",
+		},
+		Spec: corev1.PersistentVolumeSpec{
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				CSI: &corev1.CSIPersistentVolumeSource{
+					Driver:       csi.DriverName,
+					VolumeHandle: "cluster-1/pve-1/local-lvm/vm-9999-pvc-123",
+				},
+			},
+			ClaimRef: &corev1.ObjectReference{
+				Namespace: "default",
+				Name:      "storage-test-0",
+				UID:       "uid-1",
+			},
+			PersistentVolumeReclaimPolicy: corev1.PersistentVolumeReclaimRetain,
+		},
+	}
+
+	return pvc, pv
+}
+
+func TestReplacementResources(t *testing.T) {
+	pvc, pv := testResources()
+	vol, err := volume.NewVolumeFromVolumeID(pv.Spec.CSI.VolumeHandle)
+	require.NoError(t, err)
+
+	newPV, err := replacementResources(pvc, pv, vol, "pve-2")
+	require.NoError(t, err)
+
+	assert.NotEqual(t, pv.Name, newPV.Name)
+	assert.Empty(t, newPV.UID)
+	assert.Equal(t, "cluster-1/pve-2/local-lvm/vm-9999-pvc-123", newPV.Spec.CSI.VolumeHandle)
+	assert.Equal(t, "cluster-1/pve-1/local-lvm/vm-9999-pvc-123", pv.Spec.CSI.VolumeHandle)
+	assert.Equal(t, pv.Name, newPV.Labels[migrateFromLabel])
+	assert.Equal(t, "node-1,node-2", newPV.Annotations[migrateCordonedAnnotation])
+	assert.Equal(t, &corev1.ObjectReference{
+		Kind:       "PersistentVolumeClaim",
+		APIVersion: "v1",
+		Namespace:  "default",
+		Name:       "storage-test-0",
+	}, newPV.Spec.ClaimRef)
+	assert.Equal(t, []corev1.NodeSelectorRequirement{
+		{Key: corev1.LabelTopologyRegion, Operator: corev1.NodeSelectorOpIn, Values: []string{"cluster-1"}},
+		{Key: corev1.LabelTopologyZone, Operator: corev1.NodeSelectorOpIn, Values: []string{"pve-2"}},
+	}, newPV.Spec.NodeAffinity.Required.NodeSelectorTerms[0].MatchExpressions)
+
+	newPVC := &corev1.PersistentVolumeClaim{}
+	require.NoError(t, json.Unmarshal([]byte(newPV.Annotations[migrateClaimAnnotation]), newPVC))
+
+	assert.Equal(t, "storage-test-0", newPVC.Name)
+	assert.Empty(t, newPVC.UID)
+	assert.Equal(t, newPV.Name, newPVC.Spec.VolumeName)
+	assert.Equal(t, map[string]string{"custom": "value"}, newPVC.Annotations)
+	assert.Equal(t, resource.MustParse("1Gi"), newPVC.Spec.Resources.Requests[corev1.ResourceStorage])
+}
+
+func TestFinishMigration(t *testing.T) {
+	pvc, pv := testResources()
+	vol, err := volume.NewVolumeFromVolumeID(pv.Spec.CSI.VolumeHandle)
+	require.NoError(t, err)
+
+	newPV, err := replacementResources(pvc, pv, vol, "pve-2")
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	nodes := &corev1.NodeList{
+		Items: []corev1.Node{
+			{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}, Spec: corev1.NodeSpec{Unschedulable: true}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "node-2"}, Spec: corev1.NodeSpec{Unschedulable: true}},
+		},
+	}
+
+	kclient := fake.NewClientset(nodes, pvc, pv, newPV)
+	c := &Controller{
+		kclient:  kclient,
+		recorder: record.NewFakeRecorder(10),
+	}
+
+	requeue, err := c.finishMigration(ctx, pvc.Namespace, pvc.Name, newPV)
+	require.NoError(t, err)
+	assert.Equal(t, waitInterval, requeue)
+
+	_, err = kclient.CoreV1().PersistentVolumeClaims(pvc.Namespace).Get(ctx, pvc.Name, metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err))
+
+	requeue, err = c.finishMigration(ctx, pvc.Namespace, pvc.Name, newPV)
+	require.NoError(t, err)
+	assert.Zero(t, requeue)
+
+	res, err := kclient.CoreV1().PersistentVolumeClaims(pvc.Namespace).Get(ctx, pvc.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, newPV.Name, res.Spec.VolumeName)
+
+	_, err = kclient.CoreV1().PersistentVolumes().Get(ctx, pv.Name, metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err))
+
+	resPV, err := kclient.CoreV1().PersistentVolumes().Get(ctx, newPV.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.NotContains(t, resPV.Labels, migrateFromLabel)
+	assert.NotContains(t, resPV.Annotations, migrateClaimAnnotation)
+	assert.NotContains(t, resPV.Annotations, migrateCordonedAnnotation)
+
+	node, err := kclient.CoreV1().Nodes().Get(ctx, "node-1", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.False(t, node.Spec.Unschedulable)
+
+	// The migration is finished, the new volume is not found anymore.
+	found, err := c.replacementVolume(ctx, pvc.Namespace, pvc.Name)
+	require.NoError(t, err)
+	assert.Nil(t, found)
+}
+
+func TestSetCondition(t *testing.T) {
+	pvc, _ := testResources()
+
+	ctx := context.Background()
+	recorder := record.NewFakeRecorder(10)
+	c := &Controller{
+		kclient:  fake.NewClientset(pvc),
+		recorder: recorder,
+	}
+
+	require.NoError(t, c.setCondition(ctx, pvc, ReasonWaitingForPods, "Waiting for pods app-0 to terminate"))
+	require.NoError(t, c.setCondition(ctx, pvc, ReasonWaitingForPods, "Waiting for pods app-0 to terminate"))
+	require.NoError(t, c.setCondition(ctx, pvc, ReasonWaitingForPods, "Terminating pods app-0"))
+	require.NoError(t, c.setCondition(ctx, pvc, ReasonFailed, "failed to copy disk"))
+
+	assert.Len(t, pvc.Status.Conditions, 1)
+	assert.Equal(t, MigrationCondition, pvc.Status.Conditions[0].Type)
+	assert.Equal(t, corev1.ConditionFalse, pvc.Status.Conditions[0].Status)
+	assert.Equal(t, ReasonFailed, pvc.Status.Conditions[0].Reason)
+
+	assert.Len(t, recorder.Events, 2)
+	assert.Equal(t, "Normal WaitingForPods Waiting for pods app-0 to terminate", <-recorder.Events)
+	assert.Equal(t, "Warning MigrationFailed failed to copy disk", <-recorder.Events)
+}
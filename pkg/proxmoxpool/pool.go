@@ -20,9 +20,11 @@ package proxmoxpool
 import (
 	"context"
 	"crypto/tls"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"os"
 	"strings"
 
@@ -45,11 +47,17 @@ type ProxmoxCluster struct {
 	Username        string `yaml:"username,omitempty"`
 	Password        string `yaml:"password,omitempty"`
 	Region          string `yaml:"region,omitempty"`
+	// Fingerprint is the TLS certificate fingerprint used by other clusters for the remote migrations.
+	Fingerprint string `yaml:"fingerprint,omitempty"`
+
+	// WrapTransport wraps the HTTP transport of the Proxmox client, e.g. to record the API requests.
+	WrapTransport func(http.RoundTripper) http.RoundTripper `yaml:"-"`
 }
 
 // ProxmoxPool is a Proxmox client pool of proxmox clusters.
 type ProxmoxPool struct {
 	clients map[string]*goproxmox.APIClient
+	configs map[string]*ProxmoxCluster
 }
 
 // NewProxmoxPool creates a new Proxmox cluster client.
@@ -57,19 +65,32 @@ func NewProxmoxPool(config []*ProxmoxCluster, options ...proxmox.Option) (*Proxm
 	clusters := len(config)
 	if clusters > 0 {
 		clients := make(map[string]*goproxmox.APIClient, clusters)
+		configs := make(map[string]*ProxmoxCluster, clusters)
 
 		for _, cfg := range config {
 			opts := []proxmox.Option{proxmox.WithUserAgent("ProxmoxCSIPlugin/1.0")}
 			opts = append(opts, options...)
 
+			var httpTr http.RoundTripper
+
 			if cfg.Insecure {
-				httpTr := &http.Transport{
+				httpTr = &http.Transport{
 					TLSClientConfig: &tls.Config{
 						InsecureSkipVerify: true,
 						MinVersion:         tls.VersionTLS12,
 					},
 				}
+			}
+
+			if cfg.WrapTransport != nil {
+				if httpTr == nil {
+					httpTr = http.DefaultTransport
+				}
+
+				httpTr = cfg.WrapTransport(httpTr)
+			}
 
+			if httpTr != nil {
 				opts = append(opts, proxmox.WithHTTPClient(&http.Client{Transport: httpTr}))
 			}
 
@@ -106,10 +127,12 @@ func NewProxmoxPool(config []*ProxmoxCluster, options ...proxmox.Option) (*Proxm
 			}
 
 			clients[cfg.Region] = pxClient
+			configs[cfg.Region] = cfg
 		}
 
 		return &ProxmoxPool{
 			clients: clients,
+			configs: configs,
 		}, nil
 	}
 
@@ -165,6 +188,69 @@ func (c *ProxmoxPool) GetProxmoxCluster(region string) (*goproxmox.APIClient, er
 	return nil, ErrRegionNotFound
 }
 
+// redactedSecret replaces the secrets in the strings which can be logged.
+const redactedSecret = "***"
+
+// RemoteEndpoint is the remote migration endpoint of the Proxmox cluster.
+// The API token secret is written only to the JSON request body, the string form redacts it.
+type RemoteEndpoint struct {
+	Host        string
+	Port        string
+	Fingerprint string
+	TokenID     string
+
+	tokenSecret string
+}
+
+// String returns the endpoint with the redacted API token secret.
+func (e *RemoteEndpoint) String() string {
+	return e.format(redactedSecret)
+}
+
+// MarshalJSON returns the endpoint in the format of the target-endpoint parameter of the remote migration.
+func (e *RemoteEndpoint) MarshalJSON() ([]byte, error) {
+	return json.Marshal(e.format(e.tokenSecret))
+}
+
+func (e *RemoteEndpoint) format(secret string) string {
+	endpoint := fmt.Sprintf("apitoken=PVEAPIToken=%s=%s,host=%s", e.TokenID, secret, e.Host)
+	if e.Port != "" {
+		endpoint += ",port=" + e.Port
+	}
+
+	if e.Fingerprint != "" {
+		endpoint += ",fingerprint=" + e.Fingerprint
+	}
+
+	return endpoint
+}
+
+// GetRemoteEndpoint returns the remote migration endpoint of the Proxmox cluster in a given region.
+// Proxmox accepts only API tokens for the remote migrations.
+func (c *ProxmoxPool) GetRemoteEndpoint(region string) (*RemoteEndpoint, error) {
+	cfg := c.configs[region]
+	if cfg == nil {
+		return nil, ErrRegionNotFound
+	}
+
+	if cfg.TokenID == "" || cfg.TokenSecret == "" {
+		return nil, fmt.Errorf("remote migration requires an API token in region %s", region)
+	}
+
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse url of region %s: %v", region, err)
+	}
+
+	return &RemoteEndpoint{
+		Host:        u.Hostname(),
+		Port:        u.Port(),
+		Fingerprint: cfg.Fingerprint,
+		TokenID:     cfg.TokenID,
+		tokenSecret: cfg.TokenSecret,
+	}, nil
+}
+
 // GetNodeGroup returns a Proxmox node ha-group in a given region.
 func (c *ProxmoxPool) GetNodeGroup(ctx context.Context, region string, node string) (string, error) {
 	px, err := c.GetProxmoxCluster(region)
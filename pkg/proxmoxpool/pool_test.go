@@ -17,6 +17,8 @@ limitations under the License.
 package proxmoxpool_test
 
 import (
+	"encoding/json"
+	"fmt"
 	"os"
 	"testing"
 
@@ -96,6 +98,39 @@ func TestNewClientWithCredentialsFromFile(t *testing.T) {
 	assert.Equal(t, "secret", cfg[0].TokenSecret)
 }
 
+func TestGetRemoteEndpoint(t *testing.T) {
+	cfg := newClusterEnv()
+	cfg[1].Fingerprint = "AA:BB"
+	cfg = append(cfg, &pxpool.ProxmoxCluster{
+		URL:      "https://127.0.0.3/api2/json",
+		Username: "root@pam",
+		Password: "secret",
+		Region:   "cluster-3",
+	})
+
+	pxClient, err := pxpool.NewProxmoxPool(cfg)
+	assert.Nil(t, err)
+
+	endpoint, err := pxClient.GetRemoteEndpoint("cluster-1")
+	assert.Nil(t, err)
+	assert.Equal(t, "apitoken=PVEAPIToken=user!token-id=***,host=127.0.0.1,port=8006", endpoint.String())
+
+	data, err := json.Marshal(map[string]any{"target-endpoint": endpoint})
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"target-endpoint":"apitoken=PVEAPIToken=user!token-id=secret,host=127.0.0.1,port=8006"}`, string(data))
+
+	endpoint, err = pxClient.GetRemoteEndpoint("cluster-2")
+	assert.Nil(t, err)
+	assert.Equal(t, "apitoken=PVEAPIToken=user!token-id=***,host=127.0.0.2,port=8006,fingerprint=AA:BB", endpoint.String())
+	assert.NotContains(t, fmt.Sprintf("%v %+v", map[string]any{"target-endpoint": endpoint}, endpoint), "secret")
+
+	_, err = pxClient.GetRemoteEndpoint("cluster-3")
+	assert.EqualError(t, err, "remote migration requires an API token in region cluster-3")
+
+	_, err = pxClient.GetRemoteEndpoint("cluster-4")
+	assert.Equal(t, pxpool.ErrRegionNotFound, err)
+}
+
 func TestCheckClusters(t *testing.T) {
 	cfg := newClusterEnv()
 	assert.NotNil(t, cfg)
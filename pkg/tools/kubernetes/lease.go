@@ -0,0 +1,109 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tools
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	clientkubernetes "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// DefaultLeaseDuration is the time the other replicas wait before taking over the lease.
+	DefaultLeaseDuration = 15 * time.Second
+	// DefaultRenewDeadline is the time the lease holder retries to renew the lease before it gives up.
+	DefaultRenewDeadline = 10 * time.Second
+	// DefaultRetryPeriod is the time between the attempts to acquire or renew the lease.
+	DefaultRetryPeriod = 2 * time.Second
+)
+
+// LeaseConfig is the lease which allows only one replica of the controller to run the loop.
+type LeaseConfig struct {
+	Namespace string
+	Name      string
+	// Identity is the name of the replica, the hostname with a random suffix by default.
+	Identity string
+
+	LeaseDuration time.Duration
+	RenewDeadline time.Duration
+	RetryPeriod   time.Duration
+}
+
+// RunWithLease blocks until the lease is acquired and runs fn while holding it.
+// The context of fn is canceled when the lease is lost, RunWithLease returns after ctx is done or the lease is lost.
+func RunWithLease(ctx context.Context, kclient clientkubernetes.Interface, cfg LeaseConfig, fn func(ctx context.Context)) error {
+	if cfg.Identity == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return fmt.Errorf("failed to get hostname: %v", err)
+		}
+
+		cfg.Identity = hostname + "_" + string(uuid.NewUUID())
+	}
+
+	if cfg.LeaseDuration == 0 {
+		cfg.LeaseDuration = DefaultLeaseDuration
+	}
+
+	if cfg.RenewDeadline == 0 {
+		cfg.RenewDeadline = DefaultRenewDeadline
+	}
+
+	if cfg.RetryPeriod == 0 {
+		cfg.RetryPeriod = DefaultRetryPeriod
+	}
+
+	le, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock: &resourcelock.LeaseLock{
+			LeaseMeta:  metav1.ObjectMeta{Namespace: cfg.Namespace, Name: cfg.Name},
+			Client:     kclient.CoordinationV1(),
+			LockConfig: resourcelock.ResourceLockConfig{Identity: cfg.Identity},
+		},
+		LeaseDuration:   cfg.LeaseDuration,
+		RenewDeadline:   cfg.RenewDeadline,
+		RetryPeriod:     cfg.RetryPeriod,
+		ReleaseOnCancel: true,
+		Name:            cfg.Name,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				klog.InfoS("Acquired lease", "lease", klog.KRef(cfg.Namespace, cfg.Name), "identity", cfg.Identity)
+
+				fn(ctx)
+			},
+			OnStoppedLeading: func() {
+				klog.InfoS("Released lease", "lease", klog.KRef(cfg.Namespace, cfg.Name), "identity", cfg.Identity)
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create leader elector: %v", err)
+	}
+
+	klog.InfoS("Waiting for lease", "lease", klog.KRef(cfg.Namespace, cfg.Name), "identity", cfg.Identity)
+
+	le.Run(ctx)
+
+	return nil
+}
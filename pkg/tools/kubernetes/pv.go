@@ -31,7 +31,7 @@ import (
 )
 
 // PVCResources returns the PersistentVolumeClaim and PersistentVolume resources.
-func PVCResources(ctx context.Context, clientset *clientkubernetes.Clientset, namespace, pvcName string) (*corev1.PersistentVolumeClaim, *corev1.PersistentVolume, error) {
+func PVCResources(ctx context.Context, clientset clientkubernetes.Interface, namespace, pvcName string) (*corev1.PersistentVolumeClaim, *corev1.PersistentVolume, error) {
 	pvc, err := clientset.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, pvcName, metav1.GetOptions{})
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to get PersistentVolumeClaims: %v", err)
@@ -46,7 +46,7 @@ func PVCResources(ctx context.Context, clientset *clientkubernetes.Clientset, na
 }
 
 // PVCPodUsage returns the list of pods and the node that are using the specified PersistentVolumeClaim.
-func PVCPodUsage(ctx context.Context, clientset *clientkubernetes.Clientset, namespace, pvcName string) (pods []string, node string, err error) {
+func PVCPodUsage(ctx context.Context, clientset clientkubernetes.Interface, namespace, pvcName string) (pods []string, node string, err error) {
 	podList, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
 	if err != nil {
 		return nil, "", fmt.Errorf("failed to list pods: %v", err)
@@ -71,7 +71,7 @@ func PVCPodUsage(ctx context.Context, clientset *clientkubernetes.Clientset, nam
 // PVCCreateOrUpdate creates or updates the specified PersistentVolumeClaim resource.
 func PVCCreateOrUpdate(
 	ctx context.Context,
-	clientset *clientkubernetes.Clientset,
+	clientset clientkubernetes.Interface,
 	pvc *corev1.PersistentVolumeClaim,
 ) (*corev1.PersistentVolumeClaim, error) {
 	res, err := clientset.CoreV1().PersistentVolumeClaims(pvc.Namespace).Create(ctx, pvc, metav1.CreateOptions{})
@@ -94,7 +94,7 @@ func PVCCreateOrUpdate(
 }
 
 // PVWaitDelete waits for the specified PersistentVolume to be deleted.
-func PVWaitDelete(ctx context.Context, clientset *clientkubernetes.Clientset, pvName string) error {
+func PVWaitDelete(ctx context.Context, clientset clientkubernetes.Interface, pvName string) error {
 	// We reuse PV name for change nodeSelector, but some comtrollers may modify PV on deletion event.
 	// So we need to wait after PV deletion before creating new PV with the same name.
 	select {
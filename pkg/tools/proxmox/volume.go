@@ -20,6 +20,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"path"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -29,6 +32,9 @@ import (
 	volume "github.com/sergelogvinov/proxmox-csi-plugin/pkg/utils/volume"
 )
 
+// ErrMovedDiskNotFound is returned when the moved disk is not attached to the virtual machine after the move.
+var ErrMovedDiskNotFound = errors.New("moved disk not found")
+
 // WaitForVolumeDetach waits for the volume to be detached from the VM.
 // vmID is the Proxmox VM ID of the Kubernetes node that was using the volume.
 // If vmID is 0, the check is skipped (volume was not in use).
@@ -73,30 +79,47 @@ func WaitForVolumeDetach(ctx context.Context, client *goproxmox.APIClient, vmID
 	}
 }
 
-// MoveQemuDisk moves the volume from one node to another.
-func MoveQemuDisk(ctx context.Context, cluster *goproxmox.APIClient, vol *volume.Volume, node string, taskTimeout int) error {
+// CopyQemuDisk starts the copy of the volume to the destination volume and returns the copy task ID.
+// The destination can be on another node or storage, the volume on shared storage is copied from the destination node.
+func CopyQemuDisk(ctx context.Context, cluster *goproxmox.APIClient, vol *volume.Volume, dst *volume.Volume) (proxmox.UPID, error) {
+	node := vol.Node()
+	if node == "" {
+		node = dst.Node()
+	}
+
 	params := map[string]interface{}{
-		"node":        vol.Node(),
-		"target":      vol.Disk(),
-		"target_node": node,
+		"node":        node,
+		"target":      dst.VolID(),
+		"target_node": dst.Node(),
 		"volume":      vol.Disk(),
 	}
 
 	// POST https://pve.proxmox.com/pve-docs/api-viewer/index.html#/nodes/{node}/storage/{storage}/content/{volume}
 	// Copy a volume. This is experimental code - do not use.
 	var upid proxmox.UPID
-	if err := cluster.Client.Post(ctx, fmt.Sprintf("/nodes/%s/storage/%s/content/%s", vol.Node(), vol.Storage(), vol.Disk()), params, &upid); err != nil {
-		return fmt.Errorf("failed to copy pvc: %v, params=%+v", err, params)
+	if err := cluster.Client.Post(ctx, fmt.Sprintf("/nodes/%s/storage/%s/content/%s", node, vol.Storage(), vol.Disk()), params, &upid); err != nil {
+		return "", fmt.Errorf("failed to copy pvc: %v, params=%+v", err, params)
+	}
+
+	return upid, nil
+}
+
+// MoveQemuDisk moves the volume to the destination volume on another node or storage.
+func MoveQemuDisk(ctx context.Context, cluster *goproxmox.APIClient, vol *volume.Volume, dst *volume.Volume, taskTimeout int) error {
+	upid, err := CopyQemuDisk(ctx, cluster, vol, dst)
+	if err != nil {
+		return err
 	}
 
 	task := proxmox.NewTask(upid, cluster.Client)
 	if task != nil {
-		_, completed, err := task.WaitForCompleteStatus(ctx, taskTimeout/15, 15)
+		status, completed, err := task.WaitForCompleteStatus(ctx, taskTimeout/15, 15)
 		if err != nil {
 			return fmt.Errorf("unable to delete virtual machine disk: %w", err)
 		}
 
-		if completed {
+		// The failed copy is completed too, the source disk must be kept
+		if completed && status {
 			return nil
 		}
 
@@ -105,3 +128,192 @@ func MoveQemuDisk(ctx context.Context, cluster *goproxmox.APIClient, vol *volume
 
 	return nil
 }
+
+// MoveAttachedDisk moves the volume attached to the running virtual machine to another storage on the same node.
+// Proxmox allocates the new disk for the virtual machine, so the returned volume has a new disk name.
+func MoveAttachedDisk(ctx context.Context, cluster *goproxmox.APIClient, vmID int, vol *volume.Volume, dst *volume.Volume, taskTimeout int) (*volume.Volume, error) {
+	vm, err := cluster.GetVMConfig(ctx, vmID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get vm config: %v", err)
+	}
+
+	if vm.Node != dst.Node() {
+		return nil, fmt.Errorf("vm %d runs on proxmox node %s, the disk can be moved only on the same node", vmID, vm.Node)
+	}
+
+	device := ""
+
+	for d, disk := range vm.VirtualMachineConfig.MergeSCSIs() {
+		if strings.Split(disk, ",")[0] == vol.VolID() {
+			device = d
+
+			break
+		}
+	}
+
+	if device == "" {
+		return nil, fmt.Errorf("disk %s is not attached to vm %d", vol.VolID(), vmID)
+	}
+
+	format := strings.TrimPrefix(path.Ext(dst.Disk()), ".")
+	if format == "" {
+		format = "raw"
+	}
+
+	task, err := vm.MoveDisk(ctx, device, &proxmox.VirtualMachineMoveDiskOptions{
+		Storage: dst.Storage(),
+		Format:  format,
+		Delete:  1,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to move disk: %v", err)
+	}
+
+	if err = task.WaitFor(ctx, taskTimeout); err != nil {
+		return nil, fmt.Errorf("unable to move disk: %w", err)
+	}
+
+	if task.IsFailed {
+		return nil, fmt.Errorf("unable to move disk: %s", task.ExitStatus)
+	}
+
+	if vm, err = cluster.GetVMConfig(ctx, vmID); err != nil {
+		return nil, fmt.Errorf("failed to get vm config: %v", err)
+	}
+
+	storage, disk, ok := strings.Cut(strings.Split(vm.VirtualMachineConfig.MergeSCSIs()[device], ",")[0], ":")
+	if !ok || storage != dst.Storage() {
+		return nil, fmt.Errorf("%w in vm %d", ErrMovedDiskNotFound, vmID)
+	}
+
+	return volume.NewVolume(dst.Region(), dst.Node(), storage, disk), nil
+}
+
+// DestinationVolume returns the volume on the destination node and storage.
+// File storages keep the disk format of the volume, block storages support only raw disks.
+func DestinationVolume(ctx context.Context, cluster *goproxmox.APIClient, vol *volume.Volume, node, storage string) (*volume.Volume, bool, error) {
+	st, err := cluster.GetClusterStorage(ctx, storage)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get storage %s: %v", storage, err)
+	}
+
+	format := strings.TrimPrefix(path.Ext(vol.Disk()), ".")
+	disk := strings.TrimSuffix(path.Base(vol.Disk()), path.Ext(vol.Disk()))
+
+	// see https://pve.proxmox.com/wiki/Storage
+	switch st.PluginType {
+	case "dir", "nfs", "cifs", "cephfs", "btrfs":
+		if format == "" {
+			format = "raw"
+		}
+	default:
+		if format != "" && format != "raw" {
+			return nil, false, fmt.Errorf("disk %s has %s format, storage %s supports only raw disks", vol.Disk(), format, storage)
+		}
+
+		format = ""
+	}
+
+	return volume.NewVolume(vol.Region(), node, storage, disk, format), st.Shared == 1, nil
+}
+
+// RollbackVolumeSnapshot rolls the volume back to its native snapshot, vol.Snapshot() is the snapshot name.
+// The snapshots are taken of the VM named after the PV, or of the VM which owns the restored volume.
+func RollbackVolumeSnapshot(ctx context.Context, cluster *goproxmox.APIClient, vol *volume.Volume, taskTimeout int) error {
+	vmr, err := cluster.GetVMByFilter(ctx, func(rs *proxmox.ClusterResource) (bool, error) {
+		if rs.Type != "qemu" {
+			return false, nil
+		}
+
+		if rs.Name == vol.PV() {
+			return true, nil
+		}
+
+		return slices.Contains(strings.Split(rs.Tags, ";"), "csi-volume") && vol.VMID() == strconv.FormatUint(rs.VMID, 10), nil
+	})
+	if err != nil && !errors.Is(err, goproxmox.ErrVirtualMachineNotFound) {
+		return fmt.Errorf("failed to find snapshot vm: %v", err)
+	}
+
+	if vmr == nil || vmr.VMID == 0 {
+		return fmt.Errorf("snapshot vm of volume %s: %w", vol.VolID(), goproxmox.ErrVirtualMachineNotFound)
+	}
+
+	vm, err := cluster.GetVMConfig(ctx, int(vmr.VMID))
+	if err != nil {
+		return fmt.Errorf("failed to get vm config: %v", err)
+	}
+
+	task, err := vm.SnapshotRollback(ctx, vol.Snapshot())
+	if err != nil {
+		return fmt.Errorf("failed to rollback vm snapshot: %v", err)
+	}
+
+	if err = task.WaitFor(ctx, taskTimeout); err != nil {
+		return fmt.Errorf("unable to rollback vm snapshot: %w", err)
+	}
+
+	if task.IsFailed {
+		return fmt.Errorf("unable to rollback vm snapshot: %s", task.ExitStatus)
+	}
+
+	return nil
+}
+
+// GetVolumeSize returns the size of the volume from the storage content of the volume node.
+func GetVolumeSize(ctx context.Context, cluster *goproxmox.APIClient, vol *volume.Volume) (int64, error) {
+	contents, err := cluster.GetStorageContent(ctx, vol.Node(), vol.Storage())
+	if err != nil {
+		return 0, fmt.Errorf("failed to get content of storage %s on node %s: %v", vol.Storage(), vol.Node(), err)
+	}
+
+	for _, content := range contents {
+		if content.Volid == vol.VolID() {
+			return int64(content.Size), nil
+		}
+	}
+
+	return 0, fmt.Errorf("volume %s not found on node %s", vol.VolID(), vol.Node())
+}
+
+// RenameVolume copies the volume to the new disk name on the same node and deletes the source volume.
+func RenameVolume(ctx context.Context, cluster *goproxmox.APIClient, vol *volume.Volume, disk string, taskTimeout int) error {
+	params := map[string]interface{}{
+		"target": disk,
+	}
+
+	// POST https://pve.proxmox.com/pve-docs/api-viewer/index.html#/nodes/{node}/storage/{storage}/content/{volume}
+	var upid proxmox.UPID
+	if err := cluster.Client.Post(ctx, fmt.Sprintf("/nodes/%s/storage/%s/content/%s", vol.Node(), vol.Storage(), vol.Disk()), params, &upid); err != nil {
+		return fmt.Errorf("failed to copy disk: %v, params=%+v", err, params)
+	}
+
+	task := proxmox.NewTask(upid, cluster.Client)
+	if err := task.WaitFor(ctx, taskTimeout); err != nil {
+		return fmt.Errorf("unable to copy disk: %w", err)
+	}
+
+	if task.IsFailed {
+		return fmt.Errorf("unable to copy disk: %s", task.ExitStatus)
+	}
+
+	if err := cluster.DeleteVMDisk(ctx, vol.Node(), vol.Storage(), vol.Disk()); err != nil {
+		return fmt.Errorf("failed to delete source disk: %v", err)
+	}
+
+	return nil
+}
+
+// GetVolumePath returns the path of the volume on the Proxmox node, e.g. /dev/zvol/rpool/data/vm-9999-pvc-123.
+func GetVolumePath(ctx context.Context, cluster *goproxmox.APIClient, vol *volume.Volume, node string) (string, error) {
+	attrs := struct {
+		Path string `json:"path"`
+	}{}
+
+	// GET https://pve.proxmox.com/pve-docs/api-viewer/index.html#/nodes/{node}/storage/{storage}/content/{volume}
+	if err := cluster.Client.Get(ctx, fmt.Sprintf("/nodes/%s/storage/%s/content/%s", node, vol.Storage(), vol.Disk()), &attrs); err != nil {
+		return "", fmt.Errorf("failed to get volume %s attributes on node %s: %v", vol.VolID(), node, err)
+	}
+
+	return attrs.Path, nil
+}
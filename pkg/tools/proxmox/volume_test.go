@@ -0,0 +1,373 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxmox_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/jarcoal/httpmock"
+	proxmox "github.com/luthermonson/go-proxmox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	goproxmox "github.com/sergelogvinov/go-proxmox"
+	toolsproxmox "github.com/sergelogvinov/proxmox-csi-plugin/pkg/tools/proxmox"
+	volume "github.com/sergelogvinov/proxmox-csi-plugin/pkg/utils/volume"
+)
+
+const (
+	taskOK    = "UPID:pve-1:003B4235:1DF4ABCA:667C1C45:csi:100:root@pam:"
+	taskError = "UPID:pve-1:003B4235:1DF4ABCA:667C1C45:csi:101:root@pam:"
+)
+
+// setupCluster activates the Proxmox API mock with the completed and the failed tasks.
+func setupCluster(t *testing.T) *goproxmox.APIClient {
+	t.Helper()
+
+	httpmock.Activate()
+	t.Cleanup(httpmock.DeactivateAndReset)
+
+	for upid, exitStatus := range map[string]string{taskOK: "OK", taskError: "ERROR"} {
+		httpmock.RegisterResponder(http.MethodGet, fmt.Sprintf(`=~/nodes/pve-1/tasks/%s/status`, upid),
+			httpmock.NewJsonResponderOrPanic(200, map[string]any{"data": proxmox.Task{
+				UPID:       proxmox.UPID(upid),
+				Node:       "pve-1",
+				Status:     "stopped",
+				ExitStatus: exitStatus,
+			}}))
+	}
+
+	cluster, err := goproxmox.NewAPIClient("https://127.0.0.1:8006/api2/json", proxmox.WithAPIToken("user!token", "secret"))
+	require.NoError(t, err)
+
+	return cluster
+}
+
+func TestRenameVolume(t *testing.T) {
+	tests := []struct {
+		msg             string
+		copyResponder   httpmock.Responder
+		expectedError   string
+		expectedDeletes int
+	}{
+		{
+			msg:             "Renamed",
+			copyResponder:   httpmock.NewJsonResponderOrPanic(200, map[string]any{"data": taskOK}),
+			expectedDeletes: 1,
+		},
+		{
+			msg:           "CopyFailed",
+			copyResponder: httpmock.NewStringResponder(500, ""),
+			expectedError: "failed to copy disk: 500 Internal Server Error, params=map[target:vm-9999-pvc-123]",
+		},
+		{
+			msg:           "CopyTaskFailed",
+			copyResponder: httpmock.NewJsonResponderOrPanic(200, map[string]any{"data": taskError}),
+			expectedError: "unable to copy disk: ERROR",
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.msg, func(t *testing.T) {
+			cluster := setupCluster(t)
+
+			httpmock.RegisterResponder(http.MethodPost, `=~/nodes/pve-1/storage/local-lvm/content/vm-100-disk-1$`, testCase.copyResponder)
+			httpmock.RegisterResponder(http.MethodDelete, `=~/nodes/pve-1/storage/local-lvm/content/vm-100-disk-1$`,
+				httpmock.NewJsonResponderOrPanic(200, map[string]any{"data": taskOK}))
+
+			vol := volume.NewVolume("cluster-1", "pve-1", "local-lvm", "vm-100-disk-1")
+
+			err := toolsproxmox.RenameVolume(context.Background(), cluster, vol, "vm-9999-pvc-123", 60)
+			if testCase.expectedError == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, testCase.expectedError)
+			}
+
+			// The source disk is deleted only after the copy has succeeded
+			assert.Equal(t, testCase.expectedDeletes, httpmock.GetCallCountInfo()["DELETE =~/nodes/pve-1/storage/local-lvm/content/vm-100-disk-1$"])
+		})
+	}
+}
+
+func TestDestinationVolume(t *testing.T) {
+	cluster := setupCluster(t)
+
+	httpmock.RegisterResponder(http.MethodGet, `=~/cluster/status$`,
+		httpmock.NewJsonResponderOrPanic(200, map[string]any{"data": []any{}}))
+	httpmock.RegisterResponder(http.MethodGet, `=~/cluster/resources`,
+		httpmock.NewJsonResponderOrPanic(200, map[string]any{"data": []*proxmox.ClusterResource{
+			{Type: "storage", Node: "pve-2", Storage: "local-lvm", PluginType: "lvmthin", Status: "available"},
+			{Type: "storage", Node: "pve-2", Storage: "local", PluginType: "dir", Status: "available"},
+			{Type: "storage", Node: "pve-2", Storage: "ceph", PluginType: "rbd", Shared: 1, Status: "available"},
+		}}))
+
+	tests := []struct {
+		msg            string
+		volumeID       string
+		storage        string
+		expectedError  string
+		expectedVolume string
+		expectedShared bool
+	}{
+		{
+			msg:            "BlockToBlock",
+			volumeID:       "cluster-1/pve-1/local-lvm/vm-9999-pvc-1",
+			storage:        "local-lvm",
+			expectedVolume: "cluster-1/pve-2/local-lvm/vm-9999-pvc-1",
+		},
+		{
+			msg:            "BlockToFile",
+			volumeID:       "cluster-1/pve-1/local-lvm/vm-9999-pvc-1",
+			storage:        "local",
+			expectedVolume: "cluster-1/pve-2/local/9999/vm-9999-pvc-1.raw",
+		},
+		{
+			msg:            "FileToFile",
+			volumeID:       "cluster-1/pve-1/local/9999/vm-9999-pvc-1.qcow2",
+			storage:        "local",
+			expectedVolume: "cluster-1/pve-2/local/9999/vm-9999-pvc-1.qcow2",
+		},
+		{
+			msg:            "RawFileToBlock",
+			volumeID:       "cluster-1/pve-1/local/9999/vm-9999-pvc-1.raw",
+			storage:        "local-lvm",
+			expectedVolume: "cluster-1/pve-2/local-lvm/vm-9999-pvc-1",
+		},
+		{
+			msg:           "QcowFileToBlock",
+			volumeID:      "cluster-1/pve-1/local/9999/vm-9999-pvc-1.qcow2",
+			storage:       "local-lvm",
+			expectedError: "disk 9999/vm-9999-pvc-1.qcow2 has qcow2 format, storage local-lvm supports only raw disks",
+		},
+		{
+			msg:            "SharedStorage",
+			volumeID:       "cluster-1/pve-1/local-lvm/vm-9999-pvc-1",
+			storage:        "ceph",
+			expectedVolume: "cluster-1/pve-2/ceph/vm-9999-pvc-1",
+			expectedShared: true,
+		},
+		{
+			msg:           "UnknownStorage",
+			volumeID:      "cluster-1/pve-1/local-lvm/vm-9999-pvc-1",
+			storage:       "nfs",
+			expectedError: "failed to get storage nfs: not found",
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.msg, func(t *testing.T) {
+			vol, err := volume.NewVolumeFromVolumeID(testCase.volumeID)
+			require.NoError(t, err)
+
+			dst, shared, err := toolsproxmox.DestinationVolume(context.Background(), cluster, vol, "pve-2", testCase.storage)
+			if testCase.expectedError != "" {
+				assert.EqualError(t, err, testCase.expectedError)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, testCase.expectedVolume, dst.VolumeID())
+			assert.Equal(t, testCase.expectedShared, shared)
+		})
+	}
+}
+
+func TestMoveAttachedDisk(t *testing.T) {
+	tests := []struct {
+		msg            string
+		dst            *volume.Volume
+		config         map[string]any
+		movedConfig    map[string]any
+		moveTask       string
+		expectedError  string
+		expectedVolume string
+		expectedFormat string
+	}{
+		{
+			msg:            "Moved",
+			dst:            volume.NewVolume("cluster-1", "pve-1", "ceph", "vm-9999-pvc-1"),
+			config:         map[string]any{"scsi0": "local-lvm:vm-100-disk-0,size=8G", "scsi2": "local-lvm:vm-9999-pvc-1,size=1G"},
+			movedConfig:    map[string]any{"scsi0": "local-lvm:vm-100-disk-0,size=8G", "scsi2": "ceph:vm-100-disk-1,size=1G"},
+			moveTask:       taskOK,
+			expectedVolume: "cluster-1/pve-1/ceph/vm-100-disk-1",
+			expectedFormat: "raw",
+		},
+		{
+			msg:            "MovedToFileStorage",
+			dst:            volume.NewVolume("cluster-1", "pve-1", "local", "vm-9999-pvc-1", "qcow2"),
+			config:         map[string]any{"scsi1": "local-lvm:vm-9999-pvc-1,size=1G"},
+			movedConfig:    map[string]any{"scsi1": "local:100/vm-100-disk-1.qcow2,size=1G"},
+			moveTask:       taskOK,
+			expectedVolume: "cluster-1/pve-1/local/100/vm-100-disk-1.qcow2",
+			expectedFormat: "qcow2",
+		},
+		{
+			msg:           "WrongNode",
+			dst:           volume.NewVolume("cluster-1", "pve-2", "ceph", "vm-9999-pvc-1"),
+			config:        map[string]any{"scsi1": "local-lvm:vm-9999-pvc-1,size=1G"},
+			expectedError: "vm 100 runs on proxmox node pve-1, the disk can be moved only on the same node",
+		},
+		{
+			msg:           "NotAttached",
+			dst:           volume.NewVolume("cluster-1", "pve-1", "ceph", "vm-9999-pvc-1"),
+			config:        map[string]any{"scsi1": "local-lvm:vm-9999-pvc-10,size=1G"},
+			expectedError: "disk local-lvm:vm-9999-pvc-1 is not attached to vm 100",
+		},
+		{
+			msg:            "MoveTaskFailed",
+			dst:            volume.NewVolume("cluster-1", "pve-1", "ceph", "vm-9999-pvc-1"),
+			config:         map[string]any{"scsi1": "local-lvm:vm-9999-pvc-1,size=1G"},
+			movedConfig:    map[string]any{"scsi1": "local-lvm:vm-9999-pvc-1,size=1G"},
+			moveTask:       taskError,
+			expectedError:  "unable to move disk: ERROR",
+			expectedFormat: "raw",
+		},
+		{
+			msg:            "MovedDiskNotFound",
+			dst:            volume.NewVolume("cluster-1", "pve-1", "ceph", "vm-9999-pvc-1"),
+			config:         map[string]any{"scsi1": "local-lvm:vm-9999-pvc-1,size=1G"},
+			movedConfig:    map[string]any{},
+			moveTask:       taskOK,
+			expectedError:  "moved disk not found in vm 100",
+			expectedFormat: "raw",
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.msg, func(t *testing.T) {
+			cluster := setupCluster(t)
+
+			httpmock.RegisterResponder(http.MethodGet, `=~/cluster/status$`,
+				httpmock.NewJsonResponderOrPanic(200, map[string]any{"data": []any{}}))
+			httpmock.RegisterResponder(http.MethodGet, `=~/cluster/resources`,
+				httpmock.NewJsonResponderOrPanic(200, map[string]any{"data": []*proxmox.ClusterResource{
+					{Type: "qemu", Node: "pve-1", VMID: 100, Name: "worker-1"},
+				}}))
+			httpmock.RegisterResponder(http.MethodGet, `=~/nodes/pve-1/qemu/100/status/current$`,
+				httpmock.NewJsonResponderOrPanic(200, map[string]any{"data": map[string]any{"vmid": 100, "name": "worker-1"}}))
+
+			moved := false
+			format := ""
+
+			httpmock.RegisterResponder(http.MethodGet, `=~/nodes/pve-1/qemu/100/config$`,
+				func(_ *http.Request) (*http.Response, error) {
+					if moved {
+						return httpmock.NewJsonResponse(200, map[string]any{"data": testCase.movedConfig})
+					}
+
+					return httpmock.NewJsonResponse(200, map[string]any{"data": testCase.config})
+				})
+			httpmock.RegisterResponder(http.MethodPost, `=~/nodes/pve-1/qemu/100/move_disk$`,
+				func(req *http.Request) (*http.Response, error) {
+					params := proxmox.VirtualMachineMoveDiskOptions{}
+					if err := json.NewDecoder(req.Body).Decode(&params); err != nil {
+						return nil, err
+					}
+
+					moved = true
+					format = params.Format
+
+					return httpmock.NewJsonResponse(200, map[string]any{"data": testCase.moveTask})
+				})
+
+			vol := volume.NewVolume("cluster-1", "pve-1", "local-lvm", "vm-9999-pvc-1")
+
+			dst, err := toolsproxmox.MoveAttachedDisk(context.Background(), cluster, 100, vol, testCase.dst, 60)
+			if testCase.expectedError != "" {
+				assert.EqualError(t, err, testCase.expectedError)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, testCase.expectedVolume, dst.VolumeID())
+			}
+
+			assert.Equal(t, testCase.expectedFormat, format)
+		})
+	}
+}
+
+func TestRollbackVolumeSnapshot(t *testing.T) {
+	tests := []struct {
+		msg              string
+		volumeID         string
+		expectedError    string
+		expectedRollback string
+	}{
+		{
+			msg:              "HolderVM",
+			volumeID:         "cluster-1/pve-1/local-lvm/vm-9999-pvc-1@snap",
+			expectedRollback: "/nodes/pve-1/qemu/101/snapshot/snap/rollback",
+		},
+		{
+			msg:              "OwnerVM",
+			volumeID:         "cluster-1/pve-1/local-lvm/vm-102-disk-0@snap",
+			expectedRollback: "/nodes/pve-1/qemu/102/snapshot/snap/rollback",
+		},
+		{
+			msg:           "OwnerVMWithoutTag",
+			volumeID:      "cluster-1/pve-1/local-lvm/vm-103-disk-0@snap",
+			expectedError: "snapshot vm of volume local-lvm:vm-103-disk-0: virtual machine not found",
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.msg, func(t *testing.T) {
+			cluster := setupCluster(t)
+
+			httpmock.RegisterResponder(http.MethodGet, `=~/cluster/status$`,
+				httpmock.NewJsonResponderOrPanic(200, map[string]any{"data": []any{}}))
+			httpmock.RegisterResponder(http.MethodGet, `=~/cluster/resources`,
+				httpmock.NewJsonResponderOrPanic(200, map[string]any{"data": []*proxmox.ClusterResource{
+					{Type: "qemu", Node: "pve-1", VMID: 101, Name: "pvc-1"},
+					{Type: "qemu", Node: "pve-1", VMID: 102, Name: "pvc-2", Tags: "k8s;csi-volume"},
+					{Type: "qemu", Node: "pve-1", VMID: 103, Name: "pvc-3", Tags: "csi-volume-old"},
+				}}))
+			httpmock.RegisterResponder(http.MethodGet, `=~/nodes/pve-1/qemu/(\d+)/status/current$`,
+				func(req *http.Request) (*http.Response, error) {
+					return httpmock.NewJsonResponse(200, map[string]any{"data": map[string]any{"vmid": httpmock.MustGetSubmatchAsInt(req, 1)}})
+				})
+			httpmock.RegisterResponder(http.MethodGet, `=~/nodes/pve-1/qemu/\d+/config$`,
+				httpmock.NewJsonResponderOrPanic(200, map[string]any{"data": map[string]any{}}))
+
+			rollback := ""
+
+			httpmock.RegisterResponder(http.MethodPost, `=~/nodes/pve-1/qemu/\d+/snapshot/snap/rollback$`,
+				func(req *http.Request) (*http.Response, error) {
+					rollback = req.URL.Path
+
+					return httpmock.NewJsonResponse(200, map[string]any{"data": taskOK})
+				})
+
+			vol, err := volume.NewVolumeFromVolumeID(testCase.volumeID)
+			require.NoError(t, err)
+
+			err = toolsproxmox.RollbackVolumeSnapshot(context.Background(), cluster, vol, 60)
+			if testCase.expectedError == "" {
+				require.NoError(t, err)
+				assert.Equal(t, "/api2/json"+testCase.expectedRollback, rollback)
+			} else {
+				require.EqualError(t, err, testCase.expectedError)
+				assert.Empty(t, rollback)
+			}
+		})
+	}
+}
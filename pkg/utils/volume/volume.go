@@ -27,11 +27,12 @@ var vmidre = regexp.MustCompile(`(^|-|/)vm-([1-9][0-9]{2,8})(-|$)`)
 
 // Volume is the volume ID type.
 type Volume struct {
-	region  string
-	zone    string
-	node    string
-	storage string
-	disk    string
+	region   string
+	zone     string
+	node     string
+	storage  string
+	disk     string
+	snapshot string
 }
 
 // NewVolume creates a new volume ID.
@@ -81,23 +82,34 @@ func parseVolumeID(vol string) (*Volume, error) {
 		return nil, fmt.Errorf("VolumeID must be in the format of region/zone/storageName/diskName")
 	}
 
+	disk, snapshot, _ := strings.Cut(parts[3], "@")
+
 	return &Volume{
-		region:  parts[0],
-		zone:    parts[1],
-		node:    parts[1],
-		storage: parts[2],
-		disk:    parts[3],
+		region:   parts[0],
+		zone:     parts[1],
+		node:     parts[1],
+		storage:  parts[2],
+		disk:     disk,
+		snapshot: snapshot,
 	}, nil
 }
 
 // VolumeID function returns the volume magic string.
 func (v *Volume) VolumeID() string {
-	return v.region + "/" + v.zone + "/" + v.storage + "/" + v.disk
+	return v.region + "/" + v.zone + "/" + v.storage + "/" + v.disk + v.snapshotSuffix()
 }
 
 // VolumeSharedID function returns the shared volume magic string.
 func (v *Volume) VolumeSharedID() string {
-	return v.region + "//" + v.storage + "/" + v.disk
+	return v.region + "//" + v.storage + "/" + v.disk + v.snapshotSuffix()
+}
+
+func (v *Volume) snapshotSuffix() string {
+	if v.snapshot == "" {
+		return ""
+	}
+
+	return "@" + v.snapshot
 }
 
 // Region function returns the region in which the volume was created.
@@ -125,6 +137,11 @@ func (v *Volume) Disk() string {
 	return v.disk
 }
 
+// Snapshot function returns the Proxmox snapshot name of the disk, it is empty if the volume is not a native snapshot.
+func (v *Volume) Snapshot() string {
+	return v.snapshot
+}
+
 // Cluster function returns the cluster name in which the volume was created.
 func (v *Volume) Cluster() string {
 	return v.region
@@ -174,6 +191,11 @@ func (v *Volume) SetStorage(storage string) {
 	v.storage = storage
 }
 
+// SetSnapshot sets the Proxmox snapshot name of the disk.
+func (v *Volume) SetSnapshot(snapshot string) {
+	v.snapshot = snapshot
+}
+
 // SetDisk sets the proxmox disk name.
 func (v *Volume) SetDisk(disk string) {
 	v.disk = disk
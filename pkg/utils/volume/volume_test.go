@@ -108,6 +108,23 @@ func TestNewVolumeFromSharedVolumeIDWithFolder(t *testing.T) {
 	assert.Equal(t, "region//storage/1000/folder/vm-1000-disk.raw", v.VolumeID())
 }
 
+func TestNewVolumeFromSnapshotID(t *testing.T) {
+	v, err := volume.NewVolumeFromVolumeID("region//storage/vm-1000-disk@snap1")
+	assert.Nil(t, err)
+	assert.NotNil(t, v)
+	assert.Equal(t, "region", v.Cluster())
+	assert.Equal(t, "", v.Zone())
+	assert.Equal(t, "storage", v.Storage())
+	assert.Equal(t, "vm-1000-disk", v.Disk())
+	assert.Equal(t, "snap1", v.Snapshot())
+	assert.Equal(t, "1000", v.VMID())
+	assert.Equal(t, "storage:vm-1000-disk", v.VolID())
+	assert.Equal(t, "region//storage/vm-1000-disk@snap1", v.VolumeID())
+
+	v.SetSnapshot("")
+	assert.Equal(t, "region//storage/vm-1000-disk", v.VolumeSharedID())
+}
+
 func TestNewVolumeFromVolumeIDError(t *testing.T) {
 	_, err := volume.NewVolumeFromVolumeID("region/storage/disk")
 	assert.NotNil(t, err)
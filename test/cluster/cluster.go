@@ -171,6 +171,18 @@ func SetupMockResponders() {
 			})
 		})
 
+	httpmock.RegisterResponder(http.MethodGet, `=~/api2/json/storage$`,
+		func(_ *http.Request) (*http.Response, error) {
+			return httpmock.NewJsonResponse(200, map[string]any{
+				"data": proxmox.ClusterStorages{
+					{Type: "lvmthin", Storage: "local-lvm", Content: "images,rootdir"},
+					{Type: "dir", Storage: "rbd", Shared: 1, Content: "images"},
+					{Type: "cifs", Storage: "smb", Shared: 1, Content: "images"},
+					{Type: "zfspool", Storage: "zfs", Content: "images"},
+				},
+			})
+		},
+	)
 	httpmock.RegisterResponder(http.MethodGet, `=~/storage/rbd$`,
 		func(_ *http.Request) (*http.Response, error) {
 			return httpmock.NewJsonResponse(200, map[string]any{
@@ -298,10 +310,29 @@ func SetupMockResponders() {
 						Size:   1024 * 1024 * 1024,
 						Volid:  "local-lvm:vm-9999-pvc-unpublished",
 					},
+					{
+						Format: "raw",
+						Size:   uint64(csi.MinChunkSizeBytes),
+						Volid:  "local-lvm:vm-9999-snapshot-123_vm-9999-pvc-123",
+						Ctime:  1700000000,
+					},
+					{
+						Format: "raw",
+						Size:   uint64(csi.MinChunkSizeBytes),
+						Volid:  "local-lvm:vm-9999-snapshot-6bd1c8b4",
+						Ctime:  1600000000,
+					},
 				},
 			})
 		},
 	)
+	httpmock.RegisterResponder(http.MethodGet, `=~/nodes/\S+/storage/zfs/content`,
+		func(_ *http.Request) (*http.Response, error) {
+			return httpmock.NewJsonResponse(200, map[string]any{
+				"data": []proxmox.StorageContent{},
+			})
+		},
+	)
 	httpmock.RegisterResponder(http.MethodGet, `=~/nodes/\S+/storage/\S+/content`,
 		func(_ *http.Request) (*http.Response, error) {
 			return httpmock.NewJsonResponse(500, map[string]any{